@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"deploy/gitlab"
+	"deploy/logger"
+	"deploy/plan"
+	"deploy/state"
+	"deploy/version"
+)
+
+// runAbort implements "deploy abort": cancels the pipelines of a deployment that are
+// still running, using the pipeline IDs saved in its state file, and marks the
+// deployment as aborted in the state file and the deployment report
+func runAbort(args []string) {
+	fs := flag.NewFlagSet("abort", flag.ExitOnError)
+	var logOpts logFlags
+	logOpts.register(fs)
+	var (
+		directory  string
+		versionStr string
+		redeploy   bool
+		dryRun     bool
+	)
+	fs.StringVar(&directory, "directory", "", "Base directory for services, where the deployment state is saved (required)")
+	fs.StringVar(&directory, "d", "", "Base directory for services (shorthand)")
+	fs.StringVar(&versionStr, "version", "", "Version of the deployment to abort, e.g. 123 or 123.0.1 for a hotfix (required)")
+	fs.StringVar(&versionStr, "v", "", "Version of the deployment to abort (shorthand)")
+	fs.BoolVar(&redeploy, "redeploy", false, "Abort the -from-tag redeployment of the version instead of its release")
+	fs.BoolVar(&dryRun, "dry-run", false, "Only show which pipelines would be canceled")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s abort [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Cancels the running pipelines of a deployment and marks it as aborted.\n\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExample:\n")
+		fmt.Fprintf(os.Stderr, "  %s abort -d /path/to/services -v 123\n", os.Args[0])
+	}
+	fs.Parse(args)
+	logOpts.apply()
+
+	if directory == "" {
+		logger.Exitf(exitConfig, "Error: -directory parameter is required\n\nUse -h for help")
+	}
+	if versionStr == "" {
+		logger.Exitf(exitConfig, "Error: -version parameter is required\n\nUse -h for help")
+	}
+	ver, err := version.Parse(versionStr)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+
+	// The state key and report name match those of the deployment, see deploy()
+	stateKey, reportFile := ver.String(), fmt.Sprintf("deploy-report-%s.json", ver)
+	if redeploy {
+		stateKey, reportFile = ver.String()+"-redeploy", fmt.Sprintf("deploy-report-%s-redeploy.json", ver)
+	}
+	stateFile := state.FileName(directory, stateKey)
+	st, err := state.Load(stateFile, stateKey)
+	if err != nil {
+		logger.Exitf(exitConfig, "No deployment of %s to abort: %v", stateKey, err)
+	}
+
+	if dryRun {
+		plan.Enable()
+		logger.Infof("*** DRY RUN: no pipelines will be canceled ***")
+	}
+
+	pipelines := st.CreatedPipelines()
+	logger.Infof("Aborting deployment %s: %d pipeline(s) recorded", stateKey, len(pipelines))
+	failed := 0
+	for _, p := range pipelines {
+		status, canceled, err := gitlab.CancelPipeline(p.Project, p.ID)
+		switch {
+		case err != nil:
+			failed++
+			logger.Errorf("  Pipeline %d for %s (%s): %v", p.ID, p.Service, p.Namespace, err)
+		case canceled:
+			logger.Infof("  Canceled pipeline %d for %s (%s), was %s", p.ID, p.Service, p.Namespace, status)
+		case gitlab.PipelineFinished(status):
+			logger.Infof("  Pipeline %d for %s (%s) already %s", p.ID, p.Service, p.Namespace, status)
+		}
+	}
+
+	if plan.Enabled() {
+		logger.Infof("\nDry run completed, no pipelines were canceled.")
+		return
+	}
+
+	if err := st.MarkAborted(); err != nil {
+		logger.Fatalf("Failed to save deployment state: %v", err)
+	}
+	markReportAborted(reportFile)
+
+	if failed > 0 {
+		logger.Exitf(exitPipeline, "Failed to cancel %d pipeline(s)", failed)
+	}
+	logger.Noticef("\nDeployment %s aborted", stateKey)
+}
+
+// markReportAborted sets the status of an existing deployment report to aborted.
+// A deployment still running writes its report itself and reports the abort.
+func markReportAborted(filename string) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		logger.Warnf("Warning: failed to read deployment report: %v", err)
+		return
+	}
+	var report deployReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		logger.Warnf("Warning: failed to parse deployment report %s: %v", filename, err)
+		return
+	}
+	report.Status = "aborted"
+	report.Error = "aborted with deploy abort"
+	if data, err = json.MarshalIndent(report, "", "  "); err == nil {
+		err = ioutil.WriteFile(filename, data, 0644)
+	}
+	if err != nil {
+		logger.Warnf("Warning: failed to update deployment report: %v", err)
+		return
+	}
+	logger.Infof("Deployment report %s marked as aborted", filename)
+}
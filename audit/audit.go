@@ -0,0 +1,260 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"deploy/logger"
+)
+
+// Entry is one line of the audit log: an external command or a GitLab API request.
+// Every entry carries the hash of the previous one, so removing or editing a line
+// breaks the chain.
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"kind"` // exec or http
+	Command    []string  `json:"command,omitempty"`
+	Dir        string    `json:"dir,omitempty"`
+	Method     string    `json:"method,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	ExitCode   int       `json:"exit_code"`        // exec: process exit code, -1 if it did not start
+	Status     int       `json:"status,omitempty"` // http: response status code
+	Error      string    `json:"error,omitempty"`
+	Prev       string    `json:"prev"`           // hash of the previous entry
+	Hash       string    `json:"hash,omitempty"` // sha256 of prev and this entry without the hash
+}
+
+var (
+	mu       sync.Mutex
+	file     *os.File
+	lastHash string
+	secrets  []string
+)
+
+// credentialsPattern matches passwords or tokens embedded in URLs
+var credentialsPattern = regexp.MustCompile(`://([^:/@\s]+):[^@\s]+@`)
+
+// tokenParams are URL query parameters whose values are redacted
+var tokenParams = []string{"private_token", "access_token", "token"}
+
+// Open starts appending to the audit log at path. An empty path disables auditing.
+func Open(path string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if path == "" {
+		return nil
+	}
+
+	last, err := lastEntryHash(path)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %v", path, err)
+	}
+	file = f
+	lastHash = last
+	return nil
+}
+
+// AddSecret registers a value that must never appear in the log, such as a token
+// that is part of a request URL
+func AddSecret(secret string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if secret != "" {
+		secrets = append(secrets, secret)
+	}
+}
+
+// Enabled reports whether an audit log is open
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return file != nil
+}
+
+// lastEntryHash returns the hash of the last entry of an existing log, continuing its chain
+func lastEntryHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read audit log %s: %v", path, err)
+	}
+	defer f.Close()
+
+	var last string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			last = line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read audit log %s: %v", path, err)
+	}
+	if last == "" {
+		return "", nil
+	}
+
+	var entry Entry
+	if err := json.Unmarshal([]byte(last), &entry); err != nil {
+		return "", fmt.Errorf("audit log %s has a corrupted last line: %v", path, err)
+	}
+	return entry.Hash, nil
+}
+
+// Do sends the request like client.Do and records it. Request headers,
+// which carry the GitLab token, are never written.
+func Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := client.Do(req)
+	recordRequest(req, resp, start, err)
+	return resp, err
+}
+
+// Transport returns a RoundTripper that sends requests through base, or
+// http.DefaultTransport if base is nil, and records them like Do
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return transport{base}
+}
+
+// transport is the RoundTripper of Transport
+type transport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip sends the request through the base transport and records it
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	recordRequest(req, resp, start, err)
+	return resp, err
+}
+
+// recordRequest writes the entry of a request sent at start
+func recordRequest(req *http.Request, resp *http.Response, start time.Time, err error) {
+	entry := Entry{
+		Kind:       "http",
+		Method:     req.Method,
+		URL:        redactURL(req.URL),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if resp != nil {
+		entry.Status = resp.StatusCode
+	}
+	if err != nil {
+		entry.Error = Redact(err.Error())
+	}
+	logger.Debugf("%s %s -> %d (%dms)", entry.Method, entry.URL, entry.Status, entry.DurationMs)
+	write(entry)
+}
+
+// RecordCommand writes the entry of a finished command started at start
+func RecordCommand(cmd *exec.Cmd, start time.Time, err error) {
+	entry := Entry{
+		Kind:       "exec",
+		Dir:        cmd.Dir,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	for _, arg := range cmd.Args {
+		entry.Command = append(entry.Command, Redact(arg))
+	}
+	if cmd.ProcessState != nil {
+		entry.ExitCode = cmd.ProcessState.ExitCode()
+	} else {
+		entry.ExitCode = -1
+	}
+	if err != nil {
+		entry.Error = Redact(err.Error())
+	}
+	write(entry)
+}
+
+// write appends the entry to the log, chaining it to the previous one.
+// Audit failures are reported on stderr but never stop the deployment.
+func write(entry Entry) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if file == nil {
+		return
+	}
+
+	entry.Time = time.Now()
+	entry.Prev = lastHash
+	entry.Hash = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to encode audit entry: %v\n", err)
+		return
+	}
+	sum := sha256.Sum256(append([]byte(entry.Prev), data...))
+	entry.Hash = hex.EncodeToString(sum[:])
+
+	data, err = json.Marshal(entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to encode audit entry: %v\n", err)
+		return
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write audit log: %v\n", err)
+		return
+	}
+	lastHash = entry.Hash
+}
+
+// Redact hides credentials in URLs, the value of GITLAB_TOKEN and registered secrets
+func Redact(s string) string {
+	s = credentialsPattern.ReplaceAllString(s, "://$1:***@")
+	if token := os.Getenv("GITLAB_TOKEN"); token != "" {
+		s = strings.ReplaceAll(s, token, "***")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, "***")
+	}
+	return s
+}
+
+// redactURL returns the URL with embedded credentials and token parameters hidden
+func redactURL(u *url.URL) string {
+	copied := *u
+	if copied.User != nil {
+		copied.User = url.UserPassword(copied.User.Username(), "***")
+	}
+	query := copied.Query()
+	changed := false
+	for _, param := range tokenParams {
+		if query.Has(param) {
+			query.Set(param, "***")
+			changed = true
+		}
+	}
+	if changed {
+		copied.RawQuery = query.Encode()
+	}
+	return Redact(copied.String())
+}
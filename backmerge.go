@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"deploy/git"
+	"deploy/gitlab"
+	"deploy/plan"
+	"deploy/tui"
+)
+
+// Phase 11: Merge the release branches back into the development branch, so that the
+// version bumps and the commits made only on the release branch are not lost.
+// The phase runs only when back_merge is configured.
+func (d *deployment) backMerge() {
+	target := d.cfg.BackMerge.TargetBranch()
+	d.forEachRepository("back-merge", func(service string) {
+		if d.skipDone("back-merge", service) {
+			return
+		}
+		source := d.releaseBranchFor(service)
+		if d.cfg.BackMerge.MergeRequest {
+			d.openBackMergeRequest(service, source, target)
+			return
+		}
+		d.mergeBack(service, source, target)
+	})
+}
+
+// releaseBranchFor returns the release branch of the service, which for a hotfix may
+// use the old / separator
+func (d *deployment) releaseBranchFor(service string) string {
+	if !d.hotfix {
+		return d.version.ServiceBranch(service)
+	}
+	branch := d.baseBranchFor(service)
+	if found, ok := git.FindBranch(d.repoDirs[service], branch); ok {
+		return found
+	}
+	return branch
+}
+
+// openBackMergeRequest opens a GitLab merge request from the release branch into target
+func (d *deployment) openBackMergeRequest(service, source, target string) {
+	project := d.gitlabProject(service)
+	title := fmt.Sprintf("Merge %s into %s after release %s", source, target, d.tagFor(service))
+	mr, err := gitlab.OpenMergeRequest(project, source, target, title, "", nil)
+	if err != nil {
+		d.failService(service, exitFailure, "Failed to open a merge request of %s into %s in %s: %v", source, target, service, err)
+		return
+	}
+	if mr.WebURL != "" {
+		d.logFor(service).Noticef("  Merge request of %s into %s for %s: %s", source, target, service, mr.WebURL)
+	}
+	d.markDone("back-merge", service)
+}
+
+// mergeBack merges the release branch into target in the working copy and pushes it.
+// A conflict pauses an interactive deployment until the user resolves it; otherwise
+// the merge is aborted and the service fails with the steps to finish it by hand.
+func (d *deployment) mergeBack(service, source, target string) {
+	dir := d.repoDirs[service]
+	log := d.logFor(service)
+	log.Infof("  Merging %s into %s for service: %s", source, target, service)
+
+	if err := git.Fetch(dir); err != nil {
+		d.failService(service, exitFailure, "Failed to fetch in %s: %v", service, err)
+		return
+	}
+	if err := git.Checkout(dir, target); err != nil {
+		d.failService(service, exitFailure, "Failed to checkout %s branch in %s: %v", target, service, err)
+		return
+	}
+	if err := git.Merge(dir, "--ff-only", "origin/"+target); err != nil {
+		d.failService(service, exitFailure, "Local %s branch of %s has diverged from origin: %v", target, service, err)
+		return
+	}
+
+	// A shallow clone may not have the history down to the common ancestor
+	if err := git.EnsureHistory(dir, target, source); err != nil {
+		d.failService(service, exitFailure, "Failed to fetch the history of %s: %v", service, err)
+		return
+	}
+
+	message := fmt.Sprintf("Merge %s into %s after release %s", source, target, d.tagFor(service))
+	if err := git.Merge(dir, "--no-ff", "-m", message, source); err != nil {
+		if !git.MergeInProgress(dir) {
+			d.failService(service, exitFailure, "Failed to merge %s into %s in %s: %v", source, target, service, err)
+			return
+		}
+		if !d.resolveConflicts(service, source, target) {
+			return
+		}
+	}
+
+	if err := git.PushBranch(dir, target); err != nil {
+		d.failService(service, exitPush, "Failed to push %s in %s: %v", target, service, err)
+		return
+	}
+	// Leave the working copy on the release branch, as the previous phases did
+	if err := git.Checkout(dir, source); err != nil {
+		log.Warnf("  Warning: failed to switch %s back to %s: %v", service, source, err)
+	}
+	d.markDone("back-merge", service)
+}
+
+// resolveConflicts handles a back-merge stopped on conflicts. It returns true once the
+// user committed the merge, or false if the merge was aborted and the service failed.
+func (d *deployment) resolveConflicts(service, source, target string) bool {
+	dir := d.repoDirs[service]
+	log := d.logFor(service)
+	files := git.ConflictedFiles(dir)
+	interactive := !d.assumeYes && !plan.Enabled() && tui.IsTerminal(os.Stdin) && tui.IsTerminal(os.Stdout)
+
+	if interactive {
+		d.promptMu.Lock()
+		defer d.promptMu.Unlock()
+		log.Warnf("\n  Merge conflict in %s:\n    %s", service, strings.Join(files, "\n    "))
+		log.Warnf("  Resolve the conflicts in %s and commit the merge (git add <files> && git commit --no-edit);\n  the deployment pushes %s afterwards.", dir, target)
+		for {
+			response := d.prompt(fmt.Sprintf("Press Enter when the merge of %s is committed, or type [a]bort to leave %s unmerged: ", service, target))
+			if response == "a" || response == "abort" {
+				break
+			}
+			if !git.MergeInProgress(dir) && len(git.ConflictedFiles(dir)) == 0 {
+				return true
+			}
+			log.Warnf("  The merge in %s is not committed yet", dir)
+		}
+	}
+
+	if err := git.AbortMerge(dir); err != nil {
+		log.Warnf("  Warning: failed to abort the merge in %s: %v", service, err)
+	} else if err := git.Checkout(dir, source); err != nil {
+		log.Warnf("  Warning: failed to switch %s back to %s: %v", service, source, err)
+	}
+	d.failService(service, exitFailure, "Merge conflict in %s (%s). To merge %s into %s by hand:\n"+
+		"    cd %s\n"+
+		"    git checkout %s && git pull\n"+
+		"    git merge --no-ff %s\n"+
+		"    # resolve the conflicts, then\n"+
+		"    git add <files> && git commit --no-edit && git push origin %s",
+		service, strings.Join(files, ", "), source, target, dir, target, source, target)
+	return false
+}
+
+// gitlabProject returns the GitLab project of the service
+func (d *deployment) gitlabProject(service string) string {
+	for _, svc := range d.cfg.GetAllServices() {
+		if svc.Name == service {
+			return svc.GitlabProject
+		}
+	}
+	return ""
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"deploy/git"
+	"deploy/logger"
+	"deploy/plan"
+)
+
+// refBackup lists the branches and tags a deployment deleted or force-pushed, saved
+// to .deploy-refs-<id>.json in the services directory for "deploy undo-refs".
+// The objects themselves are kept under refs/backup/deploy/<id>/ of the repositories.
+type refBackup struct {
+	ID        string        `json:"id"`
+	Version   string        `json:"version"`
+	CreatedAt time.Time     `json:"created_at"`
+	Refs      []backedUpRef `json:"refs"`
+
+	path string // empty in dry-run mode
+	mu   sync.Mutex
+}
+
+// backedUpRef is a ref saved in the repository of a service
+type backedUpRef struct {
+	Service    string `json:"service"`
+	Repository string `json:"repository"` // repository the backup ref is in
+	git.BackupRef
+}
+
+// backupFileName returns the path of the ref backup list of a deployment run
+func backupFileName(dir, id string) string {
+	return filepath.Join(dir, fmt.Sprintf(".deploy-refs-%s.json", id))
+}
+
+// newRefBackup creates the ref backup of a deployment run started now
+func newRefBackup(dir, version string) *refBackup {
+	now := time.Now()
+	b := &refBackup{ID: now.Format("20060102-150405"), Version: version, CreatedAt: now}
+	if !plan.Enabled() {
+		b.path = backupFileName(dir, b.ID)
+	}
+	return b
+}
+
+// add records refs saved in the repository of the service and writes the list
+func (b *refBackup) add(service, repository string, refs []git.BackupRef) error {
+	// undo-refs may run from another directory
+	if abs, err := filepath.Abs(repository); err == nil {
+		repository = abs
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ref := range refs {
+		b.Refs = append(b.Refs, backedUpRef{Service: service, Repository: repository, BackupRef: ref})
+	}
+	if b.path == "" || len(refs) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(b.path, data, 0644)
+}
+
+// backUpRefs records the refs of the service saved before a destructive step. It
+// returns false, failing the service, if they could not be saved: nothing is deleted
+// or overwritten without a backup.
+func (d *deployment) backUpRefs(service string, refs []git.BackupRef, err error) bool {
+	if err == nil {
+		err = d.backup.add(service, d.backupDirs[service], refs)
+	}
+	if err != nil {
+		d.failService(service, exitFailure, "Failed to back up the refs of %s, nothing was deleted: %v", service, err)
+		return false
+	}
+	for _, ref := range refs {
+		where := "local"
+		if ref.Remote {
+			where = "origin"
+		}
+		d.logFor(service).Infof("  Backed up %s %s (%s) as %s", where, ref.Ref, shortSHA(ref.SHA), ref.Backup)
+	}
+	if len(refs) > 0 && d.backup.path != "" {
+		d.backupOnce.Do(func() {
+			logger.Noticef("  Refs replaced by this run are listed in %s; restore them with: %s undo-refs -d %s -id %s",
+				d.backup.path, os.Args[0], d.directory, d.backup.ID)
+		})
+	}
+	return true
+}
+
+// shortSHA abbreviates a commit or tag object ID for the log
+func shortSHA(sha string) string {
+	if len(sha) > 10 {
+		return sha[:10]
+	}
+	return sha
+}
+
+// loadRefBackup reads the ref backup list with the id, or the latest one if id is empty
+func loadRefBackup(dir, id string) (*refBackup, error) {
+	path := backupFileName(dir, id)
+	if id == "" {
+		matches, err := filepath.Glob(backupFileName(dir, "*"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no ref backups in %s", dir)
+		}
+		// IDs are timestamps, so the latest sorts last
+		path = matches[len(matches)-1]
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &refBackup{path: path}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return b, nil
+}
+
+// runUndoRefs implements "deploy undo-refs": restores the branches and tags a
+// deployment deleted or force-pushed, locally and on origin, from their backups
+func runUndoRefs(args []string) {
+	fs := flag.NewFlagSet("undo-refs", flag.ExitOnError)
+	var logOpts logFlags
+	logOpts.register(fs)
+	var (
+		directory string
+		id        string
+		assumeYes bool
+		dryRun    bool
+	)
+	fs.StringVar(&directory, "directory", "", "Base directory for services, where the ref backups are listed (required)")
+	fs.StringVar(&directory, "d", "", "Base directory for services (shorthand)")
+	fs.StringVar(&id, "id", "", "Backup to restore, as printed by the deployment (default: the latest)")
+	fs.BoolVar(&assumeYes, "yes", false, "Restore without asking for confirmation")
+	fs.BoolVar(&dryRun, "dry-run", false, "Only show which refs would be restored")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s undo-refs [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Restores the branches and tags a deployment deleted or force-pushed.\n\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExample:\n")
+		fmt.Fprintf(os.Stderr, "  %s undo-refs -d /path/to/services -id 20240520-101500\n", os.Args[0])
+	}
+	fs.Parse(args)
+	logOpts.apply()
+
+	if directory == "" {
+		logger.Exitf(exitConfig, "Error: -directory parameter is required\n\nUse -h for help")
+	}
+	b, err := loadRefBackup(directory, id)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+	if len(b.Refs) == 0 {
+		logger.Infof("Backup %s of version %s has no refs", b.ID, b.Version)
+		return
+	}
+
+	if dryRun {
+		plan.Enable()
+		logger.Infof("*** DRY RUN: no refs will be restored ***")
+	}
+
+	logger.Infof("Backup %s of version %s, made %s:", b.ID, b.Version, b.CreatedAt.Format("2006-01-02 15:04:05"))
+	for _, ref := range b.Refs {
+		where := "local"
+		if ref.Remote {
+			where = "origin"
+		}
+		logger.Infof("  %-20s %-6s %s -> %s", ref.Service, where, ref.Ref, shortSHA(ref.SHA))
+	}
+
+	if !assumeYes && !plan.Enabled() {
+		fmt.Print("\nRestore these refs, overwriting their current values? (y/n): ")
+		response, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		response = strings.TrimSpace(strings.ToLower(response))
+		if response != "y" && response != "yes" {
+			logger.Exitf(exitAborted, "Restore cancelled by user")
+		}
+	}
+
+	failed := 0
+	for _, ref := range b.Refs {
+		if err := git.RestoreRef(ref.Repository, ref.BackupRef); err != nil {
+			failed++
+			logger.Errorf("  Failed to restore %s of %s: %v", ref.Ref, ref.Service, err)
+			continue
+		}
+		logger.Infof("  Restored %s of %s", ref.Ref, ref.Service)
+	}
+
+	if plan.Enabled() {
+		logger.Infof("\nDry run completed, no refs were restored.")
+		return
+	}
+	if failed > 0 {
+		logger.Exitf(exitPush, "Failed to restore %d ref(s)", failed)
+	}
+	logger.Noticef("\nRestored %d ref(s) of backup %s", len(b.Refs), b.ID)
+}
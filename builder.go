@@ -0,0 +1,196 @@
+package main
+
+import (
+	"deploy/config"
+	"deploy/gradle"
+	"deploy/logger"
+	"deploy/maven"
+	"deploy/npm"
+	"deploy/version"
+)
+
+// builder updates the version of a service and builds it with its build tool
+type builder interface {
+	// tool is the build tool name, as in the build key of a service
+	tool() string
+	updateVersion(d *deployment, service string) error
+	// cleanCache removes the company artifacts from the cache of the tool, once per run
+	cleanCache(cachePath string) error
+	// build writes the build output to log and returns the number of failed tests of
+	// a build with test mode warn
+	build(d *deployment, service string, log *logger.Logger) (int, error)
+	// sbom writes the CycloneDX SBOM of the built service to file (-sbom)
+	sbom(d *deployment, service, file string, log *logger.Logger) error
+	// projectVersion returns the version the build files in dir declare, as the
+	// -bump fallback for services without release tags
+	projectVersion(dir string) (version.Version, bool, error)
+}
+
+// builders of the build tools services can declare
+var builders = map[string]builder{
+	config.BuildMaven:  mavenBuilder{},
+	config.BuildGradle: gradleBuilder{},
+	config.BuildNpm:    npmBuilder{manager: npm.ManagerNpm},
+	config.BuildYarn:   npmBuilder{manager: npm.ManagerYarn},
+}
+
+// builderFor returns the builder of the service
+func (d *deployment) builderFor(service string) builder {
+	if b, ok := builders[d.buildTools[service]]; ok {
+		return b
+	}
+	return mavenBuilder{}
+}
+
+// testModeFlag is the -run-tests flag: alone it runs the tests, -run-tests=warn runs
+// them without failing the build
+type testModeFlag struct {
+	mode string
+}
+
+func (f *testModeFlag) String() string {
+	return f.mode
+}
+
+func (f *testModeFlag) Set(value string) error {
+	mode, err := config.ParseTestMode(value)
+	if err != nil {
+		return err
+	}
+	f.mode = mode
+	return nil
+}
+
+func (f *testModeFlag) IsBoolFlag() bool {
+	return true
+}
+
+// testMode returns the test mode of the service: its run_tests, or the -run-tests mode
+func testMode(service config.Service, flagMode string) string {
+	// An empty run_tests does not parse; invalid values are rejected by loadConfig
+	if mode, err := config.ParseTestMode(service.RunTests); err == nil {
+		return mode
+	}
+	if flagMode != "" {
+		return flagMode
+	}
+	return config.TestsSkip
+}
+
+type mavenBuilder struct{}
+
+func (mavenBuilder) tool() string { return config.BuildMaven }
+
+func (mavenBuilder) updateVersion(d *deployment, service string) error {
+	// Convert config exclusions to maven exclusions
+	var excludeArtifacts []maven.ArtifactExclusion
+	for _, excl := range d.cfg.SkipVersionUpdate {
+		excludeArtifacts = append(excludeArtifacts, maven.ArtifactExclusion{
+			GroupID:    excl.GroupID,
+			ArtifactID: excl.ArtifactID,
+		})
+	}
+	return maven.UpdatePomFiles(d.serviceDirs[service], d.versionFor(service), d.propertyPatterns, excludeArtifacts, d.cfg.SkipProperties, d.mavenBuilds[service].Invocation)
+}
+
+func (mavenBuilder) cleanCache(cachePath string) error {
+	return maven.CleanCache(cachePath)
+}
+
+func (mavenBuilder) build(d *deployment, service string, log *logger.Logger) (int, error) {
+	opts := d.mavenBuilds[service]
+	opts.RunTests = d.testModes[service] != config.TestsSkip
+	opts.IgnoreTestFailures = d.testModes[service] == config.TestsWarn
+	opts.Log = log
+	if opts.JavaHome != "" {
+		log.Infof("  JDK: %s", opts.JavaHome)
+	}
+	var failed int
+	var err error
+	if d.meshServices[service] {
+		log.Infof("  This is a GraphQL Mesh service, using special build sequence...")
+		failed, err = maven.BuildMeshService(d.serviceDirs[service], opts)
+	} else {
+		failed, err = maven.BuildService(d.serviceDirs[service], opts)
+	}
+	if err != nil || d.mavenDeploy == nil {
+		return failed, err
+	}
+	log.Infof("  Deploying the artifacts of %s...", service)
+	return failed, maven.DeployService(d.serviceDirs[service], opts, *d.mavenDeploy)
+}
+
+func (mavenBuilder) sbom(d *deployment, service, file string, log *logger.Logger) error {
+	return maven.GenerateSBOM(d.serviceDirs[service], d.mavenBuilds[service].Invocation, file)
+}
+
+func (mavenBuilder) projectVersion(dir string) (version.Version, bool, error) {
+	return maven.ProjectVersion(dir)
+}
+
+type gradleBuilder struct{}
+
+func (gradleBuilder) tool() string { return config.BuildGradle }
+
+func (gradleBuilder) updateVersion(d *deployment, service string) error {
+	return gradle.UpdateVersionFiles(d.serviceDirs[service], d.versionFor(service), d.propertyPatterns, d.cfg.SkipProperties)
+}
+
+func (gradleBuilder) cleanCache(cachePath string) error {
+	return gradle.CleanCache(cachePath)
+}
+
+func (gradleBuilder) build(d *deployment, service string, log *logger.Logger) (int, error) {
+	mode := d.testModes[service]
+	return gradle.BuildService(d.serviceDirs[service], mode != config.TestsSkip, mode == config.TestsWarn, log)
+}
+
+func (gradleBuilder) sbom(d *deployment, service, file string, log *logger.Logger) error {
+	return gradle.GenerateSBOM(d.serviceDirs[service], file, log)
+}
+
+func (gradleBuilder) projectVersion(dir string) (version.Version, bool, error) {
+	return gradle.ProjectVersion(dir)
+}
+
+// npmBuilder builds frontend services with npm or yarn
+type npmBuilder struct {
+	manager string
+}
+
+func (b npmBuilder) tool() string { return b.manager }
+
+func (npmBuilder) updateVersion(d *deployment, service string) error {
+	return npm.UpdateVersionFiles(d.serviceDirs[service], d.versionFor(service))
+}
+
+// cleanCache has nothing to clean: npm ci and yarn --frozen-lockfile install the
+// locked versions, never a stale snapshot
+func (npmBuilder) cleanCache(cachePath string) error {
+	return nil
+}
+
+// build does not run tests: run_tests applies to the Java builds
+func (b npmBuilder) build(d *deployment, service string, log *logger.Logger) (int, error) {
+	return 0, npm.BuildService(d.serviceDirs[service], b.manager, log)
+}
+
+func (npmBuilder) sbom(d *deployment, service, file string, log *logger.Logger) error {
+	return npm.GenerateSBOM(d.serviceDirs[service], file)
+}
+
+func (npmBuilder) projectVersion(dir string) (version.Version, bool, error) {
+	return npm.ProjectVersion(dir)
+}
+
+// deployTarget returns the repositories of -maven-deploy from the config, nil if it is off
+func deployTarget(cfg *config.Config, enabled bool) *maven.DeployTarget {
+	if !enabled {
+		return nil
+	}
+	repos := cfg.MavenDeploy
+	return &maven.DeployTarget{
+		Release:  maven.Repository{ID: repos.ReleaseRepository.ID, URL: repos.ReleaseRepository.URL},
+		Snapshot: maven.Repository{ID: repos.SnapshotRepository.ID, URL: repos.SnapshotRepository.URL},
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+// forEachBuild runs fn for the services of the build phase. Without depends_on in the
+// config they are built one after another in deployment order: the order may be what
+// makes the artifacts of one service available to the next. With depends_on a service
+// starts once the services it depends on are built, up to d.concurrency at once, and
+// fails without building if one of them failed.
+func (d *deployment) forEachBuild(services []string, fn func(service string)) {
+	if !d.cfg.HasDependencies() {
+		for _, service := range services {
+			fn(service)
+		}
+		return
+	}
+
+	limit := d.concurrency
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > 1 {
+		d.parallel = true
+		defer func() { d.parallel = false }()
+	}
+
+	// Only services of this run are waited for: the others are not rebuilt
+	inRun := make(map[string]bool)
+	for _, service := range d.services {
+		inRun[service] = true
+	}
+
+	built := make(map[string]bool)
+	results := make(chan string, len(services))
+	pending := services
+	running := 0
+	for {
+		var waiting []string
+		for _, service := range pending {
+			ready, failedDep := true, ""
+			for _, dep := range d.dependsOn[service] {
+				if !inRun[dep] {
+					continue
+				}
+				if _, failed := d.failure(dep); failed {
+					failedDep = dep
+					break
+				}
+				if !built[dep] {
+					ready = false
+				}
+			}
+			switch {
+			case failedDep != "":
+				d.failService(service, exitBuild, "Build of %s skipped: dependency %s failed", service, failedDep)
+			case !ready || running == limit:
+				waiting = append(waiting, service)
+			case limit == 1:
+				running++
+				fn(service)
+				results <- service
+			default:
+				running++
+				go func(service string) {
+					fn(service)
+					results <- service
+				}(service)
+			}
+		}
+		pending = waiting
+
+		if running == 0 {
+			// Nothing runs and nothing can start: only possible with a dependency cycle,
+			// which loadConfig rejects
+			for _, service := range pending {
+				d.failService(service, exitBuild, "Build of %s skipped: its dependencies were not built", service)
+			}
+			return
+		}
+		service := <-results
+		running--
+		if _, failed := d.failure(service); !failed {
+			built[service] = true
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"deploy/config"
+	"deploy/logger"
+)
+
+func TestForEachBuild(t *testing.T) {
+	tests := []struct {
+		name        string
+		services    []string
+		dependsOn   map[string][]string
+		concurrency int
+		fail        string   // service whose build fails
+		wantSkipped []string // services failed without building
+		wantMax     int      // builds running at once
+		wantOrder   []string // order of the builds, checked if set
+	}{
+		{
+			name:        "dependencies first",
+			services:    []string{"api", "lib", "core", "web"},
+			dependsOn:   map[string][]string{"api": {"lib", "core"}, "web": {"api"}},
+			concurrency: 2,
+			wantMax:     2,
+		},
+		{
+			name:        "concurrency limit",
+			services:    []string{"a", "b", "c", "d", "e", "f"},
+			dependsOn:   map[string][]string{"f": {"a"}},
+			concurrency: 3,
+			wantMax:     3,
+		},
+		{
+			name:        "failed dependency",
+			services:    []string{"lib", "api", "web", "auth"},
+			dependsOn:   map[string][]string{"api": {"lib"}, "web": {"api"}},
+			concurrency: 2,
+			fail:        "lib",
+			wantSkipped: []string{"api", "web"},
+			wantMax:     2,
+		},
+		{
+			name:        "one worker",
+			services:    []string{"web", "api", "lib"},
+			dependsOn:   map[string][]string{"api": {"lib"}},
+			concurrency: 1,
+			wantMax:     1,
+			wantOrder:   []string{"web", "lib", "api"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			for _, service := range tt.services {
+				cfg.Sequential = append(cfg.Sequential, config.Service{Name: service, DependsOn: tt.dependsOn[service]})
+			}
+			d := &deployment{
+				cfg:         cfg,
+				services:    tt.services,
+				dependsOn:   tt.dependsOn,
+				concurrency: tt.concurrency,
+				keepGoing:   true,
+				phase:       "build",
+				log:         logger.With("phase", "build"),
+				failures:    make(map[string]serviceFailure),
+			}
+
+			var mu sync.Mutex
+			var order []string
+			finished := make(map[string]bool)
+			running, maxRunning := 0, 0
+			d.forEachBuild(tt.services, func(service string) {
+				mu.Lock()
+				for _, dep := range tt.dependsOn[service] {
+					if !finished[dep] {
+						t.Errorf("%s started before its dependency %s was built", service, dep)
+					}
+				}
+				order = append(order, service)
+				running++
+				maxRunning = max(maxRunning, running)
+				mu.Unlock()
+
+				// Long enough for the other ready builds to start
+				time.Sleep(20 * time.Millisecond)
+
+				mu.Lock()
+				running--
+				finished[service] = true
+				mu.Unlock()
+				if service == tt.fail {
+					d.failService(service, exitBuild, "Build failed for service %s", service)
+				}
+			})
+
+			if maxRunning != tt.wantMax {
+				t.Errorf("%d builds ran at once, want %d", maxRunning, tt.wantMax)
+			}
+			if tt.wantOrder != nil && !reflect.DeepEqual(order, tt.wantOrder) {
+				t.Errorf("builds ran in order %v, want %v", order, tt.wantOrder)
+			}
+			skipped := make(map[string]bool)
+			for _, service := range tt.wantSkipped {
+				skipped[service] = true
+				if finished[service] {
+					t.Errorf("%s was built, want it skipped", service)
+				}
+				if f, ok := d.failure(service); !ok || f.code != exitBuild {
+					t.Errorf("failure of %s = %+v, %v, want exit code %d", service, f, ok, exitBuild)
+				}
+			}
+			for _, service := range tt.services {
+				if !skipped[service] && !finished[service] {
+					t.Errorf("%s was not built", service)
+				}
+			}
+		})
+	}
+}
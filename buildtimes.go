@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"deploy/logger"
+	"deploy/plan"
+)
+
+// buildTime is when the build of a service started and finished
+type buildTime struct {
+	started, finished time.Time
+}
+
+// buildTiming is a row of the build timing summary of the report
+type buildTiming struct {
+	Service    string    `json:"service"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Seconds    float64   `json:"seconds"`
+	Percent    float64   `json:"percent"` // share of the build time of all services
+}
+
+// buildTimings returns the builds of the services of a report, longest first
+func buildTimings(services []serviceReport) []buildTiming {
+	var timings []buildTiming
+	total := 0.0
+	for _, svc := range services {
+		if svc.BuildStartedAt == nil || svc.BuildFinishedAt == nil {
+			continue
+		}
+		timings = append(timings, buildTiming{
+			Service:    svc.Name,
+			StartedAt:  *svc.BuildStartedAt,
+			FinishedAt: *svc.BuildFinishedAt,
+			Seconds:    svc.BuildSeconds,
+		})
+		total += svc.BuildSeconds
+	}
+	sort.SliceStable(timings, func(i, j int) bool { return timings[i].Seconds > timings[j].Seconds })
+	if total > 0 {
+		for i := range timings {
+			timings[i].Percent = math.Round(timings[i].Seconds/total*1000) / 10
+		}
+	}
+	return timings
+}
+
+// printBuildTimes prints the builds of this run, longest first, with their share of
+// the total build time and when they started after the first build: the services that
+// dominate the build, and how much the parallel builds overlapped
+func (d *deployment) printBuildTimes() {
+	if plan.Enabled() {
+		return
+	}
+	d.failMu.Lock()
+	times := make(map[string]buildTime, len(d.buildTimes))
+	for service, t := range d.buildTimes {
+		times[service] = t
+	}
+	d.failMu.Unlock()
+	if len(times) == 0 {
+		return
+	}
+
+	services := make([]string, 0, len(times))
+	var total time.Duration
+	var first, last time.Time
+	for service, t := range times {
+		services = append(services, service)
+		total += t.finished.Sub(t.started)
+		if first.IsZero() || t.started.Before(first) {
+			first = t.started
+		}
+		if t.finished.After(last) {
+			last = t.finished
+		}
+	}
+	sort.Slice(services, func(i, j int) bool {
+		di := times[services[i]].finished.Sub(times[services[i]].started)
+		dj := times[services[j]].finished.Sub(times[services[j]].started)
+		if di != dj {
+			return di > dj
+		}
+		return services[i] < services[j]
+	})
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tDURATION\tSHARE\tSTARTED")
+	for _, service := range services {
+		t := times[service]
+		duration := t.finished.Sub(t.started)
+		share := 0.0
+		if total > 0 {
+			share = float64(duration) / float64(total) * 100
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.0f%%\t+%s\n", service, duration.Round(time.Second), share, t.started.Sub(first).Round(time.Second))
+	}
+	w.Flush()
+
+	logger.Infof("\nBuild times (total %s, wall clock %s):", total.Round(time.Second), last.Sub(first).Round(time.Second))
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		logger.Infof("  %s", line)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"deploy/config"
+	"deploy/git"
+	"deploy/logger"
+	"deploy/tui"
+)
+
+// cloneOptions controls what happens to service repositories missing from -directory
+type cloneOptions struct {
+	clone     bool   // -clone: clone without asking
+	protocol  string // -clone-protocol: ssh or https
+	assumeYes bool
+}
+
+// ensureRepository clones the repository of a service that does not exist yet from its
+// GitLab project: with -clone, or when the user agrees. Otherwise the deployment stops,
+// as it did before repositories could be cloned.
+func ensureRepository(svc config.Service, repoDir string, opts cloneOptions) {
+	if _, err := os.Stat(repoDir); !os.IsNotExist(err) {
+		return
+	}
+
+	remote, err := git.CloneURL(os.Getenv("GITLAB_URI"), svc.GitlabProject, opts.protocol)
+	if err != nil {
+		logger.Exitf(exitConfig, "Service directory does not exist: %s (cannot clone it: %v)", repoDir, err)
+	}
+
+	if !opts.clone {
+		interactive := !opts.assumeYes && tui.IsTerminal(os.Stdin) && tui.IsTerminal(os.Stdout)
+		if !interactive {
+			logger.Exitf(exitConfig, "Service directory does not exist: %s (use -clone to clone it from %s)", repoDir, remote)
+		}
+		fmt.Printf("\nService directory does not exist: %s\nClone %s from %s? (y/n): ", repoDir, svc.Name, remote)
+		response, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		response = strings.TrimSpace(strings.ToLower(response))
+		if response != "y" && response != "yes" {
+			logger.Exitf(exitConfig, "Service directory does not exist: %s", repoDir)
+		}
+	}
+
+	logger.Infof("Cloning %s from %s into %s...", svc.Name, remote, repoDir)
+	token := ""
+	if opts.protocol == git.CloneHTTPS {
+		token = os.Getenv("GITLAB_TOKEN")
+	}
+	if err := git.Clone(remote, repoDir, token); err != nil {
+		logger.Exitf(exitFailure, "Failed to clone %s: %v", svc.Name, err)
+	}
+}
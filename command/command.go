@@ -0,0 +1,214 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"deploy/audit"
+	"deploy/logger"
+)
+
+var (
+	mu          sync.Mutex
+	timeouts    = make(map[string]time.Duration) // by program ("git") or program and subcommand ("git pull")
+	phase       string                           // running phase with a timeout
+	phaseCtx    = context.Background()           // expires when the phase timeout does
+	phaseTTL    time.Duration
+	phaseCancel context.CancelFunc
+)
+
+// Cmd is an external command that is traced with -debug, recorded in the audit log
+// and killed when its timeout or the timeout of the running phase expires
+type Cmd struct {
+	*exec.Cmd
+	Timeout time.Duration // 0 means no limit of its own
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	phase    string
+	phaseTTL time.Duration
+	timedOut bool
+}
+
+// TimeoutError is returned when a command was killed because of a timeout
+type TimeoutError struct {
+	Command string
+	Dir     string
+	Timeout time.Duration
+	Phase   string // set if the phase timeout expired
+}
+
+func (e *TimeoutError) Error() string {
+	where := ""
+	if e.Dir != "" {
+		where = " in " + e.Dir
+	}
+	if e.Phase != "" {
+		return fmt.Sprintf("%s%s killed: phase %s timed out after %s", e.Command, where, e.Phase, e.Timeout)
+	}
+	return fmt.Sprintf("%s%s timed out after %s", e.Command, where, e.Timeout)
+}
+
+// SetTimeouts sets the timeouts of commands, keyed by program ("mvn") or by program
+// and subcommand ("git pull"); the more specific key wins
+func SetTimeouts(limits map[string]time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	timeouts = make(map[string]time.Duration)
+	for key, limit := range limits {
+		timeouts[strings.Join(strings.Fields(key), " ")] = limit
+	}
+}
+
+// StartPhase limits the commands run until EndPhase is called to the phase timeout.
+// A zero timeout does not limit the phase.
+func StartPhase(name string, timeout time.Duration) {
+	EndPhase()
+	if timeout <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	mu.Lock()
+	phase, phaseCtx, phaseTTL, phaseCancel = name, ctx, timeout, cancel
+	mu.Unlock()
+}
+
+// EndPhase lifts the timeout of the running phase
+func EndPhase() {
+	mu.Lock()
+	defer mu.Unlock()
+	if phaseCancel != nil {
+		phaseCancel()
+	}
+	phase, phaseCtx, phaseTTL, phaseCancel = "", context.Background(), 0, nil
+}
+
+// New creates the command like exec.Command
+func New(name string, args ...string) *Cmd {
+	mu.Lock()
+	parent, phaseName, ttl := phaseCtx, phase, phaseTTL
+	timeout := timeoutOf(name, args)
+	mu.Unlock()
+
+	ctx, cancel := context.WithCancel(parent)
+	cmd := exec.CommandContext(ctx, name, args...)
+	killGroup(cmd)
+	// Children that escaped the kill may keep the output of the command open
+	cmd.WaitDelay = 5 * time.Second
+	return &Cmd{
+		Cmd:      cmd,
+		Timeout:  timeout,
+		ctx:      ctx,
+		cancel:   cancel,
+		phase:    phaseName,
+		phaseTTL: ttl,
+	}
+}
+
+// TimeoutOf returns the timeout of the program with the arguments, for a command run
+// under another name, like a wrapper script of the program
+func TimeoutOf(name string, args ...string) time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+	return timeoutOf(name, args)
+}
+
+// timeoutOf returns the timeout of the command; mu must be held
+func timeoutOf(name string, args []string) time.Duration {
+	timeout := timeouts[name]
+	if len(args) > 0 {
+		if limit, ok := timeouts[name+" "+args[0]]; ok {
+			timeout = limit
+		}
+	}
+	return timeout
+}
+
+// Run runs the command like exec.Cmd.Run
+func (c *Cmd) Run() error {
+	return c.run(c.Cmd.Run)
+}
+
+// Output runs the command like exec.Cmd.Output
+func (c *Cmd) Output() ([]byte, error) {
+	var output []byte
+	err := c.run(func() error {
+		var err error
+		output, err = c.Cmd.Output()
+		return err
+	})
+	traceOutput(output)
+	return output, err
+}
+
+// CombinedOutput runs the command like exec.Cmd.CombinedOutput
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	var output []byte
+	err := c.run(func() error {
+		var err error
+		output, err = c.Cmd.CombinedOutput()
+		return err
+	})
+	traceOutput(output)
+	return output, err
+}
+
+// run executes the command with its timeout, records it and turns a kill
+// caused by a timeout into a TimeoutError
+func (c *Cmd) run(execute func() error) error {
+	defer c.cancel()
+	c.trace()
+
+	if c.Timeout > 0 {
+		timer := time.AfterFunc(c.Timeout, func() {
+			mu.Lock()
+			c.timedOut = true
+			mu.Unlock()
+			c.cancel()
+		})
+		defer timer.Stop()
+	}
+
+	start := time.Now()
+	err := execute()
+	audit.RecordCommand(c.Cmd, start, err)
+	if err == nil {
+		return nil
+	}
+
+	mu.Lock()
+	timedOut := c.timedOut
+	mu.Unlock()
+	name := audit.Redact(strings.Join(c.Args, " "))
+	switch {
+	case timedOut:
+		return &TimeoutError{Command: name, Dir: c.Dir, Timeout: c.Timeout}
+	case c.phase != "" && c.ctx.Err() != nil:
+		return &TimeoutError{Command: name, Dir: c.Dir, Timeout: c.phaseTTL, Phase: c.phase}
+	}
+	return err
+}
+
+// trace prints the command about to run at debug level (-debug)
+func (c *Cmd) trace() {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = audit.Redact(arg)
+	}
+	if c.Dir != "" {
+		logger.Debugf("$ %s (in %s)", strings.Join(args, " "), c.Dir)
+		return
+	}
+	logger.Debugf("$ %s", strings.Join(args, " "))
+}
+
+// traceOutput prints the captured output of a command at debug level
+func traceOutput(output []byte) {
+	if text := strings.TrimRight(string(output), "\n"); text != "" {
+		logger.Debugf("%s", audit.Redact(text))
+	}
+}
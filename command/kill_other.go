@@ -0,0 +1,8 @@
+//go:build !unix
+
+package command
+
+import "os/exec"
+
+// killGroup keeps the default of killing only the command itself
+func killGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"deploy/version"
+)
+
+// commitMessageData is the data the commit_message_template is executed with: the
+// fields of tag_template and the tasks released since the previous release
+type commitMessageData struct {
+	version.RefData
+	Tasks []string // task IDs found in the commits, e.g. ABC-12345
+	Task  string   // the first task ID, empty if there are none
+}
+
+// parseCommitTemplate parses the commit_message_template and tries it on sample data,
+// so that its errors are reported when the configuration is read
+func parseCommitTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("commit_message_template").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("commit_message_template %q: %v", text, err)
+	}
+	sample := commitMessageData{RefData: version.Version{Major: 1}.RefData("service"), Tasks: []string{"ABC-1"}, Task: "ABC-1"}
+	if _, err := executeCommitTemplate(tmpl, sample); err != nil {
+		return nil, fmt.Errorf("commit_message_template %q: %v", text, err)
+	}
+	return tmpl, nil
+}
+
+// executeCommitTemplate returns the commit message, which must not be empty
+func executeCommitTemplate(tmpl *template.Template, data commitMessageData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	message := strings.TrimSpace(b.String())
+	if message == "" {
+		return "", fmt.Errorf("the commit message is empty")
+	}
+	return message, nil
+}
+
+// commitMessage returns the message of the version bump commit of the service
+func (d *deployment) commitMessage(service string) (string, error) {
+	ver := d.versionFor(service)
+	if d.cfg.CommitTemplate == "" {
+		return ver.CommitMessage(), nil
+	}
+	tmpl, err := parseCommitTemplate(d.cfg.CommitTemplate)
+	if err != nil {
+		return "", err
+	}
+	data := commitMessageData{RefData: ver.RefData(service), Tasks: d.releasedTasks(service)}
+	if len(data.Tasks) > 0 {
+		data.Task = data.Tasks[0]
+	}
+	message, err := executeCommitTemplate(tmpl, data)
+	if err != nil {
+		return "", fmt.Errorf("commit_message_template: %v", err)
+	}
+	return message, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"deploy/logger"
+	"deploy/notes"
+)
+
+// runCompare implements "deploy compare": lists, for every service, the commits and task
+// IDs that differ between two releases, e.g. to audit what actually went to production.
+// Only the local repositories are read; nothing is changed.
+func runCompare(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	var logOpts logFlags
+	logOpts.register(fs)
+	var (
+		configFile  string
+		directory   string
+		servicesStr string
+		envName     string
+		fromRef     string
+		toRef       string
+		output      string
+	)
+	fs.StringVar(&configFile, "config", "", "Path to YAML configuration file (default: $DEPLOY_CONFIG or deploy.yaml)")
+	fs.StringVar(&configFile, "c", "", "Path to YAML configuration file (shorthand)")
+	fs.StringVar(&directory, "directory", "", "Base directory for services (required)")
+	fs.StringVar(&directory, "d", "", "Base directory for services (shorthand)")
+	fs.StringVar(&servicesStr, "services", "", "Only these services, comma-separated names or globs")
+	fs.StringVar(&envName, "env", "", "Environment profile from the config, e.g. staging or production")
+	fs.StringVar(&fromRef, "from", "", "Older release: a version (its release tag) or any ref, e.g. 120 or release/120.0 (required)")
+	fs.StringVar(&toRef, "to", "", "Newer release: a version (its release tag) or any ref (required)")
+	fs.StringVar(&output, "output", "", "Write the comparison to this file instead of the standard output")
+	fs.StringVar(&output, "o", "", "Output file (shorthand)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s compare [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Lists the commits and task IDs of every service that differ between two releases.\n\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExample:\n")
+		fmt.Fprintf(os.Stderr, "  %s compare -c deploy.yaml -d /path/to/services -from 120 -to 123\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s compare -c deploy.yaml -d /path/to/services -from release/120.0 -to release/123.0\n", os.Args[0])
+	}
+	fs.Parse(args)
+	logOpts.apply()
+
+	if directory == "" {
+		logger.Exitf(exitConfig, "Error: -directory parameter is required\n\nUse -h for help")
+	}
+	if fromRef == "" || toRef == "" {
+		logger.Exitf(exitConfig, "Error: -from and -to parameters are required\n\nUse -h for help")
+	}
+
+	cfg, _ := loadConfig(configFile, directory, envName, servicesStr)
+
+	var services []notes.Service
+	for _, wc := range workingCopies(cfg, directory) {
+		services = append(services, notes.Service{Name: wc.Name, Dir: wc.Dir})
+	}
+
+	comparison := notes.Compare(services, fromRef, toRef)
+	compared := 0
+	for _, svc := range comparison.Services {
+		if svc.Error != "" {
+			logger.Warnf("Warning: %s not compared: %s", svc.Name, svc.Error)
+			continue
+		}
+		compared++
+	}
+
+	text := notes.RenderComparison(comparison)
+	if output == "" {
+		fmt.Print(text)
+	} else if err := ioutil.WriteFile(output, []byte(text), 0644); err != nil {
+		logger.Fatalf("Failed to write the comparison: %v", err)
+	} else {
+		logger.Noticef("Comparison of %s and %s: %d task(s) added across %d service(s) written to %s",
+			fromRef, toRef, len(comparison.AddedTasks), compared, output)
+	}
+
+	if compared == 0 {
+		logger.Exitf(exitConfig, "Error: no service has both %s and %s (fetch the tags with git fetch --tags?)", fromRef, toRef)
+	}
+}
@@ -1,17 +1,254 @@
 package config
 
 import (
-	"gopkg.in/yaml.v2"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
 )
 
 // Service represents a service configuration
 type Service struct {
-	Name          string `yaml:"name"`
-	Directory     string `yaml:"directory"`
-	GitlabProject string `yaml:"gitlab_project"`
-	IsMesh        bool   `yaml:"is_mesh"`
-	IsLibrary     bool   `yaml:"is_library"`
+	Name            string            `yaml:"name"`
+	Directory       string            `yaml:"directory"`
+	Repository      string            `yaml:"repository"` // git repository of the module, if several services share it
+	GitlabProject   string            `yaml:"gitlab_project"`
+	IsMesh          bool              `yaml:"is_mesh"`
+	IsLibrary       bool              `yaml:"is_library"`
+	Build           string            `yaml:"build"`              // build tool: maven (default), gradle, npm or yarn
+	MavenGoals      []string          `yaml:"maven_goals"`        // overrides maven_goals of the config for this service
+	MavenArgs       []string          `yaml:"maven_args"`         // overrides maven_args of the config for this service
+	Profiles        []string          `yaml:"profiles"`           // Maven profiles activated in addition to -maven-profiles
+	MavenModules    []string          `yaml:"maven_modules"`      // modules of the reactor built with -pl and -am, all if empty
+	MavenSettings   string            `yaml:"maven_settings"`     // overrides maven_settings of the environment and the config
+	MavenCommand    string            `yaml:"maven_command"`      // overrides maven_command of the config for this service
+	MavenOpts       string            `yaml:"maven_opts"`         // MAVEN_OPTS of the mvn calls, after maven_opts of the config, e.g. -Xmx4g
+	JDK             string            `yaml:"jdk"`                // JDK version of the mvn calls, e.g. 17; the JAVA_HOME of the environment if empty
+	RunTests        string            `yaml:"run_tests"`          // true, false or warn; overrides -run-tests for this service
+	BuildRetries    *int              `yaml:"build_retries"`      // overrides build_retries of the config for this service
+	DependsOn       []string          `yaml:"depends_on"`         // services whose build artifacts this service needs
+	Artifacts       []string          `yaml:"artifacts"`          // groupId:artifactId of the Maven artifacts the service releases
+	BaseBranch      string            `yaml:"base_branch"`        // overrides -base-branch for this service
+	VersionOverride string            `yaml:"version_override"`   // own version or template, e.g. 7.{minor}.{patch}
+	Variables       map[string]string `yaml:"variables"`          // extra GitLab pipeline variables
+	PipelineVars    map[string]string `yaml:"pipeline_variables"` // extra GitLab pipeline variables whose values may contain ${ENV} references
+	DeployJob       string            `yaml:"deploy_job"`         // manual job played in the pipeline and waited for instead of "deploy helm"
+	Hooks           []Hook            `yaml:"hooks"`              // commands run in the service directory around phases
+}
+
+// RepositoryDir returns the directory of the git repository of the service, relative to
+// the services directory: the service directory unless it is a module of a shared repository
+func (s Service) RepositoryDir() string {
+	if s.Repository != "" {
+		return s.Repository
+	}
+	return s.Directory
+}
+
+// Build tools of a service
+const (
+	BuildMaven  = "maven"
+	BuildGradle = "gradle"
+	BuildNpm    = "npm"
+	BuildYarn   = "yarn"
+)
+
+// BuildTool returns the build tool of the service, maven unless it declares another one
+func (s Service) BuildTool() string {
+	if s.Build != "" {
+		return s.Build
+	}
+	return BuildMaven
+}
+
+// Test modes of a build
+const (
+	TestsSkip = "skip" // build without running the tests (default)
+	TestsRun  = "run"  // run the tests, a failure fails the build
+	TestsWarn = "warn" // run the tests, failures are reported as a warning
+)
+
+// ParseTestMode returns the test mode of a run_tests or -run-tests value: true, false
+// or warn, with yes/no and on/off as synonyms
+func ParseTestMode(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "on", TestsRun:
+		return TestsRun, nil
+	case "false", "no", "off", TestsSkip:
+		return TestsSkip, nil
+	case TestsWarn:
+		return TestsWarn, nil
+	}
+	return "", fmt.Errorf("invalid test mode %q, expected true, false or warn", value)
+}
+
+// BaseBranchOr returns the base branch configured for the service, or def if there is none
+func (s Service) BaseBranchOr(def string) string {
+	if s.BaseBranch != "" {
+		return s.BaseBranch
+	}
+	return def
+}
+
+// Hook is a shell command run before or after a deployment phase
+type Hook struct {
+	Phase     string `yaml:"phase"`      // phase name, e.g. update-poms
+	When      string `yaml:"when"`       // before or after
+	Run       string `yaml:"run"`        // command, executed with sh -c
+	OnFailure string `yaml:"on_failure"` // fatal (default) or warn
+	Timeout   string `yaml:"timeout"`    // e.g. 5m, overrides the timeout of sh commands
+}
+
+// Hook timing and failure policies
+const (
+	HookBefore = "before"
+	HookAfter  = "after"
+	HookFatal  = "fatal"
+	HookWarn   = "warn"
+)
+
+// Fatal reports whether a failure of the hook stops the deployment
+func (h Hook) Fatal() bool {
+	return h.OnFailure != HookWarn
+}
+
+// Notifications configures where the results of a deployment are sent
+type Notifications struct {
+	Email    *EmailNotification    `yaml:"email"`
+	Telegram *TelegramNotification `yaml:"telegram"`
+}
+
+// TelegramNotification configures the bot posting deployment progress to a chat
+type TelegramNotification struct {
+	BotToken string `yaml:"bot_token"` // $TELEGRAM_BOT_TOKEN if empty
+	ChatID   string `yaml:"chat_id"`
+	Phases   *bool  `yaml:"phases"`  // post phase transitions, true by default
+	APIURL   string `yaml:"api_url"` // Bot API server, https://api.telegram.org by default
+}
+
+// EmailNotification configures the summary email sent at the end of a deployment
+type EmailNotification struct {
+	Host        string   `yaml:"smtp_host"`
+	Port        int      `yaml:"smtp_port"`    // 465 if security is tls, 587 otherwise
+	Security    string   `yaml:"security"`     // tls (default), starttls or none
+	Username    string   `yaml:"username"`     // no authentication if empty
+	PasswordEnv string   `yaml:"password_env"` // environment variable with the password, SMTP_PASSWORD by default
+	From        string   `yaml:"from"`
+	To          []string `yaml:"to"`
+	Subject     string   `yaml:"subject"` // text/template, a default is used if empty
+	Body        string   `yaml:"body"`    // text/template, a default is used if empty
+	// Format of the attached release notes: text (default), markdown, html or json
+	ReleaseNotesFormat string `yaml:"release_notes_format"`
+}
+
+// Timeouts limits how long phases and external commands may run. Values are Go
+// durations such as 90s or 15m; a missing entry means no limit.
+type Timeouts struct {
+	Phases   map[string]string `yaml:"phases"`   // by phase name, e.g. build
+	Commands map[string]string `yaml:"commands"` // by program ("mvn") or program and subcommand ("git push")
+}
+
+// MavenDeploy holds the repositories -maven-deploy deploys the Maven artifacts to.
+// Without them the distributionManagement of the project applies. Credentials are those
+// of the servers with the repository IDs in settings.xml, which may read them from the
+// environment with ${env.NAME}.
+type MavenDeploy struct {
+	ReleaseRepository  Repository `yaml:"release_repository"`
+	SnapshotRepository Repository `yaml:"snapshot_repository"`
+}
+
+// Repository is a Maven repository: the server ID of its credentials and its URL
+type Repository struct {
+	ID  string `yaml:"id"`
+	URL string `yaml:"url"`
+}
+
+// Check returns an error if only one of the ID and URL of a configured repository is set
+func (r Repository) Check() error {
+	if (r.ID == "") != (r.URL == "") {
+		return fmt.Errorf("both id and url must be set, got id %q and url %q", r.ID, r.URL)
+	}
+	return nil
+}
+
+// History limits how much of the history of huge repositories is downloaded
+type History struct {
+	Depth  int    `yaml:"depth"`  // --depth of fetch, pull and clone, the full history if 0
+	Filter string `yaml:"filter"` // --filter of clone, e.g. blob:none for a partial clone
+}
+
+// Requirements are the minimum versions of the tools a deployment runs, checked by
+// deploy validate, e.g. "2.30" or "17". An empty value only requires the tool to be installed.
+type Requirements struct {
+	Git   string `yaml:"git"`
+	Maven string `yaml:"maven"`
+	Java  string `yaml:"java"`
+}
+
+// Tracker is a task tracker whose task IDs are found in commit subjects
+type Tracker struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"` // regular expression of the task IDs, e.g. [A-Z]{2,10}-\d{3,6}
+	URL     string `yaml:"url"`     // URL of a task, {task} is replaced with its ID
+}
+
+// Jira configures reading the tasks of the release notes from Jira
+type Jira struct {
+	URL          string   `yaml:"url"`           // e.g. https://jira.company.com
+	User         string   `yaml:"user"`          // Jira Cloud: account email, the token is an API token
+	TokenEnv     string   `yaml:"token_env"`     // environment variable with the token, JIRA_TOKEN by default
+	DoneStatuses []string `yaml:"done_statuses"` // statuses counted as done besides the done category
+	Trackers     []string `yaml:"trackers"`      // trackers whose tasks are in this Jira, all tasks if empty
+}
+
+// GitlabRelease configures the GitLab releases created for the release tags once the
+// pipelines succeeded
+type GitlabRelease struct {
+	LinkPipelines bool     `yaml:"link_pipelines"` // link the pipelines of the release
+	ArtifactJobs  []string `yaml:"artifact_jobs"`  // jobs whose artifacts are linked, e.g. build
+}
+
+// GitlabAPI configures the retries of the GitLab API requests that failed with a
+// network error, 429 or 5xx. Values are Go durations such as 2s.
+type GitlabAPI struct {
+	Retries       *int   `yaml:"retries"`         // times a failed request is retried, 5 by default
+	RetryDelay    string `yaml:"retry_delay"`     // delay before the first retry, doubled for each next one; 1s if empty
+	MaxRetryDelay string `yaml:"max_retry_delay"` // longest delay between retries, 30s if empty
+}
+
+// BackMerge configures the back-merge phase, which brings the release branch back into
+// the development branch once the pipelines succeeded
+type BackMerge struct {
+	Branch       string `yaml:"branch"`        // target branch, develop by default
+	MergeRequest bool   `yaml:"merge_request"` // open a GitLab merge request instead of merging locally
+}
+
+// TargetBranch returns the branch the release branch is merged into
+func (b BackMerge) TargetBranch() string {
+	if b.Branch != "" {
+		return b.Branch
+	}
+	return "develop"
+}
+
+// ReleaseMergeRequest configures the merge-request phase, which opens a GitLab merge
+// request of the release branch into the production branch once the pipelines succeeded
+type ReleaseMergeRequest struct {
+	Target    string   `yaml:"target"`    // target branch, master by default
+	Reviewers []string `yaml:"reviewers"` // GitLab usernames of the reviewers
+}
+
+// TargetBranch returns the branch the merge requests are opened into
+func (m ReleaseMergeRequest) TargetBranch() string {
+	if m.Target != "" {
+		return m.Target
+	}
+	return "master"
 }
 
 // ArtifactExclusion defines an artifact whose version should not be updated anywhere
@@ -22,10 +259,101 @@ type ArtifactExclusion struct {
 
 // Config represents the deploy configuration with new structure
 type Config struct {
-	SkipVersionUpdate  []ArtifactExclusion  `yaml:"skip_version_update"`
-	SkipProperties     []string             `yaml:"skip_properties"`
-	Sequential         []Service            `yaml:"sequential"`
-	Groups             map[string][]Service `yaml:"groups"`
+	SkipVersionUpdate  []ArtifactExclusion     `yaml:"skip_version_update"`
+	SkipProperties     []string                `yaml:"skip_properties"`
+	PropertyPatterns   []string                `yaml:"property_patterns"`   // properties set to the release version, in addition to -pom-property-pattern
+	UpdateDependencies []string                `yaml:"update_dependencies"` // groupId:artifactId patterns of dependencies set to the release version
+	VersionUpdate      string                  `yaml:"version_update"`      // xml (default), text or versions-plugin
+	MavenGoals         []string                `yaml:"maven_goals"`         // goals of the Maven build, clean install if unset
+	MavenArgs          []string                `yaml:"maven_args"`          // arguments of the Maven build, -DskipTests=true if unset
+	MavenSettings      string                  `yaml:"maven_settings"`      // settings.xml passed with -s to every mvn call, relative to the config
+	MavenCommand       string                  `yaml:"maven_command"`       // auto (default), mvn or mvnw: whether the Maven wrapper of a service runs its mvn calls
+	MavenDeploy        MavenDeploy             `yaml:"maven_deploy"`        // repositories of -maven-deploy
+	MavenOpts          string                  `yaml:"maven_opts"`          // MAVEN_OPTS of every mvn call instead of that of the environment
+	BuildRetries       int                     `yaml:"build_retries"`       // times a failed build is retried, none by default
+	BuildRetryDelay    string                  `yaml:"build_retry_delay"`   // delay before the first retry, doubled for each next one; 30s if empty
+	JDKs               map[string]string       `yaml:"jdks"`                // JDK homes by version for jdk of the services, ~/.m2/toolchains.xml otherwise
+	Sequential         []Service               `yaml:"sequential"`
+	Groups             map[string][]Service    `yaml:"groups"`
+	Environments       map[string]*Environment `yaml:"environments"`
+	Hooks              []Hook                  `yaml:"hooks"` // commands run once in the services directory around phases
+	Notifications      Notifications           `yaml:"notifications"`
+	Timeouts           Timeouts                `yaml:"timeouts"`
+	Requirements       Requirements            `yaml:"requirements"`
+	AnnotatedTags      *bool                   `yaml:"annotated_tags"`          // release tags carry the release metadata, true by default
+	TagTemplate        string                  `yaml:"tag_template"`            // Go template of the release tag names, the plain version if empty
+	BranchTemplate     string                  `yaml:"branch_template"`         // Go template of the release branch names, release-<version> if empty
+	CommitTemplate     string                  `yaml:"commit_message_template"` // Go template of the version bump commit message
+	TaskURL            string                  `yaml:"task_url"`                // tracker URL of a task, {task} is replaced with its ID
+	Trackers           []Tracker               `yaml:"trackers"`                // task ID patterns; ABC-12345 with task_url if empty
+	NotesTemplate      string                  `yaml:"notes_template"`          // text/template file of the text release notes, relative to the config
+	NotesNoMerges      bool                    `yaml:"notes_no_merges"`         // leave merge commits out of the release notes
+	Jira               *Jira                   `yaml:"jira"`                    // summaries and statuses of the tasks in the release notes, off if nil
+	History            History                 `yaml:"history"`
+	BackMerge          *BackMerge              `yaml:"back_merge"`            // merge the release branch back after the pipelines, off if nil
+	GitlabRelease      *GitlabRelease          `yaml:"gitlab_release"`        // create GitLab releases after the pipelines, off if nil
+	MergeRequest       *ReleaseMergeRequest    `yaml:"release_merge_request"` // open merge requests of the release branches after the pipelines, off if nil
+	GitlabAPI          GitlabAPI               `yaml:"gitlab_api"`
+
+	// Env is the environment selected with ApplyEnvironment, nil if none
+	Env *Environment `yaml:"-"`
+}
+
+// Environment is a deployment profile (e.g. staging, production) selected with -env
+type Environment struct {
+	Namespaces    []string                   `yaml:"namespaces"`     // helm namespaces used when -namespace is not given
+	BaseBranch    string                     `yaml:"base_branch"`    // base branch of services without their own base_branch
+	Variables     map[string]string          `yaml:"variables"`      // GitLab pipeline variables of all services
+	Services      []string                   `yaml:"services"`       // names or globs of the services deployed, all if empty
+	Overrides     map[string]ServiceOverride `yaml:"overrides"`      // per-service settings in this environment
+	MavenSettings string                     `yaml:"maven_settings"` // settings.xml of the services without their own
+}
+
+// ServiceOverride changes the settings of a single service in an environment
+type ServiceOverride struct {
+	BaseBranch      string            `yaml:"base_branch"`
+	VersionOverride string            `yaml:"version_override"`
+	Variables       map[string]string `yaml:"variables"`
+	MavenSettings   string            `yaml:"maven_settings"`
+}
+
+// DefaultFileName is the configuration file looked up when neither -config nor DEPLOY_CONFIG is set
+const DefaultFileName = "deploy.yaml"
+
+// ResolvePath finds the configuration file to use. The name comes from the -config flag,
+// then the DEPLOY_CONFIG environment variable, then DefaultFileName. Absolute paths are used
+// as-is; relative ones are searched in the current directory, the services directory
+// and $HOME/.config/deploy, in that order.
+func ResolvePath(name string, directory string) (string, error) {
+	if name == "" {
+		name = os.Getenv("DEPLOY_CONFIG")
+	}
+	if name == "" {
+		name = DefaultFileName
+	}
+
+	if filepath.IsAbs(name) {
+		if _, err := os.Stat(name); err != nil {
+			return "", fmt.Errorf("configuration file does not exist: %s", name)
+		}
+		return name, nil
+	}
+
+	candidates := []string{name}
+	if directory != "" {
+		candidates = append(candidates, filepath.Join(directory, name))
+	}
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(homeDir, ".config", "deploy", name))
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("configuration file %s not found (searched: %s)", name, strings.Join(candidates, ", "))
 }
 
 // ReadYAMLConfig reads and parses the YAML configuration file
@@ -44,6 +372,115 @@ func ReadYAMLConfig(filename string) (*Config, error) {
 	return &config, nil
 }
 
+// CheckSchema reads the configuration file strictly, reporting keys that do not
+// belong to the configuration (typically misspelled ones) and duplicate keys
+func CheckSchema(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var config Config
+	return yaml.UnmarshalStrict(data, &config)
+}
+
+// MavenBuild returns the Maven goals and arguments of the service: its own, or those
+// of the config. Each entry may hold several arguments separated by spaces, like
+// "-T 1C". Nil means the Maven defaults.
+func (c *Config) MavenBuild(s Service) (goals, args []string) {
+	goals, args = c.MavenGoals, c.MavenArgs
+	if s.MavenGoals != nil {
+		goals = s.MavenGoals
+	}
+	if s.MavenArgs != nil {
+		args = s.MavenArgs
+	}
+	return splitArgs(goals), splitArgs(args)
+}
+
+// MavenCommandFor returns the maven_command of the service: its own, or that of the config
+func (c *Config) MavenCommandFor(s Service) string {
+	if s.MavenCommand != "" {
+		return s.MavenCommand
+	}
+	return c.MavenCommand
+}
+
+// MavenOptsFor returns the MAVEN_OPTS of the service: maven_opts of the config followed
+// by its own, so that its -Xmx wins; empty if neither is set
+func (c *Config) MavenOptsFor(s Service) string {
+	return strings.TrimSpace(c.MavenOpts + " " + s.MavenOpts)
+}
+
+// BuildRetriesFor returns the build_retries of the service: its own, or that of the config
+func (c *Config) BuildRetriesFor(s Service) int {
+	if s.BuildRetries != nil {
+		return *s.BuildRetries
+	}
+	return c.BuildRetries
+}
+
+// MavenSettingsFor returns the settings.xml the service is built with: its own, or
+// that of the config; empty for the Maven default
+func (c *Config) MavenSettingsFor(s Service) string {
+	if s.MavenSettings != "" {
+		return s.MavenSettings
+	}
+	return c.MavenSettings
+}
+
+// ResolveMavenSettings turns the relative maven_settings paths of the config, its
+// services and environments into absolute paths relative to dir, the directory of
+// the config file
+func (c *Config) ResolveMavenSettings(dir string) {
+	resolve := func(path *string) {
+		if *path == "" || filepath.IsAbs(*path) {
+			return
+		}
+		// mvn runs in the service directories, so the path must not depend on the working directory
+		*path = filepath.Join(dir, *path)
+		if abs, err := filepath.Abs(*path); err == nil {
+			*path = abs
+		}
+	}
+	resolve(&c.MavenSettings)
+	for i := range c.Sequential {
+		resolve(&c.Sequential[i].MavenSettings)
+	}
+	for _, services := range c.Groups {
+		for i := range services {
+			resolve(&services[i].MavenSettings)
+		}
+	}
+	for _, env := range c.Environments {
+		if env == nil {
+			continue
+		}
+		resolve(&env.MavenSettings)
+		for name, override := range env.Overrides {
+			resolve(&override.MavenSettings)
+			env.Overrides[name] = override
+		}
+	}
+}
+
+// splitArgs splits every entry on spaces, keeping nil and empty lists apart
+func splitArgs(entries []string) []string {
+	if entries == nil {
+		return nil
+	}
+	split := []string{}
+	for _, entry := range entries {
+		split = append(split, strings.Fields(entry)...)
+	}
+	return split
+}
+
+// Annotated reports whether release tags are created as annotated tags
+func (c *Config) UseAnnotatedTags() bool {
+	return c.AnnotatedTags == nil || *c.AnnotatedTags
+}
+
 // GetAllServices returns all services as a flat list with metadata
 func (c *Config) GetAllServices() []ServiceWithMeta {
 	var services []ServiceWithMeta
@@ -71,9 +508,309 @@ func (c *Config) GetAllServices() []ServiceWithMeta {
 	return services
 }
 
+// FilterServices returns a copy of the configuration that contains only the services
+// whose names match one of the patterns (exact names or globs such as "proezd-*").
+// Sequential order and group membership of the remaining services are preserved.
+func (c *Config) FilterServices(patterns []string) (*Config, error) {
+	matched := make(map[string]bool)
+	matches := func(name string) (bool, error) {
+		for _, pattern := range patterns {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return false, fmt.Errorf("invalid service pattern %q: %v", pattern, err)
+			}
+			if ok {
+				matched[pattern] = true
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	filtered := *c
+	filtered.Sequential = nil
+	filtered.Groups = make(map[string][]Service)
+
+	for _, svc := range c.Sequential {
+		ok, err := matches(svc.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			filtered.Sequential = append(filtered.Sequential, svc)
+		}
+	}
+
+	for groupName, groupServices := range c.Groups {
+		for _, svc := range groupServices {
+			ok, err := matches(svc.Name)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				filtered.Groups[groupName] = append(filtered.Groups[groupName], svc)
+			}
+		}
+	}
+
+	for _, pattern := range patterns {
+		if !matched[pattern] {
+			return nil, fmt.Errorf("no service matches %q", pattern)
+		}
+	}
+
+	return &filtered, nil
+}
+
+// ApplyEnvironment returns a copy of the configuration with the named environment applied:
+// only its services are kept and their base branch and pipeline variables are resolved.
+// Precedence, from highest: the environment override of the service, the service itself,
+// the environment defaults.
+func (c *Config) ApplyEnvironment(name string) (*Config, error) {
+	env, ok := c.Environments[name]
+	if !ok || env == nil {
+		var names []string
+		for envName := range c.Environments {
+			names = append(names, envName)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("environment %q is not defined: the configuration has no environments", name)
+		}
+		return nil, fmt.Errorf("environment %q is not defined (available: %s)", name, strings.Join(names, ", "))
+	}
+
+	applied := c
+	if len(env.Services) > 0 {
+		filtered, err := c.FilterServices(env.Services)
+		if err != nil {
+			return nil, fmt.Errorf("environment %q: %v", name, err)
+		}
+		applied = filtered
+	}
+
+	for serviceName := range env.Overrides {
+		if !c.hasService(serviceName) {
+			return nil, fmt.Errorf("environment %q: override for unknown service %q", name, serviceName)
+		}
+	}
+
+	result := *applied
+	result.Env = env
+	result.Sequential = nil
+	for _, svc := range applied.Sequential {
+		result.Sequential = append(result.Sequential, env.resolve(svc))
+	}
+	result.Groups = make(map[string][]Service)
+	for groupName, groupServices := range applied.Groups {
+		for _, svc := range groupServices {
+			result.Groups[groupName] = append(result.Groups[groupName], env.resolve(svc))
+		}
+	}
+	return &result, nil
+}
+
+// resolve returns the effective settings of a service in the environment
+func (e *Environment) resolve(svc Service) Service {
+	override := e.Overrides[svc.Name]
+
+	if override.BaseBranch != "" {
+		svc.BaseBranch = override.BaseBranch
+	} else if svc.BaseBranch == "" {
+		svc.BaseBranch = e.BaseBranch
+	}
+	if override.VersionOverride != "" {
+		svc.VersionOverride = override.VersionOverride
+	}
+	if override.MavenSettings != "" {
+		svc.MavenSettings = override.MavenSettings
+	} else if svc.MavenSettings == "" {
+		svc.MavenSettings = e.MavenSettings
+	}
+
+	variables := make(map[string]string)
+	for _, vars := range []map[string]string{e.Variables, svc.Variables, override.Variables} {
+		for key, value := range vars {
+			variables[key] = value
+		}
+	}
+	svc.Variables = variables
+	return svc
+}
+
+// hasService reports whether a service with the name is configured
+func (c *Config) hasService(name string) bool {
+	for _, svc := range c.GetAllServices() {
+		if svc.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasDependencies reports whether any service declares depends_on
+func (c *Config) HasDependencies() bool {
+	for _, svc := range c.GetAllServices() {
+		if len(svc.DependsOn) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedVariables are the pipeline variables the deployment sets itself
+var reservedVariables = map[string]bool{"CI_PIPELINE_SOURCE": true, "HELM_NAMESPACE": true}
+
+// ExpandPipelineVariables adds the pipeline_variables of every service to its variables,
+// with the environment variables their values refer to, as $NAME or ${NAME}, replaced;
+// $$ is a literal $. An environment variable that is not set, a key that is also in
+// variables and the variables the deployment sets itself are errors.
+func (c *Config) ExpandPipelineVariables() error {
+	expand := func(svc *Service) error {
+		keys := make([]string, 0, len(svc.PipelineVars))
+		for key := range svc.PipelineVars {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if reservedVariables[key] {
+				return fmt.Errorf("%s: pipeline_variables: %s is set by the deployment", svc.Name, key)
+			}
+			if _, ok := svc.Variables[key]; ok {
+				return fmt.Errorf("%s: %s is in both variables and pipeline_variables", svc.Name, key)
+			}
+			var missing []string
+			value := os.Expand(svc.PipelineVars[key], func(name string) string {
+				if name == "$" {
+					return "$"
+				}
+				value, ok := os.LookupEnv(name)
+				if !ok {
+					missing = append(missing, name)
+				}
+				return value
+			})
+			if len(missing) > 0 {
+				return fmt.Errorf("%s: pipeline_variables.%s: environment variable %s is not set", svc.Name, key, strings.Join(missing, ", "))
+			}
+			if svc.Variables == nil {
+				svc.Variables = make(map[string]string)
+			}
+			svc.Variables[key] = value
+		}
+		return nil
+	}
+
+	for i := range c.Sequential {
+		if err := expand(&c.Sequential[i]); err != nil {
+			return err
+		}
+	}
+	groupNames := make([]string, 0, len(c.Groups))
+	for name := range c.Groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+	for _, name := range groupNames {
+		for i := range c.Groups[name] {
+			if err := expand(&c.Groups[name][i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CheckDependencies checks that depends_on only names configured services and has
+// no cycles
+func (c *Config) CheckDependencies() error {
+	deps := make(map[string][]string)
+	var names []string
+	for _, svc := range c.GetAllServices() {
+		for _, dep := range svc.DependsOn {
+			if dep == svc.Name {
+				return fmt.Errorf("%s: depends_on lists the service itself", svc.Name)
+			}
+			if !c.hasService(dep) {
+				return fmt.Errorf("%s: depends_on: unknown service %s", svc.Name, dep)
+			}
+		}
+		deps[svc.Name] = svc.DependsOn
+		names = append(names, svc.Name)
+	}
+	sort.Strings(names)
+
+	// Depth-first search; a service met again while its dependencies are being
+	// visited closes a cycle
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, p := range path {
+				if p == name {
+					return fmt.Errorf("depends_on cycle: %s", strings.Join(append(path[i:], name), " -> "))
+				}
+			}
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range deps[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckArtifacts checks that every artifacts entry is a groupId:artifactId released
+// by a single service
+func (c *Config) CheckArtifacts() error {
+	owners := make(map[string]string)
+	for _, svc := range c.GetAllServices() {
+		for _, artifact := range svc.Artifacts {
+			parts := strings.Split(artifact, ":")
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("%s: invalid artifacts entry %q, expected groupId:artifactId", svc.Name, artifact)
+			}
+			if owner, ok := owners[artifact]; ok && owner != svc.Name {
+				return fmt.Errorf("artifact %s is listed by both %s and %s", artifact, owner, svc.Name)
+			}
+			owners[artifact] = svc.Name
+		}
+	}
+	return nil
+}
+
 // ServiceWithMeta includes service with its execution metadata
 type ServiceWithMeta struct {
 	Service
 	Sequential bool
 	Group      string
 }
+
+// HasTracker reports whether the trackers section has a tracker with the name
+func (c *Config) HasTracker(name string) bool {
+	for _, t := range c.Trackers {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
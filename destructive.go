@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"deploy/git"
+	"deploy/logger"
+	"deploy/plan"
+)
+
+// destructivePhases are the phases that delete or overwrite refs locally and on origin
+var destructivePhases = map[string]bool{
+	"create-branch": true,
+	"tag":           true,
+	"push":          true,
+}
+
+// confirmDestructive shows, before the first destructive phase, everything the selected
+// phases will delete or force-push, with the commits on origin the push would discard,
+// and asks for a single confirmation.
+// -yes and -auto-approve skip the question; dry-run only prints the plan.
+func (d *deployment) confirmDestructive(selected map[int]bool) {
+	d.log.Infof("\nChecking existing release branches and tags...")
+
+	var mu sync.Mutex
+	actions := make(map[string][]string)   // service -> actions
+	discarded := make(map[string][]string) // service -> lines describing the lost commits
+	primaries, _ := d.repositories()
+	d.forEachServiceOf(primaries, func(service string) {
+		list := d.destructiveActions(selected, service)
+		lines := d.discardedCommits(selected, service)
+		mu.Lock()
+		actions[service] = list
+		discarded[service] = lines
+		mu.Unlock()
+	})
+
+	// Group services by action, keeping the order of services and actions
+	var order []string
+	repos := make(map[string][]string)
+	for _, service := range d.services {
+		for _, action := range actions[service] {
+			if repos[action] == nil {
+				order = append(order, action)
+			}
+			repos[action] = append(repos[action], service)
+		}
+	}
+	var rewritten []string
+	for _, service := range primaries {
+		if len(discarded[service]) > 0 {
+			rewritten = append(rewritten, service)
+		}
+	}
+	if len(order) == 0 {
+		return
+	}
+
+	logger.Warnf("\n=== Destructive actions ===")
+	for _, action := range order {
+		logger.Warnf("  Will %s in %d repo(s): %s", action, len(repos[action]), strings.Join(repos[action], ", "))
+	}
+	if len(rewritten) > 0 {
+		logger.Warnf("  The push replaces these refs on origin with the local release; the commits below are lost from them:")
+		for _, service := range rewritten {
+			logger.Warnf("    %s:", service)
+			for _, line := range discarded[service] {
+				logger.Warnf("      %s", line)
+			}
+		}
+	}
+	logger.Warnf("===========================")
+
+	switch {
+	case plan.Enabled():
+		return
+	case d.autoApprove && len(rewritten) > 0:
+		logger.Warnf("Auto-approved: rewriting the history of %s", strings.Join(rewritten, ", "))
+		return
+	case d.autoApprove:
+		logger.Infof("Auto-approved")
+		return
+	}
+
+	response := d.prompt("\nProceed? (y/n): ")
+	if response != "y" && response != "yes" {
+		logger.Exitf(exitAborted, "Deployment cancelled by user")
+	}
+}
+
+// destructiveActions lists what the selected phases not yet completed for the service
+// will delete or force-push in its repository
+func (d *deployment) destructiveActions(selected map[int]bool, service string) []string {
+	dir := d.repoDirs[service]
+	var actions []string
+
+	refs := func(kind string, local, remote []string, err error) {
+		for _, name := range local {
+			actions = append(actions, fmt.Sprintf("delete local %s %s", kind, name))
+		}
+		for _, name := range remote {
+			actions = append(actions, fmt.Sprintf("delete %s %s on origin", kind, name))
+		}
+		if err != nil {
+			d.logFor(service).Warnf("  Warning: could not check %s on origin in %s: %v", kind, service, err)
+		}
+	}
+
+	if selected[phaseNumber("create-branch")] && !d.hotfix && !d.st.Done("create-branch", service) {
+		local, remote, err := git.ExistingBranches(dir, d.version.ServiceBranch(service))
+		refs("branch", local, remote, err)
+	}
+	if selected[phaseNumber("tag")] && !d.st.Done("tag", service) {
+		local, remote, err := git.ExistingTags(dir, d.tagFor(service))
+		refs("tag", local, remote, err)
+	}
+	if selected[phaseNumber("push")] && !d.st.Done("push", service) {
+		actions = append(actions, "force-push (--force-with-lease) the branch and tags to origin")
+	}
+	return actions
+}
+
+// discardedCommits describes the commits on origin that the selected push would discard
+// from the release branch and tag of the service. Refs that an earlier selected phase
+// deletes on origin are already listed as actions; the branch is checked against the local
+// HEAD, which the release only adds commits to. Dry-run skips the check.
+func (d *deployment) discardedCommits(selected map[int]bool, service string) []string {
+	if plan.Enabled() || !selected[phaseNumber("push")] || d.st.Done("push", service) {
+		return nil
+	}
+	dir := d.repoDirs[service]
+	log := d.logFor(service)
+
+	var lines []string
+	check := func(ref, local string) {
+		commits, err := git.DiscardedCommits(dir, ref, local)
+		if err != nil {
+			log.Warnf("  Warning: could not check %s on origin in %s: %v", ref, service, err)
+			return
+		}
+		if len(commits) == 0 {
+			return
+		}
+		lines = append(lines, fmt.Sprintf("%s: %d commit(s) not in the local %s", ref, len(commits), local))
+		for _, c := range commits {
+			lines = append(lines, fmt.Sprintf("  %s %s", shortSHA(c.Hash), c.Subject))
+		}
+	}
+
+	if !selected[phaseNumber("create-branch")] || d.hotfix || d.st.Done("create-branch", service) {
+		branch, err := git.GetCurrentBranch(dir)
+		if err != nil {
+			log.Warnf("  Warning: could not check origin of %s for rewritten history: %v", service, err)
+			return nil
+		}
+		check("refs/heads/"+branch, "HEAD")
+	}
+	if !selected[phaseNumber("tag")] || d.st.Done("tag", service) {
+		if tag := "refs/tags/" + d.tagFor(service); git.RefExists(dir, tag) {
+			check(tag, tag)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,14 @@
+package main
+
+// Exit codes, so that wrapper scripts can tell failure classes apart.
+// Other failures exit with 1.
+const (
+	exitFailure     = 1   // any other error
+	exitConfig      = 2   // invalid flags or configuration (also used by the flag package)
+	exitDirty       = 3   // a working copy has local changes
+	exitBuild       = 4   // Maven build failed
+	exitPush        = 5   // git push failed
+	exitPipeline    = 6   // a GitLab pipeline failed or could not be created
+	exitAborted     = 7   // cancelled by the user at a confirmation prompt
+	exitInterrupted = 130 // stopped with Ctrl+C or SIGTERM
+)
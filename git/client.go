@@ -0,0 +1,346 @@
+package git
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"deploy/command"
+)
+
+// GitClient reads the local state of repositories. Commands that change a repository
+// or talk to origin always run the git binary.
+type GitClient interface {
+	// CurrentBranch returns the name of the checked out branch, HEAD if detached
+	CurrentBranch(dir string) (string, error)
+	// RefExists reports whether a branch, tag or commit resolves to a commit
+	RefExists(dir string, ref string) bool
+	// ResolveCommit returns the full SHA of the commit a branch, tag or commit resolves to
+	ResolveCommit(dir string, ref string) (string, error)
+	// CommitsBetween returns the commits reachable from to but not from from, newest
+	// first; the whole history of to if from is empty
+	CommitsBetween(dir string, from string, to string) ([]CommitInfo, error)
+	// LocalRefs returns the names that exist as refs under the prefix, e.g. refs/tags/
+	LocalRefs(dir string, prefix string, names []string) []string
+}
+
+// client reads the repositories for the functions of the package, see SetClient
+var client GitClient = goGitClient{fallback: execClient{}}
+
+// SetClient replaces the client reading the repositories, e.g. with a fake in tests
+// of other packages
+func SetClient(c GitClient) {
+	client = c
+}
+
+// goGitClient reads repositories with go-git, without starting a git process. What
+// go-git cannot read, e.g. a repository with extensions it does not support or
+// a revision syntax it does not know, is read by the fallback.
+type goGitClient struct {
+	fallback GitClient
+}
+
+// open opens the repository containing dir
+func (c goGitClient) open(dir string) (*gogit.Repository, error) {
+	return gogit.PlainOpenWithOptions(dir, &gogit.PlainOpenOptions{DetectDotGit: true, EnableDotGitCommonDir: true})
+}
+
+// resolve returns the commit a revision points to
+func (c goGitClient) resolve(repo *gogit.Repository, ref string) (*object.Commit, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(strings.TrimSuffix(ref, "^{commit}")))
+	if err != nil {
+		return nil, err
+	}
+	return repo.CommitObject(*hash)
+}
+
+func (c goGitClient) CurrentBranch(dir string) (string, error) {
+	repo, err := c.open(dir)
+	if err != nil {
+		return c.fallback.CurrentBranch(dir)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return c.fallback.CurrentBranch(dir)
+	}
+	if !head.Name().IsBranch() {
+		return "HEAD", nil
+	}
+	return head.Name().Short(), nil
+}
+
+func (c goGitClient) RefExists(dir string, ref string) bool {
+	repo, err := c.open(dir)
+	if err != nil {
+		return c.fallback.RefExists(dir, ref)
+	}
+	if _, err := c.resolve(repo, ref); err != nil {
+		// go-git knows fewer revision forms than rev-parse, e.g. @{upstream}
+		return err != plumbing.ErrReferenceNotFound && c.fallback.RefExists(dir, ref)
+	}
+	return true
+}
+
+func (c goGitClient) ResolveCommit(dir string, ref string) (string, error) {
+	repo, err := c.open(dir)
+	if err != nil {
+		return c.fallback.ResolveCommit(dir, ref)
+	}
+	commit, err := c.resolve(repo, ref)
+	if err == plumbing.ErrReferenceNotFound {
+		return "", fmt.Errorf("failed to resolve %s: %v", ref, err)
+	}
+	if err != nil {
+		return c.fallback.ResolveCommit(dir, ref)
+	}
+	return commit.Hash.String(), nil
+}
+
+func (c goGitClient) CommitsBetween(dir string, from string, to string) ([]CommitInfo, error) {
+	repo, err := c.open(dir)
+	if err != nil {
+		return c.fallback.CommitsBetween(dir, from, to)
+	}
+	commits, err := c.commitsBetween(repo, from, to)
+	if err != nil {
+		// e.g. a shallow history or a revision go-git does not know
+		return c.fallback.CommitsBetween(dir, from, to)
+	}
+	return commits, nil
+}
+
+// commitsBetween lists the commits like git log from..to: by commit date, newest first
+func (c goGitClient) commitsBetween(repo *gogit.Repository, from string, to string) ([]CommitInfo, error) {
+	head, err := c.resolve(repo, to)
+	if err != nil {
+		return nil, err
+	}
+
+	walk := &rangeWalk{seen: make(map[plumbing.Hash]*walkedCommit)}
+	walk.add(head, false)
+	if from != "" {
+		base, err := c.resolve(repo, from)
+		if err != nil {
+			return nil, err
+		}
+		walk.add(base, true)
+	}
+	if err := walk.run(); err != nil {
+		return nil, err
+	}
+
+	var commits []CommitInfo
+	for _, w := range walk.byDate() {
+		if !w.excluded {
+			commits = append(commits, commitInfo(w.commit))
+		}
+	}
+	return commits, nil
+}
+
+// walkSlop is how many commits the walk reads after only excluded ones are left, like
+// git, in case a commit date is older than that of its parent
+const walkSlop = 5
+
+// walkedCommit is a commit the walk of a range has read
+type walkedCommit struct {
+	commit   *object.Commit
+	excluded bool // reachable from the start of the range
+}
+
+// rangeWalk reads the histories of both ends of a range together, newest commit first,
+// and marks the ancestors of the start as excluded. It stops once only excluded commits
+// are left to read, so it reads the commits since the merge base rather than the whole
+// history of the start.
+type rangeWalk struct {
+	seen  map[plumbing.Hash]*walkedCommit
+	queue []*walkedCommit // by commit date, newest first
+	read  []*walkedCommit
+}
+
+// add queues a commit not seen yet; a seen commit reached from an excluded one is excluded
+func (w *rangeWalk) add(commit *object.Commit, excluded bool) {
+	if seen, ok := w.seen[commit.Hash]; ok {
+		if excluded {
+			w.exclude(seen)
+		}
+		return
+	}
+	wc := &walkedCommit{commit: commit, excluded: excluded}
+	w.seen[commit.Hash] = wc
+	i := sort.Search(len(w.queue), func(i int) bool { return w.queue[i].commit.Committer.When.Before(commit.Committer.When) })
+	w.queue = append(w.queue, nil)
+	copy(w.queue[i+1:], w.queue[i:])
+	w.queue[i] = wc
+}
+
+// exclude marks the commit and its ancestors read so far as excluded
+func (w *rangeWalk) exclude(wc *walkedCommit) {
+	pending := []*walkedCommit{wc}
+	for len(pending) > 0 {
+		wc, pending = pending[len(pending)-1], pending[:len(pending)-1]
+		if wc.excluded {
+			continue
+		}
+		wc.excluded = true
+		for _, parent := range wc.commit.ParentHashes {
+			if seen, ok := w.seen[parent]; ok {
+				pending = append(pending, seen)
+			}
+		}
+	}
+}
+
+// run reads the queued commits and their parents until only excluded ones are left
+func (w *rangeWalk) run() error {
+	slop := walkSlop
+	for len(w.queue) > 0 && slop > 0 {
+		wc := w.queue[0]
+		w.queue = w.queue[1:]
+		w.read = append(w.read, wc)
+
+		err := wc.commit.Parents().ForEach(func(parent *object.Commit) error {
+			w.add(parent, wc.excluded)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if w.onlyExcludedLeft() {
+			slop--
+		} else {
+			slop = walkSlop
+		}
+	}
+	return nil
+}
+
+// onlyExcludedLeft reports whether every queued commit is excluded
+func (w *rangeWalk) onlyExcludedLeft() bool {
+	for _, wc := range w.queue {
+		if !wc.excluded {
+			return false
+		}
+	}
+	return true
+}
+
+// byDate returns the commits read, newest first
+func (w *rangeWalk) byDate() []*walkedCommit {
+	sort.SliceStable(w.read, func(i, j int) bool {
+		return w.read[i].commit.Committer.When.After(w.read[j].commit.Committer.When)
+	})
+	return w.read
+}
+
+// commitInfo describes a commit read by go-git like the git log format of execClient:
+// the subject is the first paragraph of the message on one line
+func commitInfo(commit *object.Commit) CommitInfo {
+	subject, body, _ := strings.Cut(strings.TrimLeft(commit.Message, "\n"), "\n\n")
+	subject = strings.Join(strings.Fields(subject), " ")
+	body = strings.TrimSpace(body)
+	return CommitInfo{
+		Hash:          commit.Hash.String(),
+		Merge:         commit.NumParents() > 1,
+		Author:        commit.Author.Name,
+		Date:          commit.Author.When,
+		Subject:       subject,
+		Body:          body,
+		MergeRequests: mergeRequestRefs(subject + "\n" + body),
+	}
+}
+
+func (c goGitClient) LocalRefs(dir string, prefix string, names []string) []string {
+	repo, err := c.open(dir)
+	if err != nil {
+		return c.fallback.LocalRefs(dir, prefix, names)
+	}
+	var found []string
+	for _, n := range names {
+		if _, err := repo.Reference(plumbing.ReferenceName(prefix+n), false); err == nil {
+			found = append(found, n)
+		}
+	}
+	return found
+}
+
+// execClient reads repositories with the git binary
+type execClient struct{}
+
+func (execClient) CurrentBranch(dir string) (string, error) {
+	cmd := command.New("git", "rev-parse", "--abbrev-ref", "HEAD")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current branch: %v: %s", err, output)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (execClient) RefExists(dir string, ref string) bool {
+	cmd := command.New("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	cmd.Dir = dir
+	return cmd.Run() == nil
+}
+
+func (execClient) ResolveCommit(dir string, ref string) (string, error) {
+	cmd := command.New("git", "rev-parse", "--verify", ref+"^{commit}")
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %v: %s", ref, err, output)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (execClient) CommitsBetween(dir string, from string, to string) ([]CommitInfo, error) {
+	rangeSpec := to
+	if from != "" {
+		rangeSpec = from + ".." + to
+	}
+
+	// Fields are separated by US and commits by RS, which do not occur in messages
+	cmd := command.New("git", "log", "--format=%H%x1f%P%x1f%an%x1f%aI%x1f%s%x1f%b%x1e", rangeSpec)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commits %s: %v", rangeSpec, err)
+	}
+
+	var commits []CommitInfo
+	for _, record := range strings.Split(string(output), "\x1e") {
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), "\x1f", 6)
+		if len(fields) != 6 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, fields[3])
+		commits = append(commits, CommitInfo{
+			Hash:          fields[0],
+			Merge:         len(strings.Fields(fields[1])) > 1,
+			Author:        fields[2],
+			Date:          date,
+			Subject:       fields[4],
+			Body:          strings.TrimSpace(fields[5]),
+			MergeRequests: mergeRequestRefs(fields[4] + "\n" + fields[5]),
+		})
+	}
+	return commits, nil
+}
+
+func (execClient) LocalRefs(dir string, prefix string, names []string) []string {
+	var found []string
+	for _, n := range names {
+		cmd := command.New("git", "rev-parse", "--verify", "--quiet", prefix+n)
+		cmd.Dir = dir
+		if cmd.Run() == nil {
+			found = append(found, n)
+		}
+	}
+	return found
+}
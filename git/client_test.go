@@ -0,0 +1,298 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// failingClient fails the test if the go-git client falls back to it
+type failingClient struct {
+	t *testing.T
+}
+
+func (c failingClient) CurrentBranch(dir string) (string, error) {
+	c.t.Fatalf("unexpected fallback: CurrentBranch(%s)", dir)
+	return "", nil
+}
+
+func (c failingClient) RefExists(dir string, ref string) bool {
+	c.t.Fatalf("unexpected fallback: RefExists(%s, %s)", dir, ref)
+	return false
+}
+
+func (c failingClient) ResolveCommit(dir string, ref string) (string, error) {
+	c.t.Fatalf("unexpected fallback: ResolveCommit(%s, %s)", dir, ref)
+	return "", nil
+}
+
+func (c failingClient) CommitsBetween(dir string, from string, to string) ([]CommitInfo, error) {
+	c.t.Fatalf("unexpected fallback: CommitsBetween(%s, %s, %s)", dir, from, to)
+	return nil, nil
+}
+
+func (c failingClient) LocalRefs(dir string, prefix string, names []string) []string {
+	c.t.Fatalf("unexpected fallback: LocalRefs(%s, %s)", dir, prefix)
+	return nil
+}
+
+// testRepo is a repository created with go-git, without the git binary
+type testRepo struct {
+	t    *testing.T
+	dir  string
+	repo *gogit.Repository
+	when time.Time
+}
+
+// newTestRepo creates a repository on branch master with the client of the package
+// set to go-git without fallback
+func newTestRepo(t *testing.T) *testRepo {
+	dir := t.TempDir()
+	repo, err := gogit.PlainInitWithOptions(dir, &gogit.PlainInitOptions{
+		InitOptions: gogit.InitOptions{DefaultBranch: plumbing.NewBranchReferenceName("master")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := client
+	client = goGitClient{fallback: failingClient{t}}
+	t.Cleanup(func() { client = saved })
+
+	return &testRepo{t: t, dir: dir, repo: repo, when: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)}
+}
+
+// commit writes a file and commits it with the message, one minute after the previous
+// commit; extra parents make a merge commit
+func (r *testRepo) commit(message string, parents ...plumbing.Hash) plumbing.Hash {
+	r.t.Helper()
+	file := filepath.Join(r.dir, "file.txt")
+	if err := os.WriteFile(file, []byte(message), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if _, err := wt.Add("file.txt"); err != nil {
+		r.t.Fatal(err)
+	}
+
+	r.when = r.when.Add(time.Minute)
+	opts := &gogit.CommitOptions{Author: &object.Signature{Name: "Dev", Email: "dev@example.com", When: r.when}}
+	if len(parents) > 0 {
+		head, err := r.repo.Head()
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		opts.Parents = append([]plumbing.Hash{head.Hash()}, parents...)
+	}
+	hash, err := wt.Commit(message, opts)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return hash
+}
+
+// checkout switches to the branch, creating it at HEAD if create is set
+func (r *testRepo) checkout(branch string, create bool) {
+	r.t.Helper()
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	err = wt.Checkout(&gogit.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Create: create})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func TestGetCurrentBranch(t *testing.T) {
+	r := newTestRepo(t)
+	first := r.commit("initial")
+	r.checkout("release-1.2", true)
+
+	branch, err := GetCurrentBranch(r.dir)
+	if err != nil || branch != "release-1.2" {
+		t.Errorf("GetCurrentBranch = %q, %v; want release-1.2", branch, err)
+	}
+
+	// A subdirectory of the repository, like the directory of a monorepo module
+	sub := filepath.Join(r.dir, "module")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if branch, err := GetCurrentBranch(sub); err != nil || branch != "release-1.2" {
+		t.Errorf("GetCurrentBranch(subdirectory) = %q, %v; want release-1.2", branch, err)
+	}
+
+	wt, _ := r.repo.Worktree()
+	if err := wt.Checkout(&gogit.CheckoutOptions{Hash: first}); err != nil {
+		t.Fatal(err)
+	}
+	if branch, err := GetCurrentBranch(r.dir); err != nil || branch != "HEAD" {
+		t.Errorf("GetCurrentBranch(detached) = %q, %v; want HEAD", branch, err)
+	}
+}
+
+func TestRefExists(t *testing.T) {
+	r := newTestRepo(t)
+	first := r.commit("initial")
+	r.commit("second")
+	if _, err := r.repo.CreateTag("v1.0.0", first, nil); err != nil {
+		t.Fatal(err)
+	}
+	annotated := &gogit.CreateTagOptions{Message: "Release 1.1.0", Tagger: &object.Signature{Name: "Dev", When: r.when}}
+	if _, err := r.repo.CreateTag("v1.1.0", first, annotated); err != nil {
+		t.Fatal(err)
+	}
+
+	for ref, want := range map[string]bool{
+		"master":                   true,
+		"refs/heads/master":        true,
+		"v1.0.0":                   true,
+		"v1.1.0":                   true, // annotated, peeled to its commit
+		"v1.1.0^{commit}":          true,
+		"HEAD~1":                   true,
+		first.String():             true,
+		first.String()[:10]:        true,
+		"release-9.9":              false,
+		"refs/tags/v2.0.0":         false,
+		"origin/master":            false,
+		plumbing.ZeroHash.String(): false,
+	} {
+		if got := RefExists(r.dir, ref); got != want {
+			t.Errorf("RefExists(%q) = %v, want %v", ref, got, want)
+		}
+	}
+}
+
+func TestResolveCommit(t *testing.T) {
+	r := newTestRepo(t)
+	head := r.commit("initial")
+	if err := r.repo.Storer.SetReference(plumbing.NewHashReference("refs/remotes/origin/release-1.2", head)); err != nil {
+		t.Fatal(err)
+	}
+
+	if hash, err := ResolveCommit(r.dir, "master"); err != nil || hash != head.String() {
+		t.Errorf("ResolveCommit(master) = %q, %v; want %s", hash, err, head)
+	}
+	if hash, err := GetHeadCommit(r.dir); err != nil || hash != head.String()[:8] {
+		t.Errorf("GetHeadCommit = %q, %v; want %s", hash, err, head.String()[:8])
+	}
+	if _, err := ResolveCommit(r.dir, "release-9.9"); err == nil {
+		t.Error("ResolveCommit(release-9.9) succeeded")
+	}
+
+	// Branches are looked up on origin under both separators
+	for _, name := range []string{"release-1.2", "release/1.2"} {
+		if found, ok := FindBranch(r.dir, name); !ok || found != "release-1.2" {
+			t.Errorf("FindBranch(%s) = %q, %v; want release-1.2", name, found, ok)
+		}
+	}
+	if found, ok := FindBranch(r.dir, "master"); ok {
+		t.Errorf("FindBranch(master) = %q, want not found", found)
+	}
+}
+
+func TestGetCommitsBetween(t *testing.T) {
+	r := newTestRepo(t)
+	base := r.commit("initial")
+	r.checkout("feature", true)
+	feature := r.commit("Add billing export\n\nExports invoices as CSV, see !41.")
+	r.checkout("master", false)
+	r.commit("TASK-7 Fix rounding\nof totals")
+	merge := r.commit("Merge branch 'feature'\n\nSee merge request team/billing!42", feature)
+
+	commits, err := GetCommitsBetween(r.dir, base.String(), "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var subjects []string
+	for _, c := range commits {
+		subjects = append(subjects, c.Subject)
+	}
+	// newest first by date: the feature commit is older than the fix on master
+	want := []string{"Merge branch 'feature'", "TASK-7 Fix rounding of totals", "Add billing export"}
+	if !reflect.DeepEqual(subjects, want) {
+		t.Fatalf("subjects = %q, want %q", subjects, want)
+	}
+
+	if c := commits[0]; c.Hash != merge.String() || !c.Merge || !reflect.DeepEqual(c.MergeRequests, []int{42}) {
+		t.Errorf("merge commit = %+v, want hash %s, Merge and merge request 42", c, merge)
+	}
+	if c := commits[2]; c.Merge || c.Body != "Exports invoices as CSV, see !41." || !reflect.DeepEqual(c.MergeRequests, []int{41}) {
+		t.Errorf("feature commit = %+v", c)
+	}
+	if c := commits[1]; c.Author != "Dev" || !c.Date.Equal(time.Date(2024, 3, 1, 10, 3, 0, 0, time.UTC)) {
+		t.Errorf("fix commit author/date = %s/%s", c.Author, c.Date)
+	}
+
+	all, err := GetCommitsBetween(r.dir, "", "master")
+	if err != nil || len(all) != 4 {
+		t.Errorf("GetCommitsBetween(whole history) = %d commits, %v; want 4", len(all), err)
+	}
+
+	none, err := GetCommitsBetween(r.dir, "master", "feature")
+	if err != nil || len(none) != 0 {
+		t.Errorf("GetCommitsBetween(master..feature) = %d commits, %v; want none", len(none), err)
+	}
+}
+
+func TestExistingRefs(t *testing.T) {
+	r := newTestRepo(t)
+	head := r.commit("initial")
+	if _, err := r.repo.CreateTag("release-1.2.0", head, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/release/1.2", head)); err != nil {
+		t.Fatal(err)
+	}
+
+	// origin is missing, so only the local forms are known
+	if local, _, _ := ExistingTags(r.dir, "release/1.2.0"); !reflect.DeepEqual(local, []string{"release-1.2.0"}) {
+		t.Errorf("ExistingTags local = %q, want [release-1.2.0]", local)
+	}
+	if local, _, _ := ExistingBranches(r.dir, "release-1.2"); !reflect.DeepEqual(local, []string{"release/1.2"}) {
+		t.Errorf("ExistingBranches local = %q, want [release/1.2]", local)
+	}
+	if local, _, _ := ExistingTags(r.dir, "release-2.0.0"); len(local) != 0 {
+		t.Errorf("ExistingTags local = %q, want none", local)
+	}
+}
+
+func TestGetCommitsBetweenMergedOldBranch(t *testing.T) {
+	r := newTestRepo(t)
+	r.commit("initial")
+	r.checkout("feature", true)
+	r.commit("Add billing export")
+	r.checkout("master", false)
+	fix := r.commit("Fix rounding")
+	from := r.commit("Release 1.0")
+	r.checkout("feature", false)
+	sync := r.commit("Merge branch 'master' into feature", fix)
+	r.checkout("master", false)
+	r.commit("Merge branch 'feature'", sync)
+
+	// The feature branch leads to the fix and the initial commit without passing the
+	// merge base, yet both are reachable from the release
+	commits, err := GetCommitsBetween(r.dir, from.String(), "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var subjects []string
+	for _, c := range commits {
+		subjects = append(subjects, c.Subject)
+	}
+	want := []string{"Merge branch 'feature'", "Merge branch 'master' into feature", "Add billing export"}
+	if !reflect.DeepEqual(subjects, want) {
+		t.Errorf("subjects = %q, want %q", subjects, want)
+	}
+}
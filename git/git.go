@@ -3,10 +3,23 @@ package git
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
 	"fmt"
+	"io/ioutil"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"deploy/audit"
+	"deploy/command"
+	"deploy/logger"
+	"deploy/plan"
+	"deploy/version"
 )
 
 // ANSI color codes
@@ -18,15 +31,50 @@ const (
 	ColorYellow = "\033[33m"
 )
 
-// CheckClean checks if git working directory is clean
+// History limits of fetch, pull and clone for huge repositories, see SetHistoryLimits
+var (
+	fetchDepth  int    // --depth, the full history if 0
+	cloneFilter string // --filter of clone, e.g. blob:none
+)
+
+// SetHistoryLimits makes fetch, pull and clone download at most depth commits of every
+// branch (0 for the full history) and clone only the objects matching filter
+// (e.g. blob:none: the blobs of older commits are downloaded when they are needed)
+func SetHistoryLimits(depth int, filter string) {
+	fetchDepth, cloneFilter = depth, filter
+}
+
+// depthArgs returns the --depth argument of fetch and pull, if the history is limited
+func depthArgs() []string {
+	if fetchDepth <= 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("--depth=%d", fetchDepth)}
+}
+
+// run executes a git command that modifies the repository or its remote
+// and returns its combined output. In dry-run mode the command is only reported.
+func run(dir string, args ...string) ([]byte, error) {
+	if plan.Enabled() {
+		plan.Record("git %s (in %s)", strings.Join(args, " "), dir)
+		return nil, nil
+	}
+	cmd := command.New("git", args...)
+	cmd.Dir = dir
+	return cmd.CombinedOutput()
+}
+
+// CheckClean checks if git working directory is clean. It runs git rather than the
+// GitClient: go-git compares the hash of every file with the index and does not apply
+// the LFS and line ending filters, so LFS files would show as changed.
 func CheckClean(dir string) error {
 	// First, update the index to refresh cached file stats
-	cmd := exec.Command("git", "update-index", "--refresh")
+	cmd := command.New("git", "update-index", "--refresh")
 	cmd.Dir = dir
 	cmd.Run() // Ignore errors, as it returns non-zero if there are changes
 
 	// Now check if there are any changes to tracked files
-	cmd = exec.Command("git", "diff-index", "--quiet", "HEAD", "--")
+	cmd = command.New("git", "diff-index", "--quiet", "HEAD", "--")
 	cmd.Dir = dir
 	err := cmd.Run()
 
@@ -43,30 +91,92 @@ func CheckClean(dir string) error {
 
 // ShowStatus shows git status
 func ShowStatus(dir string) error {
-	cmd := exec.Command("git", "status")
+	cmd := command.New("git", "status")
 	cmd.Dir = dir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	out := logger.Writer(logger.LevelWarn)
+	defer out.Flush()
+	cmd.Stdout = out
+	cmd.Stderr = out
 	return cmd.Run()
 }
 
 // CleanWorkingDirectory resets all tracked files to HEAD
 func CleanWorkingDirectory(dir string) error {
-	cmd := exec.Command("git", "reset", "--hard", "HEAD")
-	cmd.Dir = dir
-	output, err := cmd.CombinedOutput()
+	output, err := run(dir, "reset", "--hard", "HEAD")
 	if err != nil {
 		return fmt.Errorf("failed to reset: %v: %s", err, output)
 	}
 	return nil
 }
 
+// Stash saves local changes to tracked files in the stash with the given message and
+// returns the commit of the stash entry: "git stash apply <commit>" restores the changes
+// even after more entries were stashed. It is empty if there was nothing to stash and
+// in dry-run mode.
+func Stash(dir string, message string) (string, error) {
+	before := stashCommit(dir)
+	output, err := run(dir, "stash", "push", "-m", message)
+	if err != nil {
+		return "", fmt.Errorf("failed to stash: %v: %s", err, output)
+	}
+	if plan.Enabled() {
+		return "", nil
+	}
+	if after := stashCommit(dir); after != before {
+		return after, nil
+	}
+	return "", nil
+}
+
+// stashCommit returns the commit of the latest stash entry, empty if there is none
+func stashCommit(dir string) string {
+	cmd := command.New("git", "rev-parse", "-q", "--verify", "refs/stash")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
 // Checkout performs git checkout
 func Checkout(dir string, args ...string) error {
-	cmdArgs := append([]string{"checkout"}, args...)
-	cmd := exec.Command("git", cmdArgs...)
-	cmd.Dir = dir
-	output, err := cmd.CombinedOutput()
+	output, err := run(dir, append([]string{"checkout"}, args...)...)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// UsesLFS reports whether the repository stores files in Git LFS, according to the
+// .gitattributes at its root
+func UsesLFS(dir string) bool {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ".gitattributes"))
+	return err == nil && bytes.Contains(data, []byte("filter=lfs"))
+}
+
+// CheckLFS returns an error explaining how to install git-lfs if it is missing
+func CheckLFS() error {
+	cmd := command.New("git", "lfs", "version")
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git-lfs is not installed (git lfs version: %v); install it from https://git-lfs.com and run git lfs install", err)
+	}
+	return nil
+}
+
+// LFSPull downloads the LFS files of the checked out commit
+func LFSPull(dir string) error {
+	output, err := run(dir, "lfs", "pull")
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// LFSPush uploads the LFS objects of the current branch to origin, so that the push
+// does not depend on the pre-push hook of git lfs install
+func LFSPush(dir string) error {
+	output, err := run(dir, "lfs", "push", "origin", "HEAD")
 	if err != nil {
 		return fmt.Errorf("%v: %s", err, output)
 	}
@@ -75,9 +185,53 @@ func Checkout(dir string, args ...string) error {
 
 // Pull performs git pull
 func Pull(dir string) error {
-	cmd := exec.Command("git", "pull")
+	output, err := run(dir, append([]string{"pull"}, depthArgs()...)...)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// Merge runs git merge with the given arguments
+func Merge(dir string, args ...string) error {
+	output, err := run(dir, append([]string{"merge"}, args...)...)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// MergeInProgress reports whether a merge stopped on conflicts waits to be committed
+func MergeInProgress(dir string) bool {
+	if plan.Enabled() {
+		return false
+	}
+	return RefExists(dir, "MERGE_HEAD")
+}
+
+// ConflictedFiles lists the files with unresolved merge conflicts
+func ConflictedFiles(dir string) []string {
+	cmd := command.New("git", "diff", "--name-only", "--diff-filter=U")
 	cmd.Dir = dir
-	output, err := cmd.CombinedOutput()
+	output, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(output))
+}
+
+// AbortMerge abandons a merge stopped on conflicts
+func AbortMerge(dir string) error {
+	output, err := run(dir, "merge", "--abort")
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// PushBranch pushes a branch to origin
+func PushBranch(dir string, branch string) error {
+	output, err := run(dir, "push", "origin", branch)
 	if err != nil {
 		return fmt.Errorf("%v: %s", err, output)
 	}
@@ -86,9 +240,7 @@ func Pull(dir string) error {
 
 // AddAll stages all changes
 func AddAll(dir string) error {
-	cmd := exec.Command("git", "add", ".")
-	cmd.Dir = dir
-	output, err := cmd.CombinedOutput()
+	output, err := run(dir, "add", ".")
 	if err != nil {
 		return fmt.Errorf("%v: %s", err, output)
 	}
@@ -97,9 +249,7 @@ func AddAll(dir string) error {
 
 // Commit creates a commit with the given message
 func Commit(dir string, message string) error {
-	cmd := exec.Command("git", "commit", "-m", message)
-	cmd.Dir = dir
-	output, err := cmd.CombinedOutput()
+	output, err := run(dir, "commit", "-m", message)
 	if err != nil {
 		return fmt.Errorf("%v: %s", err, output)
 	}
@@ -108,9 +258,16 @@ func Commit(dir string, message string) error {
 
 // Tag creates a tag
 func Tag(dir string, tagName string) error {
-	cmd := exec.Command("git", "tag", tagName)
-	cmd.Dir = dir
-	output, err := cmd.CombinedOutput()
+	output, err := run(dir, "tag", tagName)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// AnnotatedTag creates an annotated tag with the given message
+func AnnotatedTag(dir string, tagName string, message string) error {
+	output, err := run(dir, "tag", "-a", tagName, "--cleanup=verbatim", "-m", message)
 	if err != nil {
 		return fmt.Errorf("%v: %s", err, output)
 	}
@@ -119,9 +276,7 @@ func Tag(dir string, tagName string) error {
 
 // PushWithTags pushes branch and tags
 func PushWithTags(dir string) error {
-	cmd := exec.Command("git", "push", "-u", "origin", "HEAD", "--tags", "--force-with-lease")
-	cmd.Dir = dir
-	output, err := cmd.CombinedOutput()
+	output, err := run(dir, "push", "-u", "origin", "HEAD", "--tags", "--force-with-lease")
 	if err != nil {
 		return fmt.Errorf("%v: %s", err, output)
 	}
@@ -129,72 +284,160 @@ func PushWithTags(dir string) error {
 }
 
 // DeleteBranchIfExists deletes a branch locally and remotely if it exists
-// It tries both / and - separators to handle old and new branch naming conventions
+// It tries both / and - separators to handle old and new branch naming conventions,
+// unless the branches are named with a branch_template
 func DeleteBranchIfExists(dir string, branchName string) error {
-	// Generate both possible branch names
-	dashName := strings.ReplaceAll(branchName, "/", "-")
-	slashName := strings.ReplaceAll(branchName, "-", "/")
-
-	branchesToDelete := []string{dashName}
-	if dashName != slashName {
-		branchesToDelete = append(branchesToDelete, slashName)
-	}
+	branchesToDelete := branchVariants(branchName)
 
 	// Try to delete local branches (ignore error if they don't exist)
 	for _, branch := range branchesToDelete {
-		cmd := exec.Command("git", "branch", "-D", branch)
-		cmd.Dir = dir
-		cmd.Run() // Ignore error, branch might not exist
+		run(dir, "branch", "-D", branch) // Ignore error, branch might not exist
 	}
 
 	// Try to delete remote branches (ignore error if they don't exist)
 	for _, branch := range branchesToDelete {
-		cmd := exec.Command("git", "push", "origin", "--delete", branch)
-		cmd.Dir = dir
-		cmd.Run() // Ignore error, remote branch might not exist
+		run(dir, "push", "origin", "--delete", branch) // Ignore error, remote branch might not exist
 	}
 
 	return nil
 }
 
 // DeleteTagIfExists deletes a tag locally and remotely if it exists
-// It tries both / and - separators to handle old and new tag naming conventions
+// It tries both / and - separators to handle old and new tag naming conventions,
+// unless the tags are named with a tag_template
 func DeleteTagIfExists(dir string, tagName string) error {
-	// Generate both possible tag names
-	dashName := strings.ReplaceAll(tagName, "/", "-")
-	slashName := strings.ReplaceAll(tagName, "-", "/")
-
-	tagsToDelete := []string{dashName}
-	if dashName != slashName {
-		tagsToDelete = append(tagsToDelete, slashName)
-	}
+	tagsToDelete := tagVariants(tagName)
 
 	// Try to delete local tags (ignore error if they don't exist)
 	for _, tag := range tagsToDelete {
-		cmd := exec.Command("git", "tag", "-d", tag)
-		cmd.Dir = dir
-		cmd.Run() // Ignore error, tag might not exist
+		run(dir, "tag", "-d", tag) // Ignore error, tag might not exist
 	}
 
 	// Try to delete remote tags (ignore error if they don't exist)
 	for _, tag := range tagsToDelete {
-		cmd := exec.Command("git", "push", "origin", ":refs/tags/"+tag)
-		cmd.Dir = dir
-		cmd.Run() // Ignore error, remote tag might not exist
+		run(dir, "push", "origin", ":refs/tags/"+tag) // Ignore error, remote tag might not exist
 	}
 
 	return nil
 }
 
+// BackupRef is a ref saved before the deployment deleted or overwrote it
+type BackupRef struct {
+	Ref    string `json:"ref"`              // e.g. refs/tags/123.0.0
+	Remote bool   `json:"remote,omitempty"` // the ref of origin, not of the local repository
+	SHA    string `json:"sha"`
+	Backup string `json:"backup"` // refs/backup/deploy/<id>/... the object is kept under
+}
+
+// BackupBranch saves the forms of the branch name that exist in the repository dir and
+// on origin, i.e. what DeleteBranchIfExists would delete, under refs/backup/deploy/<id>
+// of the repository store
+func BackupBranch(dir, store, id, branchName string) ([]BackupRef, error) {
+	return backupRefs(dir, store, id, true, "refs/heads/", branchVariants(branchName))
+}
+
+// BackupTag saves the forms of the tag name that exist in the repository dir and on
+// origin, i.e. what DeleteTagIfExists would delete, under refs/backup/deploy/<id>
+// of the repository store
+func BackupTag(dir, store, id, tagName string) ([]BackupRef, error) {
+	return backupRefs(dir, store, id, true, "refs/tags/", tagVariants(tagName))
+}
+
+// BackupRemoteRefs saves the refs that exist on origin, e.g. refs/heads/release-123,
+// before they are force-pushed
+func BackupRemoteRefs(dir, store, id string, refs ...string) ([]BackupRef, error) {
+	return backupRefs(dir, store, id, false, "", refs)
+}
+
+// backupRefs saves the refs prefix+name of origin, and of the repository dir if local
+// is set, by fetching them into the repository store. A ref already saved under the id
+// keeps its first backup, the state before the deployment changed it.
+func backupRefs(dir, store, id string, local bool, prefix string, names []string) ([]BackupRef, error) {
+	source, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var saved []BackupRef
+	save := func(from, side, ref, sha string) error {
+		backup := fmt.Sprintf("refs/backup/deploy/%s/%s/%s", id, side, strings.TrimPrefix(ref, "refs/"))
+		cmd := command.New("git", "rev-parse", "--verify", "--quiet", backup)
+		cmd.Dir = store
+		if cmd.Run() == nil {
+			return nil
+		}
+		if output, err := run(store, "fetch", "--no-tags", "--quiet", from, "+"+ref+":"+backup); err != nil {
+			return fmt.Errorf("failed to back up %s: %v: %s", ref, err, strings.TrimSpace(string(output)))
+		}
+		saved = append(saved, BackupRef{Ref: ref, Remote: side == "origin", SHA: sha, Backup: backup})
+		return nil
+	}
+
+	if local {
+		for _, n := range names {
+			cmd := command.New("git", "rev-parse", "--verify", "--quiet", prefix+n)
+			cmd.Dir = dir
+			output, err := cmd.Output()
+			if err != nil {
+				continue
+			}
+			if err := save(source, "local", prefix+n, strings.TrimSpace(string(output))); err != nil {
+				return saved, err
+			}
+		}
+	}
+
+	args := []string{"ls-remote", "origin"}
+	for _, n := range names {
+		args = append(args, prefix+n)
+	}
+	cmd := command.New("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return saved, fmt.Errorf("failed to list refs on origin: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || strings.HasSuffix(fields[1], "^{}") {
+			continue
+		}
+		if err := save("origin", "origin", fields[1], fields[0]); err != nil {
+			return saved, err
+		}
+	}
+	return saved, nil
+}
+
+// RestoreRef points the ref back at its backup: in the repository dir, or on origin
+// with a force push
+func RestoreRef(dir string, b BackupRef) error {
+	cmd := command.New("git", "rev-parse", "--verify", "--quiet", b.Backup)
+	cmd.Dir = dir
+	if cmd.Run() != nil {
+		return fmt.Errorf("backup %s not found in %s", b.Backup, dir)
+	}
+	args := []string{"update-ref", b.Ref, b.Backup}
+	if b.Remote {
+		args = []string{"push", "--force", "origin", b.Backup + ":" + b.Ref}
+	}
+	if output, err := run(dir, args...); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
 // ShowDiff shows git diff with color
 func ShowDiff(dir string) error {
-	cmd := exec.Command("git", "diff")
+	cmd := command.New("git", "diff")
 	cmd.Dir = dir
 
 	// Capture output to process it
 	var stdout bytes.Buffer
+	stderr := logger.Writer(logger.LevelError)
+	defer stderr.Flush()
 	cmd.Stdout = &stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = stderr
 
 	err := cmd.Run()
 	if err != nil {
@@ -207,7 +450,7 @@ func ShowDiff(dir string) error {
 	for scanner.Scan() {
 		line := scanner.Text()
 		coloredLine := colorizeDiffLine(line)
-		fmt.Println(coloredLine)
+		logger.Infof("%s", coloredLine)
 	}
 
 	return scanner.Err()
@@ -274,14 +517,11 @@ func findRefWithBothSeparators(dir string, refType string, pattern string) (stri
 	}
 
 	for _, name := range namesToTry {
-		var checkCmd *exec.Cmd
+		ref := name
 		if refType == "branch" {
-			checkCmd = exec.Command("git", "rev-parse", "--verify", fmt.Sprintf("origin/%s", name))
-		} else {
-			checkCmd = exec.Command("git", "rev-parse", "--verify", name)
+			ref = "origin/" + name
 		}
-		checkCmd.Dir = dir
-		if err := checkCmd.Run(); err == nil {
+		if client.RefExists(dir, ref) {
 			return name, true
 		}
 	}
@@ -289,13 +529,385 @@ func findRefWithBothSeparators(dir string, refType string, pattern string) (stri
 	return "", false
 }
 
+// separatorVariants returns both possible forms of a release ref name:
+// with - (new format) and with / (old format)
+func separatorVariants(name string) []string {
+	dashName := strings.ReplaceAll(name, "/", "-")
+	slashName := strings.ReplaceAll(name, "-", "/")
+
+	names := []string{dashName}
+	if dashName != slashName {
+		names = append(names, slashName)
+	}
+	return names
+}
+
+// tagVariants returns the forms of a tag name to look for: the name itself for tags
+// named with a tag_template, both separator forms otherwise
+func tagVariants(name string) []string {
+	if version.HasTagTemplate() {
+		return []string{name}
+	}
+	return separatorVariants(name)
+}
+
+// branchVariants returns the forms of a branch name to look for: the name itself for
+// branches named with a branch_template, both separator forms otherwise
+func branchVariants(name string) []string {
+	if version.HasBranchTemplate() {
+		return []string{name}
+	}
+	return separatorVariants(name)
+}
+
+// ExistingBranches returns which forms of the branch name exist locally and on origin,
+// i.e. what DeleteBranchIfExists would delete
+func ExistingBranches(dir string, branchName string) (local []string, remote []string, err error) {
+	return existingRefs(dir, "refs/heads/", branchVariants(branchName))
+}
+
+// ExistingTags returns which forms of the tag name exist locally and on origin,
+// i.e. what DeleteTagIfExists would delete
+func ExistingTags(dir string, tagName string) (local []string, remote []string, err error) {
+	return existingRefs(dir, "refs/tags/", tagVariants(tagName))
+}
+
+// existingRefs checks the forms of a ref name under the ref prefix in the local
+// repository and on origin
+func existingRefs(dir string, prefix string, names []string) ([]string, []string, error) {
+	local := client.LocalRefs(dir, prefix, names)
+	var remote []string
+
+	args := []string{"ls-remote", "origin"}
+	for _, n := range names {
+		args = append(args, prefix+n)
+	}
+	cmd := command.New("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return local, nil, fmt.Errorf("failed to list refs on origin: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && !strings.HasSuffix(fields[1], "^{}") {
+			remote = append(remote, strings.TrimPrefix(fields[1], prefix))
+		}
+	}
+	return local, remote, nil
+}
+
+// FindBranch looks up a remote branch trying both / and - separators (only the name
+// itself for branches named with a branch_template) and returns the name under which it exists
+func FindBranch(dir string, branchName string) (string, bool) {
+	if version.HasBranchTemplate() {
+		return branchName, client.RefExists(dir, "origin/"+branchName)
+	}
+	return findRefWithBothSeparators(dir, "branch", branchName)
+}
+
+// Fetch updates remote-tracking branches and tags from origin
+func Fetch(dir string) error {
+	output, err := run(dir, append([]string{"fetch", "origin", "--tags"}, depthArgs()...)...)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// FetchPrune updates the remote-tracking branches from origin, removing those deleted
+// on origin, and the tags, also those recreated on origin at another commit
+func FetchPrune(dir string) error {
+	output, err := run(dir, append([]string{"fetch", "--prune", "--tags", "--force", "origin"}, depthArgs()...)...)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// ListRemoteTags returns the names of tags on origin matching a glob pattern
+func ListRemoteTags(dir string, pattern string) ([]string, error) {
+	cmd := command.New("git", "ls-remote", "--tags", "--refs", "origin", pattern)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote tags: %v", err)
+	}
+
+	var tags []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			tags = append(tags, strings.TrimPrefix(fields[1], "refs/tags/"))
+		}
+	}
+	return tags, nil
+}
+
 // GetCurrentBranch returns the current branch name
 func GetCurrentBranch(dir string) (string, error) {
-	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+	return client.CurrentBranch(dir)
+}
+
+// Protocols of CloneURL
+const (
+	CloneSSH   = "ssh"
+	CloneHTTPS = "https"
+)
+
+// CloneURL returns the URL to clone a GitLab project from: git@host:project.git over
+// SSH, or the project under gitlabURI over HTTPS
+func CloneURL(gitlabURI, project, protocol string) (string, error) {
+	base, err := url.Parse(strings.TrimSuffix(gitlabURI, "/"))
+	if err != nil || base.Host == "" {
+		return "", fmt.Errorf("invalid GitLab URL %q", gitlabURI)
+	}
+	project = strings.Trim(project, "/")
+	switch protocol {
+	case CloneSSH:
+		return fmt.Sprintf("git@%s:%s.git", base.Hostname(), project), nil
+	case CloneHTTPS:
+		return fmt.Sprintf("%s/%s.git", base.String(), project), nil
+	}
+	return "", fmt.Errorf("unknown clone protocol %q: expected %s or %s", protocol, CloneSSH, CloneHTTPS)
+}
+
+// Clone clones the repository into dir, creating its parent directories. Over HTTPS a
+// non-empty token authenticates the clone; it is passed as a header of this command
+// only and is not stored in the configuration of the clone.
+func Clone(remote, dir, token string) error {
+	args := append([]string{"clone"}, depthArgs()...)
+	if cloneFilter != "" {
+		args = append(args, "--filter="+cloneFilter)
+	}
+	args = append(args, remote, dir)
+	if token != "" && !strings.HasPrefix(remote, "git@") {
+		credentials := base64.StdEncoding.EncodeToString([]byte("oauth2:" + token))
+		audit.AddSecret(credentials)
+		args = append([]string{"-c", "http.extraHeader=Authorization: Basic " + credentials}, args...)
+	}
+	if plan.Enabled() {
+		plan.Record("git clone %s %s", remote, dir)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	output, err := run("", args...)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// CloneReference clones the repository into dir borrowing the objects of the local
+// repository reference, so that only the objects missing from it are downloaded
+func CloneReference(remote, dir, reference string) error {
+	if plan.Enabled() {
+		plan.Record("git clone --reference-if-able %s %s %s", reference, remote, dir)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	cmd := command.New("git", "clone", "--reference-if-able", reference, remote, dir)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
+
+// RemoteURL returns the URL of the origin remote
+func RemoteURL(dir string) (string, error) {
+	cmd := command.New("git", "remote", "get-url", "origin")
 	cmd.Dir = dir
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return "", fmt.Errorf("failed to get current branch: %v: %s", err, output)
+		return "", fmt.Errorf("failed to get origin URL: %v: %s", err, strings.TrimSpace(string(output)))
 	}
 	return strings.TrimSpace(string(output)), nil
 }
+
+// Version returns the version of the installed git
+func Version() (version.Version, error) {
+	output, err := command.New("git", "--version").CombinedOutput()
+	if err != nil {
+		return version.Version{}, err
+	}
+	v, ok := version.Find(string(output))
+	if !ok {
+		return version.Version{}, fmt.Errorf("unexpected output of git --version: %s", strings.TrimSpace(string(output)))
+	}
+	return v, nil
+}
+
+// CommitInfo describes a single commit
+type CommitInfo struct {
+	Hash          string
+	Subject       string
+	Body          string // message after the subject
+	Author        string
+	Date          time.Time // author date
+	MergeRequests []int     // IIDs of the merge requests the commit references, e.g. 123 for !123
+	Merge         bool      // the commit has more than one parent
+}
+
+// mergeRequestPattern matches merge request references: !123 in a subject, or
+// group/project!123 in the "See merge request" line GitLab adds to merge commits
+var mergeRequestPattern = regexp.MustCompile(`(?:^|[\s(\[]|[\w.-]+/[\w./-]+)!(\d+)\b`)
+
+// mergeRequestRefs returns the unique merge request IIDs referenced in the text, in order
+func mergeRequestRefs(text string) []int {
+	var iids []int
+	seen := make(map[int]bool)
+	for _, match := range mergeRequestPattern.FindAllStringSubmatch(text, -1) {
+		iid, err := strconv.Atoi(match[1])
+		if err != nil || seen[iid] {
+			continue
+		}
+		seen[iid] = true
+		iids = append(iids, iid)
+	}
+	return iids
+}
+
+// GetCommitsBetween returns the commits reachable from "to" but not from "from", newest first.
+// An empty "from" returns the whole history of "to".
+func GetCommitsBetween(dir string, from string, to string) ([]CommitInfo, error) {
+	return client.CommitsBetween(dir, from, to)
+}
+
+// DiscardedCommits returns the commits of the ref on origin (e.g. refs/heads/release-123)
+// that force-pushing local to it would discard, newest first: none if the ref does not
+// exist on origin or local already contains it. The ref is fetched from origin first.
+func DiscardedCommits(dir, ref, local string) ([]CommitInfo, error) {
+	cmd := command.New("git", "ls-remote", "origin", ref)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s on origin: %v", ref, err)
+	}
+	var remote string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[1] == ref {
+			remote = fields[0]
+		}
+	}
+	if remote == "" {
+		return nil, nil
+	}
+
+	// Fetch the ref even if its commit is known: the remote-tracking branch the lease of
+	// the push is checked against may be older. Without a destination the local tag of
+	// the same name is left alone.
+	cmd = command.New("git", "fetch", "--no-tags", "--quiet", "origin", ref)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v: %s", ref, err, strings.TrimSpace(string(output)))
+	}
+	return GetCommitsBetween(dir, local, remote+"^{commit}")
+}
+
+// GetPreviousReleaseTag returns the highest local release tag of the service lower than
+// the given version
+func GetPreviousReleaseTag(dir string, service string, before version.Version) (string, bool, error) {
+	cmd := command.New("git", "tag", "-l")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to list tags: %v", err)
+	}
+
+	var best version.Version
+	bestTag := ""
+	for _, tag := range strings.Fields(string(output)) {
+		v, ok := version.ParseTag(tag, service)
+		if !ok || !v.Less(before) {
+			continue
+		}
+		if bestTag == "" || best.Less(v) {
+			best = v
+			bestTag = tag
+		}
+	}
+	return bestTag, bestTag != "", nil
+}
+
+// IsShallow reports whether the repository has an incomplete history
+func IsShallow(dir string) bool {
+	cmd := command.New("git", "rev-parse", "--is-shallow-repository")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	return err == nil && strings.TrimSpace(string(output)) == "true"
+}
+
+// maxDeepen is how many times EnsureHistory deepens a shallow repository before it
+// fetches the complete history
+const maxDeepen = 5
+
+// EnsureHistory deepens a shallow repository until the histories of from and to meet,
+// so that the commits between them can be listed. The history is deepened by the
+// configured depth (or 100 commits) at a time, then fetched completely.
+// It does nothing in a repository with the full history.
+func EnsureHistory(dir string, from string, to string) error {
+	if !IsShallow(dir) {
+		return nil
+	}
+	step := fetchDepth
+	if step <= 0 {
+		step = 100
+	}
+	for i := 0; i < maxDeepen; i++ {
+		if historiesMeet(dir, from, to) {
+			return nil
+		}
+		logger.Infof("  Deepening the history of %s by %d commits...", dir, step)
+		if err := deepen(dir, fmt.Sprintf("--deepen=%d", step)); err != nil {
+			return err
+		}
+		step *= 2
+	}
+	if historiesMeet(dir, from, to) {
+		return nil
+	}
+	logger.Infof("  Fetching the complete history of %s...", dir)
+	return deepen(dir, "--unshallow")
+}
+
+// historiesMeet reports whether from and to have a common ancestor in the local history
+func historiesMeet(dir string, from string, to string) bool {
+	cmd := command.New("git", "merge-base", from, to)
+	cmd.Dir = dir
+	return cmd.Run() == nil
+}
+
+// deepen fetches more history of a shallow repository. It only adds objects, so unlike
+// the commands of run it also runs in dry-run mode, for read-only reports.
+func deepen(dir string, arg string) error {
+	cmd := command.New("git", "fetch", "origin", "--tags", arg)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to deepen the history: %v: %s", err, output)
+	}
+	return nil
+}
+
+// RefExists reports whether a branch, tag or commit can be resolved locally
+func RefExists(dir string, ref string) bool {
+	return client.RefExists(dir, ref)
+}
+
+// ResolveCommit returns the full SHA of the commit a ref points to
+func ResolveCommit(dir string, ref string) (string, error) {
+	return client.ResolveCommit(dir, ref)
+}
+
+// GetHeadCommit returns the hash of HEAD abbreviated to 8 characters, like the commits
+// of release notes
+func GetHeadCommit(dir string) (string, error) {
+	hash, err := client.ResolveCommit(dir, "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("failed to get HEAD commit: %v", err)
+	}
+	return hash[:8], nil
+}
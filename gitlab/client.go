@@ -0,0 +1,312 @@
+package gitlab
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	gl "gitlab.com/gitlab-org/api/client-go"
+
+	"deploy/audit"
+)
+
+// api is the part of the GitLab API the deployment uses. Projects are given by their
+// path, e.g. group/service.
+type api interface {
+	GetProject(project string) error
+	ListTags(project, search string) ([]TagResponse, error)
+
+	CreatePipeline(project, ref string, variables []PipelineVariable) (PipelineResponse, error)
+	GetPipeline(project string, id int) (PipelineResponse, error)
+	ListPipelines(project string, filter pipelineFilter) ([]PipelineResponse, error)
+	PipelineVariables(project string, id int) ([]PipelineVariable, error)
+	CancelPipeline(project string, id int) error
+
+	PipelineJobs(project string, pipelineID int) ([]JobResponse, error)
+	CancelJob(project string, id int) error
+	PlayJob(project string, id int) error
+	JobTrace(project string, id int) (string, error)
+
+	OpenMergeRequests(project, source, target string) ([]MergeRequest, error)
+	CreateMergeRequest(project, source, target, title, description string, reviewerIDs []int) (MergeRequest, error)
+	FindUser(username string) (int, error)
+
+	CreateRelease(project, tag, name, description string, links []ReleaseLink) (string, error)
+	UpdateRelease(project, tag, name, description string) (string, error)
+}
+
+// pipelineFilter selects the pipelines of a project listed by ListPipelines, newest
+// first. Zero values do not filter; without a limit all pages are read.
+type pipelineFilter struct {
+	ref          string
+	updatedAfter time.Time
+	limit        int
+}
+
+// errConflict is returned by CreateRelease for a release that already exists
+var errConflict = errors.New("already exists")
+
+// newClient returns the client of the GitLab API at GITLAB_URI, authenticated with
+// GITLAB_TOKEN. Every request is recorded in the audit log.
+func newClient() (api, error) {
+	gitlabToken := os.Getenv("GITLAB_TOKEN")
+	if gitlabToken == "" {
+		return nil, fmt.Errorf("GITLAB_TOKEN environment variable is not set")
+	}
+
+	gitlabURI := os.Getenv("GITLAB_URI")
+	if gitlabURI == "" {
+		return nil, fmt.Errorf("GITLAB_URI environment variable is not set")
+	}
+
+	httpClient := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: audit.Transport(nil),
+	}
+	client, err := gl.NewClient(gitlabToken,
+		gl.WithBaseURL(strings.TrimSuffix(gitlabURI, "/")+"/api/v4"),
+		gl.WithHTTPClient(httpClient),
+		gl.WithCustomRetryMax(retryMax),
+		gl.WithCustomRetryWaitMinMax(retryWaitMin, retryWaitMax),
+		gl.WithCustomBackoff(retryBackoff),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GITLAB_URI %q: %v", gitlabURI, err)
+	}
+	return &clientAPI{client}, nil
+}
+
+// clientAPI implements api with the official GitLab client
+type clientAPI struct {
+	client *gl.Client
+}
+
+func (c *clientAPI) GetProject(project string) error {
+	_, _, err := c.client.Projects.GetProject(project, nil)
+	return err
+}
+
+func (c *clientAPI) ListTags(project, search string) ([]TagResponse, error) {
+	opts := &gl.ListTagsOptions{}
+	if search != "" {
+		opts.Search = gl.Ptr(search)
+	}
+	tags, err := allPages(func(page gl.ListOptions) ([]*gl.Tag, *gl.Response, error) {
+		opts.ListOptions = page
+		return c.client.Tags.ListTags(project, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]TagResponse, 0, len(tags))
+	for _, t := range tags {
+		list = append(list, TagResponse{Name: t.Name})
+	}
+	return list, nil
+}
+
+func (c *clientAPI) CreatePipeline(project, ref string, variables []PipelineVariable) (PipelineResponse, error) {
+	vars := make([]*gl.PipelineVariableOptions, 0, len(variables))
+	for _, v := range variables {
+		vars = append(vars, &gl.PipelineVariableOptions{Key: gl.Ptr(v.Key), Value: gl.Ptr(v.Value)})
+	}
+	pipeline, _, err := c.client.Pipelines.CreatePipeline(project, &gl.CreatePipelineOptions{
+		Ref:       gl.Ptr(ref),
+		Variables: &vars,
+	}, gl.WithRequestRetry(retryCreate))
+	if err != nil {
+		return PipelineResponse{}, err
+	}
+	return pipelineResponse(pipeline.ID, pipeline.Ref, pipeline.Status, pipeline.WebURL), nil
+}
+
+func (c *clientAPI) GetPipeline(project string, id int) (PipelineResponse, error) {
+	pipeline, _, err := c.client.Pipelines.GetPipeline(project, int64(id))
+	if err != nil {
+		return PipelineResponse{}, err
+	}
+	return pipelineResponse(pipeline.ID, pipeline.Ref, pipeline.Status, pipeline.WebURL), nil
+}
+
+func (c *clientAPI) ListPipelines(project string, filter pipelineFilter) ([]PipelineResponse, error) {
+	opts := &gl.ListProjectPipelinesOptions{
+		OrderBy: gl.Ptr("id"),
+		Sort:    gl.Ptr("desc"),
+	}
+	if filter.ref != "" {
+		opts.Ref = gl.Ptr(filter.ref)
+	}
+	if !filter.updatedAfter.IsZero() {
+		opts.UpdatedAfter = gl.Ptr(filter.updatedAfter)
+	}
+	var pipelines []*gl.PipelineInfo
+	var err error
+	if filter.limit > 0 {
+		opts.PerPage = int64(filter.limit)
+		pipelines, _, err = c.client.Pipelines.ListProjectPipelines(project, opts)
+	} else {
+		pipelines, err = allPages(func(page gl.ListOptions) ([]*gl.PipelineInfo, *gl.Response, error) {
+			opts.ListOptions = page
+			return c.client.Pipelines.ListProjectPipelines(project, opts)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := make([]PipelineResponse, 0, len(pipelines))
+	for _, p := range pipelines {
+		list = append(list, pipelineResponse(p.ID, p.Ref, p.Status, p.WebURL))
+	}
+	return list, nil
+}
+
+func (c *clientAPI) PipelineVariables(project string, id int) ([]PipelineVariable, error) {
+	variables, _, err := c.client.Pipelines.GetPipelineVariables(project, int64(id))
+	if err != nil {
+		return nil, err
+	}
+	list := make([]PipelineVariable, 0, len(variables))
+	for _, v := range variables {
+		list = append(list, PipelineVariable{Key: v.Key, Value: v.Value})
+	}
+	return list, nil
+}
+
+func (c *clientAPI) CancelPipeline(project string, id int) error {
+	_, _, err := c.client.Pipelines.CancelPipelineBuild(project, int64(id))
+	return err
+}
+
+func (c *clientAPI) PipelineJobs(project string, pipelineID int) ([]JobResponse, error) {
+	jobs, err := allPages(func(page gl.ListOptions) ([]*gl.Job, *gl.Response, error) {
+		return c.client.Jobs.ListPipelineJobs(project, int64(pipelineID), &gl.ListJobsOptions{ListOptions: page})
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]JobResponse, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, JobResponse{ID: int(j.ID), Name: j.Name, Stage: j.Stage, Status: j.Status, AllowFailure: j.AllowFailure})
+	}
+	return list, nil
+}
+
+func (c *clientAPI) CancelJob(project string, id int) error {
+	_, _, err := c.client.Jobs.CancelJob(project, int64(id))
+	return err
+}
+
+func (c *clientAPI) PlayJob(project string, id int) error {
+	_, _, err := c.client.Jobs.PlayJob(project, int64(id), nil, gl.WithRequestRetry(retryCreate))
+	return err
+}
+
+func (c *clientAPI) JobTrace(project string, id int) (string, error) {
+	trace, _, err := c.client.Jobs.GetTraceFile(project, int64(id))
+	if err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(trace)
+	return string(b), err
+}
+
+func (c *clientAPI) OpenMergeRequests(project, source, target string) ([]MergeRequest, error) {
+	opts := &gl.ListProjectMergeRequestsOptions{
+		State:        gl.Ptr("opened"),
+		SourceBranch: gl.Ptr(source),
+		TargetBranch: gl.Ptr(target),
+	}
+	mrs, err := allPages(func(page gl.ListOptions) ([]*gl.BasicMergeRequest, *gl.Response, error) {
+		opts.ListOptions = page
+		return c.client.MergeRequests.ListProjectMergeRequests(project, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]MergeRequest, 0, len(mrs))
+	for _, mr := range mrs {
+		list = append(list, MergeRequest{IID: int(mr.IID), WebURL: mr.WebURL})
+	}
+	return list, nil
+}
+
+func (c *clientAPI) CreateMergeRequest(project, source, target, title, description string, reviewerIDs []int) (MergeRequest, error) {
+	opts := &gl.CreateMergeRequestOptions{
+		SourceBranch: gl.Ptr(source),
+		TargetBranch: gl.Ptr(target),
+		Title:        gl.Ptr(title),
+	}
+	if description != "" {
+		opts.Description = gl.Ptr(description)
+	}
+	if len(reviewerIDs) > 0 {
+		ids := make([]int64, 0, len(reviewerIDs))
+		for _, id := range reviewerIDs {
+			ids = append(ids, int64(id))
+		}
+		opts.ReviewerIDs = &ids
+	}
+	mr, _, err := c.client.MergeRequests.CreateMergeRequest(project, opts, gl.WithRequestRetry(retryCreate))
+	if err != nil {
+		return MergeRequest{}, err
+	}
+	return MergeRequest{IID: int(mr.IID), WebURL: mr.WebURL}, nil
+}
+
+func (c *clientAPI) FindUser(username string) (int, error) {
+	users, _, err := c.client.Users.ListUsers(&gl.ListUsersOptions{Username: gl.Ptr(username)})
+	if err != nil {
+		return 0, err
+	}
+	if len(users) == 0 {
+		return 0, fmt.Errorf("no GitLab user %s", username)
+	}
+	return int(users[0].ID), nil
+}
+
+func (c *clientAPI) CreateRelease(project, tag, name, description string, links []ReleaseLink) (string, error) {
+	opts := &gl.CreateReleaseOptions{
+		TagName:     gl.Ptr(tag),
+		Name:        gl.Ptr(name),
+		Description: gl.Ptr(description),
+	}
+	if len(links) > 0 {
+		assets := &gl.ReleaseAssetsOptions{}
+		for _, link := range links {
+			l := &gl.ReleaseAssetLinkOptions{Name: gl.Ptr(link.Name), URL: gl.Ptr(link.URL)}
+			if link.LinkType != "" {
+				l.LinkType = gl.Ptr(gl.LinkTypeValue(link.LinkType))
+			}
+			assets.Links = append(assets.Links, l)
+		}
+		opts.Assets = assets
+	}
+	release, resp, err := c.client.Releases.CreateRelease(project, opts, gl.WithRequestRetry(retryCreate))
+	if resp != nil && resp.StatusCode == http.StatusConflict {
+		return "", errConflict
+	}
+	if err != nil {
+		return "", err
+	}
+	return release.Links.Self, nil
+}
+
+func (c *clientAPI) UpdateRelease(project, tag, name, description string) (string, error) {
+	release, _, err := c.client.Releases.UpdateRelease(project, tag, &gl.UpdateReleaseOptions{
+		Name:        gl.Ptr(name),
+		Description: gl.Ptr(description),
+	})
+	if err != nil {
+		return "", err
+	}
+	return release.Links.Self, nil
+}
+
+// pipelineResponse converts a pipeline of the client
+func pipelineResponse(id int64, ref, status, webURL string) PipelineResponse {
+	return PipelineResponse{ID: int(id), Ref: ref, Status: status, WebURL: webURL}
+}
@@ -0,0 +1,150 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestListTagsReadsEveryPage(t *testing.T) {
+	pages := map[string][]string{
+		"1": {"v1.0.0", "v1.1.0"},
+		"2": {"v1.2.0", "v1.3.0"},
+		"3": {"v2.0.0"},
+	}
+	var requested []string
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/projects/team/billing/repository/tags" {
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		page := r.URL.Query().Get("page")
+		requested = append(requested, page)
+		if got := r.URL.Query().Get("per_page"); got != strconv.Itoa(perPage) {
+			t.Errorf("per_page = %q, want %d", got, perPage)
+		}
+		if got := r.URL.Query().Get("search"); got != "^v" {
+			t.Errorf("search = %q, want ^v", got)
+		}
+
+		var tags []map[string]string
+		for _, name := range pages[page] {
+			tags = append(tags, map[string]string{"name": name})
+		}
+		if next, _ := strconv.Atoi(page); next < len(pages) {
+			w.Header().Set("X-Next-Page", strconv.Itoa(next+1))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tags)
+	}))
+
+	tags, err := client.ListTags("team/billing", "^v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	if want := []string{"v1.0.0", "v1.1.0", "v1.2.0", "v1.3.0", "v2.0.0"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ListTags = %q, want %q", names, want)
+	}
+	if want := []string{"1", "2", "3"}; !reflect.DeepEqual(requested, want) {
+		t.Errorf("pages requested = %q, want %q", requested, want)
+	}
+}
+
+func TestCreateReleaseConflict(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message": "Release already exists"}`))
+	}))
+
+	if _, err := client.CreateRelease("team/billing", "v1.0.0", "Release 1.0.0", "notes", nil); err != errConflict {
+		t.Errorf("CreateRelease on 409 = %v, want errConflict", err)
+	}
+}
+
+func TestCreateReleaseUpdatesExisting(t *testing.T) {
+	const self = "https://gitlab.example.com/team/billing/-/releases/v1.0.0"
+	var methods []string
+	newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method+" "+r.URL.Path)
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["description"] != "new notes" {
+			t.Errorf("%s %s description = %v, want new notes", r.Method, r.URL.Path, body["description"])
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"message": "Release already exists"}`))
+			return
+		}
+		fmt.Fprintf(w, `{"tag_name": "v1.0.0", "_links": {"self": %q}}`, self)
+	}))
+
+	url, err := CreateRelease("team/billing", "v1.0.0", "Release 1.0.0", "new notes", []ReleaseLink{{Name: "Pipeline", URL: "https://ci.example.com/1"}})
+	if err != nil || url != self {
+		t.Fatalf("CreateRelease = %q, %v; want %q", url, err, self)
+	}
+	want := []string{"POST /api/v4/projects/team/billing/releases", "PUT /api/v4/projects/team/billing/releases/v1.0.0"}
+	if !reflect.DeepEqual(methods, want) {
+		t.Errorf("requests = %q, want %q", methods, want)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	header := func(value string) *http.Response {
+		resp := &http.Response{Header: http.Header{}}
+		if value != "" {
+			resp.Header.Set("Retry-After", value)
+		}
+		return resp
+	}
+
+	for _, tc := range []struct {
+		name string
+		resp *http.Response
+		wait time.Duration
+		ok   bool
+	}{
+		{"no response", nil, 0, false},
+		{"no header", header(""), 0, false},
+		{"seconds", header("120"), 2 * time.Minute, true},
+		{"zero seconds", header("0"), 0, true},
+		{"negative seconds", header("-5"), 0, false},
+		{"past date", header("Wed, 21 Oct 2015 07:28:00 GMT"), 0, true},
+		{"invalid", header("soon"), 0, false},
+	} {
+		wait, ok := retryAfter(tc.resp)
+		if wait != tc.wait || ok != tc.ok {
+			t.Errorf("%s: retryAfter = %s, %v; want %s, %v", tc.name, wait, ok, tc.wait, tc.ok)
+		}
+	}
+
+	// A date is the time left until it, which shrinks while the test runs
+	at := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if wait, ok := retryAfter(header(at)); !ok || wait <= 58*time.Minute || wait > time.Hour {
+		t.Errorf("retryAfter(%s) = %s, %v; want about an hour", at, wait, ok)
+	}
+}
+
+func TestRetryBackoffCapsRetryAfter(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Retry-After", "3600")
+	if wait := retryBackoff(time.Second, 30*time.Second, 0, resp); wait != 30*time.Second {
+		t.Errorf("retryBackoff(Retry-After: 3600) = %s, want 30s", wait)
+	}
+	resp.Header.Set("Retry-After", "5")
+	if wait := retryBackoff(time.Second, 30*time.Second, 0, resp); wait != 5*time.Second {
+		t.Errorf("retryBackoff(Retry-After: 5) = %s, want 5s", wait)
+	}
+}
@@ -1,32 +1,36 @@
 package gitlab
 
 import (
-	"bytes"
-	"deploy/config"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"net/url"
 	"os"
 	"sort"
 	"strings"
 	"sync"
 	"time"
+
+	"deploy/config"
+	"deploy/logger"
+	"deploy/plan"
+	"deploy/version"
 )
 
 // Service represents a service configuration
 type Service struct {
-	Name          string `yaml:"name"`
-	Directory     string `yaml:"directory"`
-	GitlabProject string `yaml:"gitlab_project"`
-	Group         string `yaml:"group"`
-	Sequential    bool   `yaml:"sequential"`
+	Name          string            `yaml:"name"`
+	Directory     string            `yaml:"directory"`
+	GitlabProject string            `yaml:"gitlab_project"`
+	Group         string            `yaml:"group"`
+	Sequential    bool              `yaml:"sequential"`
+	Variables     map[string]string `yaml:"variables"`  // extra pipeline variables
+	DeployJob     string            `yaml:"deploy_job"` // manual job played and waited for, "deploy helm" if empty
 }
 
 // PipelineResponse represents GitLab pipeline creation response
 type PipelineResponse struct {
 	ID     int    `json:"id"`
+	Ref    string `json:"ref"`
 	Status string `json:"status"`
 	WebURL string `json:"web_url"`
 }
@@ -57,25 +61,402 @@ const (
 	colorReset = "\033[0m"
 )
 
+// ErrInterrupted is returned for pipelines that were not created or not waited for
+// because the deployment was interrupted
+var ErrInterrupted = errors.New("interrupted")
+
+// interrupted is closed by Interrupt to stop creating and polling pipelines
+var (
+	interrupted   = make(chan struct{})
+	interruptOnce sync.Once
+)
+
+// CreatedPipeline describes a pipeline this run created in GitLab
+type CreatedPipeline struct {
+	Service   string
+	Namespace string
+	Project   string
+	ID        int
+	WebURL    string
+}
+
+// onCreated is called for every pipeline created, see OnPipelineCreated
+var onCreated func(CreatedPipeline)
+
+// OnPipelineCreated registers a function called, possibly from several goroutines, for
+// every pipeline created. It must be registered before pipelines are created.
+func OnPipelineCreated(fn func(CreatedPipeline)) {
+	onCreated = fn
+}
+
+// activePipeline is a pipeline created or awaited by this run that has not finished yet
+type activePipeline struct {
+	project   string
+	id        int
+	service   string
+	namespace string
+}
+
+var (
+	activeMu  sync.Mutex
+	activeSet = make(map[activePipeline]bool)
+	canceled  = make(map[activePipeline]string) // pipelines canceled by cancelSiblings -> failed service
+)
+
+// cancelGroups enables canceling the running pipelines of a group once one of them fails
+var cancelGroups bool
+
+// SetCancelGroups enables canceling, via the GitLab API, the pipelines of the other
+// services of a group still running on a namespace when the pipeline of one of them
+// fails there, instead of leaving them deploying half a release
+func SetCancelGroups(enabled bool) {
+	cancelGroups = enabled
+}
+
+// Interrupt stops all pipeline polling: waits in progress return ErrInterrupted and
+// no new pipelines are created. Pipelines already running in GitLab are not affected,
+// use CancelActivePipelines for that.
+func Interrupt() {
+	interruptOnce.Do(func() { close(interrupted) })
+}
+
+// isInterrupted reports whether Interrupt has been called
+func isInterrupted() bool {
+	select {
+	case <-interrupted:
+		return true
+	default:
+		return false
+	}
+}
+
+// CancelActivePipelines cancels, via the GitLab API, the pipelines this run is still
+// waiting for. Failures are reported but do not stop the remaining cancellations.
+func CancelActivePipelines() {
+	activeMu.Lock()
+	var pipelines []activePipeline
+	for p := range activeSet {
+		pipelines = append(pipelines, p)
+	}
+	activeMu.Unlock()
+
+	if len(pipelines) == 0 {
+		return
+	}
+
+	client, err := newClient()
+	if err != nil {
+		logger.Warnf("  Warning: failed to cancel pipelines: %v", err)
+		return
+	}
+
+	for _, p := range pipelines {
+		if err := client.CancelPipeline(p.project, p.id); err != nil {
+			logger.Warnf("  Warning: failed to cancel pipeline %d for %s (%s): %v", p.id, p.service, p.namespace, err)
+			continue
+		}
+		logger.Infof("  Canceled pipeline %d for %s (%s)", p.id, p.service, p.namespace)
+	}
+}
+
+// cancelSiblings cancels the pipelines of the other services of the group of a failed
+// service that are still running on the namespace, if SetCancelGroups enabled it
+func cancelSiblings(client api, group []config.Service, failed, namespace string) {
+	if !cancelGroups || len(group) < 2 {
+		return
+	}
+	siblings := make(map[string]bool)
+	for _, svc := range group {
+		siblings[svc.Name] = svc.Name != failed
+	}
+
+	activeMu.Lock()
+	var pipelines []activePipeline
+	for p := range activeSet {
+		if _, done := canceled[p]; !done && p.namespace == namespace && siblings[p.service] {
+			pipelines = append(pipelines, p)
+			canceled[p] = failed
+		}
+	}
+	activeMu.Unlock()
+
+	for _, p := range pipelines {
+		if err := client.CancelPipeline(p.project, p.id); err != nil {
+			logger.Warnf("  Warning: failed to cancel pipeline %d for %s (%s): %v", p.id, p.service, p.namespace, err)
+			continue
+		}
+		logger.Warnf("  Canceled pipeline %d for %s (%s) because %s failed", p.id, p.service, p.namespace, failed)
+	}
+}
+
+// canceledFor returns the service whose failure canceled the pipeline, if any
+func canceledFor(service Service, pipelineID int, namespace string) string {
+	activeMu.Lock()
+	defer activeMu.Unlock()
+	return canceled[activePipeline{project: service.GitlabProject, id: pipelineID, service: service.Name, namespace: namespace}]
+}
+
+// PipelineFinished reports whether a pipeline status is final
+func PipelineFinished(status string) bool {
+	switch status {
+	case "success", "failed", "canceled", "skipped":
+		return true
+	}
+	return false
+}
+
+// CancelPipeline cancels a pipeline unless it has already finished. It returns the
+// status of the pipeline before the cancellation and whether it was canceled.
+func CancelPipeline(project string, pipelineID int) (string, bool, error) {
+	client, err := newClient()
+	if err != nil {
+		return "", false, err
+	}
+
+	pipeline, err := client.GetPipeline(project, pipelineID)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get pipeline: %v", err)
+	}
+
+	if PipelineFinished(pipeline.Status) {
+		return pipeline.Status, false, nil
+	}
+	if plan.Enabled() {
+		plan.Record("cancel pipeline %d of %s (%s)", pipelineID, project, pipeline.Status)
+		return pipeline.Status, false, nil
+	}
+	if err := client.CancelPipeline(project, pipelineID); err != nil {
+		return pipeline.Status, false, fmt.Errorf("failed to cancel pipeline: %v", err)
+	}
+	return pipeline.Status, true, nil
+}
+
+// MergeRequest is an open GitLab merge request
+type MergeRequest struct {
+	IID    int    `json:"iid"`
+	WebURL string `json:"web_url"`
+}
+
+// MergeRequestURL returns the web address of a merge request of the project, or the
+// reference project!iid if GITLAB_URI is not set
+func MergeRequestURL(project string, iid int) string {
+	gitlabURI := strings.TrimSuffix(os.Getenv("GITLAB_URI"), "/")
+	if gitlabURI == "" {
+		return fmt.Sprintf("%s!%d", project, iid)
+	}
+	return fmt.Sprintf("%s/%s/-/merge_requests/%d", gitlabURI, project, iid)
+}
+
+// OpenMergeRequest opens a merge request from source into target in the project, with
+// the description and the reviewers given by their usernames, or returns the merge
+// request already open between the two branches
+func OpenMergeRequest(project, source, target, title, description string, reviewers []string) (MergeRequest, error) {
+	client, err := newClient()
+	if err != nil {
+		return MergeRequest{}, err
+	}
+
+	existing, err := client.OpenMergeRequests(project, source, target)
+	if err != nil {
+		return MergeRequest{}, fmt.Errorf("failed to list merge requests: %v", err)
+	}
+	if len(existing) > 0 {
+		return existing[0], nil
+	}
+
+	if plan.Enabled() {
+		plan.Record("open merge request %s -> %s in %s", source, target, project)
+		return MergeRequest{}, nil
+	}
+
+	var reviewerIDs []int
+	for _, username := range reviewers {
+		id, err := client.FindUser(strings.TrimPrefix(username, "@"))
+		if err != nil {
+			return MergeRequest{}, fmt.Errorf("reviewer %s: %v", username, err)
+		}
+		reviewerIDs = append(reviewerIDs, id)
+	}
+
+	mr, err := client.CreateMergeRequest(project, source, target, title, description, reviewerIDs)
+	if err != nil {
+		return MergeRequest{}, fmt.Errorf("failed to open merge request: %v", err)
+	}
+	return mr, nil
+}
+
+// ReleaseLink is a link attached to a GitLab release, e.g. to a pipeline or artifacts
+type ReleaseLink struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	LinkType string `json:"link_type,omitempty"` // other, runbook, image or package
+}
+
+// CreateRelease creates the GitLab release of the tag in the project and returns its
+// URL. A release that already exists, e.g. from a resumed deployment, gets the new
+// description; its links are kept.
+func CreateRelease(project, tag, name, description string, links []ReleaseLink) (string, error) {
+	if plan.Enabled() {
+		plan.Record("create GitLab release %s in %s with %d link(s)", tag, project, len(links))
+		return "", nil
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return "", err
+	}
+
+	self, err := client.CreateRelease(project, tag, name, description, links)
+	if err == errConflict {
+		self, err = client.UpdateRelease(project, tag, name, description)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create release: %v", err)
+	}
+	return self, nil
+}
+
+// ArtifactsURL returns the URL downloading the artifacts of the job from the latest
+// successful pipeline of the ref
+func ArtifactsURL(project, ref, job string) string {
+	gitlabURI := strings.TrimSuffix(os.Getenv("GITLAB_URI"), "/")
+	return fmt.Sprintf("%s/%s/-/jobs/artifacts/%s/download?job=%s", gitlabURI, project, url.PathEscape(ref), url.QueryEscape(job))
+}
+
+// trackPipeline registers a pipeline as active until the returned function is called
+func trackPipeline(service Service, pipelineID int, namespace string) func() {
+	p := activePipeline{project: service.GitlabProject, id: pipelineID, service: service.Name, namespace: namespace}
+	activeMu.Lock()
+	activeSet[p] = true
+	activeMu.Unlock()
+	return func() {
+		activeMu.Lock()
+		delete(activeSet, p)
+		activeMu.Unlock()
+	}
+}
+
+// PipelineResult is the outcome of the pipeline of a service on a namespace in this run
+type PipelineResult struct {
+	Service   string   `json:"service"`
+	Namespace string   `json:"namespace"`
+	ID        int      `json:"id,omitempty"`
+	WebURL    string   `json:"web_url,omitempty"`
+	Status    string   `json:"status"` // running, success, failed or interrupted
+	Error     string   `json:"error,omitempty"`
+	Traces    []string `json:"traces,omitempty"` // saved traces of the failed jobs
+}
+
+var (
+	resultsMu sync.Mutex
+	results   = make(map[string]*PipelineResult) // service/namespace -> result
+)
+
+// recordPipeline updates the result of the pipeline of a service on a namespace.
+// Zero ID and empty URL keep the values recorded before; err is kept for failed pipelines.
+func recordPipeline(service, namespace string, pipelineID int, webURL, status string, err error) {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	key := service + "/" + namespace
+	r := results[key]
+	if r == nil {
+		r = &PipelineResult{Service: service, Namespace: namespace}
+		results[key] = r
+	}
+	if pipelineID != 0 {
+		r.ID = pipelineID
+	}
+	if webURL != "" {
+		r.WebURL = webURL
+	}
+	r.Status = status
+	r.Error = ""
+	if status == "failed" && err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// recordTraces adds the saved traces of failed jobs to the result of the pipeline of a
+// service on a namespace
+func recordTraces(service, namespace string, files []string) {
+	if len(files) == 0 {
+		return
+	}
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	if r := results[service+"/"+namespace]; r != nil {
+		r.Traces = append(r.Traces, files...)
+	}
+}
+
+// outcomeStatus returns the pipeline result status for the error of waiting for it
+func outcomeStatus(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case err == ErrInterrupted:
+		return "interrupted"
+	default:
+		return "failed"
+	}
+}
+
+// PipelineResults returns the pipelines created or checked by this run, ordered by service and namespace
+func PipelineResults() []PipelineResult {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	var list []PipelineResult
+	for _, r := range results {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Service != list[j].Service {
+			return list[i].Service < list[j].Service
+		}
+		return list[i].Namespace < list[j].Namespace
+	})
+	return list
+}
+
 // CreatePipelinesFromConfig creates GitLab pipelines using a pipelined approach:
 // as soon as a service succeeds on namespace N, it starts on namespace N+1,
 // without waiting for other services to finish on namespace N.
 // Within a namespace, ordering is preserved: sequential services first, then groups in order.
 func CreatePipelinesFromConfig(cfg *config.Config, ref string, namespaces []string) error {
-	gitlabToken := os.Getenv("GITLAB_TOKEN")
-	if gitlabToken == "" {
-		return fmt.Errorf("GITLAB_TOKEN environment variable is not set")
+	return createPipelines(cfg, func(config.Service) string { return ref }, namespaces)
+}
+
+// CreatePipelinesForRefs works like CreatePipelinesFromConfig, but runs every service
+// on its own ref, e.g. the previous release tag of that service when rolling back
+func CreatePipelinesForRefs(cfg *config.Config, refs map[string]string, namespaces []string) error {
+	return createPipelines(cfg, func(svc config.Service) string { return refs[svc.Name] }, namespaces)
+}
+
+// createPipelines implements CreatePipelinesFromConfig with the ref resolved per service
+func createPipelines(cfg *config.Config, refFor func(config.Service) string, namespaces []string) error {
+	if plan.Enabled() {
+		planPipelines(cfg, namespaces, func(svc config.Service, namespace string) {
+			if svc.DeployJob != "" {
+				plan.Record("create pipeline for %s (project %s, ref %s, HELM_NAMESPACE=%s), play job %q", svc.Name, svc.GitlabProject, refFor(svc), namespace, svc.DeployJob)
+				return
+			}
+			plan.Record("create pipeline for %s (project %s, ref %s, HELM_NAMESPACE=%s)", svc.Name, svc.GitlabProject, refFor(svc), namespace)
+		})
+		return nil
 	}
 
-	gitlabURI := os.Getenv("GITLAB_URI")
-	if gitlabURI == "" {
-		return fmt.Errorf("GITLAB_URI environment variable is not set")
+	client, err := newClient()
+	if err != nil {
+		return err
 	}
 
 	// Build deployment phases: each sequential service is its own phase,
 	// each group is a phase with parallel services.
 	type deployPhase struct {
 		services []config.Service
+		group    bool
 	}
 
 	var phases []deployPhase
@@ -89,7 +470,7 @@ func CreatePipelinesFromConfig(cfg *config.Config, ref string, namespaces []stri
 	}
 	sort.Strings(groupNames)
 	for _, name := range groupNames {
-		phases = append(phases, deployPhase{services: cfg.Groups[name]})
+		phases = append(phases, deployPhase{services: cfg.Groups[name], group: true})
 	}
 
 	numPhases := len(phases)
@@ -139,13 +520,15 @@ func CreatePipelinesFromConfig(cfg *config.Config, ref string, namespaces []stri
 			go func(p, s int, svc config.Service) {
 				defer wg.Done()
 				svcFailed := false
+				ref := refFor(svc)
 
 				for n := 0; n < numNS; n++ {
 					namespace := namespaces[n]
+					log := logger.With("service", svc.Name).With("namespace", namespace)
 
 					// Library services deploy only to first namespace
 					if svc.IsLibrary && n > 0 {
-						fmt.Printf("  Skipping library service %s on %s (only first namespace)\n", svc.Name, namespace)
+						log.Infof("  Skipping library service %s on %s (only first namespace)", svc.Name, namespace)
 						close(svcDone[p][s][n])
 						continue
 					}
@@ -165,12 +548,12 @@ func CreatePipelinesFromConfig(cfg *config.Config, ref string, namespaces []stri
 						<-svcDone[p][s][n-1]
 					}
 
-					fmt.Printf("\n%sStarting pipeline for %s on tag: %s (namespace: %s)%s\n", colorBlue, svc.Name, ref, namespace, colorReset)
+					log.Infof("\n%sStarting pipeline for %s on tag: %s (namespace: %s)%s", colorBlue, svc.Name, ref, namespace, colorReset)
 
-					pipelineID, err := createPipelineForService(svc, gitlabURI, gitlabToken, ref, namespace)
+					pipelineID, err := createPipelineForService(client, svc, ref, namespace)
 					if err != nil {
 						errMsg := fmt.Sprintf("failed to create pipeline for %s (namespace: %s): %v", svc.Name, namespace, err)
-						fmt.Printf("  \033[31m✗ %s\033[0m\n", errMsg)
+						log.Errorf("  \033[31m✗ %s\033[0m", errMsg)
 						mu.Lock()
 						allErrors = append(allErrors, errMsg)
 						mu.Unlock()
@@ -179,9 +562,12 @@ func CreatePipelinesFromConfig(cfg *config.Config, ref string, namespaces []stri
 						continue
 					}
 
-					if err := waitForPipelineForService(svc, gitlabURI, gitlabToken, pipelineID, namespace); err != nil {
+					if err := waitForPipelineForService(client, svc, pipelineID, namespace); err != nil {
 						errMsg := fmt.Sprintf("pipeline failed for %s (namespace: %s): %v", svc.Name, namespace, err)
-						fmt.Printf("  \033[31m✗ %s\033[0m\n", errMsg)
+						log.Errorf("  \033[31m✗ %s\033[0m", errMsg)
+						if phases[p].group && err != ErrInterrupted {
+							cancelSiblings(client, phases[p].services, svc.Name, namespace)
+						}
 						mu.Lock()
 						allErrors = append(allErrors, errMsg)
 						mu.Unlock()
@@ -199,31 +585,168 @@ func CreatePipelinesFromConfig(cfg *config.Config, ref string, namespaces []stri
 	wg.Wait()
 
 	if len(allErrors) > 0 {
-		fmt.Printf("\n\033[31m=== Failed pipelines ===\033[0m\n")
+		logger.Errorf("\n\033[31m=== Failed pipelines ===\033[0m")
 		for _, e := range allErrors {
-			fmt.Printf("  \033[31m✗ %s\033[0m\n", e)
+			logger.Errorf("  \033[31m✗ %s\033[0m", e)
 		}
 		return fmt.Errorf("%d pipeline(s) failed", len(allErrors))
 	}
 
-	fmt.Printf("\n%s=== All namespaces deployed successfully ===%s\n", colorGreen, colorReset)
+	logger.Noticef("\n%s=== All namespaces deployed successfully ===%s", colorGreen, colorReset)
 	return nil
 }
 
+// TagResponse represents a GitLab repository tag
+type TagResponse struct {
+	Name string `json:"name"`
+}
+
+// FindPreviousTags returns, for every service in the configuration, the highest
+// release tag of its GitLab project that is lower than the given version, or than
+// the own version of the service in serviceVersions
+func FindPreviousTags(cfg *config.Config, before version.Version, serviceVersions map[string]version.Version) (map[string]string, error) {
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]string)
+
+	for _, svcMeta := range cfg.GetAllServices() {
+		svc := svcMeta.Service
+		limit := before
+		if v, ok := serviceVersions[svc.Name]; ok {
+			limit = v
+		}
+		tags, err := client.ListTags(svc.GitlabProject, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags for %s: %v", svc.Name, err)
+		}
+
+		var best version.Version
+		bestTag := ""
+		for _, tag := range tags {
+			v, ok := version.ParseTag(tag.Name, svc.Name)
+			if !ok || !v.Less(limit) {
+				continue
+			}
+			if bestTag == "" || best.Less(v) {
+				best = v
+				bestTag = tag.Name
+			}
+		}
+		if bestTag == "" {
+			return nil, fmt.Errorf("no release tag lower than %s found for %s", before, svc.Name)
+		}
+		refs[svc.Name] = bestTag
+	}
+
+	return refs, nil
+}
+
+// LatestPipelines returns the most recent pipeline of each service's GitLab project, by
+// service name. Services whose project has no pipelines are missing from the result;
+// services whose pipelines could not be read are reported in errs.
+func LatestPipelines(cfg *config.Config) (latest map[string]PipelineResponse, errs map[string]error, err error) {
+	client, err := newClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	latest = make(map[string]PipelineResponse)
+	errs = make(map[string]error)
+
+	for _, svcMeta := range cfg.GetAllServices() {
+		svc := svcMeta.Service
+		pipelines, err := client.ListPipelines(svc.GitlabProject, pipelineFilter{limit: 1})
+		if err != nil {
+			errs[svc.Name] = err
+			continue
+		}
+		if len(pipelines) > 0 {
+			latest[svc.Name] = pipelines[0]
+		}
+	}
+
+	return latest, errs, nil
+}
+
+// ServicesWithoutTag returns the services whose GitLab project has no tag with the given name
+func ServicesWithoutTag(cfg *config.Config, tag string) ([]string, error) {
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+
+	for _, svcMeta := range cfg.GetAllServices() {
+		svc := svcMeta.Service
+		tags, err := client.ListTags(svc.GitlabProject, "^"+tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags for %s: %v", svc.Name, err)
+		}
+
+		found := false
+		for _, t := range tags {
+			found = found || t.Name == tag
+		}
+		if !found {
+			missing = append(missing, svc.Name)
+		}
+	}
+
+	return missing, nil
+}
+
+// CheckProjectAccess reports, by service name, the GitLab projects that GITLAB_TOKEN
+// cannot read
+func CheckProjectAccess(cfg *config.Config) (map[string]error, error) {
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make(map[string]error)
+
+	for _, svcMeta := range cfg.GetAllServices() {
+		svc := svcMeta.Service
+		if svc.GitlabProject == "" {
+			continue
+		}
+		if err := client.GetProject(svc.GitlabProject); err != nil {
+			errs[svc.Name] = err
+		}
+	}
+
+	return errs, nil
+}
+
 // ContinuePipelinesFromConfig checks pipeline statuses and re-runs failed/missing ones.
 // All namespaces are processed in parallel since continue mode recovers an existing deployment.
 func ContinuePipelinesFromConfig(cfg *config.Config, ref string, namespaces []string) error {
-	gitlabToken := os.Getenv("GITLAB_TOKEN")
-	if gitlabToken == "" {
-		return fmt.Errorf("GITLAB_TOKEN environment variable is not set")
-	}
+	return continuePipelines(cfg, func(config.Service) string { return ref }, namespaces)
+}
 
-	gitlabURI := os.Getenv("GITLAB_URI")
-	if gitlabURI == "" {
-		return fmt.Errorf("GITLAB_URI environment variable is not set")
+// ContinuePipelinesForRefs works like ContinuePipelinesFromConfig, but checks every
+// service on its own ref, e.g. the tag of a service with its own version
+func ContinuePipelinesForRefs(cfg *config.Config, refs map[string]string, namespaces []string) error {
+	return continuePipelines(cfg, func(svc config.Service) string { return refs[svc.Name] }, namespaces)
+}
+
+// continuePipelines implements ContinuePipelinesFromConfig with the ref resolved per service
+func continuePipelines(cfg *config.Config, refFor func(config.Service) string, namespaces []string) error {
+	if plan.Enabled() {
+		planPipelines(cfg, namespaces, func(svc config.Service, namespace string) {
+			plan.Record("check pipeline for %s (project %s, ref %s, HELM_NAMESPACE=%s), re-run if failed or missing", svc.Name, svc.GitlabProject, refFor(svc), namespace)
+		})
+		return nil
 	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
 
 	var mu sync.Mutex
 	var allErrors []string
@@ -233,7 +756,7 @@ func ContinuePipelinesFromConfig(cfg *config.Config, ref string, namespaces []st
 		nsWg.Add(1)
 		go func(i int, namespace string) {
 			defer nsWg.Done()
-			errs := continueNamespace(cfg, client, gitlabURI, gitlabToken, ref, namespace, i == 0)
+			errs := continueNamespace(cfg, client, refFor, namespace, i == 0)
 			if len(errs) > 0 {
 				mu.Lock()
 				allErrors = append(allErrors, errs...)
@@ -244,9 +767,9 @@ func ContinuePipelinesFromConfig(cfg *config.Config, ref string, namespaces []st
 	nsWg.Wait()
 
 	if len(allErrors) > 0 {
-		fmt.Printf("\n\033[31m=== Failed pipelines ===\033[0m\n")
+		logger.Errorf("\n\033[31m=== Failed pipelines ===\033[0m")
 		for _, e := range allErrors {
-			fmt.Printf("  \033[31m✗ %s\033[0m\n", e)
+			logger.Errorf("  \033[31m✗ %s\033[0m", e)
 		}
 		return fmt.Errorf("%d pipeline(s) failed across namespaces", len(allErrors))
 	}
@@ -254,53 +777,83 @@ func ContinuePipelinesFromConfig(cfg *config.Config, ref string, namespaces []st
 	return nil
 }
 
+// planPipelines reports, in deployment order, the pipeline operations that would be
+// performed for every service and namespace. Used in dry-run mode instead of calling the API.
+func planPipelines(cfg *config.Config, namespaces []string, describe func(svc config.Service, namespace string)) {
+	var groupNames []string
+	for name := range cfg.Groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	for n, namespace := range namespaces {
+		logger.Infof("\n%sNamespace: %s%s", colorBlue, namespace, colorReset)
+
+		services := append([]config.Service{}, cfg.Sequential...)
+		for _, name := range groupNames {
+			services = append(services, cfg.Groups[name]...)
+		}
+
+		for _, svc := range services {
+			// Library services deploy only to first namespace
+			if svc.IsLibrary && n > 0 {
+				logger.Infof("  Skipping library service %s on %s (only first namespace)", svc.Name, namespace)
+				continue
+			}
+			describe(svc, namespace)
+		}
+	}
+}
+
 // continueNamespace processes a single namespace in continue mode.
 // Returns a list of error messages for failed services.
-func continueNamespace(cfg *config.Config, client *http.Client, gitlabURI, gitlabToken, ref, namespace string, isFirstNamespace bool) []string {
-	fmt.Printf("\n%s=== Continuing deployment for namespace: %s ===%s\n", colorBlue, namespace, colorReset)
+func continueNamespace(cfg *config.Config, client api, refFor func(config.Service) string, namespace string, isFirstNamespace bool) []string {
+	logger.Infof("\n%s=== Continuing deployment for namespace: %s ===%s", colorBlue, namespace, colorReset)
 
 	var errors []string
 
 	continueService := func(service config.Service) error {
-		info, err := checkServicePipelineStatus(client, gitlabURI, gitlabToken, service.GitlabProject, ref, service.Name, namespace)
+		ref := refFor(service)
+		info, err := checkServicePipelineStatus(client, service.GitlabProject, ref, service.Name, namespace, service.Variables, service.DeployJob)
 		if err != nil {
 			return fmt.Errorf("failed to check pipeline status for %s: %v", service.Name, err)
 		}
 
 		switch info.result {
 		case pipelineSuccess:
-			fmt.Printf("  %s✓ %s already deployed successfully (namespace: %s), skipping%s\n", colorGreen, service.Name, namespace, colorReset)
+			recordPipeline(service.Name, namespace, 0, info.webURL, "success", nil)
+			logger.Infof("  %s✓ %s already deployed successfully (namespace: %s), skipping%s", colorGreen, service.Name, namespace, colorReset)
 			if info.webURL != "" {
-				fmt.Printf("    %s\n", info.webURL)
+				logger.Infof("    %s", info.webURL)
 			}
 			return nil
 
 		case pipelineRunning:
-			fmt.Printf("  %sWaiting for existing pipeline %d for %s (namespace: %s)%s\n", colorBlue, info.pipelineID, service.Name, namespace, colorReset)
+			logger.Infof("  %sWaiting for existing pipeline %d for %s (namespace: %s)%s", colorBlue, info.pipelineID, service.Name, namespace, colorReset)
 			if info.webURL != "" {
-				fmt.Printf("    %s\n", info.webURL)
+				logger.Infof("    %s", info.webURL)
 			}
-			return waitForPipelineForService(service, gitlabURI, gitlabToken, info.pipelineID, namespace)
+			return waitForPipelineForService(client, service, info.pipelineID, namespace)
 
 		default: // pipelineNeedsRerun
-			fmt.Printf("\n%sRe-running pipeline for %s on tag: %s (namespace: %s)%s\n", colorBlue, service.Name, ref, namespace, colorReset)
-			pipelineID, err := createPipelineForService(service, gitlabURI, gitlabToken, ref, namespace)
+			logger.Infof("\n%sRe-running pipeline for %s on tag: %s (namespace: %s)%s", colorBlue, service.Name, ref, namespace, colorReset)
+			pipelineID, err := createPipelineForService(client, service, ref, namespace)
 			if err != nil {
 				return fmt.Errorf("failed to create pipeline for %s: %v", service.Name, err)
 			}
-			return waitForPipelineForService(service, gitlabURI, gitlabToken, pipelineID, namespace)
+			return waitForPipelineForService(client, service, pipelineID, namespace)
 		}
 	}
 
 	// Process sequential services first
 	for _, service := range cfg.Sequential {
 		if service.IsLibrary && !isFirstNamespace {
-			fmt.Printf("  Skipping library service %s (only deployed to first namespace)\n", service.Name)
+			logger.Infof("  Skipping library service %s (only deployed to first namespace)", service.Name)
 			continue
 		}
 		if err := continueService(service); err != nil {
 			errMsg := fmt.Sprintf("[%s] %s: %v", namespace, service.Name, err)
-			fmt.Printf("  \033[31m✗ %s\033[0m\n", errMsg)
+			logger.Errorf("  \033[31m✗ %s\033[0m", errMsg)
 			errors = append(errors, errMsg)
 		}
 	}
@@ -310,7 +863,7 @@ func continueNamespace(cfg *config.Config, client *http.Client, gitlabURI, gitla
 		var servicesToRun []config.Service
 		for _, svc := range groupServices {
 			if svc.IsLibrary && !isFirstNamespace {
-				fmt.Printf("  Skipping library service %s (only deployed to first namespace)\n", svc.Name)
+				logger.Infof("  Skipping library service %s (only deployed to first namespace)", svc.Name)
 				continue
 			}
 			servicesToRun = append(servicesToRun, svc)
@@ -320,7 +873,7 @@ func continueNamespace(cfg *config.Config, client *http.Client, gitlabURI, gitla
 			continue
 		}
 
-		fmt.Printf("\n%sProcessing group: %s (namespace: %s)%s\n", colorBlue, groupName, namespace, colorReset)
+		logger.Infof("\n%sProcessing group: %s (namespace: %s)%s", colorBlue, groupName, namespace, colorReset)
 
 		var wg sync.WaitGroup
 		groupErrors := make(chan error, len(servicesToRun))
@@ -331,6 +884,9 @@ func continueNamespace(cfg *config.Config, client *http.Client, gitlabURI, gitla
 				defer wg.Done()
 				if err := continueService(svc); err != nil {
 					groupErrors <- fmt.Errorf("%s: %v", svc.Name, err)
+					if err != ErrInterrupted {
+						cancelSiblings(client, servicesToRun, svc.Name, namespace)
+					}
 				}
 			}(service)
 		}
@@ -341,16 +897,16 @@ func continueNamespace(cfg *config.Config, client *http.Client, gitlabURI, gitla
 		for err := range groupErrors {
 			if err != nil {
 				errMsg := fmt.Sprintf("[%s] %v", namespace, err)
-				fmt.Printf("  \033[31m✗ %s\033[0m\n", errMsg)
+				logger.Errorf("  \033[31m✗ %s\033[0m", errMsg)
 				errors = append(errors, errMsg)
 			}
 		}
 	}
 
 	if len(errors) > 0 {
-		fmt.Printf("\n\033[31m=== Namespace %s completed with errors ===\033[0m\n", namespace)
+		logger.Errorf("\n\033[31m=== Namespace %s completed with errors ===\033[0m", namespace)
 	} else {
-		fmt.Printf("\n%s=== Namespace %s completed ===%s\n", colorGreen, namespace, colorReset)
+		logger.Noticef("\n%s=== Namespace %s completed ===%s", colorGreen, namespace, colorReset)
 	}
 
 	return errors
@@ -373,54 +929,42 @@ type pipelineCheckInfo struct {
 }
 
 // checkServicePipelineStatus checks the latest pipeline status for a service,
-// matching by ref and HELM_NAMESPACE pipeline variable.
-func checkServicePipelineStatus(client *http.Client, gitlabURI, gitlabToken, gitlabProject, ref, serviceName, helmNamespace string) (pipelineCheckInfo, error) {
-	projectPath := url.QueryEscape(gitlabProject)
-	updatedAfter := time.Now().Add(-24 * time.Hour).Format(time.RFC3339)
-
+// matching by ref, HELM_NAMESPACE and the extra variables of the service.
+// With a deployJob the status of that job decides instead of the pipeline status.
+func checkServicePipelineStatus(client api, gitlabProject, ref, serviceName, helmNamespace string, serviceVariables map[string]string, deployJob string) (pipelineCheckInfo, error) {
 	// Get recent pipelines for this ref
-	pipelinesURL := fmt.Sprintf("%s/api/v4/projects/%s/pipelines?ref=%s&updated_after=%s&order_by=id&sort=desc",
-		gitlabURI, projectPath, url.QueryEscape(ref), url.QueryEscape(updatedAfter))
-
-	body, err := gitlabGet(client, pipelinesURL, gitlabToken)
+	pipelines, err := client.ListPipelines(gitlabProject, pipelineFilter{ref: ref, updatedAfter: time.Now().Add(-24 * time.Hour)})
 	if err != nil {
 		return pipelineCheckInfo{result: pipelineNeedsRerun}, fmt.Errorf("failed to list pipelines: %v", err)
 	}
 
-	var pipelines []PipelineResponse
-	if err := json.Unmarshal(body, &pipelines); err != nil {
-		return pipelineCheckInfo{result: pipelineNeedsRerun}, fmt.Errorf("failed to parse pipelines: %v", err)
-	}
-
 	if len(pipelines) == 0 {
-		fmt.Printf("  No pipelines found for %s on %s in last 24h\n", serviceName, ref)
+		logger.Infof("  No pipelines found for %s on %s in last 24h", serviceName, ref)
 		return pipelineCheckInfo{result: pipelineNeedsRerun}, nil
 	}
 
 	// Find pipeline matching HELM_NAMESPACE variable
 	var runningInfo pipelineCheckInfo
 	for _, pipeline := range pipelines {
-		varsURL := fmt.Sprintf("%s/api/v4/projects/%s/pipelines/%d/variables",
-			gitlabURI, projectPath, pipeline.ID)
-
-		varsBody, err := gitlabGet(client, varsURL, gitlabToken)
+		variables, err := client.PipelineVariables(gitlabProject, pipeline.ID)
 		if err != nil {
-			fmt.Printf("  Warning: could not get variables for pipeline %d: %v\n", pipeline.ID, err)
+			logger.Warnf("  Warning: could not get variables for pipeline %d: %v", pipeline.ID, err)
 			continue
 		}
 
-		var variables []PipelineVariable
-		if err := json.Unmarshal(varsBody, &variables); err != nil {
-			fmt.Printf("  Warning: could not parse variables for pipeline %d: %v\n", pipeline.ID, err)
-			continue
-		}
-
-		// Check if HELM_NAMESPACE matches
+		// Check if HELM_NAMESPACE matches, and the variables of the service: modules of
+		// a monorepo share the project and ref and differ only in their variables
 		namespaceMatches := false
+		values := make(map[string]string)
 		for _, v := range variables {
+			values[v.Key] = v.Value
 			if v.Key == "HELM_NAMESPACE" && v.Value == helmNamespace {
 				namespaceMatches = true
-				break
+			}
+		}
+		for key, value := range serviceVariables {
+			if values[key] != value {
+				namespaceMatches = false
 			}
 		}
 
@@ -428,37 +972,61 @@ func checkServicePipelineStatus(client *http.Client, gitlabURI, gitlabToken, git
 			continue
 		}
 
+		// A pipeline whose manual deploy job was never played may still succeed,
+		// so a named deploy job is judged by its own status
+		if deployJob != "" {
+			jobs, err := client.PipelineJobs(gitlabProject, pipeline.ID)
+			if err != nil {
+				logger.Warnf("  Warning: could not get jobs for pipeline %d: %v", pipeline.ID, err)
+				continue
+			}
+			status := "missing"
+			for _, job := range jobs {
+				if job.Name == deployJob {
+					status = job.Status
+					break
+				}
+			}
+			waiting := status == "created" || status == "waiting_for_resource" || status == "pending"
+			switch {
+			case status == "success":
+				logger.Infof("  Found successful job %q in pipeline %d for %s with HELM_NAMESPACE=%s", deployJob, pipeline.ID, serviceName, helmNamespace)
+				return pipelineCheckInfo{result: pipelineSuccess, webURL: pipeline.WebURL}, nil
+			case status == "manual" || status == "running" || (waiting && !PipelineFinished(pipeline.Status)):
+				if runningInfo.pipelineID == 0 {
+					runningInfo = pipelineCheckInfo{result: pipelineRunning, pipelineID: pipeline.ID, webURL: pipeline.WebURL}
+				}
+			default:
+				logger.Infof("  Pipeline %d for %s: job %q is %s, checking other pipelines...", pipeline.ID, serviceName, deployJob, status)
+			}
+			continue
+		}
+
 		// Found matching pipeline — check if all stages completed (success/warning)
 		switch pipeline.Status {
 		case "success", "warning":
-			fmt.Printf("  Found successful pipeline %d for %s with HELM_NAMESPACE=%s (status: %s)\n", pipeline.ID, serviceName, helmNamespace, pipeline.Status)
+			logger.Infof("  Found successful pipeline %d for %s with HELM_NAMESPACE=%s (status: %s)", pipeline.ID, serviceName, helmNamespace, pipeline.Status)
 			return pipelineCheckInfo{result: pipelineSuccess, webURL: pipeline.WebURL}, nil
 		case "running", "pending", "created":
 			// Check deploy jobs before assuming pipeline is still viable
-			jobsURL := fmt.Sprintf("%s/api/v4/projects/%s/pipelines/%d/jobs?per_page=100",
-				gitlabURI, projectPath, pipeline.ID)
-			jobsBody, jobsErr := gitlabGet(client, jobsURL, gitlabToken)
-			if jobsErr == nil {
-				var jobs []JobResponse
-				if json.Unmarshal(jobsBody, &jobs) == nil {
-					// Check if "deploy helm" job is skipped/failed/canceled
-					deploySkipped := false
-					for _, job := range jobs {
-						if job.Name == "deploy helm" {
-							if job.Status == "skipped" || isJobFailed(job) {
-								deploySkipped = true
-								fmt.Printf("  Pipeline %d for %s: deploy helm job is %s, treating as failed\n", pipeline.ID, serviceName, job.Status)
-							}
-							break
+			if jobs, jobsErr := client.PipelineJobs(gitlabProject, pipeline.ID); jobsErr == nil {
+				// Check if "deploy helm" job is skipped/failed/canceled
+				deploySkipped := false
+				for _, job := range jobs {
+					if job.Name == "deploy helm" {
+						if job.Status == "skipped" || isJobFailed(job) {
+							deploySkipped = true
+							logger.Infof("  Pipeline %d for %s: deploy helm job is %s, treating as failed", pipeline.ID, serviceName, job.Status)
 						}
+						break
 					}
-					if deploySkipped {
-						break // treat as failed, check next pipeline
-					}
-					// Also check deploy stage jobs via existing helper
-					if info, found := checkDeployStageStatus(jobs, pipeline.ID, serviceName); found && info.result == pipelineNeedsRerun {
-						break // deploy stage has failed/skipped jobs
-					}
+				}
+				if deploySkipped {
+					break // treat as failed, check next pipeline
+				}
+				// Also check deploy stage jobs via existing helper
+				if info, found := checkDeployStageStatus(jobs, pipeline.ID, serviceName); found && info.result == pipelineNeedsRerun {
+					break // deploy stage has failed/skipped jobs
 				}
 			}
 			// Remember the first running pipeline, but keep looking for a successful one
@@ -467,97 +1035,87 @@ func checkServicePipelineStatus(client *http.Client, gitlabURI, gitlabToken, git
 			}
 		default:
 			// failed/canceled — skip, check remaining pipelines
-			fmt.Printf("  Pipeline %d for %s is %s, checking other pipelines...\n", pipeline.ID, serviceName, pipeline.Status)
+			logger.Infof("  Pipeline %d for %s is %s, checking other pipelines...", pipeline.ID, serviceName, pipeline.Status)
 		}
 	}
 
 	// No successful pipeline found — but maybe one is still running
 	if runningInfo.pipelineID != 0 {
-		fmt.Printf("  No successful pipeline found for %s, but pipeline %d is still running, waiting...\n", serviceName, runningInfo.pipelineID)
+		logger.Infof("  No successful pipeline found for %s, but pipeline %d is still running, waiting...", serviceName, runningInfo.pipelineID)
 		return runningInfo, nil
 	}
 
 	// No matching pipelines at all, or all failed
-	fmt.Printf("  No successful pipeline found for %s on %s with HELM_NAMESPACE=%s in last 24h\n", serviceName, ref, helmNamespace)
+	logger.Infof("  No successful pipeline found for %s on %s with HELM_NAMESPACE=%s in last 24h", serviceName, ref, helmNamespace)
 	return pipelineCheckInfo{result: pipelineNeedsRerun}, nil
 }
 
 // createPipelineForService creates a pipeline for config.Service
-func createPipelineForService(service config.Service, gitlabURI, gitlabToken, ref, helmNamespace string) (int, error) {
+func createPipelineForService(client api, service config.Service, ref, helmNamespace string) (int, error) {
+	if isInterrupted() {
+		recordPipeline(service.Name, helmNamespace, 0, "", "interrupted", nil)
+		return 0, ErrInterrupted
+	}
 	gitlabService := Service{
 		Name:          service.Name,
 		Directory:     service.Directory,
 		GitlabProject: service.GitlabProject,
+		Variables:     service.Variables,
+	}
+	pipelineID, err := createPipeline(client, gitlabService, ref, helmNamespace)
+	if err != nil {
+		recordPipeline(service.Name, helmNamespace, 0, "", "failed", err)
 	}
-	return createPipeline(gitlabService, gitlabURI, gitlabToken, ref, helmNamespace)
+	return pipelineID, err
 }
 
 // waitForPipelineForService waits for a pipeline for config.Service
-func waitForPipelineForService(service config.Service, gitlabURI, gitlabToken string, pipelineID int, namespace string) error {
+func waitForPipelineForService(client api, service config.Service, pipelineID int, namespace string) error {
 	gitlabService := Service{
 		Name:          service.Name,
 		Directory:     service.Directory,
 		GitlabProject: service.GitlabProject,
+		DeployJob:     service.DeployJob,
+	}
+	err := waitForPipeline(client, gitlabService, pipelineID, namespace)
+	recordPipeline(service.Name, namespace, pipelineID, "", outcomeStatus(err), err)
+	if _, ok := err.(*terminalError); ok {
+		recordTraces(service.Name, namespace, reportFailedJobs(client, gitlabService, pipelineID, namespace))
 	}
-	return waitForPipeline(gitlabService, gitlabURI, gitlabToken, pipelineID, namespace)
+	return err
 }
 
 // createPipeline creates a single pipeline with HELM_NAMESPACE variable
-func createPipeline(service Service, gitlabURI, gitlabToken, ref, helmNamespace string) (int, error) {
-	projectPath := url.QueryEscape(service.GitlabProject)
-	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/pipeline", gitlabURI, projectPath)
-
-	requestBody := map[string]interface{}{
-		"ref": ref,
-		"variables": []map[string]string{
-			{"key": "CI_PIPELINE_SOURCE", "value": "web"},
-			{"key": "HELM_NAMESPACE", "value": helmNamespace},
-		},
+func createPipeline(client api, service Service, ref, helmNamespace string) (int, error) {
+	variables := []PipelineVariable{
+		{Key: "CI_PIPELINE_SOURCE", Value: "web"},
+		{Key: "HELM_NAMESPACE", Value: helmNamespace},
 	}
-
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return 0, fmt.Errorf("failed to marshal request body: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", apiURL, bytes.NewReader(jsonBody))
-	if err != nil {
-		return 0, err
+	var keys []string
+	for key := range service.Variables {
+		if key != "CI_PIPELINE_SOURCE" && key != "HELM_NAMESPACE" {
+			keys = append(keys, key)
+		}
 	}
-
-	req.Header.Set("PRIVATE-TOKEN", gitlabToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 15 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, err
+	sort.Strings(keys)
+	for _, key := range keys {
+		variables = append(variables, PipelineVariable{Key: key, Value: service.Variables[key]})
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	pipelineResp, err := client.CreatePipeline(service.GitlabProject, ref, variables)
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("failed to create pipeline: %v", err)
 	}
 
-	if resp.StatusCode != http.StatusCreated {
-		return 0, fmt.Errorf("failed to create pipeline: %s", string(body))
+	logger.Infof("  Created pipeline for %s: %s", service.Name, pipelineResp.WebURL)
+	recordPipeline(service.Name, helmNamespace, pipelineResp.ID, pipelineResp.WebURL, "running", nil)
+	if onCreated != nil {
+		onCreated(CreatedPipeline{Service: service.Name, Namespace: helmNamespace, Project: service.GitlabProject, ID: pipelineResp.ID, WebURL: pipelineResp.WebURL})
 	}
 
-	var pipelineResp PipelineResponse
-	if err := json.Unmarshal(body, &pipelineResp); err != nil {
-		return 0, err
-	}
-
-	fmt.Printf("  Created pipeline for %s: %s\n", service.Name, pipelineResp.WebURL)
-
 	// Cancel any test jobs immediately so they don't hold up the deploy stage
-	jobsURL := fmt.Sprintf("%s/api/v4/projects/%s/pipelines/%d/jobs?per_page=100", gitlabURI, projectPath, pipelineResp.ID)
-	if jobsBody, jobsErr := gitlabGet(client, jobsURL, gitlabToken); jobsErr == nil {
-		var jobs []JobResponse
-		if json.Unmarshal(jobsBody, &jobs) == nil {
-			cancelTestJobs(client, gitlabURI, gitlabToken, projectPath, jobs, service.Name, helmNamespace)
-		}
+	if jobs, jobsErr := client.PipelineJobs(service.GitlabProject, pipelineResp.ID); jobsErr == nil {
+		cancelTestJobs(client, service.GitlabProject, jobs, service.Name, helmNamespace)
 	}
 
 	return pipelineResp.ID, nil
@@ -566,7 +1124,7 @@ func createPipeline(service Service, gitlabURI, gitlabToken, ref, helmNamespace
 // cancelTestJobs cancels any job whose name contains "test" (case-insensitive)
 // and has not finished yet. Test jobs are skipped during deployment so the
 // pipeline can proceed straight to the deploy stage.
-func cancelTestJobs(client *http.Client, gitlabURI, gitlabToken, projectPath string, jobs []JobResponse, serviceName, namespace string) {
+func cancelTestJobs(client api, project string, jobs []JobResponse, serviceName, namespace string) {
 	for _, job := range jobs {
 		if !strings.Contains(strings.ToLower(job.Name), "test") {
 			continue
@@ -575,60 +1133,12 @@ func cancelTestJobs(client *http.Client, gitlabURI, gitlabToken, projectPath str
 		case "success", "failed", "canceled", "skipped":
 			continue
 		}
-		cancelURL := fmt.Sprintf("%s/api/v4/projects/%s/jobs/%d/cancel", gitlabURI, projectPath, job.ID)
-		if err := gitlabPost(client, cancelURL, gitlabToken); err != nil {
-			fmt.Printf("  Warning: failed to cancel test job %q for %s (%s): %v\n", job.Name, serviceName, namespace, err)
+		if err := client.CancelJob(project, job.ID); err != nil {
+			logger.Warnf("  Warning: failed to cancel test job %q for %s (%s): %v", job.Name, serviceName, namespace, err)
 			continue
 		}
-		fmt.Printf("  Canceled test job %q for %s (%s)\n", job.Name, serviceName, namespace)
-	}
-}
-
-// gitlabGet performs a GET request to GitLab API
-func gitlabGet(client *http.Client, apiURL, token string) ([]byte, error) {
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return nil, err
+		logger.Infof("  Canceled test job %q for %s (%s)", job.Name, serviceName, namespace)
 	}
-	req.Header.Set("PRIVATE-TOKEN", token)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("GitLab API returned %d: %s", resp.StatusCode, string(body))
-	}
-
-	return body, nil
-}
-
-// gitlabPost performs a POST request to GitLab API with no body.
-func gitlabPost(client *http.Client, apiURL, token string) error {
-	req, err := http.NewRequest("POST", apiURL, nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("PRIVATE-TOKEN", token)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	body, _ := ioutil.ReadAll(resp.Body)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("GitLab API returned %d: %s", resp.StatusCode, string(body))
-	}
-	return nil
 }
 
 // pollResult represents the outcome of a single polling iteration
@@ -649,10 +1159,11 @@ func (e *terminalError) Error() string {
 }
 
 // waitForPipeline waits for a pipeline to complete by polling the pipeline status
-// and the "deploy helm" job directly.
-func waitForPipeline(service Service, gitlabURI, gitlabToken string, pipelineID int, namespace string) error {
-	projectPath := url.QueryEscape(service.GitlabProject)
-	client := &http.Client{Timeout: 30 * time.Second}
+// and the deploy job directly: the DeployJob of the service, played as soon as it
+// waits for a manual start, or "deploy helm".
+func waitForPipeline(client api, service Service, pipelineID int, namespace string) error {
+	untrack := trackPipeline(service, pipelineID, namespace)
+	defer untrack()
 
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -663,7 +1174,7 @@ func waitForPipeline(service Service, gitlabURI, gitlabToken string, pipelineID
 	var firstErrorTime time.Time
 
 	for {
-		result, err := pollPipeline(client, gitlabURI, gitlabToken, projectPath, pipelineID, service.Name, namespace)
+		result, err := pollPipeline(client, service.GitlabProject, pipelineID, service.Name, namespace, service.DeployJob)
 
 		if result == pollSuccess {
 			return nil
@@ -672,6 +1183,9 @@ func waitForPipeline(service Service, gitlabURI, gitlabToken string, pipelineID
 		if err != nil {
 			// Terminal errors (failed/canceled) — return immediately
 			if _, ok := err.(*terminalError); ok {
+				if failed := canceledFor(service, pipelineID, namespace); failed != "" {
+					return &terminalError{fmt.Sprintf("pipeline canceled for %s (%s) because %s failed", service.Name, namespace, failed)}
+				}
 				return err
 			}
 			// Transient errors — retry with timeout
@@ -681,7 +1195,7 @@ func waitForPipeline(service Service, gitlabURI, gitlabToken string, pipelineID
 			if time.Since(firstErrorTime) > maxRetryDuration {
 				return fmt.Errorf("pipeline monitoring failed for %s, errors for over %v: %v", service.Name, maxRetryDuration, err)
 			}
-			fmt.Printf("  Warning: %v\n", err)
+			logger.Warnf("  Warning: %v", err)
 		} else {
 			firstErrorTime = time.Time{}
 		}
@@ -690,69 +1204,86 @@ func waitForPipeline(service Service, gitlabURI, gitlabToken string, pipelineID
 			return fmt.Errorf("pipeline timeout for %s", service.Name)
 		}
 
-		<-ticker.C
+		select {
+		case <-ticker.C:
+		case <-interrupted:
+			return ErrInterrupted
+		}
 	}
 }
 
-// pollPipeline checks the pipeline status and "deploy helm" job directly.
-// Returns pollSuccess when "deploy helm" succeeds.
-// Returns terminalError when pipeline or "deploy helm" job fails/cancels.
+// pollPipeline checks the pipeline status and the deploy job directly: deployJob, or
+// "deploy helm" if empty. A named deployJob waiting for a manual start is played.
+// Returns pollSuccess when the deploy job succeeds.
+// Returns terminalError when pipeline or the deploy job fails/cancels.
 // Returns pollContinue to keep polling.
-func pollPipeline(client *http.Client, gitlabURI, gitlabToken, projectPath string, pipelineID int, serviceName, namespace string) (pollResult, error) {
+func pollPipeline(client api, project string, pipelineID int, serviceName, namespace, deployJob string) (pollResult, error) {
 	// Check pipeline status
-	pipelineURL := fmt.Sprintf("%s/api/v4/projects/%s/pipelines/%d", gitlabURI, projectPath, pipelineID)
-	body, err := gitlabGet(client, pipelineURL, gitlabToken)
+	pipelineResp, err := client.GetPipeline(project, pipelineID)
 	if err != nil {
 		return pollContinue, fmt.Errorf("failed to check pipeline for %s: %v", serviceName, err)
 	}
 
-	var pipelineResp PipelineResponse
-	if err := json.Unmarshal(body, &pipelineResp); err != nil {
-		return pollContinue, fmt.Errorf("failed to parse pipeline response for %s: %v", serviceName, err)
-	}
-
-	// Get jobs first — deploy helm success takes priority over pipeline-level status,
+	// Get jobs first — deploy job success takes priority over pipeline-level status,
 	// because non-critical jobs (e.g. "notify deploy") may fail the pipeline
 	// even though the actual deployment succeeded.
-	jobsURL := fmt.Sprintf("%s/api/v4/projects/%s/pipelines/%d/jobs?per_page=100", gitlabURI, projectPath, pipelineID)
-	jobsBody, err := gitlabGet(client, jobsURL, gitlabToken)
+	jobs, err := client.PipelineJobs(project, pipelineID)
 	if err != nil {
 		return pollContinue, fmt.Errorf("failed to check jobs for %s: %v", serviceName, err)
 	}
 
-	var jobs []JobResponse
-	if err := json.Unmarshal(jobsBody, &jobs); err != nil {
-		return pollContinue, fmt.Errorf("failed to parse jobs for %s: %v", serviceName, err)
-	}
-
 	// Cancel any test jobs that may have appeared since the last poll
-	cancelTestJobs(client, gitlabURI, gitlabToken, projectPath, jobs, serviceName, namespace)
+	cancelTestJobs(client, project, jobs, serviceName, namespace)
 
 	pipelineFailed := pipelineResp.Status == "failed" || pipelineResp.Status == "canceled"
 
-	// Check "deploy helm" job first
+	jobName := deployJob
+	if jobName == "" {
+		jobName = "deploy helm"
+	}
+
+	// Check the deploy job first
 	for _, job := range jobs {
-		if job.Name == "deploy helm" {
+		if job.Name == jobName {
 			switch job.Status {
 			case "success":
-				fmt.Printf("  %s✓ Job \"deploy helm\" completed successfully for %s (%s)%s\n", colorGreen, serviceName, namespace, colorReset)
+				logger.Infof("  %s✓ Job %q completed successfully for %s (%s)%s", colorGreen, jobName, serviceName, namespace, colorReset)
 				return pollSuccess, nil
 			case "failed", "canceled", "skipped":
-				return pollContinue, &terminalError{fmt.Sprintf("job \"deploy helm\" %s for %s (%s)", job.Status, serviceName, namespace)}
+				return pollContinue, &terminalError{fmt.Sprintf("job %q %s for %s (%s)", jobName, job.Status, serviceName, namespace)}
+			case "manual":
+				if deployJob == "" {
+					logger.Infof("  Job %q for %s (%s) is %s...", jobName, serviceName, namespace, job.Status)
+					return pollContinue, nil
+				}
+				if err := client.PlayJob(project, job.ID); err != nil {
+					return pollContinue, fmt.Errorf("failed to play job %q for %s: %v", jobName, serviceName, err)
+				}
+				logger.Infof("  Played manual job %q for %s (%s)", jobName, serviceName, namespace)
+				return pollContinue, nil
 			case "created", "waiting_for_resource", "pending":
-				// deploy helm hasn't started — if pipeline already failed, it will never start
+				// the deploy job hasn't started — if pipeline already failed, it will never start
 				if pipelineFailed || hasFailedJobs(jobs, job.Stage) {
-					return pollContinue, &terminalError{fmt.Sprintf("job \"deploy helm\" is %s but earlier jobs have failed for %s (%s)", job.Status, serviceName, namespace)}
+					return pollContinue, &terminalError{fmt.Sprintf("job %q is %s but earlier jobs have failed for %s (%s)", jobName, job.Status, serviceName, namespace)}
 				}
-				fmt.Printf("  Job \"deploy helm\" for %s (%s) is %s...\n", serviceName, namespace, job.Status)
+				logger.Infof("  Job %q for %s (%s) is %s...", jobName, serviceName, namespace, job.Status)
 				return pollContinue, nil
 			default:
-				fmt.Printf("  Job \"deploy helm\" for %s (%s) is %s...\n", serviceName, namespace, job.Status)
+				logger.Infof("  Job %q for %s (%s) is %s...", jobName, serviceName, namespace, job.Status)
 				return pollContinue, nil
 			}
 		}
 	}
 
+	// A named deploy job is not replaced by the deploy stage
+	if deployJob != "" {
+		if PipelineFinished(pipelineResp.Status) {
+			return pollContinue, &terminalError{fmt.Sprintf("pipeline %s for %s (%s), job %q not found", pipelineResp.Status, serviceName, namespace, deployJob)}
+		}
+		logger.Infof("  Pipeline for %s (%s) is %s, waiting for job %q...", serviceName, namespace, pipelineResp.Status, deployJob)
+		return pollContinue, nil
+	}
+
 	// No "deploy helm" job — fall back to checking "deploy" stage jobs
 	result, termErr := pollDeployStage(jobs, serviceName, namespace)
 	if result == pollSuccess || termErr != nil {
@@ -765,7 +1296,7 @@ func pollPipeline(client *http.Client, gitlabURI, gitlabToken, projectPath strin
 	}
 
 	// No deploy stage jobs found yet
-	fmt.Printf("  Pipeline for %s (%s) is %s, waiting for deploy jobs...\n", serviceName, namespace, pipelineResp.Status)
+	logger.Infof("  Pipeline for %s (%s) is %s, waiting for deploy jobs...", serviceName, namespace, pipelineResp.Status)
 	return pollContinue, nil
 }
 
@@ -791,18 +1322,18 @@ func checkDeployStageStatus(jobs []JobResponse, pipelineID int, serviceName stri
 			continue // ok or allowed to fail
 		}
 		if job.Status == "failed" || job.Status == "canceled" || job.Status == "skipped" {
-			fmt.Printf("  Pipeline %d for %s: deploy stage job \"%s\" is %s\n", pipelineID, serviceName, job.Name, job.Status)
+			logger.Infof("  Pipeline %d for %s: deploy stage job \"%s\" is %s", pipelineID, serviceName, job.Name, job.Status)
 			return pipelineCheckInfo{result: pipelineNeedsRerun}, true
 		}
 		allDone = false
 	}
 
 	if allDone {
-		fmt.Printf("  Pipeline %d for %s: all deploy stage jobs completed successfully\n", pipelineID, serviceName)
+		logger.Infof("  Pipeline %d for %s: all deploy stage jobs completed successfully", pipelineID, serviceName)
 		return pipelineCheckInfo{result: pipelineSuccess}, true
 	}
 
-	fmt.Printf("  Pipeline %d for %s: deploy stage jobs still running, waiting...\n", pipelineID, serviceName)
+	logger.Infof("  Pipeline %d for %s: deploy stage jobs still running, waiting...", pipelineID, serviceName)
 	return pipelineCheckInfo{result: pipelineRunning, pipelineID: pipelineID}, true
 }
 
@@ -833,11 +1364,11 @@ func pollDeployStage(jobs []JobResponse, serviceName, namespace string) (pollRes
 	}
 
 	if allDone {
-		fmt.Printf("  %s✓ All deploy stage jobs completed successfully for %s (%s)%s\n", colorGreen, serviceName, namespace, colorReset)
+		logger.Infof("  %s✓ All deploy stage jobs completed successfully for %s (%s)%s", colorGreen, serviceName, namespace, colorReset)
 		return pollSuccess, nil
 	}
 
-	fmt.Printf("  Deploy stage jobs for %s (%s) still running...\n", serviceName, namespace)
+	logger.Infof("  Deploy stage jobs for %s (%s) still running...", serviceName, namespace)
 	return pollContinue, nil
 }
 
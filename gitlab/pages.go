@@ -0,0 +1,26 @@
+package gitlab
+
+import (
+	gl "gitlab.com/gitlab-org/api/client-go"
+)
+
+// perPage is the page size of the listings, the largest GitLab allows
+const perPage = 100
+
+// allPages returns the items of every page of a listing: list is called with the page
+// to read, starting with the first, until the X-Next-Page header of a response is empty
+func allPages[T any](list func(page gl.ListOptions) ([]T, *gl.Response, error)) ([]T, error) {
+	var all []T
+	page := gl.ListOptions{PerPage: perPage, Page: 1}
+	for {
+		items, resp, err := list(page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+		if resp == nil || resp.NextPage == 0 {
+			return all, nil
+		}
+		page.Page = resp.NextPage
+	}
+}
@@ -0,0 +1,93 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+
+	"deploy/logger"
+)
+
+// Retry policy of the API requests: network errors, 429 and 5xx responses are retried
+// up to retryMax times, waiting retryWaitMin before the first retry, doubled for each
+// next one up to retryWaitMax
+var (
+	retryMax     = 5
+	retryWaitMin = time.Second
+	retryWaitMax = 30 * time.Second
+)
+
+// SetRetries sets how often a failed GitLab API request is retried and the delay before
+// the first retry, doubled for each next one up to maxDelay. A Retry-After header of the
+// response overrides the delay, but not maxDelay. Only the error of the last attempt is
+// returned.
+func SetRetries(retries int, delay, maxDelay time.Duration) {
+	retryMax = retries
+	retryWaitMin = delay
+	retryWaitMax = maxDelay
+}
+
+// retryCreate is the retry policy of the requests that create something in GitLab, e.g.
+// a pipeline. A 502 or 504 may come from a proxy after GitLab has already created it, and
+// resending the request would create it twice, so only the failures known to happen before
+// GitLab handled the request are retried: connections that could not be opened and 429.
+func retryCreate(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if err != nil {
+		var opErr *net.OpError
+		return errors.As(err, &opErr) && opErr.Op == "dial", nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests, nil
+}
+
+// retryBackoff returns the delay before the retry after attempt attemptNum (from 0):
+// the Retry-After of the response, or the exponential backoff between min and max. A
+// Retry-After longer than max, e.g. an hour from a proxy, is cut to max.
+func retryBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	wait, ok := retryAfter(resp)
+	if !ok {
+		wait = retryablehttp.DefaultBackoff(min, max, attemptNum, nil)
+	}
+	if wait > max {
+		wait = max
+	}
+
+	reason := "request failed"
+	if resp != nil {
+		reason = "returned " + resp.Status
+		if resp.Request != nil {
+			reason = resp.Request.Method + " " + resp.Request.URL.Path + " " + reason
+		}
+	}
+	logger.Warnf("  Warning: GitLab API %s, retry %d of %d in %s", reason, attemptNum+1, retryMax, wait)
+	return wait
+}
+
+// retryAfter returns the delay of the Retry-After header of the response, given in
+// seconds or as an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,83 @@
+package gitlab
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client of the API served by handler, retrying without delay
+func newTestClient(t *testing.T, handler http.Handler) api {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	t.Setenv("GITLAB_URI", server.URL)
+	t.Setenv("GITLAB_TOKEN", "token")
+
+	retries, delay, maxDelay := retryMax, retryWaitMin, retryWaitMax
+	SetRetries(3, time.Millisecond, time.Millisecond)
+	t.Cleanup(func() { SetRetries(retries, delay, maxDelay) })
+
+	client, err := newClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestCreatePipelineNotRetriedOnBadGateway(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+
+	if _, err := client.CreatePipeline("team/billing", "v1.0.0", nil); err == nil {
+		t.Fatal("CreatePipeline succeeded on 502")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("CreatePipeline sent %d requests, want 1", n)
+	}
+}
+
+func TestCreatePipelineRetriedOnTooManyRequests(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 7, "ref": "v1.0.0", "status": "created"}`))
+	}))
+
+	pipeline, err := client.CreatePipeline("team/billing", "v1.0.0", nil)
+	if err != nil || pipeline.ID != 7 {
+		t.Fatalf("CreatePipeline = %+v, %v; want pipeline 7", pipeline, err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("CreatePipeline sent %d requests, want 2", n)
+	}
+}
+
+func TestGetPipelineRetriedOnBadGateway(t *testing.T) {
+	var calls atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 7, "status": "running"}`))
+	}))
+
+	pipeline, err := client.GetPipeline("team/billing", 7)
+	if err != nil || pipeline.Status != "running" {
+		t.Fatalf("GetPipeline = %+v, %v; want running", pipeline, err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("GetPipeline sent %d requests, want 3", n)
+	}
+}
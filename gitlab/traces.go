@@ -0,0 +1,95 @@
+package gitlab
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"deploy/logger"
+)
+
+// traceTailLines is how many last lines of the trace of a failed job are logged
+const traceTailLines = 30
+
+// traceDir keeps the traces of the failed jobs, see SetTraceDir
+var traceDir string
+
+// SetTraceDir sets the directory that keeps the full traces of the jobs that failed a
+// pipeline. Without it only the last lines of the traces are logged.
+func SetTraceDir(dir string) {
+	traceDir = dir
+}
+
+// ansiEscapes matches the color codes and GitLab section markers of a job trace
+var ansiEscapes = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]|section_(start|end):[0-9]+:[^\r\n]*\r`)
+
+// unsafeFileChars matches the characters of a job name not kept in a file name
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// reportFailedJobs logs the end of the trace of every failed job of the pipeline and
+// saves the full traces in traceDir. It returns the files written; failures to read
+// the traces are only warned about, the pipeline has failed anyway.
+func reportFailedJobs(client api, service Service, pipelineID int, namespace string) []string {
+	jobs, err := client.PipelineJobs(service.GitlabProject, pipelineID)
+	if err != nil {
+		logger.Warnf("  Warning: could not get jobs of pipeline %d for %s: %v", pipelineID, service.Name, err)
+		return nil
+	}
+
+	log := logger.With("service", service.Name).With("namespace", namespace)
+	var files []string
+	for _, job := range jobs {
+		if job.Status != "failed" || job.AllowFailure || ignoredJobs[job.Name] {
+			continue
+		}
+		trace, err := client.JobTrace(service.GitlabProject, job.ID)
+		if err != nil {
+			log.Warnf("  Warning: could not get the trace of job %q for %s (%s): %v", job.Name, service.Name, namespace, err)
+			continue
+		}
+
+		log.Errorf("  Job %q failed for %s (%s), last lines of its trace:", job.Name, service.Name, namespace)
+		for _, line := range traceTail(trace, traceTailLines) {
+			log.Errorf("    | %s", line)
+		}
+
+		if traceDir == "" {
+			continue
+		}
+		name := fmt.Sprintf("%s-%s-%s-%d.log", service.Name, namespace, strings.Trim(unsafeFileChars.ReplaceAllString(job.Name, "-"), "-"), job.ID)
+		file := filepath.Join(traceDir, name)
+		if err := os.MkdirAll(traceDir, 0755); err != nil {
+			log.Warnf("  Warning: failed to save the trace of job %q: %v", job.Name, err)
+			continue
+		}
+		if err := os.WriteFile(file, []byte(trace), 0644); err != nil {
+			log.Warnf("  Warning: failed to save the trace of job %q: %v", job.Name, err)
+			continue
+		}
+		log.Errorf("  Full trace: %s", file)
+		files = append(files, file)
+	}
+	return files
+}
+
+// traceTail returns the last n non-empty lines of a job trace without color codes and
+// section markers, keeping of a line redrawn with \r only its final text
+func traceTail(trace string, n int) []string {
+	trace = ansiEscapes.ReplaceAllString(trace, "")
+	var lines []string
+	for _, line := range strings.Split(trace, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if i := strings.LastIndex(line, "\r"); i >= 0 {
+			line = line[i+1:]
+		}
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
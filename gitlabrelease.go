@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+
+	"deploy/gitlab"
+	"deploy/notes"
+)
+
+// createGitlabReleases creates the GitLab release of the tag of every service whose
+// pipelines succeeded, with the release notes of the service as the description.
+// It runs at the end of the pipelines phase when gitlab_release is configured.
+func (d *deployment) createGitlabReleases() {
+	for _, service := range d.activeServices() {
+		if !d.st.Done("pipelines", service) || d.skipDone("gitlab-release", service) {
+			continue
+		}
+		tag := d.tagFor(service)
+		project := d.gitlabProject(service)
+
+		description, err := d.markdownNotes(service)
+		if err != nil {
+			d.failService(service, exitPipeline, "Failed to collect the release notes of %s: %v", service, err)
+			continue
+		}
+
+		url, err := gitlab.CreateRelease(project, tag, fmt.Sprintf("Release %s", d.versionFor(service)), description, d.releaseLinks(service, project, tag))
+		if err != nil {
+			d.failService(service, exitPipeline, "Failed to create the GitLab release %s of %s: %v", tag, service, err)
+			continue
+		}
+		if url != "" {
+			d.logFor(service).Noticef("  GitLab release %s of %s: %s", tag, service, url)
+		}
+		d.markDone("gitlab-release", service)
+	}
+}
+
+// markdownNotes returns the release notes of the service in Markdown
+func (d *deployment) markdownNotes(service string) (string, error) {
+	svc := notes.Service{Name: service, Dir: d.repoDirs[service], Project: d.gitlabProject(service), Branch: d.baseBranchFor(service)}
+	ver := d.versionFor(service)
+	svc.Version = &ver
+	release, err := notes.Collect([]notes.Service{svc}, d.version, "")
+	if err != nil {
+		return "", err
+	}
+	return notes.RenderMarkdown(release, releaseNotesLinks(d.cfg)), nil
+}
+
+// releaseLinks returns the links of the GitLab release of the service: its pipelines
+// and the artifacts of the configured jobs
+func (d *deployment) releaseLinks(service, project, tag string) []gitlab.ReleaseLink {
+	var links []gitlab.ReleaseLink
+	if d.cfg.GitlabRelease.LinkPipelines {
+		for _, p := range gitlab.PipelineResults() {
+			if p.Service == service && p.WebURL != "" {
+				links = append(links, gitlab.ReleaseLink{
+					Name:     fmt.Sprintf("Pipeline #%d (%s)", p.ID, p.Namespace),
+					URL:      p.WebURL,
+					LinkType: "other",
+				})
+			}
+		}
+	}
+	for _, job := range d.cfg.GitlabRelease.ArtifactJobs {
+		links = append(links, gitlab.ReleaseLink{
+			Name:     fmt.Sprintf("Artifacts of %s", job),
+			URL:      gitlab.ArtifactsURL(project, tag, job),
+			LinkType: "package",
+		})
+	}
+	return links
+}
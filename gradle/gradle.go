@@ -0,0 +1,308 @@
+package gradle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"deploy/command"
+	"deploy/logger"
+	"deploy/plan"
+	"deploy/version"
+)
+
+// propertyLine matches a key=value or key: value line of gradle.properties
+var propertyLine = regexp.MustCompile(`^(\s*)([\w.-]+)(\s*[=:]\s*)(\S.*?)(\s*)$`)
+
+// versionLine matches the literal project version of build.gradle or build.gradle.kts:
+// version = '1.2.3', version "1.2.3" or version = "1.2.3"
+var versionLine = regexp.MustCompile(`^(\s*version\s*=?\s*)(['"])([^'"$]*)(['"].*)$`)
+
+// CleanCache removes the artifacts of the groups under cachePath, given as a Maven
+// repository path like com/company, from the Gradle dependency cache
+func CleanCache(cachePath string) error {
+	cacheDir := filepath.Join(UserHome(), "caches", "modules-2", "files-2.1")
+	group := strings.Trim(strings.ReplaceAll(filepath.ToSlash(cachePath), "/", "."), ".")
+
+	logger.Infof("Cleaning Gradle cache: %s", filepath.Join(cacheDir, group+"*"))
+
+	entries, err := ioutil.ReadDir(cacheDir)
+	if os.IsNotExist(err) {
+		logger.Infof("Gradle cache directory does not exist, skipping cleanup")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Gradle keeps one directory per group, so com.company.billing is not inside com.company
+	for _, entry := range entries {
+		if entry.Name() != group && !strings.HasPrefix(entry.Name(), group+".") {
+			continue
+		}
+		target := filepath.Join(cacheDir, entry.Name())
+		if plan.Enabled() {
+			plan.Record("remove %s", target)
+			continue
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("failed to remove Gradle cache directory: %v", err)
+		}
+	}
+
+	logger.Infof("Gradle cache cleaned successfully")
+	return nil
+}
+
+// UserHome returns the Gradle user home: GRADLE_USER_HOME or ~/.gradle
+func UserHome() string {
+	if home := os.Getenv("GRADLE_USER_HOME"); home != "" {
+		return home
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		logger.Fatalf("Could not determine Gradle user home: %v", err)
+	}
+	return filepath.Join(homeDir, ".gradle")
+}
+
+// testSummary matches the test totals of a failed Gradle test task
+var testSummary = regexp.MustCompile(`(\d+) tests? completed, (\d+) failed`)
+
+// BuildService builds a service with its Gradle wrapper, or the installed Gradle if it
+// has none: clean build -x test, or clean build with runTests. With ignoreTestFailures
+// the tests run after the build and their failures are returned as a count instead of
+// failing it. The output goes to log, or the root logger if it is nil.
+func BuildService(serviceDir string, runTests, ignoreTestFailures bool, log *logger.Logger) (int, error) {
+	gradle := wrapper(serviceDir)
+	build := []string{"clean", "build"}
+	if !runTests || ignoreTestFailures {
+		build = append(build, "-x", "test")
+	}
+	if plan.Enabled() {
+		plan.Record("%s %s (in %s)", gradle, strings.Join(build, " "), serviceDir)
+		if runTests && ignoreTestFailures {
+			plan.Record("%s test --continue (in %s)", gradle, serviceDir)
+		}
+		return 0, nil
+	}
+
+	if _, err := runGradle(log, serviceDir, gradle, build...); err != nil {
+		logger.Errorf("\n\033[31mBuild failed!\033[0m")
+		return 0, fmt.Errorf("gradle %s failed: %v", strings.Join(build, " "), err)
+	}
+	if !runTests || !ignoreTestFailures {
+		return 0, nil
+	}
+
+	output, err := runGradle(log, serviceDir, gradle, "test", "--continue")
+	if err == nil {
+		return 0, nil
+	}
+	failed := 0
+	for _, match := range testSummary.FindAllStringSubmatch(output, -1) {
+		n, _ := strconv.Atoi(match[2])
+		failed += n
+	}
+	if failed == 0 {
+		// The test task failed without a summary, e.g. on a compilation error
+		failed = 1
+	}
+	return failed, nil
+}
+
+// runGradle runs Gradle in the service directory, printing its output in real-time,
+// and returns the output
+func runGradle(log *logger.Logger, serviceDir, gradle string, args ...string) (string, error) {
+	cmd := command.New(gradle, args...)
+	cmd.Dir = serviceDir
+
+	var output, stderr bytes.Buffer
+	out := log.Writer(logger.LevelInfo)
+	cmd.Stdout = io.MultiWriter(&output, out)
+	cmd.Stderr = io.MultiWriter(&output, &stderr, out)
+
+	err := cmd.Run()
+	out.Flush()
+	if err != nil && stderr.Len() > 0 {
+		logger.Infof("Error output:\n%s", stderr.String())
+	}
+	return output.String(), err
+}
+
+// wrapper returns the command that runs Gradle in the service directory
+func wrapper(serviceDir string) string {
+	name := "gradlew"
+	if runtime.GOOS == "windows" {
+		name = "gradlew.bat"
+	}
+	path := filepath.Join(serviceDir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "gradle"
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// UpdateVersionFiles sets the project version in the gradle.properties and build.gradle
+// files of the directory tree, and the gradle.properties entries whose key contains one
+// of propertyPatterns, to the new version
+func UpdateVersionFiles(dir string, version version.Version, propertyPatterns []string, skipProperties []string) error {
+	files, err := findVersionFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		oldLines := strings.Split(string(data), "\n")
+		newLines := rewriteVersionFile(file, oldLines, version.String(), propertyPatterns, skipProperties)
+
+		changed := false
+		for i := range newLines {
+			if oldLines[i] == newLines[i] {
+				continue
+			}
+			changed = true
+			if plan.Enabled() {
+				plan.Record("edit %s:%d: %s -> %s", file, i+1, strings.TrimSpace(oldLines[i]), strings.TrimSpace(newLines[i]))
+			}
+		}
+		if !changed || plan.Enabled() {
+			continue
+		}
+		if err := ioutil.WriteFile(file, []byte(strings.Join(newLines, "\n")), 0644); err != nil {
+			return fmt.Errorf("failed to update %s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+// rewriteVersionFile returns the lines of a gradle.properties or build.gradle file
+// with the versions set to newVersion. Only the values change, never the formatting.
+func rewriteVersionFile(file string, lines []string, newVersion string, propertyPatterns []string, skipProperties []string) []string {
+	updated := make([]string, len(lines))
+	copy(updated, lines)
+	isProperties := filepath.Base(file) == "gradle.properties"
+
+	for i, line := range lines {
+		if !isProperties {
+			if m := versionLine.FindStringSubmatch(line); m != nil {
+				updated[i] = m[1] + m[2] + newVersion + m[4]
+			}
+			continue
+		}
+
+		m := propertyLine.FindStringSubmatch(line)
+		if m == nil || strings.HasPrefix(m[2], "#") {
+			continue
+		}
+		key := m[2]
+		if key != "version" && !matchesProperty(key, propertyPatterns) {
+			continue
+		}
+		if isSkipped(key, skipProperties) {
+			logger.Infof("    Skipping property %s in %s", key, file)
+			continue
+		}
+		updated[i] = m[1] + key + m[3] + newVersion + m[5]
+	}
+	return updated
+}
+
+// matchesProperty reports whether the key contains one of the property patterns
+func matchesProperty(key string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern != "" && strings.Contains(key, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSkipped reports whether the property is listed in skip_properties
+func isSkipped(key string, skipProperties []string) bool {
+	for _, skip := range skipProperties {
+		if key == skip {
+			return true
+		}
+	}
+	return false
+}
+
+// findVersionFiles returns the gradle.properties, build.gradle and build.gradle.kts
+// files of the directory tree, leaving out build outputs and the Gradle caches
+func findVersionFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && (info.Name() == "build" || strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch info.Name() {
+		case "gradle.properties", "build.gradle", "build.gradle.kts":
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// ProjectVersion returns the project version of the Gradle build in dir, from
+// gradle.properties or the build script, without a -SNAPSHOT or other qualifier; ok
+// is false if neither sets a literal version
+func ProjectVersion(dir string) (v version.Version, ok bool, err error) {
+	for _, name := range []string{"gradle.properties", "build.gradle", "build.gradle.kts"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return version.Version{}, false, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			value := ""
+			if name == "gradle.properties" {
+				if m := propertyLine.FindStringSubmatch(line); m != nil && m[2] == "version" {
+					value = m[4]
+				}
+			} else if m := versionLine.FindStringSubmatch(line); m != nil {
+				value = m[3]
+			}
+			if value == "" {
+				continue
+			}
+			if v, ok := version.Find(value); ok {
+				return v, true, nil
+			}
+		}
+	}
+	return version.Version{}, false, nil
+}
+
+// IsProject reports whether dir holds a Gradle build
+func IsProject(dir string) bool {
+	for _, name := range []string{"build.gradle", "build.gradle.kts", "settings.gradle", "settings.gradle.kts"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package gradle
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"deploy/logger"
+	"deploy/plan"
+)
+
+// bomFiles are where the org.cyclonedx.bom plugin writes the SBOM of the project,
+// by plugin version
+var bomFiles = []string{
+	filepath.Join("build", "reports", "bom.json"),
+	filepath.Join("build", "reports", "cyclonedx", "bom.json"),
+}
+
+// GenerateSBOM writes the CycloneDX SBOM of the built service in serviceDir to file
+// with the cyclonedxBom task, which the build must get from the org.cyclonedx.bom
+// plugin. The output goes to log, or the root logger if it is nil.
+func GenerateSBOM(serviceDir, file string, log *logger.Logger) error {
+	gradle := wrapper(serviceDir)
+	if plan.Enabled() {
+		plan.Record("%s cyclonedxBom (in %s)", gradle, serviceDir)
+		plan.Record("copy %s to %s", filepath.Join(serviceDir, bomFiles[0]), file)
+		return nil
+	}
+	if _, err := runGradle(log, serviceDir, gradle, "cyclonedxBom"); err != nil {
+		return fmt.Errorf("gradle cyclonedxBom failed (is the org.cyclonedx.bom plugin applied?): %v", err)
+	}
+	for _, name := range bomFiles {
+		data, err := ioutil.ReadFile(filepath.Join(serviceDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(file, data, 0644)
+	}
+	return fmt.Errorf("cyclonedxBom wrote no %s", bomFiles[0])
+}
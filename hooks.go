@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+
+	"deploy/config"
+	"deploy/hooks"
+	"deploy/logger"
+)
+
+// checkHooks returns the problems of the hooks in the configuration
+func checkHooks(cfg *config.Config) []string {
+	var problems []string
+	check := func(owner string, list []config.Hook) {
+		for i, hook := range list {
+			where := fmt.Sprintf("%s hook #%d", owner, i+1)
+			if !knownPhase(hook.Phase) {
+				problems = append(problems, fmt.Sprintf("%s: unknown phase %q (available: %s)", where, hook.Phase, phaseNames()))
+			}
+			if hook.When != config.HookBefore && hook.When != config.HookAfter {
+				problems = append(problems, fmt.Sprintf("%s: when must be before or after, got %q", where, hook.When))
+			}
+			if hook.Run == "" {
+				problems = append(problems, fmt.Sprintf("%s: run is not set", where))
+			}
+			if hook.OnFailure != "" && hook.OnFailure != config.HookFatal && hook.OnFailure != config.HookWarn {
+				problems = append(problems, fmt.Sprintf("%s: on_failure must be fatal or warn, got %q", where, hook.OnFailure))
+			}
+			if _, err := parseTimeout(hook.Timeout); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: timeout: %v", where, err))
+			}
+		}
+	}
+
+	check("global", cfg.Hooks)
+	for _, svc := range cfg.GetAllServices() {
+		check(svc.Name, svc.Hooks)
+	}
+	return problems
+}
+
+// knownPhase reports whether name is the name of a phase
+func knownPhase(name string) bool {
+	for _, p := range phases {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hooksFor returns the hooks of the list registered for the phase and timing
+func hooksFor(list []config.Hook, phase, when string) []config.Hook {
+	var matched []config.Hook
+	for _, hook := range list {
+		if hook.Phase == phase && hook.When == when {
+			matched = append(matched, hook)
+		}
+	}
+	return matched
+}
+
+// runHooks runs the hooks of a phase: global hooks once in the services directory,
+// then the hooks of each service in its directory. Completed hooks are recorded in
+// the state, so a resumed deployment does not repeat them.
+func (d *deployment) runHooks(phase, when string) {
+	key := fmt.Sprintf("%s-hooks-%s", when, phase)
+
+	if global := hooksFor(d.cfg.Hooks, phase, when); len(global) > 0 && !d.st.DoneGlobal(key) {
+		for _, hook := range global {
+			d.runHook(hook, d.log, "", d.directory)
+		}
+		d.markDone(key, "")
+	}
+
+	d.forEachService(func(service string) {
+		list := hooksFor(d.serviceHooks[service], phase, when)
+		if len(list) == 0 || d.st.Done(key, service) {
+			return
+		}
+		d.checkInterrupted()
+		for _, hook := range list {
+			if !d.runHook(hook, d.logFor(service), service, d.serviceDirs[service]) {
+				return
+			}
+		}
+		if err := d.st.MarkDone(key, service); err != nil {
+			logger.Fatalf("Failed to save deployment state: %v", err)
+		}
+	})
+}
+
+// runHook executes a single hook, stopping the deployment if it fails and is fatal.
+// It returns false if a fatal hook of a service failed in a -keep-going deployment.
+func (d *deployment) runHook(hook config.Hook, log *logger.Logger, service, dir string) bool {
+	env := []string{
+		"DEPLOY_PHASE=" + hook.Phase,
+		"DEPLOY_HOOK=" + hook.When,
+		"DEPLOY_VERSION=" + d.versionFor(service).String(),
+		"DEPLOY_TAG=" + d.tagFor(service),
+		"DEPLOY_DIRECTORY=" + d.directory,
+	}
+	if service != "" {
+		env = append(env, "DEPLOY_SERVICE="+service)
+	}
+
+	log.Infof("  Running %s-%s hook%s: %s", hook.When, hook.Phase, forService(service), hook.Run)
+	timeout, _ := parseTimeout(hook.Timeout) // validated by checkHooks
+	output, err := hooks.Run(hook.Run, dir, env, timeout)
+	if output != "" {
+		log.Infof("%s", output)
+	}
+	if err == nil {
+		return true
+	}
+	switch {
+	case hook.Fatal() && service != "":
+		d.failService(service, exitFailure, "Hook %q failed%s: %v", hook.Run, forService(service), err)
+		return false
+	case hook.Fatal():
+		log.Fatalf("Hook %q failed%s: %v", hook.Run, forService(service), err)
+	}
+	log.Warnf("Warning: hook %q failed%s: %v", hook.Run, forService(service), err)
+	return true
+}
+
+// forService returns " for <service>", or nothing for global hooks
+func forService(service string) string {
+	if service == "" {
+		return ""
+	}
+	return " for " + service
+}
@@ -0,0 +1,29 @@
+package hooks
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"deploy/command"
+	"deploy/plan"
+)
+
+// Run executes a hook command with sh -c in dir and returns its combined output.
+// env is added to the environment of the command. A non-zero timeout overrides
+// the configured command timeout. In dry-run mode the command is only reported.
+func Run(run string, dir string, env []string, timeout time.Duration) (string, error) {
+	if plan.Enabled() {
+		plan.Record("(cd %s && %s)", dir, run)
+		return "", nil
+	}
+
+	cmd := command.New("sh", "-c", run)
+	cmd.Dir = dir
+	if timeout > 0 {
+		cmd.Timeout = timeout
+	}
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	return strings.TrimRight(string(output), "\n"), err
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"deploy/config"
+	"deploy/git"
+	"deploy/logger"
+	"deploy/tui"
+)
+
+// discoveredService is a Maven project with its own git repository found by deploy init
+type discoveredService struct {
+	name      string
+	directory string // relative to the services directory
+	project   string // GitLab project path, empty if the origin remote is unknown
+	group     string // empty for sequential services
+}
+
+// runInit implements "deploy init": scans the services directory for git repositories
+// with a pom.xml and writes a deploy.yaml listing them, asking which services may be
+// deployed in parallel groups
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	var logOpts logFlags
+	logOpts.register(fs)
+	var (
+		directory string
+		output    string
+		force     bool
+		assumeYes bool
+	)
+	fs.StringVar(&directory, "directory", "", "Base directory to scan for services (required)")
+	fs.StringVar(&directory, "d", "", "Base directory to scan for services (shorthand)")
+	fs.StringVar(&output, "output", "deploy.yaml", "Configuration file to write")
+	fs.StringVar(&output, "o", "deploy.yaml", "Configuration file to write (shorthand)")
+	fs.BoolVar(&force, "force", false, "Overwrite the configuration file if it exists")
+	fs.BoolVar(&assumeYes, "yes", false, "Non-interactive mode: put every service in the sequential list")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s init [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Generates a configuration file from the git repositories with a pom.xml found in a directory.\n\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExample:\n")
+		fmt.Fprintf(os.Stderr, "  %s init -d /path/to/services -o deploy.yaml\n", os.Args[0])
+	}
+	fs.Parse(args)
+	logOpts.apply()
+
+	if directory == "" {
+		logger.Exitf(exitConfig, "Error: -directory parameter is required\n\nUse -h for help")
+	}
+	if _, err := os.Stat(output); err == nil && !force {
+		logger.Exitf(exitConfig, "Error: %s already exists, use -force to overwrite it", output)
+	}
+
+	services, err := discoverServices(directory)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: failed to scan %s: %v", directory, err)
+	}
+	if len(services) == 0 {
+		logger.Exitf(exitConfig, "Error: no git repositories with a pom.xml found in %s", directory)
+	}
+
+	logger.Infof("Found %d service(s) in %s:", len(services), directory)
+	for _, svc := range services {
+		project := svc.project
+		if project == "" {
+			project = "(no origin remote)"
+		}
+		logger.Infof("  %-40s %s", svc.directory, project)
+	}
+
+	if !assumeYes && tui.IsTerminal(os.Stdin) && tui.IsTerminal(os.Stdout) {
+		askGroups(services, bufio.NewReader(os.Stdin))
+	}
+
+	content := renderConfig(services)
+	if err := ioutil.WriteFile(output, []byte(content), 0644); err != nil {
+		logger.Exitf(exitFailure, "Error: failed to write %s: %v", output, err)
+	}
+	if err := config.CheckSchema(output); err != nil {
+		logger.Exitf(exitFailure, "Error: the generated %s is not valid: %v", output, err)
+	}
+
+	for _, svc := range services {
+		if svc.project == "" {
+			logger.Warnf("Warning: set gitlab_project of %s in %s, its origin remote is unknown", svc.name, output)
+		}
+	}
+	logger.Noticef("\nConfiguration written to %s", output)
+	logger.Infof("Check it with: %s validate -d %s -c %s", os.Args[0], directory, output)
+}
+
+// discoverServices walks the directory and returns every subdirectory that has both
+// a .git entry and a pom.xml, in lexical order. Modules nested inside a found
+// repository are not services of their own.
+func discoverServices(directory string) ([]*discoveredService, error) {
+	var services []*discoveredService
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if path != directory && (strings.HasPrefix(name, ".") || name == "target" || name == "node_modules") {
+			return filepath.SkipDir
+		}
+		if !exists(filepath.Join(path, ".git")) || !exists(filepath.Join(path, "pom.xml")) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+		svc := &discoveredService{name: name, directory: filepath.ToSlash(rel)}
+		if rel == "." {
+			svc.name = filepath.Base(filepath.Clean(directory))
+		}
+		if remote, err := git.RemoteURL(path); err == nil {
+			svc.project = projectFromRemote(remote)
+		}
+		services = append(services, svc)
+		return filepath.SkipDir
+	})
+	return services, err
+}
+
+// exists reports whether the path exists
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// projectFromRemote returns the GitLab project path of a remote URL (https or ssh),
+// e.g. team/api for git@gitlab:team/api.git
+func projectFromRemote(remote string) string {
+	remote = strings.TrimSuffix(strings.TrimSuffix(remote, "/"), ".git")
+	if i := strings.Index(remote, "://"); i >= 0 {
+		remote = remote[i+3:]
+		if j := strings.Index(remote, "/"); j >= 0 {
+			return strings.Trim(remote[j+1:], "/")
+		}
+		return ""
+	}
+	if i := strings.Index(remote, ":"); i >= 0 {
+		return strings.Trim(remote[i+1:], "/")
+	}
+	// A local path is not a GitLab project
+	return ""
+}
+
+// askGroups asks whether the services are deployed one by one, and otherwise the
+// parallel group of every service
+func askGroups(services []*discoveredService, reader *bufio.Reader) {
+	ask := func(question string) string {
+		fmt.Print(question)
+		response, _ := reader.ReadString('\n')
+		return strings.TrimSpace(response)
+	}
+
+	answer := strings.ToLower(ask("\nDeploy all services sequentially, one by one? [Y/n]: "))
+	if answer == "" || answer == "y" || answer == "yes" {
+		return
+	}
+
+	fmt.Println("Services of a group are deployed in parallel.")
+	fmt.Println("Enter a group name for each service, or nothing to keep it sequential.")
+	for _, svc := range services {
+		svc.group = ask(fmt.Sprintf("  %s: ", svc.name))
+	}
+}
+
+// renderConfig returns the configuration file for the services, in the layout of the
+// example configurations
+func renderConfig(services []*discoveredService) string {
+	var b strings.Builder
+	b.WriteString("# Deploy configuration generated by deploy init\n\n")
+	b.WriteString("# Artifacts whose version should NOT be updated anywhere\n")
+	b.WriteString("skip_version_update: []\n\n")
+	b.WriteString("# Properties whose version should NOT be updated\n")
+	b.WriteString("skip_properties: []\n\n")
+
+	writeService := func(indent string, svc *discoveredService) {
+		fmt.Fprintf(&b, "%s- name: %s\n", indent, yamlString(svc.name))
+		fmt.Fprintf(&b, "%s  directory: %s\n", indent, yamlString(svc.directory))
+		fmt.Fprintf(&b, "%s  gitlab_project: %s\n\n", indent, yamlString(svc.project))
+	}
+
+	b.WriteString("# Sequential services (executed one by one)\n")
+	var groups []string
+	grouped := make(map[string][]*discoveredService)
+	sequential := 0
+	for _, svc := range services {
+		if svc.group == "" {
+			if sequential == 0 {
+				b.WriteString("sequential:\n")
+			}
+			sequential++
+			writeService("  ", svc)
+			continue
+		}
+		if _, ok := grouped[svc.group]; !ok {
+			groups = append(groups, svc.group)
+		}
+		grouped[svc.group] = append(grouped[svc.group], svc)
+	}
+	if sequential == 0 {
+		b.WriteString("sequential: []\n\n")
+	}
+
+	if len(groups) > 0 {
+		b.WriteString("# Grouped services (executed in parallel within each group)\n")
+		b.WriteString("groups:\n")
+		for _, group := range groups {
+			fmt.Fprintf(&b, "  %s:\n", yamlString(group))
+			for _, svc := range grouped[group] {
+				writeService("    ", svc)
+			}
+		}
+	}
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
+
+// yamlString returns s as a YAML scalar, quoted if needed
+func yamlString(s string) string {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Sprintf("%q", s)
+	}
+	return strings.TrimSuffix(string(data), "\n")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"deploy/gitlab"
+	"deploy/logger"
+)
+
+// interrupts handles Ctrl+C and SIGTERM. The first signal asks the run to stop at
+// the next safe point and stops GitLab pipeline polling; a second one exits at once.
+type interrupts struct {
+	stop            chan struct{}
+	stopOnce        sync.Once
+	exitOnce        sync.Once
+	cancelPipelines bool   // also cancel the running pipelines via the GitLab API
+	summary         func() // prints what was completed and how to continue
+}
+
+// watchInterrupts starts listening for interrupt signals
+func watchInterrupts(cancelPipelines bool, summary func()) *interrupts {
+	in := &interrupts{
+		stop:            make(chan struct{}),
+		cancelPipelines: cancelPipelines,
+		summary:         summary,
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range signals {
+			if in.requested() {
+				in.exit()
+			}
+			logger.Warnf("\nInterrupt received, stopping after the current step (press Ctrl+C again to exit immediately)...")
+			in.stopOnce.Do(func() { close(in.stop) })
+			gitlab.Interrupt()
+			if in.cancelPipelines {
+				logger.Infof("Canceling running pipelines...")
+				gitlab.CancelActivePipelines()
+			}
+		}
+	}()
+
+	// A git or Maven command killed by the same Ctrl+C makes the run fail
+	// before it reaches a safe point, so the summary is printed there too
+	logger.OnFatal(func() {
+		if in.requested() {
+			in.summary()
+		}
+	})
+
+	return in
+}
+
+// requested reports whether an interrupt has been received
+func (in *interrupts) requested() bool {
+	select {
+	case <-in.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// exit prints the summary and terminates the program with the conventional
+// status for SIGINT
+func (in *interrupts) exit() {
+	in.exitOnce.Do(func() {
+		in.summary()
+		os.Exit(exitInterrupted)
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"deploy/git"
+	"deploy/logger"
+	"deploy/version"
+)
+
+// defaultScratchDir returns the directory the isolated clones of a release are kept in
+// when -scratch-dir is not given: the same for every run of the release, so that
+// -resume continues in the clones of the failed run
+func defaultScratchDir(directory string, ver version.Version) string {
+	abs, err := filepath.Abs(directory)
+	if err != nil {
+		abs = directory
+	}
+	return filepath.Join(os.TempDir(), "deploy-isolated", filepath.Base(abs)+"-"+ver.String())
+}
+
+// isolate clones the repositories of the services into scratch and points the service
+// and repository directories at the clones, so that the release never touches the
+// working copies in -directory. The clones borrow the objects of the working copies;
+// clones left by an earlier run of the release are reused.
+func isolate(scratch, directory string, services []string, serviceDirs, repoDirs map[string]string) {
+	clones := make(map[string]string) // working copy repository -> clone
+	for _, service := range services {
+		repoDir := repoDirs[service]
+		clone, ok := clones[repoDir]
+		if !ok {
+			rel, err := filepath.Rel(directory, repoDir)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				logger.Exitf(exitConfig, "Error: repository %s of %s is outside of %s", repoDir, service, directory)
+			}
+			clone = filepath.Join(scratch, rel)
+			clones[repoDir] = clone
+			cloneRepository(service, repoDir, clone)
+		}
+
+		rel, err := filepath.Rel(repoDir, serviceDirs[service])
+		if err != nil {
+			logger.Exitf(exitConfig, "Error: directory of %s is outside of its repository %s", service, repoDir)
+		}
+		serviceDirs[service] = filepath.Join(clone, rel)
+		repoDirs[service] = clone
+	}
+}
+
+// cloneRepository clones the working copy repository of the service from its origin
+func cloneRepository(service, repoDir, clone string) {
+	if _, err := os.Stat(filepath.Join(clone, ".git")); err == nil {
+		logger.Infof("  %s: reusing isolated clone %s", service, clone)
+		return
+	}
+	remote, err := git.RemoteURL(repoDir)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: cannot clone %s: %v", service, err)
+	}
+	logger.Infof("  %s: cloning %s into %s...", service, remote, clone)
+	if err := git.CloneReference(remote, clone, repoDir); err != nil {
+		logger.Exitf(exitFailure, "Failed to clone %s: %v", service, err)
+	}
+}
+
+// removeScratch deletes the isolated clones after a successful release
+func removeScratch(scratch string) {
+	if err := os.RemoveAll(scratch); err != nil {
+		logger.Warnf("Warning: failed to remove the isolated clones in %s: %v", scratch, err)
+		return
+	}
+	logger.Infof("Removed the isolated clones in %s", scratch)
+}
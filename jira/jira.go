@@ -0,0 +1,139 @@
+package jira
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"deploy/audit"
+	"deploy/config"
+)
+
+// Issue is what the release notes show about a task
+type Issue struct {
+	Key     string
+	Summary string
+	Type    string // issue type, e.g. Story or Bug
+	Status  string
+	Done    bool // the status is in the done category or listed in done_statuses
+}
+
+// Client reads issues through the Jira REST API
+type Client struct {
+	url          string
+	user         string // basic authentication with an API token if set, a bearer token otherwise
+	token        string
+	doneStatuses map[string]bool
+	client       *http.Client
+}
+
+// NewClient checks the Jira configuration
+func NewClient(cfg *config.Jira) (*Client, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("jira: url is not set")
+	}
+	tokenEnv := cfg.TokenEnv
+	if tokenEnv == "" {
+		tokenEnv = "JIRA_TOKEN"
+	}
+	token := os.Getenv(tokenEnv)
+	if token == "" {
+		return nil, fmt.Errorf("jira: %s environment variable is not set", tokenEnv)
+	}
+
+	audit.AddSecret(token)
+	c := &Client{
+		url:          strings.TrimRight(cfg.URL, "/"),
+		user:         cfg.User,
+		token:        token,
+		doneStatuses: make(map[string]bool),
+		client:       &http.Client{Timeout: 15 * time.Second},
+	}
+	for _, status := range cfg.DoneStatuses {
+		c.doneStatuses[strings.ToLower(status)] = true
+	}
+	return c, nil
+}
+
+// issueResponse is the part of the Jira issue resource the client reads
+type issueResponse struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Summary   string `json:"summary"`
+		IssueType struct {
+			Name string `json:"name"`
+		} `json:"issuetype"`
+		Status struct {
+			Name           string `json:"name"`
+			StatusCategory struct {
+				Key string `json:"key"`
+			} `json:"statusCategory"`
+		} `json:"status"`
+	} `json:"fields"`
+}
+
+// Issue returns the summary, type and status of the issue with the key
+func (c *Client) Issue(key string) (Issue, error) {
+	apiURL := fmt.Sprintf("%s/rest/api/2/issue/%s?fields=summary,issuetype,status", c.url, url.PathEscape(key))
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return Issue{}, err
+	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := audit.Do(c.client, req)
+	if err != nil {
+		return Issue{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Issue{}, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return Issue{}, fmt.Errorf("issue %s not found", key)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return Issue{}, fmt.Errorf("Jira API returned %d: %s", resp.StatusCode, string(body))
+	}
+
+	var r issueResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return Issue{}, fmt.Errorf("failed to parse issue %s: %v", key, err)
+	}
+	status := r.Fields.Status.Name
+	return Issue{
+		Key:     r.Key,
+		Summary: r.Fields.Summary,
+		Type:    r.Fields.IssueType.Name,
+		Status:  status,
+		Done:    r.Fields.Status.StatusCategory.Key == "done" || c.doneStatuses[strings.ToLower(status)],
+	}, nil
+}
+
+// Issues returns the issues with the keys that could be read, by key, and the errors of
+// the others
+func (c *Client) Issues(keys []string) (map[string]Issue, map[string]error) {
+	issues := make(map[string]Issue)
+	errs := make(map[string]error)
+	for _, key := range keys {
+		issue, err := c.Issue(key)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		issues[key] = issue
+	}
+	return issues, errs
+}
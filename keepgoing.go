@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"deploy/logger"
+)
+
+// serviceFailure is the error that excluded a service from the rest of a
+// -keep-going deployment
+type serviceFailure struct {
+	phase string
+	err   string
+	code  int // exit code the error would have stopped the deployment with
+}
+
+// failService stops the deployment with the exit code, or with -keep-going records the
+// failure and excludes the service from the remaining phases. In a step processing
+// services in parallel the failure is recorded as well: the other services finish the
+// step and runPhases reports all failures of the phase together. Callers return from
+// the step of the service afterwards.
+func (d *deployment) failService(service string, code int, format string, args ...interface{}) {
+	log := d.logFor(service)
+	if !d.keepGoing && !d.parallel {
+		log.Exitf(code, format, args...)
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	log.Errorf("%s", msg)
+	if d.keepGoing {
+		log.Warnf("  -keep-going: %s is excluded from the remaining phases", service)
+	}
+	d.failMu.Lock()
+	d.failures[service] = serviceFailure{phase: d.phase, err: msg, code: code}
+	d.failMu.Unlock()
+}
+
+// activeServices returns the services that have not failed, in deployment order
+func (d *deployment) activeServices() []string {
+	d.failMu.Lock()
+	defer d.failMu.Unlock()
+	if len(d.failures) == 0 {
+		return d.services
+	}
+	var active []string
+	for _, service := range d.services {
+		if _, failed := d.failures[service]; !failed {
+			active = append(active, service)
+		}
+	}
+	return active
+}
+
+// failure returns the failure of the service, if it failed
+func (d *deployment) failure(service string) (serviceFailure, bool) {
+	d.failMu.Lock()
+	defer d.failMu.Unlock()
+	f, ok := d.failures[service]
+	return f, ok
+}
+
+// hasFailures reports whether a service failed in a -keep-going deployment
+func (d *deployment) hasFailures() bool {
+	d.failMu.Lock()
+	defer d.failMu.Unlock()
+	return len(d.failures) > 0
+}
+
+// exitOnFailures prints the failures of a -keep-going deployment and stops it with the
+// exit code of the earliest failed phase. It returns if no service failed.
+func (d *deployment) exitOnFailures() {
+	if !d.hasFailures() {
+		return
+	}
+	d.stopBoard()
+
+	var failed []string
+	code, first := exitFailure, len(phases)
+	logger.Errorf("\n=== Failed services ===")
+	for _, service := range d.services {
+		f, ok := d.failure(service)
+		if !ok {
+			continue
+		}
+		failed = append(failed, service)
+		logger.Errorf("  %s (phase %s): %s", service, f.phase, f.err)
+		if n := phaseNumber(f.phase); n < first {
+			code, first = f.code, n
+		}
+	}
+	logger.Errorf("=======================")
+	if completed := len(d.services) - len(failed); completed > 0 && d.keepGoing {
+		logger.Noticef("The other %d service(s) completed the selected phases.", completed)
+	} else if completed > 0 {
+		logger.Noticef("The other %d service(s) completed phase %s.", completed, d.phase)
+	}
+	if !d.retry {
+		logger.Noticef("Resume the failed services with:\n  %s", d.resumeCommand())
+	}
+	logger.Exitf(code, "%d of %d service(s) failed: %s", len(failed), len(d.services), strings.Join(failed, ", "))
+}
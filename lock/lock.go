@@ -0,0 +1,181 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+// FileName is the name of the lock file created in the services directory
+const FileName = ".deploy.lock"
+
+// Info describes the deployment holding the lock
+type Info struct {
+	PID       int       `json:"pid"`
+	Version   string    `json:"version"`
+	User      string    `json:"user"`
+	Host      string    `json:"host"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Lock is a held deployment lock
+type Lock struct {
+	path string
+	file *os.File // kept open while the lock is held, see tryLock
+}
+
+// HeldError is returned by Acquire when another deployment holds the lock
+type HeldError struct {
+	Path string
+	Info Info
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("another deployment is running: version %s, PID %d, user %s on %s, since %s (lock file %s)",
+		e.Info.Version, e.Info.PID, e.Info.User, e.Info.Host, e.Info.StartedAt.Format("2006-01-02 15:04:05"), e.Path)
+}
+
+// Acquire creates the lock file in dir for the version being deployed. If the lock is
+// held by a process that no longer runs, the stale lock file is taken over in place and
+// stale is set. The file is never removed while another process may hold it, so two
+// deployments finding the same stale lock cannot both take it.
+func Acquire(dir string, version string) (l *Lock, stale *Info, err error) {
+	path := filepath.Join(dir, FileName)
+	info := currentInfo(version)
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			// Other processes read the file before they try its lock, so nobody can
+			// take the lock of the file until it has its content
+			if ok, err := tryLock(file, nil, info.Host); !ok || err != nil {
+				file.Close()
+				return nil, nil, fmt.Errorf("failed to lock %s: %v", path, err)
+			}
+			if err := write(file, data); err != nil {
+				file.Close()
+				os.Remove(path)
+				return nil, nil, fmt.Errorf("failed to write lock file %s: %v", path, err)
+			}
+			return &Lock{path: path, file: file}, nil, nil
+		}
+		if !os.IsExist(err) {
+			return nil, nil, fmt.Errorf("failed to create lock file %s: %v", path, err)
+		}
+
+		file, err = os.OpenFile(path, os.O_RDWR, 0)
+		if os.IsNotExist(err) {
+			continue // released in the meantime
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open lock file %s: %v", path, err)
+		}
+		holder, err := read(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("lock file %s exists and cannot be read: %v (remove it if no deployment is running)", path, err)
+		}
+		ok, err := tryLock(file, holder, info.Host)
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+		if !ok {
+			file.Close()
+			return nil, nil, &HeldError{Path: path, Info: *holder}
+		}
+
+		// The holder may have released the lock and removed the file after it was
+		// opened, or another process may have taken it over in the meantime
+		current, err := read(file)
+		if err != nil || !current.same(holder) || !isFile(file, path) {
+			file.Close()
+			continue
+		}
+		if err := write(file, data); err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("failed to write lock file %s: %v", path, err)
+		}
+		return &Lock{path: path, file: file}, holder, nil
+	}
+	return nil, nil, fmt.Errorf("failed to acquire lock file %s", path)
+}
+
+// Release removes the lock file. It is safe to call more than once.
+func (l *Lock) Release() error {
+	if l == nil || l.path == "" {
+		return nil
+	}
+	path := l.path
+	l.path = ""
+	// The file is removed before its lock is released, so a process that takes the
+	// lock afterwards sees that the file is gone
+	err := os.Remove(path)
+	l.file.Close()
+	if err != nil && !os.IsNotExist(err) {
+		// e.g. Windows does not remove files that are open
+		err = os.Remove(path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// currentInfo describes this process
+func currentInfo(version string) Info {
+	info := Info{PID: os.Getpid(), Version: version, StartedAt: time.Now()}
+	if host, err := os.Hostname(); err == nil {
+		info.Host = host
+	}
+	if u, err := user.Current(); err == nil {
+		info.User = u.Username
+	}
+	return info
+}
+
+// read parses the lock file
+func read(file *os.File) (*Info, error) {
+	data, err := ioutil.ReadAll(io.NewSectionReader(file, 0, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// write replaces the content of the lock file
+func write(file *os.File, data []byte) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.WriteAt(data, 0)
+	return err
+}
+
+// isFile reports whether the open file is still the file at path
+func isFile(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(opened, current)
+}
+
+// same reports whether both describe the same deployment
+func (i *Info) same(other *Info) bool {
+	return i.PID == other.PID && i.Host == other.Host && i.StartedAt.Equal(other.StartedAt)
+}
@@ -0,0 +1,31 @@
+//go:build !unix && !windows
+
+package lock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLock reports whether the lock file may be taken: it is new or its holder no longer
+// runs on this host. Without file locks, two deployments finding the same stale holder
+// at the same moment may both take the file over.
+func tryLock(file *os.File, holder *Info, host string) (bool, error) {
+	return holder == nil || holder.stale(host), nil
+}
+
+// stale reports whether the lock holder has exited. Locks taken on other hosts
+// (e.g. the directory is on a network share) are never considered stale.
+func (i *Info) stale(host string) bool {
+	if i.Host != host || i.PID <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(i.PID)
+	if err != nil {
+		return true
+	}
+	// EPERM means the process exists but belongs to another user
+	err = process.Signal(syscall.Signal(0))
+	return err != nil && !errors.Is(err, syscall.EPERM)
+}
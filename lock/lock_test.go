@@ -0,0 +1,65 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireHeld(t *testing.T) {
+	dir := t.TempDir()
+	l, stale, err := Acquire(dir, "1.2.0")
+	if err != nil || stale != nil {
+		t.Fatalf("Acquire = %v, %v; want the lock", stale, err)
+	}
+
+	var held *HeldError
+	if _, _, err := Acquire(dir, "1.3.0"); !errors.As(err, &held) || held.Info.Version != "1.2.0" || held.Info.PID != os.Getpid() {
+		t.Fatalf("second Acquire = %v, want the lock held by 1.2.0", err)
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, FileName)); !os.IsNotExist(err) {
+		t.Errorf("lock file left after Release: %v", err)
+	}
+	l, _, err = Acquire(dir, "1.3.0")
+	if err != nil {
+		t.Fatalf("Acquire after Release = %v", err)
+	}
+	l.Release()
+}
+
+func TestAcquireStale(t *testing.T) {
+	dir := t.TempDir()
+	host, _ := os.Hostname()
+	// A lock file left by a process that exited without releasing it
+	left := Info{PID: 1 << 30, Version: "1.1.0", Host: host, StartedAt: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)}
+	data, _ := json.Marshal(left)
+	path := filepath.Join(dir, FileName)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, stale, err := Acquire(dir, "1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release()
+	if stale == nil || stale.Version != "1.1.0" {
+		t.Errorf("stale = %+v, want the lock of 1.1.0", stale)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if holder, err := read(file); err != nil || holder.Version != "1.2.0" || holder.PID != os.Getpid() {
+		t.Errorf("lock file holder = %+v, %v; want this process", holder, err)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package lock
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLock takes an exclusive lock of the file without waiting. The system releases it
+// when the holder exits, however it exits, so a lock file whose lock can be taken was
+// left by a deployment that no longer runs.
+func tryLock(file *os.File, holder *Info, host string) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
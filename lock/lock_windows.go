@@ -0,0 +1,24 @@
+package lock
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockOffsetHigh is the high 32 bits of the offset of the locked byte, 2^62, far past
+// the content of the lock file: Windows locks keep other processes from reading the
+// range they cover, and the holder must stay readable
+const lockOffsetHigh = 1 << 30
+
+// tryLock takes an exclusive lock of the file without waiting. The system releases it
+// when the holder exits, however it exits, so a lock file whose lock can be taken was
+// left by a deployment that no longer runs.
+func tryLock(file *os.File, holder *Info, host string) (bool, error) {
+	overlapped := &windows.Overlapped{OffsetHigh: lockOffsetHigh}
+	err := windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, overlapped)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,22 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestAcquireLiveHolderOnWindows(t *testing.T) {
+	dir := t.TempDir()
+	l, _, err := Acquire(dir, "1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release()
+
+	// The holder runs, so its lock must not be taken over as stale
+	var held *HeldError
+	if _, stale, err := Acquire(dir, "1.3.0"); !errors.As(err, &held) || stale != nil || held.Info.PID != os.Getpid() {
+		t.Fatalf("second Acquire = %v, %v; want the lock held by this process", stale, err)
+	}
+}
@@ -0,0 +1,340 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log message
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelNotice // phase headers and summaries, still shown with -quiet
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug:  "debug",
+	LevelInfo:   "info",
+	LevelNotice: "notice",
+	LevelWarn:   "warn",
+	LevelError:  "error",
+}
+
+// ParseLevel parses a level name: debug, info, notice, warn or error
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if levelName == name {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q (use debug, info, notice, warn or error)", name)
+}
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var (
+	mu         sync.Mutex
+	minLevel   = LevelInfo
+	jsonFormat bool
+	stdout     io.Writer = os.Stdout
+	stderr     io.Writer = os.Stderr
+	fatalHooks []func()
+	fatalMsg   string
+	prefixKey  string
+	handler    Handler
+)
+
+// Handler receives the messages instead of the standard output, e.g. to show them in a TUI.
+// It is called with the logger lock held and must not log itself.
+type Handler func(level Level, fields map[string]string, msg string)
+
+// ansiPattern matches terminal color codes, which are stripped from JSON output
+var ansiPattern = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// SetLevel sets the minimum level of messages that are written
+func SetLevel(level Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	minLevel = level
+}
+
+// SetFormat selects plain text (default, colored console output) or JSON lines output
+func SetFormat(format string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	switch format {
+	case FormatText:
+		jsonFormat = false
+	case FormatJSON:
+		jsonFormat = true
+	default:
+		return fmt.Errorf("unknown log format %q (use text or json)", format)
+	}
+	return nil
+}
+
+// SetTextPrefix makes text output prefix every line of a message with "[value] ",
+// where value is the message's field with the given key. It keeps the output of
+// services processed in parallel apart. An empty key disables the prefix.
+func SetTextPrefix(key string) {
+	mu.Lock()
+	defer mu.Unlock()
+	prefixKey = key
+}
+
+// SetHandler routes all messages to h instead of the standard output.
+// A nil handler restores the normal output.
+func SetHandler(h Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handler = h
+}
+
+// JSON reports whether messages are written as JSON lines
+func JSON() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return jsonFormat
+}
+
+// OnFatal registers a function that Fatalf runs before exiting
+func OnFatal(hook func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	fatalHooks = append(fatalHooks, hook)
+}
+
+// FatalMessage returns the message passed to Fatalf, for use in OnFatal hooks
+func FatalMessage() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return fatalMsg
+}
+
+// field is a key/value pair attached to every message of a Logger
+type field struct {
+	key   string
+	value string
+}
+
+// Logger writes leveled messages carrying fields such as the service or phase
+type Logger struct {
+	fields []field
+	tee    io.Writer // also receives every message as plain text, e.g. a log file
+}
+
+// root is the logger without fields used by the package-level functions
+var root = &Logger{}
+
+// With returns a logger that adds the field to every message
+func With(key, value string) *Logger {
+	return root.With(key, value)
+}
+
+// With returns a copy of the logger that adds the field to every message
+func (l *Logger) With(key, value string) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+	return &Logger{fields: append(fields, field{key, value}), tee: l.tee}
+}
+
+// Tee returns a copy of the logger that also writes every message to w as plain
+// text, whatever the level and format of the output
+func (l *Logger) Tee(w io.Writer) *Logger {
+	return &Logger{fields: l.fields, tee: w}
+}
+
+// Debugf logs a debug message
+func (l *Logger) Debugf(format string, args ...interface{}) { l.write(LevelDebug, format, args...) }
+
+// Infof logs an informational message
+func (l *Logger) Infof(format string, args ...interface{}) { l.write(LevelInfo, format, args...) }
+
+// Noticef logs a phase header or a summary
+func (l *Logger) Noticef(format string, args ...interface{}) { l.write(LevelNotice, format, args...) }
+
+// Warnf logs a warning
+func (l *Logger) Warnf(format string, args ...interface{}) { l.write(LevelWarn, format, args...) }
+
+// Errorf logs an error
+func (l *Logger) Errorf(format string, args ...interface{}) { l.write(LevelError, format, args...) }
+
+// Fatalf logs an error, runs the OnFatal hooks and exits with status 1
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.Exitf(1, format, args...)
+}
+
+// Exitf logs an error, runs the OnFatal hooks and exits with the given status
+func (l *Logger) Exitf(code int, format string, args ...interface{}) {
+	l.write(LevelError, format, args...)
+	mu.Lock()
+	fatalMsg = strings.TrimSpace(ansiPattern.ReplaceAllString(fmt.Sprintf(format, args...), ""))
+	hooks := fatalHooks
+	mu.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+	os.Exit(code)
+}
+
+// Debugf logs a debug message
+func Debugf(format string, args ...interface{}) { root.write(LevelDebug, format, args...) }
+
+// Infof logs an informational message
+func Infof(format string, args ...interface{}) { root.write(LevelInfo, format, args...) }
+
+// Noticef logs a phase header or a summary
+func Noticef(format string, args ...interface{}) { root.write(LevelNotice, format, args...) }
+
+// Warnf logs a warning
+func Warnf(format string, args ...interface{}) { root.write(LevelWarn, format, args...) }
+
+// Errorf logs an error
+func Errorf(format string, args ...interface{}) { root.write(LevelError, format, args...) }
+
+// Fatalf logs an error and exits with status 1
+func Fatalf(format string, args ...interface{}) { root.Fatalf(format, args...) }
+
+// Exitf logs an error and exits with the given status
+func Exitf(code int, format string, args ...interface{}) { root.Exitf(code, format, args...) }
+
+// write formats and outputs a single message. In text mode the message is printed
+// as-is (errors go to stderr); in JSON mode it becomes one JSON object per line.
+func (l *Logger) write(level Level, format string, args ...interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if l.tee != nil {
+		fmt.Fprintln(l.tee, ansiPattern.ReplaceAllString(fmt.Sprintf(format, args...), ""))
+	}
+	if level < minLevel {
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+
+	if handler != nil {
+		fields := make(map[string]string, len(l.fields))
+		for _, f := range l.fields {
+			fields[f.key] = f.value
+		}
+		handler(level, fields, msg)
+		return
+	}
+
+	if !jsonFormat {
+		out := stdout
+		if level == LevelError {
+			out = stderr
+		}
+		if prefix := l.value(prefixKey); prefix != "" {
+			msg = prefixLines(msg, "["+prefix+"] ")
+		}
+		fmt.Fprintln(out, msg)
+		return
+	}
+
+	entry := map[string]string{
+		"time":  time.Now().Format(time.RFC3339),
+		"level": levelNames[level],
+		"msg":   strings.TrimSpace(ansiPattern.ReplaceAllString(msg, "")),
+	}
+	for _, f := range l.fields {
+		entry[f.key] = f.value
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(stdout, string(data))
+}
+
+// value returns the value of the field with the given key, or "" if there is none
+func (l *Logger) value(key string) string {
+	if key == "" {
+		return ""
+	}
+	for _, f := range l.fields {
+		if f.key == key {
+			return f.value
+		}
+	}
+	return ""
+}
+
+// prefixLines adds the prefix to every non-empty line of msg
+func prefixLines(msg, prefix string) string {
+	lines := strings.Split(msg, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// LineWriter logs every line written to it as a separate message,
+// e.g. the output of an external command
+type LineWriter struct {
+	logger *Logger
+	level  Level
+	mu     sync.Mutex
+	buf    []byte
+}
+
+// Writer returns a LineWriter logging at the level
+func Writer(level Level) *LineWriter {
+	return root.Writer(level)
+}
+
+// Writer returns a LineWriter logging at the level with the fields of the logger; a
+// nil logger writes like the package-level functions
+func (l *Logger) Writer(level Level) *LineWriter {
+	if l == nil {
+		l = root
+	}
+	return &LineWriter{logger: l, level: level}
+}
+
+// Write logs the complete lines of p and keeps the rest until the next Write or Flush
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.logger.write(w.level, "%s", strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush logs the last line if it did not end with a newline
+func (w *LineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.logger.write(w.level, "%s", strings.TrimRight(string(w.buf), "\r"))
+		w.buf = nil
+	}
+}
@@ -0,0 +1,205 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Connection security modes
+const (
+	SecurityTLS      = "tls"      // implicit TLS, usually port 465
+	SecurityStartTLS = "starttls" // plain connection upgraded with STARTTLS, usually port 587
+	SecurityNone     = "none"     // no encryption, for local relays only
+)
+
+// Server describes the SMTP server and the account mail is sent from
+type Server struct {
+	Host     string
+	Port     int
+	Security string // tls, starttls or none; tls if empty
+	Username string // no authentication if empty
+	Password string
+}
+
+// Attachment is a file attached to a message
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Message is a plain text email
+type Message struct {
+	From        string
+	To          []string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// dialTimeout limits connecting to the SMTP server
+const dialTimeout = 30 * time.Second
+
+// Send delivers the message through the server
+func Send(server Server, msg Message) error {
+	if len(msg.To) == 0 {
+		return fmt.Errorf("no recipients")
+	}
+	data, err := msg.encode()
+	if err != nil {
+		return err
+	}
+
+	client, err := dial(server)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if server.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("SMTP server %s does not support authentication", server.Host)
+		}
+		if err := client.Auth(smtp.PlainAuth("", server.Username, server.Password, server.Host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(address(msg.From)); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM failed: %v", err)
+	}
+	for _, to := range msg.To {
+		if err := client.Rcpt(address(to)); err != nil {
+			return fmt.Errorf("SMTP RCPT TO %s failed: %v", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
+	}
+	return client.Quit()
+}
+
+// dial connects to the server with the configured security
+func dial(server Server) (*smtp.Client, error) {
+	addr := net.JoinHostPort(server.Host, strconv.Itoa(server.Port))
+	tlsConfig := &tls.Config{ServerName: server.Host}
+
+	switch server.Security {
+	case "", SecurityTLS:
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", addr, tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %v", addr, err)
+		}
+		client, err := smtp.NewClient(conn, server.Host)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start SMTP session with %s: %v", addr, err)
+		}
+		return client, nil
+	case SecurityStartTLS, SecurityNone:
+		conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %v", addr, err)
+		}
+		client, err := smtp.NewClient(conn, server.Host)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start SMTP session with %s: %v", addr, err)
+		}
+		if server.Security == SecurityStartTLS {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return nil, fmt.Errorf("STARTTLS with %s failed: %v", addr, err)
+			}
+		}
+		return client, nil
+	}
+	return nil, fmt.Errorf("unknown SMTP security %q (use tls, starttls or none)", server.Security)
+}
+
+// address returns the bare address of "Name <user@host>"
+func address(value string) string {
+	if start := strings.LastIndex(value, "<"); start >= 0 {
+		if end := strings.Index(value[start:], ">"); end > 0 {
+			return value[start+1 : start+end]
+		}
+	}
+	return strings.TrimSpace(value)
+}
+
+// encode renders the message as a MIME document, multipart if it has attachments
+func (msg Message) encode() ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(msg.Attachments) == 0 {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+		writeBase64(&b, []byte(msg.Body))
+		return b.Bytes(), nil
+	}
+
+	w := multipart.NewWriter(&b)
+	fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", w.Boundary())
+
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeBase64(part, []byte(msg.Body))
+
+	for _, attachment := range msg.Attachments {
+		contentType := attachment.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64(part, attachment.Data)
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeBase64 writes data base64-encoded in lines of 76 characters
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
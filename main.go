@@ -1,377 +1,338 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"time"
 
+	"deploy/audit"
 	"deploy/config"
 	"deploy/git"
 	"deploy/gitlab"
+	"deploy/logger"
 	"deploy/maven"
+	"deploy/notes"
+	"deploy/version"
 )
 
-func main() {
-	// Parse command line arguments
-	var (
-		namespaceStr       string
-		directory          string
-		versionStr         string
-		mavenCachePath     string
-		pomPropertyPattern string
-		configFile         string
-		continueMode       bool
-	)
-
-	flag.StringVar(&namespaceStr, "namespace", "", "Helm namespace(s) for deployment, comma-separated (required)")
-	flag.StringVar(&namespaceStr, "n", "", "Helm namespace(s) for deployment, comma-separated (shorthand)")
-	flag.BoolVar(&continueMode, "continue", false, "Continue deployment: skip build phases, re-run only failed/missing pipelines")
-	flag.StringVar(&directory, "directory", "", "Base directory for services (required unless --continue)")
-	flag.StringVar(&directory, "d", "", "Base directory for services (shorthand)")
-	flag.StringVar(&versionStr, "version", "", "Version number to deploy (required)")
-	flag.StringVar(&versionStr, "v", "", "Version number to deploy (shorthand)")
-	flag.StringVar(&mavenCachePath, "maven-cache-path", "", "Path to Maven cache for cleanup (required unless --continue)")
-	flag.StringVar(&mavenCachePath, "m", "", "Path to Maven cache for cleanup (shorthand)")
-	flag.StringVar(&pomPropertyPattern, "pom-property-pattern", "", "Pattern to match properties in POM files (required unless --continue)")
-	flag.StringVar(&pomPropertyPattern, "p", "", "Pattern to match properties in POM files (shorthand)")
-	flag.StringVar(&configFile, "config", "", "Path to YAML configuration file (required)")
-	flag.StringVar(&configFile, "c", "", "Path to YAML configuration file (shorthand)")
-
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "\nRequired options:\n")
-		fmt.Fprintf(os.Stderr, "  -config, -c string\n")
-		fmt.Fprintf(os.Stderr, "        Path to YAML configuration file (e.g. deploy-proezd.yaml, deploy-skl.yaml)\n")
-		fmt.Fprintf(os.Stderr, "  -directory, -d string\n")
-		fmt.Fprintf(os.Stderr, "        Base directory for services\n")
-		fmt.Fprintf(os.Stderr, "  -version, -v string\n")
-		fmt.Fprintf(os.Stderr, "        Version number to deploy (must be an integer)\n")
-		fmt.Fprintf(os.Stderr, "  -maven-cache-path, -m string\n")
-		fmt.Fprintf(os.Stderr, "        Path to Maven cache for cleanup (e.g. ru/gov/pfr/ecp/apso/proezd)\n")
-		fmt.Fprintf(os.Stderr, "  -pom-property-pattern, -p string\n")
-		fmt.Fprintf(os.Stderr, "        Pattern to match properties in POM files for version update (e.g. proezd)\n")
-		fmt.Fprintf(os.Stderr, "  -namespace, -n string\n")
-		fmt.Fprintf(os.Stderr, "        Helm namespace(s) for deployment, comma-separated (e.g. test,prod)\n")
-		fmt.Fprintf(os.Stderr, "\nOptional:\n")
-		fmt.Fprintf(os.Stderr, "  -continue\n")
-		fmt.Fprintf(os.Stderr, "        Continue deployment: skip build phases, re-run only failed/missing pipelines\n")
-		fmt.Fprintf(os.Stderr, "\nExample:\n")
-		fmt.Fprintf(os.Stderr, "  %s -config deploy.yaml -directory /path/to/services -version 123 -maven-cache-path ru/gov/pfr/ecp/apso/proezd -pom-property-pattern proezd -namespace production\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s -c deploy.yaml -v 123 -n test,prod --continue\n", os.Args[0])
-	}
+// subcommand is a deploy subcommand with its own flag set
+type subcommand struct {
+	name        string
+	description string
+	run         func(args []string)
+}
 
-	flag.Parse()
+// commands lists the available subcommands
+var commands = []subcommand{
+	{"release", "Run the full deployment: git, pom update, Maven build, GitLab pipelines (default)", runRelease},
+	{"notes", "Generate release notes: commits and task IDs since the previous release", runNotes},
+	{"compare", "List the commits and task IDs that differ between two releases", runCompare},
+	{"status", "Show the current branch and state of every service working copy", runStatus},
+	{"retry", "Re-run the remaining phases of one service of a failed deployment", runRetry},
+	{"abort", "Cancel the running pipelines of a deployment and mark it as aborted", runAbort},
+	{"undo-refs", "Restore the branches and tags a deployment deleted or force-pushed", runUndoRefs},
+	{"rollback", "Redeploy the release tag preceding a version", runRollback},
+	{"pom-diff", "Show the pom.xml changes of a release as unified diffs without writing them", runPomDiff},
+	{"init", "Generate a configuration file from the repositories found in a directory", runInit},
+	{"validate", "Check the configuration, repositories, tools and GitLab access before a release", runValidate},
+}
 
-	// Validate required parameters
-	if configFile == "" {
-		log.Fatal("Error: -config parameter is required\n\nUse -h for help")
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "help", "-h", "-help", "--help":
+			printUsage()
+			return
+		}
+		for _, cmd := range commands {
+			if os.Args[1] == cmd.name {
+				cmd.run(os.Args[2:])
+				return
+			}
+		}
 	}
 
-	if versionStr == "" {
-		log.Fatal("Error: -version parameter is required\n\nUse -h for help")
-	}
+	// Without a subcommand the arguments are release flags, as before subcommands existed
+	runRelease(os.Args[1:])
+}
 
-	if namespaceStr == "" {
-		log.Fatal("Error: -namespace parameter is required\n\nUse -h for help")
+// printUsage lists the available subcommands
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [options]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.description)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the options of a command.\n", os.Args[0])
+}
 
-	// Parse comma-separated namespaces
-	var namespaces []string
-	for _, ns := range strings.Split(namespaceStr, ",") {
-		ns = strings.TrimSpace(ns)
-		if ns != "" {
-			namespaces = append(namespaces, ns)
+// mergeLists returns the items of both lists in order, without duplicates
+func mergeLists(a, b []string) []string {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, item := range append(append([]string{}, a...), b...) {
+		if !seen[item] {
+			seen[item] = true
+			merged = append(merged, item)
 		}
 	}
-	if len(namespaces) == 0 {
-		log.Fatal("Error: -namespace parameter must contain at least one namespace\n\nUse -h for help")
-	}
+	return merged
+}
 
-	if !continueMode {
-		if directory == "" {
-			log.Fatal("Error: -directory parameter is required\n\nUse -h for help")
-		}
-		if mavenCachePath == "" {
-			log.Fatal("Error: -maven-cache-path parameter is required\n\nUse -h for help")
-		}
-		if pomPropertyPattern == "" {
-			log.Fatal("Error: -pom-property-pattern parameter is required\n\nUse -h for help")
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
 		}
 	}
+	return items
+}
 
-	// Parse version as integer
-	version, err := strconv.Atoi(versionStr)
+// loadConfig locates and reads the configuration file, applies the environment selected
+// with -env and restricts it to the services selected with -services.
+// It returns the configuration and the resolved file path.
+func loadConfig(configFile, directory, envName, servicesStr string) (*config.Config, string) {
+	configFile, err := config.ResolvePath(configFile, directory)
 	if err != nil {
-		log.Fatalf("Error: Version must be an integer, got '%s': %v", versionStr, err)
+		logger.Exitf(exitConfig, "Error: %v", err)
 	}
 
-	// Check if configuration file exists
-	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		log.Fatalf("Error: Configuration file does not exist: %s", configFile)
-	}
-
-	// Read configuration file
 	cfg, err := config.ReadYAMLConfig(configFile)
 	if err != nil {
-		log.Fatalf("Failed to read config: %v", err)
+		logger.Exitf(exitConfig, "Failed to read config: %v", err)
 	}
 
-	tagName := fmt.Sprintf("%d.0.0", version)
-
-	if continueMode {
-		// Continue mode: skip build phases, re-run failed/missing pipelines
-		fmt.Println("=== Continue Deployment ===")
-		fmt.Printf("Config File: %s\n", configFile)
-		fmt.Printf("Version: %d\n", version)
-		fmt.Printf("Tag: %s\n", tagName)
-		fmt.Printf("Namespaces: %s\n", strings.Join(namespaces, ", "))
-		fmt.Println("===========================\n")
-
-		fmt.Println("Checking pipeline statuses and re-running failed/missing pipelines...")
-
-		if err := gitlab.ContinuePipelinesFromConfig(cfg, tagName, namespaces); err != nil {
-			log.Fatalf("Failed to continue deployment: %v", err)
-		}
-
-		fmt.Println("\nContinue deployment completed successfully!")
-		return
+	if err := version.SetTagTemplate(cfg.TagTemplate); err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
 	}
-
-	// Full deployment mode
-	// Check if directory exists
-	if _, err := os.Stat(directory); os.IsNotExist(err) {
-		log.Fatalf("Error: Directory does not exist: %s", directory)
+	if err := version.SetBranchTemplate(cfg.BranchTemplate); err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
 	}
-
-	// Get all services with metadata
-	allServices := cfg.GetAllServices()
-
-	// Build service directories map
-	serviceDirs := make(map[string]string)
-	serviceConfigs := make(map[string]gitlab.Service)
-	meshServices := make(map[string]bool)
-
-	for _, svcMeta := range allServices {
-		service := svcMeta.Service
-		serviceDir := filepath.Join(directory, service.Directory)
-
-		// Check if service directory exists
-		if _, err := os.Stat(serviceDir); os.IsNotExist(err) {
-			log.Fatalf("Service directory does not exist: %s", serviceDir)
+	if cfg.CommitTemplate != "" {
+		if _, err := parseCommitTemplate(cfg.CommitTemplate); err != nil {
+			logger.Exitf(exitConfig, "Error: %v", err)
 		}
-
-		serviceDirs[service.Name] = serviceDir
-		meshServices[service.Name] = service.IsMesh
-
-		// Convert to gitlab.Service
-		gitlabService := gitlab.Service{
-			Name:          service.Name,
-			Directory:     service.Directory,
-			GitlabProject: service.GitlabProject,
-			Group:         svcMeta.Group,
-			Sequential:    svcMeta.Sequential,
-		}
-		serviceConfigs[service.Name] = gitlabService
 	}
-
-	// Extract service names for compatibility
-	services := make([]string, len(allServices))
-	for i, svcMeta := range allServices {
-		services[i] = svcMeta.Service.Name
+	if cfg.TaskURL != "" && !strings.Contains(cfg.TaskURL, "{task}") {
+		logger.Exitf(exitConfig, "Error: task_url %q does not contain {task}", cfg.TaskURL)
 	}
-
-	// Print deployment configuration
-	fmt.Println("=== Deployment Configuration ===")
-	fmt.Printf("Config File: %s\n", configFile)
-	fmt.Printf("Directory: %s\n", directory)
-	fmt.Printf("Version: %d\n", version)
-	fmt.Printf("Maven Cache Path: %s\n", mavenCachePath)
-	fmt.Printf("POM Property Pattern: %s\n", pomPropertyPattern)
-	fmt.Printf("Namespaces: %s\n", strings.Join(namespaces, ", "))
-	fmt.Printf("Services: %d\n", len(services))
-	fmt.Println("================================\n")
-
-	// Phase 1: Check if all git working copies are clean
-	fmt.Println("Phase 1: Checking git status...")
-	for _, service := range services {
-		fmt.Printf("  Checking service: %s\n", service)
-		if err := git.CheckClean(serviceDirs[service]); err != nil {
-			fmt.Printf("\nWarning: Git working copy is not clean in %s\n", service)
-
-			// Show git status
-			if err := git.ShowStatus(serviceDirs[service]); err != nil {
-				log.Fatalf("Failed to show git status in %s: %v", service, err)
-			}
-
-			// Ask user if they want to clean
-			fmt.Printf("\nDo you want to clean the working directory for %s? (y/n): ", service)
-			reader := bufio.NewReader(os.Stdin)
-			response, _ := reader.ReadString('\n')
-			response = strings.TrimSpace(strings.ToLower(response))
-
-			if response != "y" && response != "yes" {
-				log.Fatal("Deployment cancelled by user")
+	if err := maven.SetVersionUpdate(cfg.VersionUpdate); err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+	if err := maven.SetDependencyPatterns(cfg.UpdateDependencies); err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+	if maven.UsesVersionsPlugin() && len(cfg.SkipVersionUpdate) > 0 {
+		logger.Warnf("Warning: skip_version_update does not apply with version_update: %s", maven.UpdateVersionsPlugin)
+	}
+	if err := maven.CheckCommand(cfg.MavenCommand); err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+	if cfg.BuildRetries < 0 {
+		logger.Exitf(exitConfig, "Error: build_retries must not be negative, got %d", cfg.BuildRetries)
+	}
+	if _, err := parseTimeout(cfg.BuildRetryDelay); err != nil {
+		logger.Exitf(exitConfig, "Error: build_retry_delay: %v", err)
+	}
+	if err := cfg.MavenDeploy.ReleaseRepository.Check(); err != nil {
+		logger.Exitf(exitConfig, "Error: maven_deploy.release_repository: %v", err)
+	}
+	if err := cfg.MavenDeploy.SnapshotRepository.Check(); err != nil {
+		logger.Exitf(exitConfig, "Error: maven_deploy.snapshot_repository: %v", err)
+	}
+	for _, svc := range cfg.GetAllServices() {
+		if _, ok := builders[svc.BuildTool()]; !ok {
+			logger.Exitf(exitConfig, "Error: %s: unknown build %q, expected %s, %s, %s or %s", svc.Name, svc.Build,
+				config.BuildMaven, config.BuildGradle, config.BuildNpm, config.BuildYarn)
+		}
+		if svc.IsMesh && svc.BuildTool() != config.BuildMaven {
+			logger.Exitf(exitConfig, "Error: %s: is_mesh services are built with maven", svc.Name)
+		}
+		if svc.RunTests != "" {
+			if _, err := config.ParseTestMode(svc.RunTests); err != nil {
+				logger.Exitf(exitConfig, "Error: %s: run_tests: %v", svc.Name, err)
 			}
-
-			// Clean the working directory
-			fmt.Printf("  Cleaning working directory for %s...\n", service)
-			if err := git.CleanWorkingDirectory(serviceDirs[service]); err != nil {
-				log.Fatalf("Failed to clean working directory in %s: %v", service, err)
+			if svc.BuildTool() == config.BuildNpm || svc.BuildTool() == config.BuildYarn {
+				logger.Warnf("Warning: %s: run_tests does not apply to build: %s", svc.Name, svc.BuildTool())
 			}
 		}
-	}
-
-	// Phase 2: Switch all to master branch
-	fmt.Println("\nPhase 2: Switching to master branch...")
-	for _, service := range services {
-		fmt.Printf("  Switching service: %s\n", service)
-		if err := git.Checkout(serviceDirs[service], "master"); err != nil {
-			log.Fatalf("Failed to checkout master branch in %s: %v", service, err)
+		if svc.BuildTool() != config.BuildMaven && (svc.MavenGoals != nil || svc.MavenArgs != nil || svc.Profiles != nil || svc.MavenCommand != "" || svc.MavenModules != nil || svc.JDK != "" || svc.MavenOpts != "") {
+			logger.Warnf("Warning: %s: maven_goals, maven_args, profiles, maven_command, maven_modules, maven_opts and jdk do not apply to build: %s", svc.Name, svc.BuildTool())
 		}
-	}
-
-	// Phase 3: Pull latest changes for all
-	fmt.Println("\nPhase 3: Pulling latest changes...")
-	for _, service := range services {
-		fmt.Printf("  Pulling service: %s\n", service)
-		if err := git.Pull(serviceDirs[service]); err != nil {
-			log.Fatalf("Failed to pull in %s: %v", service, err)
+		if svc.IsMesh && len(svc.MavenModules) > 0 {
+			logger.Exitf(exitConfig, "Error: %s: maven_modules does not apply to is_mesh services", svc.Name)
+		}
+		if err := maven.CheckCommand(svc.MavenCommand); err != nil {
+			logger.Exitf(exitConfig, "Error: %s: %v", svc.Name, err)
+		}
+		if svc.BuildRetries != nil && *svc.BuildRetries < 0 {
+			logger.Exitf(exitConfig, "Error: %s: build_retries must not be negative, got %d", svc.Name, *svc.BuildRetries)
 		}
 	}
-
-	// Phase 4: Update all pom.xml files
-	fmt.Println("\nPhase 4: Updating pom.xml files...")
-	versionString := fmt.Sprintf("%d", version)
-
-	// Convert config exclusions to maven exclusions
-	var excludeArtifacts []maven.ArtifactExclusion
-	for _, excl := range cfg.SkipVersionUpdate {
-		excludeArtifacts = append(excludeArtifacts, maven.ArtifactExclusion{
-			GroupID:    excl.GroupID,
-			ArtifactID: excl.ArtifactID,
-		})
+	if problems := checkServiceTags(cfg); len(problems) > 0 {
+		logger.Exitf(exitConfig, "Error: %s", strings.Join(problems, "\n  "))
 	}
-
-	for _, service := range services {
-		fmt.Printf("  Updating service: %s\n", service)
-		if err := maven.UpdatePomFiles(serviceDirs[service], versionString, pomPropertyPattern, excludeArtifacts, cfg.SkipProperties); err != nil {
-			log.Fatalf("Failed to update pom files in %s: %v", service, err)
+	var serviceNames []string
+	for _, svc := range cfg.GetAllServices() {
+		serviceNames = append(serviceNames, svc.Name)
+	}
+	if err := version.CheckRefNames(serviceNames); err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+	if err := cfg.ExpandPipelineVariables(); err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+	if err := cfg.CheckDependencies(); err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+	if err := cfg.CheckArtifacts(); err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+	notes.ExcludeMerges(cfg.NotesNoMerges)
+	if err := notes.SetTrackers(cfg.Trackers); err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+	if cfg.Jira != nil && cfg.Jira.URL == "" {
+		logger.Exitf(exitConfig, "Error: jira.url is not set")
+	}
+	if cfg.Jira != nil {
+		for _, name := range cfg.Jira.Trackers {
+			if !cfg.HasTracker(name) {
+				logger.Exitf(exitConfig, "Error: jira.trackers: unknown tracker %s", name)
+			}
 		}
 	}
-
-	// Phase 5: Create release branches for all
-	fmt.Println("\nPhase 5: Creating release branches...")
-	branchName := fmt.Sprintf("release-%d", version)
-	for _, service := range services {
-		fmt.Printf("  Creating branch for service: %s\n", service)
-
-		// Delete branch if it already exists (locally and remotely)
-		if err := git.DeleteBranchIfExists(serviceDirs[service], branchName); err != nil {
-			log.Fatalf("Failed to delete existing branch in %s: %v", service, err)
+	if cfg.NotesTemplate != "" {
+		if !filepath.IsAbs(cfg.NotesTemplate) {
+			cfg.NotesTemplate = filepath.Join(filepath.Dir(configFile), cfg.NotesTemplate)
 		}
-
-		// Create new branch
-		if err := git.Checkout(serviceDirs[service], "-b", branchName); err != nil {
-			log.Fatalf("Failed to create release branch in %s: %v", service, err)
+		if _, err := notes.ParseTemplate(cfg.NotesTemplate); err != nil {
+			logger.Exitf(exitConfig, "Error: %v", err)
 		}
 	}
+	cfg.ResolveMavenSettings(filepath.Dir(configFile))
+	if cfg.History.Depth < 0 {
+		logger.Exitf(exitConfig, "Error: history.depth must not be negative, got %d", cfg.History.Depth)
+	}
+	git.SetHistoryLimits(cfg.History.Depth, cfg.History.Filter)
+	setGitlabRetries(cfg.GitlabAPI)
 
-	// Show all diffs before committing
-	fmt.Println("\nShowing all changes before commit:")
-	fmt.Println(strings.Repeat("=", 80))
-	for _, service := range services {
-		fmt.Printf("\n--- Changes in service: %s ---\n", service)
-		if err := git.ShowDiff(serviceDirs[service]); err != nil {
-			// Don't fail if diff is empty, just continue
-			fmt.Println("No changes to show")
+	if envName != "" {
+		cfg, err = cfg.ApplyEnvironment(envName)
+		if err != nil {
+			logger.Exitf(exitConfig, "Error: -env: %v", err)
 		}
 	}
-	fmt.Println(strings.Repeat("=", 80))
 
-	// Phase 6: Commit changes for all
-	fmt.Println("\nPhase 6: Committing changes...")
-	commitMsg := fmt.Sprintf("Update version to %d.0.0", version)
-	for _, service := range services {
-		fmt.Printf("  Committing service: %s\n", service)
-		if err := git.AddAll(serviceDirs[service]); err != nil {
-			log.Fatalf("Failed to add files in %s: %v", service, err)
-		}
-		if err := git.Commit(serviceDirs[service], commitMsg); err != nil {
-			log.Fatalf("Failed to commit in %s: %v", service, err)
+	if servicesStr != "" {
+		cfg, err = cfg.FilterServices(splitList(servicesStr))
+		if err != nil {
+			logger.Exitf(exitConfig, "Error: -services: %v", err)
 		}
 	}
 
-	// Phase 7: Create tags for all
-	fmt.Println("\nPhase 7: Creating tags...")
-	for _, service := range services {
-		fmt.Printf("  Creating tag for service: %s\n", service)
-
-		// Delete tag if it already exists (locally and remotely)
-		if err := git.DeleteTagIfExists(serviceDirs[service], tagName); err != nil {
-			log.Fatalf("Failed to delete existing tag in %s: %v", service, err)
-		}
+	return cfg, configFile
+}
 
-		// Create new tag
-		if err := git.Tag(serviceDirs[service], tagName); err != nil {
-			log.Fatalf("Failed to create tag in %s: %v", service, err)
+// setGitlabRetries applies the retry policy of gitlab_api to the GitLab API requests
+func setGitlabRetries(api config.GitlabAPI) {
+	retries := 5
+	if api.Retries != nil {
+		if *api.Retries < 0 {
+			logger.Exitf(exitConfig, "Error: gitlab_api.retries must not be negative, got %d", *api.Retries)
 		}
+		retries = *api.Retries
 	}
+	delay, err := parseTimeout(api.RetryDelay)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: gitlab_api.retry_delay: %v", err)
+	}
+	if delay == 0 {
+		delay = time.Second
+	}
+	maxDelay, err := parseTimeout(api.MaxRetryDelay)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: gitlab_api.max_retry_delay: %v", err)
+	}
+	if maxDelay == 0 {
+		maxDelay = 30 * time.Second
+	}
+	if maxDelay < delay {
+		logger.Exitf(exitConfig, "Error: gitlab_api.max_retry_delay %s is shorter than retry_delay %s", maxDelay, delay)
+	}
+	gitlab.SetRetries(retries, delay, maxDelay)
+}
 
-	// Phase 8: Clean Maven cache and build all services
-	fmt.Println("\nPhase 8: Cleaning Maven cache and building services...")
+// workingCopy is a configured service together with the path of its working copy
+type workingCopy struct {
+	config.ServiceWithMeta
+	Dir string
+}
 
-	// Clean Maven cache
-	if err := maven.CleanCache(mavenCachePath); err != nil {
-		log.Fatalf("Failed to clean Maven cache: %v", err)
+// workingCopies returns the working copies of all configured services under directory
+func workingCopies(cfg *config.Config, directory string) []workingCopy {
+	var copies []workingCopy
+	for _, svcMeta := range cfg.GetAllServices() {
+		copies = append(copies, workingCopy{
+			ServiceWithMeta: svcMeta,
+			Dir:             filepath.Join(directory, svcMeta.Directory),
+		})
 	}
+	return copies
+}
 
-	// Build all services in order
-	for _, service := range services {
-		fmt.Printf("\nBuilding service: %s\n", service)
-		fmt.Println(strings.Repeat("-", 60))
-
-		// Check if this is a mesh service
-		var err error
-		if meshServices[service] {
-			fmt.Printf("  This is a GraphQL Mesh service, using special build sequence...\n")
-			err = maven.BuildMeshService(serviceDirs[service])
-		} else {
-			err = maven.BuildService(serviceDirs[service])
-		}
+// logFlags are the logging options shared by all subcommands
+type logFlags struct {
+	format   string
+	level    string
+	quiet    bool
+	debug    bool
+	auditLog string
+}
 
-		if err != nil {
-			log.Fatalf("Build failed for service %s: %v", service, err)
-		}
+// register adds -log-format, -log-level, -quiet, -debug and -audit-log to the flag set
+func (l *logFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&l.format, "log-format", logger.FormatText, "Log output format: text or json")
+	fs.StringVar(&l.level, "log-level", "info", "Minimum log level: debug, info, notice, warn or error")
+	fs.BoolVar(&l.quiet, "quiet", false, "Only phase headers, summaries, warnings and errors (same as -log-level notice)")
+	fs.BoolVar(&l.debug, "debug", false, "Trace every git/mvn command and GitLab request with its output (same as -log-level debug)")
+	fs.StringVar(&l.auditLog, "audit-log", os.Getenv("DEPLOY_AUDIT_LOG"), "Append every git/mvn command and GitLab request to this audit log (default: $DEPLOY_AUDIT_LOG)")
+}
 
-		fmt.Printf("%sService %s built successfully!%s\n", git.ColorGreen, service, git.ColorReset)
+// apply configures the logger and the audit log from the parsed flags
+func (l *logFlags) apply() {
+	if err := logger.SetFormat(l.format); err != nil {
+		logger.Exitf(exitConfig, "Error: -log-format: %v", err)
 	}
-
-	// Wait for user confirmation
-	fmt.Println("\nAll services built successfully!")
-	fmt.Println("Press Enter to continue and push changes...")
-	reader := bufio.NewReader(os.Stdin)
-	reader.ReadString('\n')
-
-	// Phase 9: Push changes and tags for all
-	fmt.Println("\nPhase 9: Pushing changes and tags...")
-	for _, service := range services {
-		fmt.Printf("  Pushing service: %s\n", service)
-		if err := git.PushWithTags(serviceDirs[service]); err != nil {
-			log.Fatalf("Failed to push in %s: %v", service, err)
-		}
+	level, err := logger.ParseLevel(l.level)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: -log-level: %v", err)
 	}
-
-	// Phase 10: Create GitLab pipelines
-	fmt.Println("\nPhase 10: Creating GitLab pipelines...")
-
-	if err := gitlab.CreatePipelinesFromConfig(cfg, tagName, namespaces); err != nil {
-		log.Fatalf("Failed to create GitLab pipelines: %v", err)
+	switch {
+	case l.quiet && l.debug:
+		logger.Exitf(exitConfig, "Error: -quiet and -debug cannot be used together")
+	case l.quiet:
+		level = logger.LevelNotice
+	case l.debug:
+		level = logger.LevelDebug
 	}
+	logger.SetLevel(level)
+	if err := audit.Open(l.auditLog); err != nil {
+		logger.Exitf(exitConfig, "Error: -audit-log: %v", err)
+	}
+}
 
-	fmt.Println("\nDeployment script completed successfully!")
+// resolveNamespaces returns the namespaces from the -namespace flag or, if it is empty,
+// from the selected environment
+func resolveNamespaces(namespaceStr string, cfg *config.Config) []string {
+	namespaces := splitList(namespaceStr)
+	if len(namespaces) == 0 && cfg.Env != nil {
+		namespaces = cfg.Env.Namespaces
+	}
+	return namespaces
 }
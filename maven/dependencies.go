@@ -0,0 +1,67 @@
+package maven
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// dependencyPatterns are the groupId:artifactId patterns of update_dependencies: the
+// dependencies, e.g. a platform BOM, whose versions are set to the release version
+var dependencyPatterns []string
+
+// SetDependencyPatterns sets the groupId:artifactId patterns of the dependencies whose
+// versions UpdatePomFiles sets, in dependencies and dependencyManagement. Both parts may
+// contain * and ? wildcards, e.g. ru.company.platform:* or ru.company:*-bom.
+func SetDependencyPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		parts := strings.Split(pattern, ":")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid update_dependencies entry %q, expected groupId:artifactId", pattern)
+		}
+		for _, part := range parts {
+			if _, err := path.Match(part, ""); err != nil {
+				return fmt.Errorf("invalid update_dependencies entry %q: %v", pattern, err)
+			}
+		}
+	}
+	dependencyPatterns = patterns
+	return nil
+}
+
+// artifactVersions are the versions the services of the release are released with, by
+// the groupId:artifactId of their artifacts
+var artifactVersions map[string]string
+
+// SetArtifactVersions sets the versions UpdatePomFiles sets the dependencies on the
+// artifacts of the released services to, by groupId:artifactId
+func SetArtifactVersions(versions map[string]string) {
+	artifactVersions = versions
+}
+
+// dependencyVersion returns the version the dependency is set to: that of the service
+// releasing the artifact, or releaseVersion if it matches update_dependencies. ok is
+// false if the dependency keeps its version, also if it is in skip_version_update.
+func dependencyVersion(groupID, artifactID, releaseVersion string, excludeArtifacts []ArtifactExclusion) (v string, ok bool) {
+	if isArtifactExcluded(groupID, artifactID, excludeArtifacts) {
+		return "", false
+	}
+	if v, ok := artifactVersions[groupID+":"+artifactID]; ok {
+		return v, true
+	}
+	for _, pattern := range dependencyPatterns {
+		parts := strings.SplitN(pattern, ":", 2)
+		groupMatch, _ := path.Match(parts[0], groupID)
+		artifactMatch, _ := path.Match(parts[1], artifactID)
+		if groupMatch && artifactMatch {
+			return releaseVersion, true
+		}
+	}
+	return "", false
+}
+
+// isLiteralVersion reports whether a dependency version is a value of its own rather
+// than a property reference, which the property pattern takes care of
+func isLiteralVersion(v string) bool {
+	return v != "" && !strings.Contains(v, "${")
+}
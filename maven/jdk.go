@@ -0,0 +1,82 @@
+package maven
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// toolchains is the part of a Maven toolchains.xml file that lists the installed JDKs
+type toolchains struct {
+	Toolchains []struct {
+		Type    string `xml:"type"`
+		Version string `xml:"provides>version"`
+		JDKHome string `xml:"configuration>jdkHome"`
+	} `xml:"toolchain"`
+}
+
+// ToolchainsFile returns the toolchains.xml of the user, ~/.m2/toolchains.xml
+func ToolchainsFile() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".m2", "toolchains.xml")
+}
+
+// FindJDK returns the home of the JDK of the version, e.g. 17: the directory given for
+// it in homes (jdks of the config), or the jdkHome of a jdk toolchain of the user's
+// toolchains.xml whose version is 17 or 17.x. The directory must contain bin/java.
+func FindJDK(version string, homes map[string]string) (string, error) {
+	home, source := homes[version], "jdks"
+	if home == "" {
+		var err error
+		home, err = toolchainJDK(ToolchainsFile(), version)
+		if err != nil {
+			return "", err
+		}
+		source = ToolchainsFile()
+	}
+	if home == "" {
+		return "", fmt.Errorf("JDK %s is neither in jdks of the config nor in %s", version, ToolchainsFile())
+	}
+
+	java := "java"
+	if runtime.GOOS == "windows" {
+		java = "java.exe"
+	}
+	if _, err := os.Stat(filepath.Join(home, "bin", java)); err != nil {
+		return "", fmt.Errorf("JDK %s from %s: %s not found", version, source, filepath.Join(home, "bin", java))
+	}
+	return home, nil
+}
+
+// toolchainJDK returns the jdkHome of the jdk toolchain of the version in the
+// toolchains file, empty if there is none or no file
+func toolchainJDK(file, version string) (string, error) {
+	if file == "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var tc toolchains
+	if err := xml.Unmarshal(data, &tc); err != nil {
+		return "", fmt.Errorf("%s: invalid XML: %v", file, err)
+	}
+	for _, t := range tc.Toolchains {
+		v := strings.TrimSpace(t.Version)
+		if strings.TrimSpace(t.Type) == "jdk" && (v == version || strings.HasPrefix(v, version+".")) {
+			return strings.TrimSpace(t.JDKHome), nil
+		}
+	}
+	return "", nil
+}
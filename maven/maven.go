@@ -5,12 +5,17 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+
+	"deploy/command"
+	"deploy/logger"
+	"deploy/plan"
+	"deploy/version"
 )
 
 // CleanCache cleans the Maven cache for the specified path
@@ -21,11 +26,16 @@ func CleanCache(cachePath string) error {
 	// Construct full path
 	targetPath := filepath.Join(mavenRepo, cachePath)
 
-	fmt.Printf("Cleaning Maven cache: %s\n", targetPath)
+	logger.Infof("Cleaning Maven cache: %s", targetPath)
 
 	// Check if directory exists
 	if _, err := os.Stat(targetPath); os.IsNotExist(err) {
-		fmt.Println("Maven cache directory does not exist, skipping cleanup")
+		logger.Infof("Maven cache directory does not exist, skipping cleanup")
+		return nil
+	}
+
+	if plan.Enabled() {
+		plan.Record("remove %s", targetPath)
 		return nil
 	}
 
@@ -34,7 +44,7 @@ func CleanCache(cachePath string) error {
 		return fmt.Errorf("failed to remove Maven cache directory: %v", err)
 	}
 
-	fmt.Println("Maven cache cleaned successfully")
+	logger.Infof("Maven cache cleaned successfully")
 	return nil
 }
 
@@ -48,7 +58,7 @@ func GetLocalRepository() string {
 	// Then, try to get from MAVEN_OPTS or standard location
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		log.Printf("Warning: Could not get user home directory: %v", err)
+		logger.Warnf("Warning: Could not get user home directory: %v", err)
 		homeDir = ""
 	}
 
@@ -64,70 +74,307 @@ func GetLocalRepository() string {
 		}
 	}
 
-	log.Fatal("Could not determine Maven local repository path")
+	logger.Fatalf("Could not determine Maven local repository path")
 	return ""
 }
 
-// BuildService builds a service using Maven
-func BuildService(serviceDir string) error {
+// Invocation holds the options of every mvn call of a service: the builds and the
+// versions plugin goals
+type Invocation struct {
+	Profiles []string // activated with -P
+	Settings string   // settings.xml passed with -s, the Maven default if empty
+	Command  string   // mvn or mvnw to force one of them; the wrapper of the service if it has one when empty
+	JavaHome string   // JAVA_HOME of the JDK of the service (jdk), that of the environment if empty
+	Offline  bool     // -o: resolve everything from the local repository (-maven-offline)
+	Opts     string   // MAVEN_OPTS of the service (maven_opts), that of the environment if empty
+}
+
+// Commands of maven_command: which Maven runs the mvn calls of a service
+const (
+	CommandAuto    = "auto" // the wrapper of the service if it has one, else mvn (default)
+	CommandMvn     = "mvn"  // the installed Maven, even if the service has a wrapper
+	CommandWrapper = "mvnw" // the wrapper of the service, which must have one
+)
+
+// CheckCommand returns an error if value is not a maven_command
+func CheckCommand(value string) error {
+	switch value {
+	case "", CommandAuto, CommandMvn, CommandWrapper:
+		return nil
+	}
+	return fmt.Errorf("unknown maven_command %q, expected %s, %s or %s", value, CommandAuto, CommandMvn, CommandWrapper)
+}
+
+// Wrapper returns the path of the Maven wrapper of the service in serviceDir, mvnw or
+// mvnw.cmd on Windows; empty if it has none
+func Wrapper(serviceDir string) string {
+	name := "mvnw"
+	if runtime.GOOS == "windows" {
+		name = "mvnw.cmd"
+	}
+	path := filepath.Join(serviceDir, name)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// program returns the executable of the mvn calls of the service in serviceDir: the
+// wrapper of the service pins the Maven version of the project, so it is preferred
+func (i Invocation) program(serviceDir string) (string, error) {
+	if i.Command == CommandMvn {
+		return "mvn", nil
+	}
+	if wrapper := Wrapper(serviceDir); wrapper != "" {
+		return wrapper, nil
+	}
+	if i.Command == CommandWrapper {
+		return "", fmt.Errorf("maven_command is %s, but %s has no Maven wrapper", CommandWrapper, serviceDir)
+	}
+	return "mvn", nil
+}
+
+// newCommand creates an mvn call run in dir with the program, the JDK and the MAVEN_OPTS
+// of the service
+func (i Invocation) newCommand(program, dir string, args ...string) *command.Cmd {
+	cmd := command.New(program, args...)
+	cmd.Dir = dir
+	if i.JavaHome != "" || i.Opts != "" {
+		cmd.Env = os.Environ()
+		if i.JavaHome != "" {
+			cmd.Env = append(cmd.Env, "JAVA_HOME="+i.JavaHome)
+		}
+		if i.Opts != "" {
+			cmd.Env = append(cmd.Env, "MAVEN_OPTS="+i.Opts)
+		}
+	}
+	if program != "mvn" {
+		// The wrapper runs Maven, so the mvn timeouts apply to it
+		cmd.Timeout = command.TimeoutOf("mvn", args...)
+	}
+	return cmd
+}
+
+// flags returns the mvn arguments of the options
+func (i Invocation) flags() []string {
+	var flags []string
+	if i.Offline {
+		flags = append(flags, "-o")
+	}
+	if i.Settings != "" {
+		flags = append(flags, "-s", i.Settings)
+	}
+	if len(i.Profiles) > 0 {
+		flags = append(flags, "-P"+strings.Join(i.Profiles, ","))
+	}
+	return flags
+}
+
+// BuildOptions are the goals and arguments of a Maven build
+type BuildOptions struct {
+	Invocation
+	Goals              []string       // clean install if empty
+	Args               []string       // arguments after the goals
+	Modules            []string       // modules built with -pl and their dependencies (-am), all if empty
+	RunTests           bool           // run the tests instead of skipping them
+	IgnoreTestFailures bool           // with RunTests, test failures do not fail the build
+	Log                *logger.Logger // receives the build output, nil for the root logger
+}
+
+// command returns the mvn arguments of the build; skipArgs are added when the tests
+// do not run
+func (o BuildOptions) command(skipArgs ...string) []string {
+	goals := o.Goals
+	if len(goals) == 0 {
+		goals = []string{"clean", "install"}
+	}
+	args := append(append(o.flags(), goals...), o.Args...)
+	args = append(args, o.moduleArgs()...)
+	switch {
+	case !o.RunTests:
+		args = append(args, skipArgs...)
+	case o.IgnoreTestFailures:
+		args = append(args, "-Dmaven.test.failure.ignore=true")
+	}
+	return args
+}
+
+// moduleArgs returns the arguments selecting the modules of the reactor
+func (o BuildOptions) moduleArgs() []string {
+	if len(o.Modules) == 0 {
+		return nil
+	}
+	return []string{"-pl", strings.Join(o.Modules, ","), "-am"}
+}
+
+// testSummary matches the totals surefire and failsafe print for a module; the lines
+// of single test classes go on with the elapsed time
+var testSummary = regexp.MustCompile(`(?m)Tests run: \d+, Failures: (\d+), Errors: (\d+), Skipped: \d+\s*$`)
+
+// failedTests returns the number of failed tests and test errors in the build output
+func failedTests(output string) int {
+	failed := 0
+	for _, match := range testSummary.FindAllStringSubmatch(output, -1) {
+		failures, _ := strconv.Atoi(match[1])
+		errors, _ := strconv.Atoi(match[2])
+		failed += failures + errors
+	}
+	return failed
+}
+
+// BuildService builds a service using Maven, by default with mvn clean install -DskipTests=true.
+// It returns the number of failed tests of a build that ignores test failures.
+func BuildService(serviceDir string, opts BuildOptions) (int, error) {
+	args := opts.command("-DskipTests=true")
+	program, err := opts.program(serviceDir)
+	if err != nil {
+		return 0, err
+	}
+	if plan.Enabled() {
+		plan.Record("%s %s (in %s)", program, strings.Join(args, " "), serviceDir)
+		return 0, nil
+	}
+
 	// Create Maven command
-	cmd := exec.Command("mvn", "clean", "install", "-DskipTests=true")
-	cmd.Dir = serviceDir
+	cmd := opts.newCommand(program, serviceDir, args...)
 
-	// Capture output
+	// Capture output and also print it in real-time
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	// Also print output in real-time
-	cmd.Stdout = io.MultiWriter(&stdout, os.Stdout)
-	cmd.Stderr = io.MultiWriter(&stderr, os.Stderr)
+	out := opts.Log.Writer(logger.LevelInfo)
+	cmd.Stdout = io.MultiWriter(&stdout, out)
+	cmd.Stderr = io.MultiWriter(&stderr, out)
 
 	// Run the build
-	err := cmd.Run()
+	err = cmd.Run()
+	out.Flush()
 
 	if err != nil {
 		// Print error details
-		fmt.Printf("\n\033[31mBuild failed!\033[0m\n")
+		logger.Errorf("\n\033[31mBuild failed!\033[0m")
 		if stderr.Len() > 0 {
-			fmt.Printf("Error output:\n%s\n", stderr.String())
+			logger.Infof("Error output:\n%s", stderr.String())
 		}
-		return fmt.Errorf("mvn clean install failed: %v", err)
+		return 0, fmt.Errorf("%s %s failed: %v", filepath.Base(program), strings.Join(args, " "), err)
 	}
 
+	return failedTests(stdout.String()), nil
+}
+
+// Repository is a Maven repository artifacts are deployed to: the ID of the server
+// whose credentials settings.xml holds, and the URL
+type Repository struct {
+	ID  string
+	URL string
+}
+
+// deployArg returns the -DaltXDeploymentRepository value of the repository
+func (r Repository) deployArg() string {
+	return r.ID + "::default::" + r.URL
+}
+
+// DeployTarget holds the repositories of DeployService; an empty one leaves the
+// distributionManagement of the project in place
+type DeployTarget struct {
+	Release  Repository
+	Snapshot Repository
+}
+
+// DeployService deploys the artifacts of a built service with mvn deploy to the
+// repositories of target. The tests ran in the build, so they are skipped.
+func DeployService(serviceDir string, opts BuildOptions, target DeployTarget) error {
+	args := append(append(opts.flags(), "deploy"), opts.Args...)
+	args = append(append(args, opts.moduleArgs()...), "-DskipTests=true")
+	if target.Release.URL != "" {
+		args = append(args, "-DaltReleaseDeploymentRepository="+target.Release.deployArg())
+	}
+	if target.Snapshot.URL != "" {
+		args = append(args, "-DaltSnapshotDeploymentRepository="+target.Snapshot.deployArg())
+	}
+	program, err := opts.program(serviceDir)
+	if err != nil {
+		return err
+	}
+	if plan.Enabled() {
+		plan.Record("%s %s (in %s)", program, strings.Join(args, " "), serviceDir)
+		return nil
+	}
+
+	cmd := opts.newCommand(program, serviceDir, args...)
+	var stderr bytes.Buffer
+	out := opts.Log.Writer(logger.LevelInfo)
+	cmd.Stdout = out
+	cmd.Stderr = io.MultiWriter(&stderr, out)
+
+	err = cmd.Run()
+	out.Flush()
+	if err != nil {
+		logger.Errorf("\n\033[31mDeploy failed!\033[0m")
+		if stderr.Len() > 0 {
+			logger.Infof("Error output:\n%s", stderr.String())
+		}
+		return fmt.Errorf("%s deploy failed: %v", filepath.Base(program), err)
+	}
 	return nil
 }
 
+// Version returns the version of the installed Maven
+func Version() (version.Version, error) {
+	return toolVersion("mvn", "-v")
+}
+
+// JavaVersion returns the version of the Java runtime Maven builds with,
+// e.g. 17.0.2, or 1.8.0 for Java 8
+func JavaVersion() (version.Version, error) {
+	return toolVersion("java", "-version") // prints to stderr
+}
+
+// toolVersion runs a tool with its version flag and finds the version in the output
+func toolVersion(name string, arg string) (version.Version, error) {
+	output, err := command.New(name, arg).CombinedOutput()
+	if err != nil {
+		return version.Version{}, err
+	}
+	v, ok := version.Find(string(output))
+	if !ok {
+		return version.Version{}, fmt.Errorf("unexpected output of %s %s: %s", name, arg, firstLine(string(output)))
+	}
+	return v, nil
+}
+
+// firstLine returns the first line of text
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	return line
+}
+
 // ArtifactExclusion defines an artifact whose version should not be updated
 type ArtifactExclusion struct {
 	GroupID    string
 	ArtifactID string
 }
 
-// UpdatePomFiles updates all pom.xml files in the directory with the new version
-func UpdatePomFiles(dir string, version string, propertyPattern string, excludeArtifacts []ArtifactExclusion, skipProperties []string) error {
-	// Find all pom.xml files
-	var pomFiles []string
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.Name() == "pom.xml" {
-			pomFiles = append(pomFiles, path)
-		}
-		return nil
-	})
+// UpdatePomFiles updates all pom.xml files in the directory with the new version. The
+// invocation options apply to the Maven calls of the versions plugin strategy.
+func UpdatePomFiles(dir string, version version.Version, propertyPatterns []string, excludeArtifacts []ArtifactExclusion, skipProperties []string, inv Invocation) error {
+	pomFiles, err := findPomFiles(dir)
 	if err != nil {
 		return err
 	}
 
+	if versionUpdate == UpdateVersionsPlugin {
+		return updateWithVersionsPlugin(dir, pomFiles, version, propertyPatterns, skipProperties, inv)
+	}
+
 	// Update each pom.xml
 	for _, pomFile := range pomFiles {
 		// Check if this is a root pom (in the service's top directory)
 		isRootPom := filepath.Dir(pomFile) == dir
 
-		if err := UpdatePomFile(pomFile, version, isRootPom, propertyPattern, excludeArtifacts, skipProperties); err != nil {
+		if err := UpdatePomFile(pomFile, version, isRootPom, propertyPatterns, excludeArtifacts, skipProperties); err != nil {
 			return fmt.Errorf("failed to update %s: %v", pomFile, err)
 		}
 	}
@@ -135,56 +382,99 @@ func UpdatePomFiles(dir string, version string, propertyPattern string, excludeA
 	return nil
 }
 
-// extractProjectIdentity extracts the project-level groupId and artifactId from POM content
-func extractProjectIdentity(content string) (groupID, artifactID string) {
-	lines := strings.Split(content, "\n")
-	insideParent := false
-	insideNested := 0
+// PomChange is the rewrite of a pom.xml file UpdatePomFiles would make
+type PomChange struct {
+	File string
+	Old  string
+	New  string
+}
 
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
+// PreviewPomFiles returns the changes UpdatePomFiles would make to the pom.xml files
+// in the directory, without writing anything. Unchanged files are not returned. With
+// the versions plugin, the changes are those of the xml strategy: Maven is not run.
+func PreviewPomFiles(dir string, version version.Version, propertyPatterns []string, excludeArtifacts []ArtifactExclusion, skipProperties []string) ([]PomChange, error) {
+	pomFiles, err := findPomFiles(dir)
+	if err != nil {
+		return nil, err
+	}
 
-		if strings.Contains(trimmed, "<parent>") {
-			insideParent = true
-		} else if strings.Contains(trimmed, "</parent>") {
-			insideParent = false
+	var changes []PomChange
+	for _, pomFile := range pomFiles {
+		data, err := ioutil.ReadFile(pomFile)
+		if err != nil {
+			return nil, err
 		}
-
-		// Track blocks that can contain their own groupId/artifactId
-		for _, tag := range []string{"<dependencies>", "<dependencyManagement>", "<build>", "<profiles>", "<reporting>"} {
-			if strings.Contains(trimmed, tag) {
-				insideNested++
-			}
+		isRootPom := filepath.Dir(pomFile) == dir
+		updated, err := rewritePom(pomFile, string(data), version, isRootPom, propertyPatterns, excludeArtifacts, skipProperties)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pomFile, err)
 		}
-		for _, tag := range []string{"</dependencies>", "</dependencyManagement>", "</build>", "</profiles>", "</reporting>"} {
-			if strings.Contains(trimmed, tag) {
-				insideNested--
-			}
+		if updated != string(data) {
+			changes = append(changes, PomChange{File: pomFile, Old: string(data), New: updated})
 		}
+	}
+	return changes, nil
+}
 
-		if !insideParent && insideNested == 0 {
-			if groupID == "" && strings.Contains(trimmed, "<groupId>") && strings.Contains(trimmed, "</groupId>") {
-				s := strings.Index(trimmed, "<groupId>") + 9
-				e := strings.Index(trimmed, "</groupId>")
-				if s > 8 && e > s {
-					groupID = trimmed[s:e]
-				}
-			}
-			if artifactID == "" && strings.Contains(trimmed, "<artifactId>") && strings.Contains(trimmed, "</artifactId>") {
-				s := strings.Index(trimmed, "<artifactId>") + 12
-				e := strings.Index(trimmed, "</artifactId>")
-				if s > 11 && e > s {
-					artifactID = trimmed[s:e]
-				}
-			}
+// findPomFiles returns all pom.xml files in the directory tree
+func findPomFiles(dir string) ([]string, error) {
+	var pomFiles []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
-
-		// Once we have both, no need to continue
-		if groupID != "" && artifactID != "" {
-			break
+		if info.Name() == "pom.xml" {
+			pomFiles = append(pomFiles, path)
 		}
+		return nil
+	})
+	return pomFiles, err
+}
+
+// ProjectVersion returns the version of the root pom.xml in dir, without a -SNAPSHOT
+// or other qualifier; ok is false if the pom has no version of its own
+func ProjectVersion(dir string) (v version.Version, ok bool, err error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "pom.xml"))
+	if err != nil {
+		return version.Version{}, false, err
+	}
+	doc, err := parsePom(string(content))
+	if err != nil {
+		return version.Version{}, false, fmt.Errorf("%s: %v", filepath.Join(dir, "pom.xml"), err)
+	}
+	if doc.Version.text() == "" {
+		return version.Version{}, false, nil
 	}
-	return
+	v, ok = version.Find(doc.Version.text())
+	return v, ok, nil
+}
+
+// InstalledArtifact returns the path of the pom of the project in dir at the version
+// in the local Maven repository; ok is false if that version is not installed
+func InstalledArtifact(dir string, v version.Version) (path string, ok bool, err error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "pom.xml"))
+	if err != nil {
+		return "", false, err
+	}
+	doc, err := parsePom(string(content))
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %v", filepath.Join(dir, "pom.xml"), err)
+	}
+	groupID, artifactID := doc.GroupID.text(), doc.ArtifactID.text()
+	if groupID == "" {
+		// A module inherits the group of its parent
+		groupID = doc.ParentGroupID.text()
+	}
+	if groupID == "" || artifactID == "" {
+		return "", false, fmt.Errorf("%s has no groupId or artifactId", filepath.Join(dir, "pom.xml"))
+	}
+
+	path = filepath.Join(GetLocalRepository(), filepath.FromSlash(strings.ReplaceAll(groupID, ".", "/")),
+		artifactID, v.String(), artifactID+"-"+v.String()+".pom")
+	if _, err := os.Stat(path); err != nil {
+		return path, false, nil
+	}
+	return path, true, nil
 }
 
 // isArtifactExcluded checks if the artifact matches any exclusion rule
@@ -197,6 +487,17 @@ func isArtifactExcluded(groupID, artifactID string, exclusions []ArtifactExclusi
 	return false
 }
 
+// matchesProperty reports whether the property name contains one of the patterns, so
+// an exact property name is a pattern too
+func matchesProperty(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern != "" && strings.Contains(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
 // isPropertySkipped checks if the property name matches any entry in the skip list
 func isPropertySkipped(propertyName string, skipProperties []string) bool {
 	for _, skip := range skipProperties {
@@ -208,238 +509,249 @@ func isPropertySkipped(propertyName string, skipProperties []string) bool {
 }
 
 // UpdatePomFile updates a single pom.xml file with the new version
-func UpdatePomFile(filename string, version string, isRootPom bool, propertyPattern string, excludeArtifacts []ArtifactExclusion, skipProperties []string) error {
+func UpdatePomFile(filename string, version version.Version, isRootPom bool, propertyPatterns []string, excludeArtifacts []ArtifactExclusion, skipProperties []string) error {
 	// Read file
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return err
 	}
 
-	content := string(data)
-	newVersion := version + ".0.0"
+	content, err := rewritePom(filename, string(data), version, isRootPom, propertyPatterns, excludeArtifacts, skipProperties)
+	if err != nil {
+		return err
+	}
 
-	// Check if this POM's own artifact matches an exclusion — skip all updates
-	projectGroupID, projectArtifactID := extractProjectIdentity(content)
-	if isArtifactExcluded(projectGroupID, projectArtifactID, excludeArtifacts) {
-		fmt.Printf("    Skipping all version updates for excluded artifact %s:%s in %s\n", projectGroupID, projectArtifactID, filename)
+	if plan.Enabled() {
+		recordPomChanges(filename, strings.Split(string(data), "\n"), strings.Split(content, "\n"))
 		return nil
 	}
 
-	// Parse line by line
-	lines := strings.Split(content, "\n")
-
-	// Flags for tracking context
-	insideProject := false
-	insideParent := false
-	insideProperties := false
-
-	// Counters for tracking what we've updated
-	rootVersionUpdated := false
-	parentVersionUpdated := false
+	// Write file back
+	return ioutil.WriteFile(filename, []byte(content), 0644)
+}
 
-	// Counter for tags after project
-	tagsAfterProject := 0
+// rewritePom returns the content of a pom.xml file with the project version, the parent
+// version of a module, the properties matching a pattern and the dependencies matching
+// update_dependencies set to the new version, and the dependencies on the artifacts of
+// other services of the release to their versions.
+// Only the element values change, never the formatting or the number of lines.
+func rewritePom(filename string, content string, version version.Version, isRootPom bool, propertyPatterns []string, excludeArtifacts []ArtifactExclusion, skipProperties []string) (string, error) {
+	if versionUpdate == UpdateText {
+		return rewritePomText(filename, content, version, isRootPom, propertyPatterns, excludeArtifacts, skipProperties), nil
+	}
+	newVersion := version.String()
 
-	// Track parent groupId and artifactId for exclusion check
-	parentGroupID := ""
-	parentArtifactID := ""
+	doc, err := parsePom(content)
+	if err != nil {
+		return "", err
+	}
 
-	for i, line := range lines {
-		trimmed := strings.TrimSpace(line)
+	// Check if this POM's own artifact matches an exclusion — skip all updates
+	projectGroupID, projectArtifactID := doc.GroupID.text(), doc.ArtifactID.text()
+	if isArtifactExcluded(projectGroupID, projectArtifactID, excludeArtifacts) {
+		logger.Infof("    Skipping all version updates for excluded artifact %s:%s in %s", projectGroupID, projectArtifactID, filename)
+		return content, nil
+	}
 
-		// Track entering/exiting project
-		if strings.Contains(line, "<project") {
-			insideProject = true
-			tagsAfterProject = 0
+	var edits []pomEdit
+	setVersion := func(v *pomValue) {
+		// ${revision} and similar CI-friendly versions are set by the build
+		if v != nil && !strings.Contains(v.Text, "revision") {
+			edits = append(edits, pomEdit{start: v.Start, end: v.End, text: newVersion})
 		}
+	}
 
-		// Track entering/exiting parent
-		if strings.Contains(line, "<parent>") {
-			insideParent = true
-			parentGroupID = ""
-			parentArtifactID = ""
-		} else if strings.Contains(line, "</parent>") {
-			insideParent = false
-		}
+	setVersion(doc.Version)
 
-		// Track parent groupId and artifactId
-		if insideParent {
-			if strings.Contains(trimmed, "<groupId>") && strings.Contains(trimmed, "</groupId>") {
-				s := strings.Index(trimmed, "<groupId>") + 9
-				e := strings.Index(trimmed, "</groupId>")
-				if s > 8 && e > s {
-					parentGroupID = trimmed[s:e]
-				}
-			}
-			if strings.Contains(trimmed, "<artifactId>") && strings.Contains(trimmed, "</artifactId>") {
-				s := strings.Index(trimmed, "<artifactId>") + 12
-				e := strings.Index(trimmed, "</artifactId>")
-				if s > 11 && e > s {
-					parentArtifactID = trimmed[s:e]
-				}
-			}
+	// Modules follow the version of their parent, unless the parent is excluded
+	if !isRootPom && doc.ParentVersion != nil {
+		parentGroupID, parentArtifactID := doc.ParentGroupID.text(), doc.ParentArtifactID.text()
+		if isArtifactExcluded(parentGroupID, parentArtifactID, excludeArtifacts) {
+			logger.Infof("    Skipping parent version update for %s:%s in %s", parentGroupID, parentArtifactID, filename)
+		} else {
+			setVersion(doc.ParentVersion)
 		}
+	}
 
-		// Track entering/exiting properties
-		if strings.Contains(line, "<properties>") {
-			insideProperties = true
-		} else if strings.Contains(line, "</properties>") {
-			insideProperties = false
+	for _, property := range doc.Properties {
+		if !matchesProperty(property.Name, propertyPatterns) {
+			continue
 		}
-
-		// Count tags after project (to determine if version is direct child)
-		if insideProject && !insideParent && !insideProperties {
-			if strings.Contains(trimmed, "<") && !strings.Contains(trimmed, "</") &&
-				!strings.Contains(trimmed, "<version>") {
-				tagsAfterProject++
-			}
+		if isPropertySkipped(property.Name, skipProperties) {
+			logger.Infof("    Skipping property <%s> in %s", property.Name, filename)
+			continue
 		}
+		edits = append(edits, pomEdit{start: property.Start, end: property.End, text: newVersion})
+	}
 
-		// Update version tags
-		if strings.Contains(trimmed, "<version>") && strings.Contains(trimmed, "</version>") {
-
-			// Extract current version
-			start := strings.Index(trimmed, "<version>") + 9
-			end := strings.Index(trimmed, "</version>")
-
-			if start > 8 && end > start {
-				currentVersion := trimmed[start:end]
-
-				// Skip replacement if version contains "revision" (e.g., ${revision})
-				if strings.Contains(currentVersion, "revision") {
-					continue
-				}
-
-				// CASE 1: Root POM - update version that's direct child of project
-				if isRootPom && insideProject && !insideParent && !insideProperties &&
-					!rootVersionUpdated && tagsAfterProject <= 4 {
-					// Replace version
-					newLine := strings.Replace(line, "<version>"+currentVersion+"</version>",
-						"<version>"+newVersion+"</version>", 1)
-					lines[i] = newLine
-					rootVersionUpdated = true
-				}
-
-				// CASE 2a: Update version inside parent (only for submodule POMs)
-				// Skip if parent matches an exclusion rule
-				if insideParent && !parentVersionUpdated && !isRootPom {
-					if !isArtifactExcluded(parentGroupID, parentArtifactID, excludeArtifacts) {
-						newLine := strings.Replace(line, "<version>"+currentVersion+"</version>",
-							"<version>"+newVersion+"</version>", 1)
-						lines[i] = newLine
-						parentVersionUpdated = true
-					} else {
-						fmt.Printf("    Skipping parent version update for %s:%s in %s\n", parentGroupID, parentArtifactID, filename)
-						parentVersionUpdated = true
-					}
-				}
-
-				// CASE 2b: Submodule POM - update project version
-				if !isRootPom && insideProject && !insideParent && !insideProperties &&
-					!rootVersionUpdated && tagsAfterProject <= 4 {
-					newLine := strings.Replace(line, "<version>"+currentVersion+"</version>",
-						"<version>"+newVersion+"</version>", 1)
-					lines[i] = newLine
-					rootVersionUpdated = true
-				}
-			}
+	for _, dep := range doc.Dependencies {
+		if dep.Version == nil || !isLiteralVersion(dep.Version.Text) {
+			continue
 		}
-
-		// CASE 3: Update properties matching the pattern
-		if insideProperties && strings.Contains(trimmed, propertyPattern) &&
-			strings.Contains(trimmed, "<") && strings.Contains(trimmed, ">") {
-			// Find property tag with pattern in name
-			startTag := strings.Index(trimmed, "<")
-			endTag := strings.Index(trimmed, ">")
-
-			if startTag >= 0 && endTag > startTag {
-				tagContent := trimmed[startTag+1 : endTag]
-
-				// Check if this is a property matching pattern (not a closing tag)
-				if strings.Contains(tagContent, propertyPattern) && !strings.HasPrefix(tagContent, "/") {
-					// Check if this property is in the skip list
-					if isPropertySkipped(tagContent, skipProperties) {
-						fmt.Printf("    Skipping property <%s> in %s\n", tagContent, filename)
-					} else {
-						// Find the value
-						valueStart := endTag + 1
-						valueEnd := strings.Index(trimmed[valueStart:], "<")
-
-						if valueEnd > 0 {
-							// Replace the value
-							oldValue := trimmed[valueStart : valueStart+valueEnd]
-							newLine := strings.Replace(line, ">"+oldValue+"<", ">"+newVersion+"<", 1)
-							lines[i] = newLine
-						}
-					}
-				}
-			}
+		if v, ok := dependencyVersion(dep.GroupID.text(), dep.ArtifactID.text(), newVersion, excludeArtifacts); ok {
+			edits = append(edits, pomEdit{start: dep.Version.Start, end: dep.Version.End, text: v})
 		}
 	}
 
-	// Join lines back
-	content = strings.Join(lines, "\n")
+	return applyPomEdits(content, edits), nil
+}
 
-	// Write file back
-	return ioutil.WriteFile(filename, []byte(content), 0644)
+// recordPomChanges reports the lines UpdatePomFile would change in dry-run mode
+func recordPomChanges(filename string, oldLines, newLines []string) {
+	for i := range newLines {
+		if oldLines[i] != newLines[i] {
+			plan.Record("edit %s:%d: %s -> %s", filename, i+1, strings.TrimSpace(oldLines[i]), strings.TrimSpace(newLines[i]))
+		}
+	}
 }
 
 // BuildMeshService builds a mesh service using Maven with special sequence:
 // 1. First builds graphql-mesh-resources submodule
 // 2. Then builds the main project
-func BuildMeshService(serviceDir string) error {
+// Both steps run the goals and arguments of opts, mvn clean install by default. It
+// returns the number of failed tests of a build that ignores test failures.
+func BuildMeshService(serviceDir string, opts BuildOptions) (int, error) {
 	// Step 1: Build graphql-mesh-resources first
 	meshResourcesDir := filepath.Join(serviceDir, "graphql-mesh-resources")
 
 	// Check if graphql-mesh-resources directory exists
 	if _, err := os.Stat(meshResourcesDir); os.IsNotExist(err) {
-		return fmt.Errorf("graphql-mesh-resources directory not found in %s", serviceDir)
+		return 0, fmt.Errorf("graphql-mesh-resources directory not found in %s", serviceDir)
+	}
+
+	args := opts.command()
+	// The wrapper of the service also builds its submodule
+	program, err := opts.program(serviceDir)
+	if err != nil {
+		return 0, err
+	}
+	if plan.Enabled() {
+		plan.Record("%s %s (in %s)", program, strings.Join(args, " "), meshResourcesDir)
+		plan.Record("%s %s (in %s)", program, strings.Join(args, " "), serviceDir)
+		return 0, nil
 	}
 
-	fmt.Printf("  Building graphql-mesh-resources first...\n")
+	logger.Infof("  Building graphql-mesh-resources first...")
 
 	// Create Maven command for mesh resources
-	cmd := exec.Command("mvn", "clean", "install")
-	cmd.Dir = meshResourcesDir
+	cmd := opts.newCommand(program, meshResourcesDir, args...)
 
 	// Capture and display output
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
-	cmd.Stdout = io.MultiWriter(&stdout, os.Stdout)
-	cmd.Stderr = io.MultiWriter(&stderr, os.Stderr)
+	out := opts.Log.Writer(logger.LevelInfo)
+	cmd.Stdout = io.MultiWriter(&stdout, out)
+	cmd.Stderr = io.MultiWriter(&stderr, out)
 
 	// Run the build for mesh resources
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("\n\033[31mBuild failed for graphql-mesh-resources!\033[0m\n")
+	err = cmd.Run()
+	out.Flush()
+	if err != nil {
+		logger.Errorf("\n\033[31mBuild failed for graphql-mesh-resources!\033[0m")
 		if stderr.Len() > 0 {
-			fmt.Printf("Error output:\n%s\n", stderr.String())
+			logger.Infof("Error output:\n%s", stderr.String())
 		}
-		return fmt.Errorf("mvn clean install failed in graphql-mesh-resources: %v", err)
+		return 0, fmt.Errorf("%s %s failed in graphql-mesh-resources: %v", filepath.Base(program), strings.Join(args, " "), err)
 	}
+	failed := failedTests(stdout.String())
 
-	fmt.Printf("  graphql-mesh-resources built successfully\n")
+	logger.Infof("  graphql-mesh-resources built successfully")
 
 	// Step 2: Build the main project
-	fmt.Printf("  Building main project...\n")
+	logger.Infof("  Building main project...")
 
 	// Create Maven command for main project
-	cmd = exec.Command("mvn", "clean", "install")
-	cmd.Dir = serviceDir
+	cmd = opts.newCommand(program, serviceDir, args...)
 
 	// Reset buffers
 	stdout.Reset()
 	stderr.Reset()
-	cmd.Stdout = io.MultiWriter(&stdout, os.Stdout)
-	cmd.Stderr = io.MultiWriter(&stderr, os.Stderr)
+	cmd.Stdout = io.MultiWriter(&stdout, out)
+	cmd.Stderr = io.MultiWriter(&stderr, out)
 
 	// Run the main build
-	if err := cmd.Run(); err != nil {
-		fmt.Printf("\n\033[31mBuild failed for main project!\033[0m\n")
+	err = cmd.Run()
+	out.Flush()
+	if err != nil {
+		logger.Errorf("\n\033[31mBuild failed for main project!\033[0m")
 		if stderr.Len() > 0 {
-			fmt.Printf("Error output:\n%s\n", stderr.String())
+			logger.Infof("Error output:\n%s", stderr.String())
 		}
-		return fmt.Errorf("mvn clean install failed in main project: %v", err)
+		return failed, fmt.Errorf("%s %s failed in main project: %v", filepath.Base(program), strings.Join(args, " "), err)
 	}
 
-	return nil
+	return failed + failedTests(stdout.String()), nil
+}
+
+// ChangedLines returns the number of lines the change replaces
+func (c PomChange) ChangedLines() int {
+	return len(c.changedLines())
+}
+
+// changedLines returns the indexes of the replaced lines
+func (c PomChange) changedLines() []int {
+	oldLines := strings.Split(c.Old, "\n")
+	newLines := strings.Split(c.New, "\n")
+	var changed []int
+	for i := range oldLines {
+		if i < len(newLines) && oldLines[i] != newLines[i] {
+			changed = append(changed, i)
+		}
+	}
+	return changed
+}
+
+// diffContext is the number of unchanged lines shown around a change
+const diffContext = 3
+
+// Diff returns the change as a unified diff with the given file names. rewritePom
+// only replaces element values, so the old and new content have the same number of lines.
+func (c PomChange) Diff(oldName, newName string) string {
+	oldLines := strings.Split(c.Old, "\n")
+	newLines := strings.Split(c.New, "\n")
+	changed := c.changedLines()
+	if len(changed) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for start := 0; start < len(changed); {
+		// Changes closer than twice the context share a hunk
+		end := start
+		for end+1 < len(changed) && changed[end+1]-changed[end] <= 2*diffContext {
+			end++
+		}
+		from := changed[start] - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := changed[end] + diffContext + 1
+		if to > len(oldLines) {
+			to = len(oldLines)
+		}
+
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", from+1, to-from, from+1, to-from)
+		for i := from; i < to; {
+			if oldLines[i] == newLines[i] {
+				fmt.Fprintf(&b, " %s\n", oldLines[i])
+				i++
+				continue
+			}
+			// A run of changed lines is shown as all removals, then all additions
+			run := i
+			for run < to && oldLines[run] != newLines[run] {
+				run++
+			}
+			for j := i; j < run; j++ {
+				fmt.Fprintf(&b, "-%s\n", oldLines[j])
+			}
+			for j := i; j < run; j++ {
+				fmt.Fprintf(&b, "+%s\n", newLines[j])
+			}
+			i = run
+		}
+		start = end + 1
+	}
+	return b.String()
 }
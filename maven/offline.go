@@ -0,0 +1,32 @@
+package maven
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"deploy/plan"
+)
+
+// CheckOffline resolves the dependencies and plugins of the service in serviceDir from
+// the local repository only, with dependency:go-offline and -o: an offline build would
+// fail on the first missing one, halfway through the release. The modules of the
+// project are not resolved, they are built. The dependency plugin itself must be in
+// the local repository, which a warm-up run of mvn dependency:go-offline takes care of.
+func CheckOffline(serviceDir string, inv Invocation) error {
+	inv.Offline = true
+	args := append(inv.flags(), "-B", "-q", "dependency:go-offline")
+	program, err := inv.program(serviceDir)
+	if err != nil {
+		return err
+	}
+	if plan.Enabled() {
+		plan.Record("%s %s (in %s)", program, strings.Join(args, " "), serviceDir)
+		return nil
+	}
+	cmd := inv.newCommand(program, serviceDir, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s dependency:go-offline failed: %v\n%s", filepath.Base(program), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
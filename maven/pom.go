@@ -0,0 +1,176 @@
+package maven
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// pomValue is the text of an element of a pom.xml file and where it is in the file
+type pomValue struct {
+	Text  string // trimmed text
+	Start int    // byte offset of the trimmed text in the file
+	End   int
+}
+
+// pomProperty is a property of a <properties> element, at any level (project or profile)
+type pomProperty struct {
+	Name string
+	pomValue
+}
+
+// pomDocument holds the elements of a pom.xml file the version update reads and changes
+type pomDocument struct {
+	GroupID          *pomValue // project-level elements, nil if absent
+	ArtifactID       *pomValue
+	Version          *pomValue
+	ParentGroupID    *pomValue // elements of project/parent
+	ParentArtifactID *pomValue
+	ParentVersion    *pomValue
+	Properties       []pomProperty
+	Dependencies     []pomDependency // <dependency> elements at any level
+}
+
+// pomDependency is a <dependency> of dependencies or dependencyManagement, at any
+// level (project, profile or plugin)
+type pomDependency struct {
+	GroupID    *pomValue
+	ArtifactID *pomValue
+	Version    *pomValue
+}
+
+// pomEdit replaces the text between two byte offsets of a pom.xml file
+type pomEdit struct {
+	start, end int
+	text       string
+}
+
+// parsePom reads a pom.xml file with an XML decoder. Comments, CDATA sections and
+// elements nested in dependencies, plugins or profiles are told apart by the document
+// tree, whatever the formatting of the file.
+func parsePom(content string) (*pomDocument, error) {
+	doc := &pomDocument{}
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	// HTML entities such as &nbsp; turn up in descriptions
+	decoder.Entity = xml.HTMLEntity
+
+	var path []string
+	// text collects the character data of the innermost element; an element with
+	// comments or child elements inside has no single text and is never changed
+	var text *pomValue
+	textParts := 0
+
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XML: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			text, textParts = nil, 0
+			if t.Name.Local == "dependency" {
+				doc.Dependencies = append(doc.Dependencies, pomDependency{})
+			}
+		case xml.CharData:
+			raw := content[offset:decoder.InputOffset()]
+			if strings.HasPrefix(raw, "<![CDATA[") {
+				textParts = 2 // never rewrite CDATA
+				continue
+			}
+			trimmed := strings.TrimSpace(raw)
+			start := offset + strings.Index(raw, trimmed)
+			text = &pomValue{Text: strings.TrimSpace(string(t)), Start: start, End: start + len(trimmed)}
+			textParts++
+		case xml.Comment, xml.ProcInst, xml.Directive:
+			if len(path) > 0 {
+				textParts = 2
+			}
+		case xml.EndElement:
+			var value *pomValue
+			if textParts == 1 {
+				value = text
+			} else if textParts == 0 && int(decoder.InputOffset()) > offset {
+				// <version></version>: an empty value at the position of the end tag.
+				// The end of <version/> reads no input and has no place for a value.
+				value = &pomValue{Start: offset, End: offset}
+			}
+			if value != nil {
+				doc.set(path, value)
+			}
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+			text, textParts = nil, 2 // the parent now has a child element
+		}
+	}
+	if len(path) != 0 {
+		return nil, fmt.Errorf("invalid XML: <%s> is not closed", path[len(path)-1])
+	}
+	return doc, nil
+}
+
+// set records the value of the element at the path, if the version update needs it
+func (d *pomDocument) set(path []string, value *pomValue) {
+	if len(path) == 0 || path[0] != "project" {
+		return
+	}
+	switch strings.Join(path, "/") {
+	case "project/groupId":
+		d.GroupID = value
+	case "project/artifactId":
+		d.ArtifactID = value
+	case "project/version":
+		d.Version = value
+	case "project/parent/groupId":
+		d.ParentGroupID = value
+	case "project/parent/artifactId":
+		d.ParentArtifactID = value
+	case "project/parent/version":
+		d.ParentVersion = value
+	default:
+		if len(path) < 2 {
+			return
+		}
+		switch path[len(path)-2] {
+		case "properties":
+			d.Properties = append(d.Properties, pomProperty{Name: path[len(path)-1], pomValue: *value})
+		case "dependency":
+			// Dependencies do not nest, so the element belongs to the last one started
+			dep := &d.Dependencies[len(d.Dependencies)-1]
+			switch path[len(path)-1] {
+			case "groupId":
+				dep.GroupID = value
+			case "artifactId":
+				dep.ArtifactID = value
+			case "version":
+				dep.Version = value
+			}
+		}
+	}
+}
+
+// text returns the text of an element that may be absent
+func (v *pomValue) text() string {
+	if v == nil {
+		return ""
+	}
+	return v.Text
+}
+
+// applyPomEdits returns the content with the edits applied. Edits only replace element
+// text, so the formatting, comments and line count of the file are kept.
+func applyPomEdits(content string, edits []pomEdit) string {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	for _, e := range edits {
+		content = content[:e.start] + e.text + content[e.end:]
+	}
+	return content
+}
@@ -0,0 +1,184 @@
+package maven
+
+import (
+	"strings"
+	"testing"
+
+	"deploy/version"
+)
+
+var releaseVersion = version.Version{Major: 2, Minor: 14, Patch: 0}
+
+// checkRewrite rewrites content as a module pom and compares the result with want,
+// then checks that only version text changed and the line count stayed the same
+func checkRewrite(t *testing.T, content, want string, propertyPatterns []string) {
+	t.Helper()
+	got, err := rewritePom("pom.xml", content, releaseVersion, false, propertyPatterns, nil, nil)
+	if err != nil {
+		t.Fatalf("rewritePom: %v", err)
+	}
+	if got != want {
+		t.Errorf("rewritePom:\n%s\nwant:\n%s", got, want)
+	}
+	if n, m := strings.Count(got, "\n"), strings.Count(content, "\n"); n != m {
+		t.Errorf("rewritePom changed the line count from %d to %d", m, n)
+	}
+	// Putting the old versions back must give the original file
+	if restored := strings.ReplaceAll(got, releaseVersion.String(), "1.0.0"); restored != content {
+		t.Errorf("rewritePom changed more than the version text:\n%s", got)
+	}
+}
+
+func TestRewritePomSingleLine(t *testing.T) {
+	content := `<project><parent><groupId>ru.company</groupId><artifactId>parent</artifactId><version>1.0.0</version></parent><artifactId>billing</artifactId><version>1.0.0</version><dependencies><dependency><groupId>org.lib</groupId><artifactId>lib</artifactId><version>3.2.1</version></dependency></dependencies></project>`
+	want := `<project><parent><groupId>ru.company</groupId><artifactId>parent</artifactId><version>2.14.0</version></parent><artifactId>billing</artifactId><version>2.14.0</version><dependencies><dependency><groupId>org.lib</groupId><artifactId>lib</artifactId><version>3.2.1</version></dependency></dependencies></project>`
+	checkRewrite(t, content, want, nil)
+}
+
+func TestRewritePomCommentsAndCDATA(t *testing.T) {
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<project>
+    <!-- <version>0.0.1</version> -->
+    <artifactId>billing</artifactId>
+    <version>
+        1.0.0
+    </version>
+    <properties>
+        <billing.version><!-- set by deploy -->1.0.0</billing.version>
+        <api.version><![CDATA[1.0.0]]></api.version>
+    </properties>
+</project>
+`
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<project>
+    <!-- <version>0.0.1</version> -->
+    <artifactId>billing</artifactId>
+    <version>
+        2.14.0
+    </version>
+    <properties>
+        <billing.version><!-- set by deploy -->1.0.0</billing.version>
+        <api.version><![CDATA[1.0.0]]></api.version>
+    </properties>
+</project>
+`
+	checkRewrite(t, content, want, []string{"version"})
+}
+
+func TestRewritePomProfilePropertyAndPluginDependency(t *testing.T) {
+	saved := dependencyPatterns
+	dependencyPatterns = []string{"ru.company.platform:*"}
+	t.Cleanup(func() { dependencyPatterns = saved })
+
+	content := `<project>
+    <artifactId>billing</artifactId>
+    <version>1.0.0</version>
+    <profiles>
+        <profile>
+            <id>release</id>
+            <properties>
+                <billing.version>1.0.0</billing.version>
+                <other.flag>true</other.flag>
+            </properties>
+        </profile>
+    </profiles>
+    <build>
+        <plugins>
+            <plugin>
+                <artifactId>maven-enforcer-plugin</artifactId>
+                <version>3.4.1</version>
+                <dependencies>
+                    <dependency>
+                        <groupId>ru.company.platform</groupId>
+                        <artifactId>rules</artifactId>
+                        <version>1.0.0</version>
+                    </dependency>
+                </dependencies>
+            </plugin>
+        </plugins>
+    </build>
+</project>
+`
+	want := `<project>
+    <artifactId>billing</artifactId>
+    <version>2.14.0</version>
+    <profiles>
+        <profile>
+            <id>release</id>
+            <properties>
+                <billing.version>2.14.0</billing.version>
+                <other.flag>true</other.flag>
+            </properties>
+        </profile>
+    </profiles>
+    <build>
+        <plugins>
+            <plugin>
+                <artifactId>maven-enforcer-plugin</artifactId>
+                <version>3.4.1</version>
+                <dependencies>
+                    <dependency>
+                        <groupId>ru.company.platform</groupId>
+                        <artifactId>rules</artifactId>
+                        <version>2.14.0</version>
+                    </dependency>
+                </dependencies>
+            </plugin>
+        </plugins>
+    </build>
+</project>
+`
+	checkRewrite(t, content, want, []string{"billing.version"})
+}
+
+func TestRewritePomRevision(t *testing.T) {
+	content := `<project>
+    <parent>
+        <artifactId>parent</artifactId>
+        <version>${revision}</version>
+    </parent>
+    <artifactId>billing</artifactId>
+    <version>${revision}</version>
+    <properties>
+        <revision>1.0.0</revision>
+    </properties>
+</project>
+`
+	want := `<project>
+    <parent>
+        <artifactId>parent</artifactId>
+        <version>${revision}</version>
+    </parent>
+    <artifactId>billing</artifactId>
+    <version>${revision}</version>
+    <properties>
+        <revision>2.14.0</revision>
+    </properties>
+</project>
+`
+	checkRewrite(t, content, want, []string{"revision"})
+}
+
+func TestRewritePomSelfClosingVersion(t *testing.T) {
+	content := `<project>
+    <artifactId>billing</artifactId>
+    <version/>
+    <properties>
+        <billing.version />
+    </properties>
+</project>
+`
+	checkRewrite(t, content, content, []string{"billing.version"})
+}
+
+func TestRewritePomMalformed(t *testing.T) {
+	for _, content := range []string{
+		"<project><version>1.0.0</project>",
+		"<project><version>1.0.0</version>",
+		"<project><version>1.0.0</version></project></project>",
+	} {
+		if got, err := rewritePom("pom.xml", content, releaseVersion, false, nil, nil, nil); err == nil {
+			t.Errorf("rewritePom(%q) = %q, want an error", content, got)
+		}
+	}
+}
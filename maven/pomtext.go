@@ -0,0 +1,261 @@
+package maven
+
+import (
+	"strings"
+
+	"deploy/logger"
+	"deploy/version"
+)
+
+// lineProjectIdentity extracts the project-level groupId and artifactId from POM content
+func lineProjectIdentity(content string) (groupID, artifactID string) {
+	return lineProjectElement(content, "groupId"), lineProjectElement(content, "artifactId")
+}
+
+// lineProjectElement returns the value of a project-level element such as <version>,
+// ignoring the same element inside <parent>, dependencies, build and profiles
+func lineProjectElement(content string, name string) string {
+	open, close := "<"+name+">", "</"+name+">"
+	insideParent := false
+	insideNested := 0
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if strings.Contains(trimmed, "<parent>") {
+			insideParent = true
+		} else if strings.Contains(trimmed, "</parent>") {
+			insideParent = false
+		}
+
+		// Track blocks that can contain their own groupId/artifactId/version
+		for _, tag := range []string{"<dependencies>", "<dependencyManagement>", "<build>", "<profiles>", "<reporting>"} {
+			if strings.Contains(trimmed, tag) {
+				insideNested++
+			}
+		}
+		for _, tag := range []string{"</dependencies>", "</dependencyManagement>", "</build>", "</profiles>", "</reporting>"} {
+			if strings.Contains(trimmed, tag) {
+				insideNested--
+			}
+		}
+
+		if !insideParent && insideNested == 0 {
+			s := strings.Index(trimmed, open)
+			e := strings.Index(trimmed, close)
+			if s >= 0 && e > s+len(open) {
+				return trimmed[s+len(open) : e]
+			}
+		}
+	}
+	return ""
+}
+
+// lineElement returns the value of an element that opens and closes on the line
+func lineElement(line, name string) (string, bool) {
+	open, close := "<"+name+">", "</"+name+">"
+	s := strings.Index(line, open)
+	e := strings.Index(line, close)
+	if s < 0 || e < s+len(open) {
+		return "", false
+	}
+	return line[s+len(open) : e], true
+}
+
+// rewritePomText is rewritePom of version_update: text, which matches the elements
+// line by line instead of parsing the file. Lines are replaced in place, never added
+// or removed.
+func rewritePomText(filename string, content string, version version.Version, isRootPom bool, propertyPatterns []string, excludeArtifacts []ArtifactExclusion, skipProperties []string) string {
+	newVersion := version.String()
+
+	// Check if this POM's own artifact matches an exclusion — skip all updates
+	projectGroupID, projectArtifactID := lineProjectIdentity(content)
+	if isArtifactExcluded(projectGroupID, projectArtifactID, excludeArtifacts) {
+		logger.Infof("    Skipping all version updates for excluded artifact %s:%s in %s", projectGroupID, projectArtifactID, filename)
+		return content
+	}
+
+	// Parse line by line
+	lines := strings.Split(content, "\n")
+
+	// Flags for tracking context
+	insideProject := false
+	insideParent := false
+	insideProperties := false
+
+	// Counters for tracking what we've updated
+	rootVersionUpdated := false
+	parentVersionUpdated := false
+
+	// Counter for tags after project
+	tagsAfterProject := 0
+
+	// Track parent groupId and artifactId for exclusion check
+	parentGroupID := ""
+	parentArtifactID := ""
+
+	// Track the elements of the current <dependency> for update_dependencies
+	insideDependency := false
+	depGroupID, depArtifactID, depVersionLine := "", "", -1
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		// Track entering/exiting project
+		if strings.Contains(line, "<project") {
+			insideProject = true
+			tagsAfterProject = 0
+		}
+
+		// Track entering/exiting parent
+		if strings.Contains(line, "<parent>") {
+			insideParent = true
+			parentGroupID = ""
+			parentArtifactID = ""
+		} else if strings.Contains(line, "</parent>") {
+			insideParent = false
+		}
+
+		// Track parent groupId and artifactId
+		if insideParent {
+			if strings.Contains(trimmed, "<groupId>") && strings.Contains(trimmed, "</groupId>") {
+				s := strings.Index(trimmed, "<groupId>") + 9
+				e := strings.Index(trimmed, "</groupId>")
+				if s > 8 && e > s {
+					parentGroupID = trimmed[s:e]
+				}
+			}
+			if strings.Contains(trimmed, "<artifactId>") && strings.Contains(trimmed, "</artifactId>") {
+				s := strings.Index(trimmed, "<artifactId>") + 12
+				e := strings.Index(trimmed, "</artifactId>")
+				if s > 11 && e > s {
+					parentArtifactID = trimmed[s:e]
+				}
+			}
+		}
+
+		// Track dependencies; the version is set once the whole element was read
+		if strings.Contains(trimmed, "<dependency>") {
+			insideDependency = true
+			depGroupID, depArtifactID, depVersionLine = "", "", -1
+		}
+		if insideDependency {
+			if value, ok := lineElement(trimmed, "groupId"); ok {
+				depGroupID = value
+			}
+			if value, ok := lineElement(trimmed, "artifactId"); ok {
+				depArtifactID = value
+			}
+			if value, ok := lineElement(trimmed, "version"); ok && isLiteralVersion(value) {
+				depVersionLine = i
+			}
+		}
+		if insideDependency && strings.Contains(trimmed, "</dependency>") {
+			insideDependency = false
+			v, ok := dependencyVersion(depGroupID, depArtifactID, newVersion, excludeArtifacts)
+			if depVersionLine >= 0 && ok {
+				old, _ := lineElement(strings.TrimSpace(lines[depVersionLine]), "version")
+				lines[depVersionLine] = strings.Replace(lines[depVersionLine],
+					"<version>"+old+"</version>", "<version>"+v+"</version>", 1)
+			}
+		}
+
+		// Track entering/exiting properties
+		if strings.Contains(line, "<properties>") {
+			insideProperties = true
+		} else if strings.Contains(line, "</properties>") {
+			insideProperties = false
+		}
+
+		// Count tags after project (to determine if version is direct child)
+		if insideProject && !insideParent && !insideProperties {
+			if strings.Contains(trimmed, "<") && !strings.Contains(trimmed, "</") &&
+				!strings.Contains(trimmed, "<version>") {
+				tagsAfterProject++
+			}
+		}
+
+		// Update version tags
+		if strings.Contains(trimmed, "<version>") && strings.Contains(trimmed, "</version>") {
+
+			// Extract current version
+			start := strings.Index(trimmed, "<version>") + 9
+			end := strings.Index(trimmed, "</version>")
+
+			if start > 8 && end > start {
+				currentVersion := trimmed[start:end]
+
+				// Skip replacement if version contains "revision" (e.g., ${revision})
+				if strings.Contains(currentVersion, "revision") {
+					continue
+				}
+
+				// CASE 1: Root POM - update version that's direct child of project
+				if isRootPom && insideProject && !insideParent && !insideProperties &&
+					!rootVersionUpdated && tagsAfterProject <= 4 {
+					// Replace version
+					newLine := strings.Replace(line, "<version>"+currentVersion+"</version>",
+						"<version>"+newVersion+"</version>", 1)
+					lines[i] = newLine
+					rootVersionUpdated = true
+				}
+
+				// CASE 2a: Update version inside parent (only for submodule POMs)
+				// Skip if parent matches an exclusion rule
+				if insideParent && !parentVersionUpdated && !isRootPom {
+					if !isArtifactExcluded(parentGroupID, parentArtifactID, excludeArtifacts) {
+						newLine := strings.Replace(line, "<version>"+currentVersion+"</version>",
+							"<version>"+newVersion+"</version>", 1)
+						lines[i] = newLine
+						parentVersionUpdated = true
+					} else {
+						logger.Infof("    Skipping parent version update for %s:%s in %s", parentGroupID, parentArtifactID, filename)
+						parentVersionUpdated = true
+					}
+				}
+
+				// CASE 2b: Submodule POM - update project version
+				if !isRootPom && insideProject && !insideParent && !insideProperties &&
+					!rootVersionUpdated && tagsAfterProject <= 4 {
+					newLine := strings.Replace(line, "<version>"+currentVersion+"</version>",
+						"<version>"+newVersion+"</version>", 1)
+					lines[i] = newLine
+					rootVersionUpdated = true
+				}
+			}
+		}
+
+		// CASE 3: Update properties matching the pattern
+		if insideProperties && strings.Contains(trimmed, "<") && strings.Contains(trimmed, ">") {
+			// Find property tag with pattern in name
+			startTag := strings.Index(trimmed, "<")
+			endTag := strings.Index(trimmed, ">")
+
+			if startTag >= 0 && endTag > startTag {
+				tagContent := trimmed[startTag+1 : endTag]
+
+				// Check if this is a property matching pattern (not a closing tag)
+				if matchesProperty(tagContent, propertyPatterns) && !strings.HasPrefix(tagContent, "/") {
+					// Check if this property is in the skip list
+					if isPropertySkipped(tagContent, skipProperties) {
+						logger.Infof("    Skipping property <%s> in %s", tagContent, filename)
+					} else {
+						// Find the value
+						valueStart := endTag + 1
+						valueEnd := strings.Index(trimmed[valueStart:], "<")
+
+						if valueEnd > 0 {
+							// Replace the value
+							oldValue := trimmed[valueStart : valueStart+valueEnd]
+							newLine := strings.Replace(line, ">"+oldValue+"<", ">"+newVersion+"<", 1)
+							lines[i] = newLine
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Join lines back
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,40 @@
+package maven
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"deploy/plan"
+)
+
+// cyclonedxPlugin is the CycloneDX Maven plugin GenerateSBOM runs, pinned so that the
+// SBOMs of a release do not depend on the latest plugin release
+const cyclonedxPlugin = "org.cyclonedx:cyclonedx-maven-plugin:2.9.1"
+
+// GenerateSBOM writes the CycloneDX SBOM of the built service in serviceDir to file:
+// makeAggregateBom of the CycloneDX plugin covers all the modules of the project in
+// target/bom.json, which is copied to file
+func GenerateSBOM(serviceDir string, inv Invocation, file string) error {
+	args := append(inv.flags(), "-B", "-q", cyclonedxPlugin+":makeAggregateBom", "-DoutputFormat=json", "-DoutputName=bom")
+	program, err := inv.program(serviceDir)
+	if err != nil {
+		return err
+	}
+	bom := filepath.Join(serviceDir, "target", "bom.json")
+	if plan.Enabled() {
+		plan.Record("%s %s (in %s)", program, strings.Join(args, " "), serviceDir)
+		plan.Record("copy %s to %s", bom, file)
+		return nil
+	}
+	cmd := inv.newCommand(program, serviceDir, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s makeAggregateBom failed: %v\n%s", filepath.Base(program), err, strings.TrimSpace(string(output)))
+	}
+	data, err := ioutil.ReadFile(bom)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
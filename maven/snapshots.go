@@ -0,0 +1,63 @@
+package maven
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// SnapshotReferences returns the -SNAPSHOT versions left in the pom.xml files of the
+// directory tree, as file:line: element: the project and parent versions, the literal
+// versions of dependencies and the property values. A dependency whose version is a
+// property, like ${project.version} of the modules of the project, is reported through
+// the property if at all.
+func SnapshotReferences(dir string) ([]string, error) {
+	pomFiles, err := findPomFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []string
+	for _, pomFile := range pomFiles {
+		data, err := ioutil.ReadFile(pomFile)
+		if err != nil {
+			return nil, err
+		}
+		content := string(data)
+		doc, err := parsePom(content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pomFile, err)
+		}
+
+		name := pomFile
+		if rel, err := filepath.Rel(dir, pomFile); err == nil {
+			name = rel
+		}
+		add := func(value *pomValue, format string, args ...interface{}) {
+			if value == nil || !isSnapshot(value.Text) {
+				return
+			}
+			line := strings.Count(content[:value.Start], "\n") + 1
+			refs = append(refs, fmt.Sprintf("%s:%d: %s", name, line, fmt.Sprintf(format, args...)))
+		}
+
+		add(doc.Version, "version %s", doc.Version.text())
+		add(doc.ParentVersion, "parent %s:%s:%s", doc.ParentGroupID.text(), doc.ParentArtifactID.text(), doc.ParentVersion.text())
+		for _, prop := range doc.Properties {
+			prop := prop
+			add(&prop.pomValue, "property %s=%s", prop.Name, prop.Text)
+		}
+		for _, dep := range doc.Dependencies {
+			if isLiteralVersion(dep.Version.text()) {
+				add(dep.Version, "dependency %s:%s:%s", dep.GroupID.text(), dep.ArtifactID.text(), dep.Version.text())
+			}
+		}
+	}
+	return refs, nil
+}
+
+// isSnapshot reports whether a version is a -SNAPSHOT version
+func isSnapshot(v string) bool {
+	return strings.HasSuffix(strings.ToUpper(v), "-SNAPSHOT")
+}
@@ -0,0 +1,132 @@
+package maven
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"deploy/logger"
+	"deploy/plan"
+	"deploy/version"
+)
+
+// Strategies of version_update: how the update-poms phase changes the versions
+const (
+	UpdateXML            = "xml"             // parse pom.xml and change the element values (default)
+	UpdateText           = "text"            // match the elements line by line
+	UpdateVersionsPlugin = "versions-plugin" // run versions:set and versions:set-property
+)
+
+// versionUpdate is the strategy of UpdatePomFiles
+var versionUpdate = UpdateXML
+
+// SetVersionUpdate sets the strategy of UpdatePomFiles. An empty strategy restores the default.
+func SetVersionUpdate(strategy string) error {
+	switch strategy {
+	case "":
+		versionUpdate = UpdateXML
+	case UpdateXML, UpdateText, UpdateVersionsPlugin:
+		versionUpdate = strategy
+	default:
+		return fmt.Errorf("unknown version_update %q, expected %s, %s or %s", strategy, UpdateXML, UpdateText, UpdateVersionsPlugin)
+	}
+	return nil
+}
+
+// UsesVersionsPlugin reports whether the versions are updated by the Maven versions plugin
+func UsesVersionsPlugin() bool {
+	return versionUpdate == UpdateVersionsPlugin
+}
+
+// updateWithVersionsPlugin sets the version of the project in dir and its modules with
+// versions:set, then the properties matching a pattern with versions:set-property and
+// the dependencies of update_dependencies and on the artifacts of the released services
+// with versions:use-dep-version.
+// Maven resolves the module tree itself; skip_version_update does not apply.
+func updateWithVersionsPlugin(dir string, pomFiles []string, version version.Version, propertyPatterns []string, skipProperties []string, inv Invocation) error {
+	newVersion := "-DnewVersion=" + version.String()
+	if err := runVersionsGoal(dir, inv, "versions:set", newVersion, "-DprocessAllModules=true"); err != nil {
+		return err
+	}
+
+	properties, err := matchingProperties(pomFiles, propertyPatterns, skipProperties)
+	if err != nil {
+		return err
+	}
+	for _, property := range properties {
+		if err := runVersionsGoal(dir, inv, "versions:set-property", "-Dproperty="+property, newVersion); err != nil {
+			return err
+		}
+	}
+	if len(dependencyPatterns) > 0 {
+		// includes takes the groupId:artifactId patterns with wildcards as they are
+		if err := runVersionsGoal(dir, inv, "versions:use-dep-version", "-Dincludes="+strings.Join(dependencyPatterns, ","),
+			"-DdepVersion="+version.String(), "-DforceVersion=true", "-DprocessDependencyManagement=true"); err != nil {
+			return err
+		}
+	}
+	artifacts := make([]string, 0, len(artifactVersions))
+	for artifact := range artifactVersions {
+		artifacts = append(artifacts, artifact)
+	}
+	sort.Strings(artifacts)
+	for _, artifact := range artifacts {
+		if err := runVersionsGoal(dir, inv, "versions:use-dep-version", "-Dincludes="+artifact,
+			"-DdepVersion="+artifactVersions[artifact], "-DforceVersion=true", "-DprocessDependencyManagement=true"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchingProperties returns the names of the properties of the pom.xml files that
+// contain one of the patterns and are not skipped, sorted
+func matchingProperties(pomFiles []string, propertyPatterns []string, skipProperties []string) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, pomFile := range pomFiles {
+		data, err := ioutil.ReadFile(pomFile)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := parsePom(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pomFile, err)
+		}
+		for _, property := range doc.Properties {
+			if !matchesProperty(property.Name, propertyPatterns) || seen[property.Name] {
+				continue
+			}
+			if isPropertySkipped(property.Name, skipProperties) {
+				logger.Infof("    Skipping property <%s> in %s", property.Name, pomFile)
+				continue
+			}
+			seen[property.Name] = true
+		}
+	}
+	var names []string
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// runVersionsGoal runs a goal of the versions plugin in dir without backup poms
+func runVersionsGoal(dir string, inv Invocation, goal string, args ...string) error {
+	args = append(append(inv.flags(), "-B", "-q", goal, "-DgenerateBackupPoms=false"), args...)
+	program, err := inv.program(dir)
+	if err != nil {
+		return err
+	}
+	if plan.Enabled() {
+		plan.Record("%s %s (in %s)", program, strings.Join(args, " "), dir)
+		return nil
+	}
+	cmd := inv.newCommand(program, dir, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s failed: %v\n%s", filepath.Base(program), goal, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"deploy/gitlab"
+)
+
+// Phase 12: Open a GitLab merge request of every release branch into the production
+// branch, with the release notes of the service as the description and the configured
+// reviewers, so the production merge is one click once the pipelines succeeded.
+// The phase runs only when release_merge_request is configured.
+func (d *deployment) openMergeRequests() {
+	target := d.cfg.MergeRequest.TargetBranch()
+	d.forEachRepository("merge-request", func(service string) {
+		if d.skipDone("merge-request", service) {
+			return
+		}
+		source := d.releaseBranchFor(service)
+		description, err := d.markdownNotes(service)
+		if err != nil {
+			d.failService(service, exitFailure, "Failed to collect the release notes of %s: %v", service, err)
+			return
+		}
+
+		title := fmt.Sprintf("Release %s", d.tagFor(service))
+		mr, err := gitlab.OpenMergeRequest(d.gitlabProject(service), source, target, title, description, d.cfg.MergeRequest.Reviewers)
+		if err != nil {
+			d.failService(service, exitFailure, "Failed to open a merge request of %s into %s in %s: %v", source, target, service, err)
+			return
+		}
+		if mr.WebURL != "" {
+			d.logFor(service).Noticef("  Merge request of %s into %s for %s: %s", source, target, service, mr.WebURL)
+		}
+		d.markDone("merge-request", service)
+	})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"deploy/config"
+	"deploy/gitlab"
+	"deploy/jira"
+	"deploy/logger"
+	"deploy/notes"
+	"deploy/version"
+)
+
+// runNotes implements "deploy notes": collects the commits and task IDs of every service
+// since its previous release and writes them to a release notes file
+func runNotes(args []string) {
+	fs := flag.NewFlagSet("notes", flag.ExitOnError)
+	var logOpts logFlags
+	logOpts.register(fs)
+	var (
+		configFile  string
+		directory   string
+		versionStr  string
+		servicesStr string
+		envName     string
+		fromRef     string
+		output      string
+		baseBranch  string
+		format      string
+		noMerges    bool
+	)
+	fs.StringVar(&configFile, "config", "", "Path to YAML configuration file (default: $DEPLOY_CONFIG or deploy.yaml)")
+	fs.StringVar(&configFile, "c", "", "Path to YAML configuration file (shorthand)")
+	fs.StringVar(&directory, "directory", "", "Base directory for services (required)")
+	fs.StringVar(&directory, "d", "", "Base directory for services (shorthand)")
+	fs.StringVar(&versionStr, "version", "", "Version the notes are generated for (required)")
+	fs.StringVar(&versionStr, "v", "", "Version the notes are generated for (shorthand)")
+	fs.StringVar(&servicesStr, "services", "", "Only these services, comma-separated names or globs")
+	fs.StringVar(&envName, "env", "", "Environment profile from the config, e.g. staging or production")
+	fs.StringVar(&fromRef, "from", "", "Compare against this ref instead of each service's previous release tag")
+	fs.StringVar(&baseBranch, "base-branch", "master", "Branch the release is built from until it is tagged (per-service base_branch overrides it)")
+	fs.BoolVar(&noMerges, "no-merges", false, "Leave merge commits out of the commits, tasks and authors (notes_no_merges in the config)")
+	fs.StringVar(&format, "notes-format", notes.FormatText, "Release notes format: text, markdown, html or json")
+	fs.StringVar(&output, "output", "", "Output file (default: release-notes-<version>.txt, .md, .html or .json)")
+	fs.StringVar(&output, "o", "", "Output file (shorthand)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s notes [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Lists commits and task IDs of every service since its previous release tag.\n\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExample:\n")
+		fmt.Fprintf(os.Stderr, "  %s notes -c deploy.yaml -d /path/to/services -v 123\n", os.Args[0])
+	}
+	fs.Parse(args)
+	logOpts.apply()
+
+	if directory == "" {
+		logger.Exitf(exitConfig, "Error: -directory parameter is required\n\nUse -h for help")
+	}
+	if versionStr == "" {
+		logger.Exitf(exitConfig, "Error: -version parameter is required\n\nUse -h for help")
+	}
+	if err := notes.CheckFormat(format); err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+	ver, err := version.Parse(versionStr)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+
+	cfg, _ := loadConfig(configFile, directory, envName, servicesStr)
+	if noMerges {
+		notes.ExcludeMerges(true)
+	}
+
+	serviceVersions, err := resolveServiceVersions(cfg, ver)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+
+	var services []notes.Service
+	for _, wc := range workingCopies(cfg, directory) {
+		svc := notes.Service{Name: wc.Name, Dir: wc.Dir, Project: wc.GitlabProject, Branch: wc.BaseBranchOr(baseBranch)}
+		if v, ok := serviceVersions[wc.Name]; ok {
+			svc.Version = &v
+		}
+		services = append(services, svc)
+	}
+
+	release, err := notes.Collect(services, ver, fromRef)
+	if err != nil {
+		logger.Fatalf("Failed to collect release notes: %v", err)
+	}
+
+	readIssues(cfg, release)
+
+	if output == "" {
+		output = notes.FileName(ver.String(), format)
+	}
+	text, err := renderReleaseNotes(cfg, release, format)
+	if err != nil {
+		logger.Fatalf("Failed to render release notes: %v", err)
+	}
+	if err := ioutil.WriteFile(output, []byte(text), 0644); err != nil {
+		logger.Fatalf("Failed to write release notes: %v", err)
+	}
+
+	logger.Noticef("Release notes for %s: %d task(s) across %d service(s) written to %s", ver, len(release.Tasks), len(release.Services), output)
+}
+
+// releaseNotesLinks returns how the release notes link tasks and merge requests: tasks
+// with task_url, merge requests when $GITLAB_URI is known
+func releaseNotesLinks(cfg *config.Config) notes.Links {
+	links := notes.Links{TaskURL: cfg.TaskURL}
+	if os.Getenv("GITLAB_URI") != "" {
+		links.MergeRequestURL = gitlab.MergeRequestURL
+	}
+	return links
+}
+
+// renderReleaseNotes returns the release notes in the format; the text notes use the
+// notes_template if one is configured
+func renderReleaseNotes(cfg *config.Config, release *notes.Release, format string) (string, error) {
+	if format == notes.FormatText && cfg.NotesTemplate != "" {
+		tmpl, err := notes.ParseTemplate(cfg.NotesTemplate)
+		if err != nil {
+			return "", err
+		}
+		return notes.RenderTemplate(tmpl, release, releaseNotesLinks(cfg))
+	}
+	return notes.RenderFormat(release, format, releaseNotesLinks(cfg))
+}
+
+// readIssues adds the Jira summaries and statuses of the tasks to the release notes and
+// warns about the tasks that are not done. Jira errors are only reported: the notes are
+// still written with the bare task IDs.
+func readIssues(cfg *config.Config, release *notes.Release) {
+	if cfg.Jira == nil || len(release.Tasks) == 0 {
+		return
+	}
+	client, err := jira.NewClient(cfg.Jira)
+	if err != nil {
+		logger.Warnf("Warning: %v", err)
+		return
+	}
+
+	tasks := release.Tasks
+	if len(cfg.Jira.Trackers) > 0 {
+		tasks = nil
+		for _, task := range release.Tasks {
+			for _, name := range cfg.Jira.Trackers {
+				if notes.TrackerOf(task) == name {
+					tasks = append(tasks, task)
+				}
+			}
+		}
+	}
+
+	issues, errs := client.Issues(tasks)
+	release.Issues = issues
+	var notDone []string
+	for _, task := range tasks {
+		if err, ok := errs[task]; ok {
+			logger.Warnf("Warning: failed to read %s from Jira: %v", task, err)
+			continue
+		}
+		if issue := issues[task]; !issue.Done {
+			notDone = append(notDone, fmt.Sprintf("  %s [%s] %s", task, issue.Status, issue.Summary))
+		}
+	}
+	if len(notDone) > 0 {
+		logger.Warnf("Warning: %d task(s) of the release are not done:\n%s", len(notDone), strings.Join(notDone, "\n"))
+	}
+}
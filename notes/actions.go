@@ -0,0 +1,31 @@
+package notes
+
+import (
+	"regexp"
+	"strings"
+
+	"deploy/git"
+)
+
+// actionPattern matches the lines of a commit message body that ask for an action
+// before the release is deployed, e.g. "MIGRATION: run the v42 migration"
+var actionPattern = regexp.MustCompile(`(?m)^\s*(BREAKING|MIGRATION|DB-CHANGE):\s*(.+)$`)
+
+// Action is a change ops must know about before deploying: a breaking change, a
+// migration or a database schema change
+type Action struct {
+	Commit git.CommitInfo
+	Kind   string // BREAKING, MIGRATION or DB-CHANGE
+	Note   string
+}
+
+// ExtractActions returns the action markers of the commit bodies, oldest commit first
+func ExtractActions(commits []git.CommitInfo) []Action {
+	var actions []Action
+	for i := len(commits) - 1; i >= 0; i-- {
+		for _, match := range actionPattern.FindAllStringSubmatch(commits[i].Body, -1) {
+			actions = append(actions, Action{Commit: commits[i], Kind: match[1], Note: strings.TrimSpace(match[2])})
+		}
+	}
+	return actions
+}
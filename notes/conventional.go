@@ -0,0 +1,88 @@
+package notes
+
+import (
+	"regexp"
+	"strings"
+
+	"deploy/git"
+)
+
+// Sections of the release notes of a service whose commits follow the conventional
+// commits format (feat: ..., fix(parser): ...)
+const (
+	SectionFeatures = "Features"
+	SectionFixes    = "Fixes"
+	SectionOther    = "Other"
+)
+
+// conventionalPattern matches a conventional commit subject: type, optional scope,
+// optional ! marking a breaking change, and the description
+var conventionalPattern = regexp.MustCompile(`^([A-Za-z]+)(?:\(([^)]*)\))?(!)?:\s*(.+)$`)
+
+// breakingPattern matches the BREAKING CHANGE footer of a commit message
+var breakingPattern = regexp.MustCompile(`(?m)^BREAKING[ -]CHANGE:\s*(.*)$`)
+
+// Change is a commit of the release notes
+type Change struct {
+	Commit       git.CommitInfo
+	Type         string // conventional commit type, e.g. feat; empty for other subjects
+	Scope        string
+	Description  string // subject without the type and scope
+	Breaking     bool
+	BreakingNote string // text of the BREAKING CHANGE footer, if any
+}
+
+// ParseChange parses the subject and footers of a commit
+func ParseChange(commit git.CommitInfo) Change {
+	change := Change{Commit: commit, Description: commit.Subject}
+	if match := conventionalPattern.FindStringSubmatch(commit.Subject); match != nil {
+		change.Type = strings.ToLower(match[1])
+		change.Scope = match[2]
+		change.Breaking = match[3] == "!"
+		change.Description = match[4]
+	}
+	if match := breakingPattern.FindStringSubmatch(commit.Body); match != nil {
+		change.Breaking = true
+		change.BreakingNote = strings.TrimSpace(match[1])
+	}
+	return change
+}
+
+// Section returns the release notes section of the change
+func (c Change) Section() string {
+	switch c.Type {
+	case "feat", "feature":
+		return SectionFeatures
+	case "fix":
+		return SectionFixes
+	}
+	return SectionOther
+}
+
+// Categorize groups the commits into the Features, Fixes and Other sections. It returns
+// nil if none of the commits follows the conventional commits format.
+func Categorize(commits []git.CommitInfo) map[string][]Change {
+	sections := make(map[string][]Change)
+	conventional := false
+	for _, commit := range commits {
+		change := ParseChange(commit)
+		conventional = conventional || change.Type != ""
+		sections[change.Section()] = append(sections[change.Section()], change)
+	}
+	if !conventional {
+		return nil
+	}
+	return sections
+}
+
+// BreakingChanges returns the commits marked as breaking changes, with ! or a
+// BREAKING CHANGE footer
+func BreakingChanges(commits []git.CommitInfo) []Change {
+	var breaking []Change
+	for _, commit := range commits {
+		if change := ParseChange(commit); change.Breaking {
+			breaking = append(breaking, change)
+		}
+	}
+	return breaking
+}
@@ -0,0 +1,88 @@
+package notes
+
+import (
+	"regexp"
+	"strings"
+
+	"deploy/git"
+)
+
+// revertPattern matches the subject git revert gives the reverting commit
+var revertPattern = regexp.MustCompile(`^Revert "(.*)"$`)
+
+// revertedHashPattern matches the line git revert adds to the message of the reverting commit
+var revertedHashPattern = regexp.MustCompile(`This reverts commit ([0-9a-f]{7,40})`)
+
+// excludeMerges leaves merge commits out of the release notes
+var excludeMerges bool
+
+// ExcludeMerges sets whether merge commits are left out of the commits, task IDs and
+// authors of the release notes. The merge requests they reference are still listed.
+func ExcludeMerges(exclude bool) {
+	excludeMerges = exclude
+}
+
+// dropReverted returns the commits without the ones reverted within the list, which
+// is newest first, and without their reverts: a change merged and reverted between two
+// releases is not part of the release. It also returns the number of reverted commits left out.
+func dropReverted(commits []git.CommitInfo) ([]git.CommitInfo, int) {
+	dropped := make(map[string]bool)
+	live := make(map[string]bool)        // hashes of commits not reverted so far
+	bySubject := make(map[string]string) // subject -> hash of the latest live commit
+
+	for i := len(commits) - 1; i >= 0; i-- {
+		commit := commits[i]
+		if target := revertedCommit(commit, live, bySubject); target != "" {
+			dropped[target] = true
+			dropped[commit.Hash] = true
+			delete(live, target)
+			for subject, hash := range bySubject {
+				if hash == target {
+					delete(bySubject, subject)
+				}
+			}
+			continue
+		}
+		live[commit.Hash] = true
+		bySubject[commit.Subject] = commit.Hash
+	}
+
+	if len(dropped) == 0 {
+		return commits, 0
+	}
+	var kept []git.CommitInfo
+	for _, commit := range commits {
+		if !dropped[commit.Hash] {
+			kept = append(kept, commit)
+		}
+	}
+	return kept, len(dropped) / 2
+}
+
+// revertedCommit returns the hash of the live commit the commit reverts, found by the
+// "This reverts commit" line or else by the subject, or an empty string
+func revertedCommit(commit git.CommitInfo, live map[string]bool, bySubject map[string]string) string {
+	match := revertPattern.FindStringSubmatch(commit.Subject)
+	if match == nil {
+		return ""
+	}
+	if ref := revertedHashPattern.FindStringSubmatch(commit.Body); ref != nil {
+		for hash := range live {
+			if strings.HasPrefix(hash, ref[1]) {
+				return hash
+			}
+		}
+	}
+	return bySubject[match[1]]
+}
+
+// withoutMerges returns the commits without merge commits
+func withoutMerges(commits []git.CommitInfo) []git.CommitInfo {
+	var kept []git.CommitInfo
+	for _, commit := range commits {
+		if !commit.Merge {
+			kept = append(kept, commit)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,164 @@
+package notes
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+)
+
+// htmlTemplate is a self-contained page: the styles are in the document, so the notes
+// look the same attached to an email and published on a portal
+var htmlTemplate = template.Must(template.New("notes").Funcs(template.FuncMap{
+	"text":         func(string) template.HTML { return "" },
+	"task":         func(string) template.HTML { return "" },
+	"mergeRequest": func(string, int) template.HTML { return "" },
+	"short":        shortHash,
+	"sections":     func() []string { return []string{SectionFeatures, SectionFixes, SectionOther} },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Release {{.Version}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; font-size: 14px; color: #24292e; max-width: 960px; margin: 0 auto; padding: 16px; }
+h1 { font-size: 24px; margin-bottom: 4px; }
+h2 { font-size: 18px; border-bottom: 1px solid #e1e4e8; padding-bottom: 4px; margin-top: 24px; }
+h3 { font-size: 16px; margin-top: 20px; }
+h4 { font-size: 14px; margin: 12px 0 4px; }
+a { color: #0366d6; text-decoration: none; }
+code { font-family: Consolas, Menlo, monospace; font-size: 12px; background: #f6f8fa; padding: 1px 4px; border-radius: 3px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #dfe2e5; padding: 4px 10px; text-align: left; }
+th { background: #f6f8fa; }
+td.number { text-align: right; }
+.date, .author { color: #6a737d; }
+.breaking { border-left: 4px solid #d73a49; background: #ffeef0; padding: 8px 16px; }
+.breaking h2 { color: #cb2431; border: none; margin-top: 0; }
+.scope { font-weight: 600; }
+.status { color: #6a737d; }
+.not-done { color: #cb2431; font-weight: 600; }
+</style>
+</head>
+<body>
+<h1>Release {{.Version}}</h1>
+<div class="date">{{.Date.Format "2006-01-02"}}</div>
+{{with .Breaking}}
+<div class="breaking">
+<h2>Breaking changes ({{len .}})</h2>
+<ul>
+{{range .}}<li><b>{{.Service}}</b>: {{text .Description}} <code>{{short .Commit.Hash}}</code>{{with .BreakingNote}}<br>{{text .}}{{end}}</li>
+{{end}}</ul>
+</div>
+{{end}}
+{{with .Actions}}
+<div class="breaking">
+<h2>Action required ({{len .}})</h2>
+<table>
+<tr><th>Service</th><th>Kind</th><th>Note</th><th>Commit</th></tr>
+{{range .}}<tr><td>{{.Service}}</td><td>{{.Kind}}</td><td>{{text .Note}}</td><td><code>{{short .Commit.Hash}}</code></td></tr>
+{{end}}</table>
+</div>
+{{end}}
+<h2>Tasks ({{len .Tasks}})</h2>
+{{with .Tasks}}<ul>
+{{range .}}<li>{{task .}}{{with $.Issue .}} {{.Summary}} <span class="status">({{.Type}}, {{.Status}})</span>{{if not .Done}} <span class="not-done">not done</span>{{end}}{{end}}</li>
+{{end}}</ul>
+{{end}}
+<h2>Services</h2>
+<table>
+<tr><th>Service</th><th>Since</th><th>Commits</th><th>Tasks</th><th>Merge requests</th></tr>
+{{range .Services}}{{$svc := .}}<tr><td>{{.Name}}</td><td>{{with .From}}<code>{{.}}</code>{{else}}beginning of history{{end}}</td><td class="number">{{len .Commits}}</td><td class="number">{{len .Tasks}}</td><td>{{range $i, $iid := .MergeRequests}}{{if $i}}, {{end}}{{mergeRequest $svc.Project $iid}}{{end}}</td></tr>
+{{end}}</table>
+{{range .Services}}{{if .Commits}}{{$svc := .}}
+<h3>{{.Name}}</h3>
+{{with .Tasks}}<p><b>Tasks:</b> {{range $i, $task := .}}{{if $i}}, {{end}}{{task $task}}{{end}}</p>
+{{end}}{{with .Authors}}<p><b>Authors:</b> {{range $i, $author := .}}{{if $i}}, {{end}}{{$author}}{{end}}</p>
+{{end}}{{range $section := sections}}{{with index $svc.Sections $section}}<h4>{{$section}}</h4>
+<ul>
+{{range .}}<li>{{with .Scope}}<span class="scope">{{.}}:</span> {{end}}{{text .Description}}</li>
+{{end}}</ul>
+{{end}}{{end}}<details>
+<summary>{{len .Commits}} commit(s)</summary>
+<ul>
+{{range .Commits}}<li><code>{{short .Hash}}</code> {{text .Subject}}{{with .Author}} <span class="author">— {{.}}</span>{{end}}</li>
+{{end}}</ul>
+</details>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// htmlData is the release as the HTML template sees it
+type htmlData struct {
+	*Release
+	Breaking []htmlBreakingChange
+	Actions  []htmlAction
+}
+
+// htmlAction is an action required by a commit of a service
+type htmlAction struct {
+	Service string
+	Action
+}
+
+// htmlBreakingChange is a breaking change with the service it was made in
+type htmlBreakingChange struct {
+	Service string
+	Change
+}
+
+// RenderHTML returns the release notes as an HTML page with clickable task and merge
+// request links, for the notification email or an internal portal
+func RenderHTML(release *Release, links Links) (string, error) {
+	data := htmlData{Release: release}
+	for _, svc := range release.Services {
+		for _, change := range svc.Breaking {
+			data.Breaking = append(data.Breaking, htmlBreakingChange{Service: svc.Name, Change: change})
+		}
+		for _, action := range svc.Actions {
+			data.Actions = append(data.Actions, htmlAction{Service: svc.Name, Action: action})
+		}
+	}
+
+	tmpl, err := htmlTemplate.Clone()
+	if err != nil {
+		return "", err
+	}
+	tmpl.Funcs(template.FuncMap{
+		"text":         func(text string) template.HTML { return htmlText(text, links) },
+		"task":         func(id string) template.HTML { return htmlTask(id, links) },
+		"mergeRequest": func(project string, iid int) template.HTML { return htmlMergeRequest(project, iid, links) },
+	})
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// htmlText escapes the text and links the task IDs it mentions
+func htmlText(text string, links Links) template.HTML {
+	escaped := template.HTMLEscapeString(text)
+	return template.HTML(taskIDPattern.ReplaceAllStringFunc(escaped, func(id string) string {
+		return string(htmlTask(normalizeTask(id), links))
+	}))
+}
+
+// htmlTask returns the task ID, linked to the tracker if task_url is set
+func htmlTask(id string, links Links) template.HTML {
+	if url := links.task(id); url != "" {
+		return template.HTML(fmt.Sprintf(`<a href="%s">%s</a>`, template.HTMLEscapeString(url), template.HTMLEscapeString(id)))
+	}
+	return template.HTML(template.HTMLEscapeString(id))
+}
+
+// htmlMergeRequest returns a link to the merge request, or its GitLab reference
+func htmlMergeRequest(project string, iid int, links Links) template.HTML {
+	if url := links.mergeRequest(project, iid); url != "" {
+		return template.HTML(fmt.Sprintf(`<a href="%s">!%d</a>`, template.HTMLEscapeString(url), iid))
+	}
+	if project == "" {
+		return template.HTML(fmt.Sprintf("!%d", iid))
+	}
+	return template.HTML(template.HTMLEscapeString(fmt.Sprintf("%s!%d", project, iid)))
+}
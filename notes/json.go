@@ -0,0 +1,137 @@
+package notes
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// jsonRelease is the JSON schema of the release notes, kept separate from Release so
+// that the tools consuming it are not broken by changes of the renderers' data
+type jsonRelease struct {
+	Version  string               `json:"version"`
+	Date     string               `json:"date"`
+	Tasks    []string             `json:"tasks"`
+	Issues   map[string]jsonIssue `json:"issues,omitempty"` // by task ID, if read from Jira
+	Services []jsonService        `json:"services"`
+}
+
+// jsonIssue is the Jira summary and status of a task
+type jsonIssue struct {
+	Summary string `json:"summary"`
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Done    bool   `json:"done"`
+}
+
+// jsonService is a service of the JSON release notes
+type jsonService struct {
+	Name          string           `json:"name"`
+	Project       string           `json:"project,omitempty"`
+	From          string           `json:"from"` // previous release tag, empty if none was found
+	To            string           `json:"to"`
+	CommitCount   int              `json:"commit_count"`
+	TaskCount     int              `json:"task_count"`
+	Tasks         []string         `json:"tasks"`
+	Authors       []string         `json:"authors"`
+	MergeRequests []int            `json:"merge_requests"`
+	Breaking      []jsonBreaking   `json:"breaking_changes"`
+	Actions       []jsonAction     `json:"actions_required"`
+	Commits       []jsonCommitInfo `json:"commits"`
+}
+
+// jsonBreaking is a breaking change of a service
+type jsonBreaking struct {
+	Hash        string `json:"hash"`
+	Description string `json:"description"`
+	Note        string `json:"note,omitempty"`
+}
+
+// jsonAction is an action required by a commit of a service
+type jsonAction struct {
+	Hash string `json:"hash"`
+	Kind string `json:"kind"` // BREAKING, MIGRATION or DB-CHANGE
+	Note string `json:"note"`
+}
+
+// jsonCommitInfo is a commit of a service
+type jsonCommitInfo struct {
+	Hash          string    `json:"hash"`
+	Subject       string    `json:"subject"`
+	Author        string    `json:"author"`
+	Date          time.Time `json:"date"`
+	Type          string    `json:"type,omitempty"` // conventional commit type
+	Scope         string    `json:"scope,omitempty"`
+	Section       string    `json:"section"` // Features, Fixes or Other
+	MergeRequests []int     `json:"merge_requests,omitempty"`
+}
+
+// RenderJSON returns the release notes as JSON for tools such as change-management bots.
+// Lists are never null, so consumers do not have to tell an empty list from a missing one.
+func RenderJSON(release *Release) (string, error) {
+	out := jsonRelease{
+		Version:  release.Version,
+		Date:     release.Date.Format("2006-01-02"),
+		Tasks:    nonNil(release.Tasks),
+		Services: []jsonService{},
+	}
+	if len(release.Issues) > 0 {
+		out.Issues = make(map[string]jsonIssue)
+		for task, issue := range release.Issues {
+			out.Issues[task] = jsonIssue{Summary: issue.Summary, Type: issue.Type, Status: issue.Status, Done: issue.Done}
+		}
+	}
+	for _, svc := range release.Services {
+		s := jsonService{
+			Name:          svc.Name,
+			Project:       svc.Project,
+			From:          svc.From,
+			To:            svc.To,
+			CommitCount:   len(svc.Commits),
+			TaskCount:     len(svc.Tasks),
+			Tasks:         nonNil(svc.Tasks),
+			Authors:       nonNil(svc.Authors),
+			MergeRequests: append([]int{}, svc.MergeRequests...),
+			Breaking:      []jsonBreaking{},
+			Actions:       []jsonAction{},
+			Commits:       []jsonCommitInfo{},
+		}
+		for _, change := range svc.Breaking {
+			s.Breaking = append(s.Breaking, jsonBreaking{
+				Hash:        change.Commit.Hash,
+				Description: change.Description,
+				Note:        change.BreakingNote,
+			})
+		}
+		for _, action := range svc.Actions {
+			s.Actions = append(s.Actions, jsonAction{Hash: action.Commit.Hash, Kind: action.Kind, Note: action.Note})
+		}
+		for _, commit := range svc.Commits {
+			change := ParseChange(commit)
+			s.Commits = append(s.Commits, jsonCommitInfo{
+				Hash:          commit.Hash,
+				Subject:       commit.Subject,
+				Author:        commit.Author,
+				Date:          commit.Date,
+				Type:          change.Type,
+				Scope:         change.Scope,
+				Section:       change.Section(),
+				MergeRequests: commit.MergeRequests,
+			})
+		}
+		out.Services = append(out.Services, s)
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// nonNil returns the list, or an empty one instead of nil
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
@@ -0,0 +1,153 @@
+package notes
+
+import (
+	"fmt"
+	"strings"
+)
+
+// markdownEscaper escapes the characters Markdown would interpret in commit subjects
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", "&lt;", ">", "&gt;", "|", `\|`,
+)
+
+// RenderMarkdown returns the release notes as a Markdown document for GitLab releases
+// and wikis: tasks and merge requests are linked, the services are summarized in a table
+// and the commits of each service are in a collapsible list
+func RenderMarkdown(release *Release, links Links) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Release %s\n\n", release.Version)
+	fmt.Fprintf(&b, "_%s_\n\n", release.Date.Format("2006-01-02"))
+
+	var breaking []string
+	for _, svc := range release.Services {
+		for _, change := range svc.Breaking {
+			line := fmt.Sprintf("> - **%s**: %s (`%s`)", markdownEscaper.Replace(svc.Name),
+				markdownText(change.Description, links), shortHash(change.Commit.Hash))
+			if change.BreakingNote != "" {
+				line += " — " + markdownText(change.BreakingNote, links)
+			}
+			breaking = append(breaking, line)
+		}
+	}
+	if len(breaking) > 0 {
+		fmt.Fprintf(&b, "> **Breaking changes (%d)**\n>\n%s\n\n", len(breaking), strings.Join(breaking, "\n"))
+	}
+
+	var actions []string
+	for _, svc := range release.Services {
+		for _, action := range svc.Actions {
+			actions = append(actions, fmt.Sprintf("| %s | %s | %s | `%s` |", markdownEscaper.Replace(svc.Name),
+				action.Kind, markdownText(action.Note, links), shortHash(action.Commit.Hash)))
+		}
+	}
+	if len(actions) > 0 {
+		fmt.Fprintf(&b, "## Action required (%d)\n\n| Service | Kind | Note | Commit |\n|---|---|---|---|\n%s\n\n",
+			len(actions), strings.Join(actions, "\n"))
+	}
+
+	fmt.Fprintf(&b, "## Tasks (%d)\n\n", len(release.Tasks))
+	for _, task := range release.Tasks {
+		fmt.Fprintf(&b, "- %s", markdownTask(task, links))
+		if issue, ok := release.Issues[task]; ok {
+			fmt.Fprintf(&b, " %s _(%s, %s)_", markdownEscaper.Replace(issue.Summary),
+				markdownEscaper.Replace(issue.Type), markdownEscaper.Replace(issue.Status))
+			if !issue.Done {
+				b.WriteString(" **not done**")
+			}
+		}
+		b.WriteString("\n")
+	}
+	if len(release.Tasks) > 0 {
+		b.WriteString("\n")
+	}
+
+	b.WriteString("## Services\n\n")
+	b.WriteString("| Service | Since | Commits | Tasks | Merge requests |\n")
+	b.WriteString("|---|---|---:|---:|---|\n")
+	for _, svc := range release.Services {
+		from := "beginning of history"
+		if svc.From != "" {
+			from = "`" + svc.From + "`"
+		}
+		mergeRequests := make([]string, len(svc.MergeRequests))
+		for i, iid := range svc.MergeRequests {
+			mergeRequests[i] = markdownMergeRequest(svc.Project, iid, links)
+		}
+		fmt.Fprintf(&b, "| %s | %s | %d | %d | %s |\n", markdownEscaper.Replace(svc.Name), from,
+			len(svc.Commits), len(svc.Tasks), strings.Join(mergeRequests, ", "))
+	}
+
+	for _, svc := range release.Services {
+		if len(svc.Commits) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n", markdownEscaper.Replace(svc.Name))
+		if len(svc.Tasks) > 0 {
+			tasks := make([]string, len(svc.Tasks))
+			for i, task := range svc.Tasks {
+				tasks[i] = markdownTask(task, links)
+			}
+			fmt.Fprintf(&b, "**Tasks:** %s\n\n", strings.Join(tasks, ", "))
+		}
+		if len(svc.Authors) > 0 {
+			fmt.Fprintf(&b, "**Authors:** %s\n\n", markdownEscaper.Replace(strings.Join(svc.Authors, ", ")))
+		}
+		for _, section := range []string{SectionFeatures, SectionFixes, SectionOther} {
+			changes := svc.Sections[section]
+			if len(changes) == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "#### %s\n\n", section)
+			for _, change := range changes {
+				scope := ""
+				if change.Scope != "" {
+					scope = "**" + markdownEscaper.Replace(change.Scope) + ":** "
+				}
+				fmt.Fprintf(&b, "- %s%s\n", scope, markdownText(change.Description, links))
+			}
+			b.WriteString("\n")
+		}
+
+		// The blank lines around the list are required for GitLab to render it inside <details>
+		fmt.Fprintf(&b, "<details>\n<summary>%d commit(s)</summary>\n\n", len(svc.Commits))
+		for _, commit := range svc.Commits {
+			fmt.Fprintf(&b, "- `%s` %s", shortHash(commit.Hash), markdownText(commit.Subject, links))
+			if commit.Author != "" {
+				fmt.Fprintf(&b, " — %s", markdownEscaper.Replace(commit.Author))
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("\n</details>\n")
+	}
+
+	return b.String()
+}
+
+// markdownText escapes the text and links the task IDs it mentions
+func markdownText(text string, links Links) string {
+	return taskIDPattern.ReplaceAllStringFunc(markdownEscaper.Replace(text), func(id string) string {
+		return markdownTask(normalizeTask(id), links)
+	})
+}
+
+// markdownTask returns the task ID, linked to the tracker if task_url is set
+func markdownTask(id string, links Links) string {
+	if url := links.task(id); url != "" {
+		return fmt.Sprintf("[%s](%s)", id, url)
+	}
+	return id
+}
+
+// markdownMergeRequest returns a link to the merge request, or its GitLab reference
+// (group/project!123), which GitLab links itself
+func markdownMergeRequest(project string, iid int, links Links) string {
+	if url := links.mergeRequest(project, iid); url != "" {
+		return fmt.Sprintf("[!%d](%s)", iid, url)
+	}
+	if project == "" {
+		return fmt.Sprintf("!%d", iid)
+	}
+	return fmt.Sprintf("%s!%d", project, iid)
+}
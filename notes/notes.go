@@ -0,0 +1,514 @@
+package notes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"deploy/git"
+	"deploy/jira"
+	"deploy/version"
+)
+
+// Service identifies a working copy the release notes are collected from
+type Service struct {
+	Name    string
+	Dir     string
+	Project string           // GitLab project, used to link merge requests
+	Branch  string           // base branch the release is built from, used until the release tag exists
+	Version *version.Version // own version of the service (version_override), nil for the release version
+}
+
+// ServiceNotes holds the changes of a single service since its previous release
+type ServiceNotes struct {
+	Name    string
+	Project string // GitLab project of the service
+	From    string // previous release tag, empty if none was found
+	To      string // ref the release is built from
+	Commits []git.CommitInfo
+	Tasks   []string
+	Authors []string // authors of the commits, sorted
+	// IIDs of the merge requests the commits came from, ascending
+	MergeRequests []int
+	// Commits by section (Features, Fixes, Other); nil unless some of the commits follow
+	// the conventional commits format
+	Sections map[string][]Change
+	Breaking []Change // commits marked as breaking changes
+	Reverted int      // commits left out with their reverts because they were reverted within the range
+	Actions  []Action // BREAKING, MIGRATION and DB-CHANGE notes of the commit bodies
+}
+
+// Release is the data the release notes are rendered from
+type Release struct {
+	Version  string
+	Date     time.Time
+	Services []ServiceNotes
+	Tasks    []string // task IDs of all services, de-duplicated and sorted
+	// Summaries and statuses of the tasks by ID, if they were read from Jira
+	Issues map[string]jira.Issue
+}
+
+// Issue returns the Jira issue of the task, or nil if it was not read
+func (r *Release) Issue(task string) *jira.Issue {
+	if issue, ok := r.Issues[task]; ok {
+		return &issue
+	}
+	return nil
+}
+
+// ExtractTaskIDs returns the unique task IDs mentioned in the commits, sorted
+func ExtractTaskIDs(commits []git.CommitInfo) []string {
+	seen := make(map[string]bool)
+	var tasks []string
+	for _, commit := range commits {
+		for _, id := range taskIDPattern.FindAllString(commit.Subject, -1) {
+			id = normalizeTask(id)
+			if !seen[id] {
+				seen[id] = true
+				tasks = append(tasks, id)
+			}
+		}
+	}
+	sort.Strings(tasks)
+	return tasks
+}
+
+// ExtractAuthors returns the unique authors of the commits, sorted
+func ExtractAuthors(commits []git.CommitInfo) []string {
+	seen := make(map[string]bool)
+	for _, commit := range commits {
+		if commit.Author != "" {
+			seen[commit.Author] = true
+		}
+	}
+	return sortedKeys(seen)
+}
+
+// ExtractMergeRequests returns the unique merge request IIDs the commits reference, ascending
+func ExtractMergeRequests(commits []git.CommitInfo) []int {
+	seen := make(map[int]bool)
+	var iids []int
+	for _, commit := range commits {
+		for _, iid := range commit.MergeRequests {
+			if !seen[iid] {
+				seen[iid] = true
+				iids = append(iids, iid)
+			}
+		}
+	}
+	sort.Ints(iids)
+	return iids
+}
+
+// Collect gathers, for every service, the commits between the release tag preceding
+// the version and "to" (the release tag itself if it exists locally, otherwise the
+// service's base branch, or HEAD if it has none).
+// A non-empty "from" overrides the previous release lookup for all services.
+func Collect(services []Service, ver version.Version, from string) (*Release, error) {
+	release := &Release{Version: ver.String(), Date: time.Now()}
+	allTasks := make(map[string]bool)
+
+	for _, svc := range services {
+		svcVer := ver
+		if svc.Version != nil {
+			svcVer = *svc.Version
+		}
+
+		to := svcVer.ServiceTag(svc.Name)
+		if !git.RefExists(svc.Dir, to) {
+			to = "HEAD"
+			if svc.Branch != "" && git.RefExists(svc.Dir, svc.Branch) {
+				to = svc.Branch
+			}
+		}
+
+		prev := from
+		if prev == "" {
+			tag, found, err := git.GetPreviousReleaseTag(svc.Dir, svc.Name, svcVer)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", svc.Name, err)
+			}
+			if found {
+				prev = tag
+			}
+		}
+
+		if prev != "" {
+			// A shallow clone may not have the history down to the previous release
+			if err := git.EnsureHistory(svc.Dir, prev, to); err != nil {
+				return nil, fmt.Errorf("%s: %v", svc.Name, err)
+			}
+		}
+
+		commits, err := git.GetCommitsBetween(svc.Dir, prev, to)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", svc.Name, err)
+		}
+		commits, reverted := dropReverted(commits)
+		mergeRequests := ExtractMergeRequests(commits)
+		if excludeMerges {
+			commits = withoutMerges(commits)
+		}
+
+		tasks := ExtractTaskIDs(commits)
+		for _, task := range tasks {
+			allTasks[task] = true
+		}
+
+		release.Services = append(release.Services, ServiceNotes{
+			Name:          svc.Name,
+			Project:       svc.Project,
+			From:          prev,
+			To:            to,
+			Commits:       commits,
+			Tasks:         tasks,
+			Authors:       ExtractAuthors(commits),
+			MergeRequests: mergeRequests,
+			Reverted:      reverted,
+			Sections:      Categorize(commits),
+			Breaking:      BreakingChanges(commits),
+			Actions:       ExtractActions(commits),
+		})
+	}
+
+	for task := range allTasks {
+		release.Tasks = append(release.Tasks, task)
+	}
+	sort.Strings(release.Tasks)
+
+	return release, nil
+}
+
+// Release notes formats
+const (
+	FormatText     = "text"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+	FormatJSON     = "json"
+)
+
+// Links builds the URLs of tasks and merge requests in the formats that support links
+type Links struct {
+	TaskURL         string                               // URL of a task without a tracker url, {task} is replaced with its ID; no links if empty
+	MergeRequestURL func(project string, iid int) string // no links if nil
+}
+
+// task returns the URL of a task, from the url of its tracker or TaskURL, or an empty string
+func (l Links) task(id string) string {
+	url := l.TaskURL
+	if t := trackerOf(id); t != nil && t.url != "" {
+		url = t.url
+	}
+	if url == "" {
+		return ""
+	}
+	return strings.Replace(url, "{task}", id, -1)
+}
+
+// mergeRequest returns the URL of a merge request of the project, or an empty string
+func (l Links) mergeRequest(project string, iid int) string {
+	if l.MergeRequestURL == nil || project == "" {
+		return ""
+	}
+	return l.MergeRequestURL(project, iid)
+}
+
+// CheckFormat returns an error if the release notes format is not supported
+func CheckFormat(format string) error {
+	switch format {
+	case FormatText, FormatMarkdown, FormatHTML, FormatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown release notes format %q, expected %s, %s, %s or %s", format, FormatText, FormatMarkdown, FormatHTML, FormatJSON)
+}
+
+// RenderFormat returns the release notes in the format, plain text if it is unknown
+func RenderFormat(release *Release, format string, links Links) (string, error) {
+	switch format {
+	case FormatMarkdown:
+		return RenderMarkdown(release, links), nil
+	case FormatHTML:
+		return RenderHTML(release, links)
+	case FormatJSON:
+		return RenderJSON(release)
+	}
+	return Render(release), nil
+}
+
+// FileName returns the default name of the release notes file of a version in the format
+func FileName(version, format string) string {
+	ext := "txt"
+	switch format {
+	case FormatMarkdown:
+		ext = "md"
+	case FormatHTML:
+		ext = "html"
+	case FormatJSON:
+		ext = "json"
+	}
+	return fmt.Sprintf("release-notes-%s.%s", version, ext)
+}
+
+// ContentType returns the MIME type of the release notes in the format
+func ContentType(format string) string {
+	switch format {
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	case FormatJSON:
+		return "application/json"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Render returns the release notes as plain text
+func Render(release *Release) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Release %s (%s)\n", release.Version, release.Date.Format("2006-01-02"))
+	fmt.Fprintf(&b, "%s\n\n", strings.Repeat("=", 40))
+
+	// Breaking changes come first: they may need action before the release is deployed
+	var breaking []string
+	for _, svc := range release.Services {
+		for _, change := range svc.Breaking {
+			line := fmt.Sprintf("  %s: %s (%s)", svc.Name, change.Description, shortHash(change.Commit.Hash))
+			if change.BreakingNote != "" {
+				line += "\n      " + change.BreakingNote
+			}
+			breaking = append(breaking, line)
+		}
+	}
+	if len(breaking) > 0 {
+		fmt.Fprintf(&b, "!!! BREAKING CHANGES (%d):\n%s\n\n", len(breaking), strings.Join(breaking, "\n"))
+	}
+
+	var actions []string
+	for _, svc := range release.Services {
+		for _, action := range svc.Actions {
+			actions = append(actions, fmt.Sprintf("  %s: %s %s (%s)", svc.Name, action.Kind, action.Note, shortHash(action.Commit.Hash)))
+		}
+	}
+	if len(actions) > 0 {
+		fmt.Fprintf(&b, "!!! ACTION REQUIRED (%d):\n%s\n\n", len(actions), strings.Join(actions, "\n"))
+	}
+
+	fmt.Fprintf(&b, "Tasks (%d):\n", len(release.Tasks))
+	for _, task := range release.Tasks {
+		issue, ok := release.Issues[task]
+		if !ok {
+			fmt.Fprintf(&b, "  %s\n", task)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s [%s, %s] %s", task, issue.Type, issue.Status, issue.Summary)
+		if !issue.Done {
+			b.WriteString("  (not done)")
+		}
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "\nServices:\n")
+	for _, svc := range release.Services {
+		from := svc.From
+		if from == "" {
+			from = "(beginning of history)"
+		}
+		fmt.Fprintf(&b, "  %s: %d commit(s), %d task(s) since %s\n", svc.Name, len(svc.Commits), len(svc.Tasks), from)
+		if len(svc.Tasks) > 0 {
+			fmt.Fprintf(&b, "    Tasks: %s\n", strings.Join(svc.Tasks, ", "))
+		}
+		if svc.Reverted > 0 {
+			fmt.Fprintf(&b, "    Left out: %d reverted commit(s) and their reverts\n", svc.Reverted)
+		}
+		if len(svc.Authors) > 0 {
+			fmt.Fprintf(&b, "    Authors: %s\n", strings.Join(svc.Authors, ", "))
+		}
+		if len(svc.MergeRequests) > 0 {
+			refs := make([]string, len(svc.MergeRequests))
+			for i, iid := range svc.MergeRequests {
+				refs[i] = fmt.Sprintf("!%d", iid)
+			}
+			fmt.Fprintf(&b, "    Merge requests: %s\n", strings.Join(refs, ", "))
+		}
+		for _, section := range []string{SectionFeatures, SectionFixes, SectionOther} {
+			changes := svc.Sections[section]
+			if len(changes) == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "    %s:\n", section)
+			for _, change := range changes {
+				scope := ""
+				if change.Scope != "" {
+					scope = change.Scope + ": "
+				}
+				fmt.Fprintf(&b, "      - %s%s (%s)\n", scope, change.Description, shortHash(change.Commit.Hash))
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// ServiceComparison holds the differences of a single service between two refs
+type ServiceComparison struct {
+	Name         string
+	From         string           // ref resolved in the service repository
+	To           string           // ref resolved in the service repository
+	Added        []git.CommitInfo // commits of To that From does not have
+	Removed      []git.CommitInfo // commits of From that To does not have
+	AddedTasks   []string         // tasks of Added not mentioned in Removed
+	RemovedTasks []string         // tasks of Removed not mentioned in Added
+	Error        string           // why the service could not be compared
+}
+
+// Comparison is the difference between two releases of all services
+type Comparison struct {
+	From         string
+	To           string
+	Services     []ServiceComparison
+	AddedTasks   []string // of all services, de-duplicated and sorted
+	RemovedTasks []string
+}
+
+// ResolveRef returns the ref of the service a compare argument names: the release tag
+// of a version (e.g. 120 with the tag_template of the service), or a branch, tag or
+// commit of the repository or of origin as given (e.g. release/120.0)
+func ResolveRef(dir, service, ref string) (string, bool) {
+	var candidates []string
+	if v, err := version.Parse(ref); err == nil {
+		candidates = append(candidates, v.ServiceTag(service))
+	}
+	candidates = append(candidates, ref, "origin/"+ref)
+	for _, c := range candidates {
+		if git.RefExists(dir, c) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// Compare lists, for every service, the commits and task IDs that differ between the
+// refs from and to. A service missing one of the refs is reported, not compared.
+func Compare(services []Service, from, to string) *Comparison {
+	c := &Comparison{From: from, To: to}
+	added := make(map[string]bool)
+	removed := make(map[string]bool)
+
+	for _, svc := range services {
+		sc := ServiceComparison{Name: svc.Name}
+		var ok bool
+		if sc.From, ok = ResolveRef(svc.Dir, svc.Name, from); !ok {
+			sc.Error = fmt.Sprintf("%s not found", from)
+		} else if sc.To, ok = ResolveRef(svc.Dir, svc.Name, to); !ok {
+			sc.Error = fmt.Sprintf("%s not found", to)
+		} else if err := compareService(svc.Dir, &sc); err != nil {
+			sc.Error = err.Error()
+		}
+		for _, task := range sc.AddedTasks {
+			added[task] = true
+		}
+		for _, task := range sc.RemovedTasks {
+			removed[task] = true
+		}
+		c.Services = append(c.Services, sc)
+	}
+
+	c.AddedTasks = sortedKeys(added)
+	c.RemovedTasks = sortedKeys(removed)
+	return c
+}
+
+// compareService fills in the commits and tasks that differ between sc.From and sc.To
+func compareService(dir string, sc *ServiceComparison) error {
+	// A shallow clone may not have the history down to the common ancestor
+	if err := git.EnsureHistory(dir, sc.From, sc.To); err != nil {
+		return err
+	}
+	var err error
+	if sc.Added, err = git.GetCommitsBetween(dir, sc.From, sc.To); err != nil {
+		return err
+	}
+	if sc.Removed, err = git.GetCommitsBetween(dir, sc.To, sc.From); err != nil {
+		return err
+	}
+	// A task cherry-picked to both sides is not a difference
+	addedTasks, removedTasks := ExtractTaskIDs(sc.Added), ExtractTaskIDs(sc.Removed)
+	sc.AddedTasks = subtract(addedTasks, removedTasks)
+	sc.RemovedTasks = subtract(removedTasks, addedTasks)
+	return nil
+}
+
+// subtract returns the items of a that are not in b
+func subtract(a, b []string) []string {
+	skip := make(map[string]bool, len(b))
+	for _, item := range b {
+		skip[item] = true
+	}
+	var result []string
+	for _, item := range a {
+		if !skip[item] {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func sortedKeys(set map[string]bool) []string {
+	var keys []string
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RenderComparison returns the comparison as plain text
+func RenderComparison(c *Comparison) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Changes from %s to %s\n", c.From, c.To)
+	fmt.Fprintf(&b, "%s\n\n", strings.Repeat("=", 40))
+
+	fmt.Fprintf(&b, "Tasks added (%d):\n", len(c.AddedTasks))
+	for _, task := range c.AddedTasks {
+		fmt.Fprintf(&b, "  %s\n", task)
+	}
+	if len(c.RemovedTasks) > 0 {
+		fmt.Fprintf(&b, "Tasks only in %s (%d):\n", c.From, len(c.RemovedTasks))
+		for _, task := range c.RemovedTasks {
+			fmt.Fprintf(&b, "  %s\n", task)
+		}
+	}
+
+	for _, svc := range c.Services {
+		fmt.Fprintf(&b, "\n%s", svc.Name)
+		if svc.Error != "" {
+			fmt.Fprintf(&b, ": not compared, %s\n", svc.Error)
+			continue
+		}
+		fmt.Fprintf(&b, " (%s..%s): %d commit(s) added, %d only in %s\n", svc.From, svc.To, len(svc.Added), len(svc.Removed), svc.From)
+		if len(svc.AddedTasks) > 0 {
+			fmt.Fprintf(&b, "  Tasks added: %s\n", strings.Join(svc.AddedTasks, ", "))
+		}
+		if len(svc.RemovedTasks) > 0 {
+			fmt.Fprintf(&b, "  Tasks only in %s: %s\n", svc.From, strings.Join(svc.RemovedTasks, ", "))
+		}
+		for _, commit := range svc.Added {
+			fmt.Fprintf(&b, "  + %s %s (%s)\n", shortHash(commit.Hash), commit.Subject, commit.Author)
+		}
+		for _, commit := range svc.Removed {
+			fmt.Fprintf(&b, "  - %s %s (%s)\n", shortHash(commit.Hash), commit.Subject, commit.Author)
+		}
+	}
+
+	return b.String()
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
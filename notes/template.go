@@ -0,0 +1,62 @@
+package notes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+
+	"deploy/git"
+)
+
+// templateFuncs are available in the notes_template; the link functions are bound to
+// the links of the run when it is rendered
+var templateFuncs = template.FuncMap{
+	"join":            strings.Join,
+	"short":           shortHash,
+	"sections":        func() []string { return []string{SectionFeatures, SectionFixes, SectionOther} },
+	"taskURL":         func(string) string { return "" },
+	"mergeRequestURL": func(string, int) string { return "" },
+}
+
+// ParseTemplate reads a release notes template (text/template) from the file and tries
+// it on sample data, so that its errors are reported when the configuration is read
+func ParseTemplate(path string) (*template.Template, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("notes_template: %v", err)
+	}
+	tmpl, err := template.New("notes_template").Funcs(templateFuncs).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("notes_template %s: %v", path, err)
+	}
+	commit := git.CommitInfo{Hash: "0123456789abcdef", Subject: "feat: ABC-12345 sample", Author: "Author", Date: time.Now(), MergeRequests: []int{1}}
+	commits := []git.CommitInfo{commit}
+	sample := &Release{Version: "1.0.0", Date: time.Now(), Tasks: []string{"ABC-12345"}, Services: []ServiceNotes{{
+		Name: "service", Project: "group/service", From: "0.9.0", To: "master",
+		Commits: commits, Tasks: []string{"ABC-12345"}, Authors: []string{"Author"}, MergeRequests: []int{1},
+		Sections: Categorize(commits), Breaking: []Change{ParseChange(commit)},
+	}}}
+	if _, err := RenderTemplate(tmpl, sample, Links{}); err != nil {
+		return nil, fmt.Errorf("notes_template %s: %v", path, err)
+	}
+	return tmpl, nil
+}
+
+// RenderTemplate returns the release notes rendered with a notes_template
+func RenderTemplate(tmpl *template.Template, release *Release, links Links) (string, error) {
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return "", err
+	}
+	tmpl.Funcs(template.FuncMap{
+		"taskURL":         links.task,
+		"mergeRequestURL": links.mergeRequest,
+	})
+	var b strings.Builder
+	if err := tmpl.Execute(&b, release); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
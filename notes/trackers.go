@@ -0,0 +1,94 @@
+package notes
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"deploy/config"
+)
+
+// defaultTaskPattern matches tracker task IDs such as ABC-12345 when no trackers are configured
+const defaultTaskPattern = `[A-Za-z]{2,10}-\d{5,6}`
+
+// tracker is a task tracker from the trackers section of the configuration
+type tracker struct {
+	name    string
+	pattern *regexp.Regexp // matches a whole task ID of the tracker
+	url     string
+}
+
+var (
+	// taskIDPattern matches the task IDs of all trackers in commit subjects
+	taskIDPattern = regexp.MustCompile(defaultTaskPattern)
+	trackers      []tracker // configured trackers, nil for the default pattern
+)
+
+// SetTrackers sets the task ID patterns of the trackers. An empty list restores the
+// default pattern.
+func SetTrackers(list []config.Tracker) error {
+	if len(list) == 0 {
+		taskIDPattern = regexp.MustCompile(defaultTaskPattern)
+		trackers = nil
+		return nil
+	}
+
+	var configured []tracker
+	var alternatives []string
+	names := make(map[string]bool)
+	for i, t := range list {
+		if t.Name == "" {
+			return fmt.Errorf("trackers[%d]: name is not set", i)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("trackers: %s is listed twice", t.Name)
+		}
+		names[t.Name] = true
+		if t.Pattern == "" {
+			return fmt.Errorf("tracker %s: pattern is not set", t.Name)
+		}
+		if _, err := regexp.Compile(t.Pattern); err != nil {
+			return fmt.Errorf("tracker %s: invalid pattern: %v", t.Name, err)
+		}
+		if t.URL != "" && !strings.Contains(t.URL, "{task}") {
+			return fmt.Errorf("tracker %s: url %q does not contain {task}", t.Name, t.URL)
+		}
+		configured = append(configured, tracker{
+			name:    t.Name,
+			pattern: regexp.MustCompile(`^(?:` + t.Pattern + `)$`),
+			url:     t.URL,
+		})
+		alternatives = append(alternatives, "(?:"+t.Pattern+")")
+	}
+	taskIDPattern = regexp.MustCompile(strings.Join(alternatives, "|"))
+	trackers = configured
+	return nil
+}
+
+// TrackerOf returns the name of the tracker of the task ID, or an empty string if no
+// trackers are configured
+func TrackerOf(id string) string {
+	if t := trackerOf(id); t != nil {
+		return t.name
+	}
+	return ""
+}
+
+// trackerOf returns the first configured tracker matching the task ID
+func trackerOf(id string) *tracker {
+	for i := range trackers {
+		if trackers[i].pattern.MatchString(id) {
+			return &trackers[i]
+		}
+	}
+	return nil
+}
+
+// normalizeTask returns a task ID as it is listed: the default pattern ignores case,
+// so its IDs are upper-cased; the IDs of configured trackers are kept as they are
+func normalizeTask(id string) string {
+	if trackers == nil {
+		return strings.ToUpper(id)
+	}
+	return id
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"deploy/config"
+	"deploy/logger"
+	"deploy/notes"
+	"deploy/notify"
+	"deploy/plan"
+)
+
+// newNotifiers creates the notifiers configured in the notifications section
+func newNotifiers(cfg *config.Config) ([]notify.Notifier, error) {
+	var notifiers []notify.Notifier
+	if cfg.Notifications.Email != nil {
+		if format := cfg.Notifications.Email.ReleaseNotesFormat; format != "" {
+			if err := notes.CheckFormat(format); err != nil {
+				return nil, fmt.Errorf("notifications.email.release_notes_format: %v", err)
+			}
+		}
+		email, err := notify.NewEmail(cfg.Notifications.Email)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, email)
+	}
+	if cfg.Notifications.Telegram != nil {
+		telegram, err := notify.NewTelegram(cfg.Notifications.Telegram)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, telegram)
+	}
+	return notifiers, nil
+}
+
+// notifyPhase tells the notifiers that a phase has started
+func (d *deployment) notifyPhase(number int, p phase) {
+	if plan.Enabled() {
+		return
+	}
+	event := notify.PhaseEvent{
+		Version: d.version.String(),
+		Number:  number,
+		Total:   len(phases) - 1,
+		Name:    p.name,
+		Title:   p.title,
+	}
+	for _, n := range d.notifiers {
+		if err := n.PhaseStarted(event); err != nil {
+			logger.Warnf("Warning: %s notification failed: %v", n.Name(), err)
+		}
+	}
+}
+
+// notifyFinished sends the outcome of the deployment to all notifiers.
+// Failures are only reported: the deployment itself is over.
+func (d *deployment) notifyFinished(report *deployReport, release *notes.Release) {
+	if len(d.notifiers) == 0 {
+		return
+	}
+
+	summary := &notify.Summary{
+		Version:    report.Version,
+		Tag:        report.Tag,
+		Status:     report.Status,
+		Error:      report.Error,
+		Warnings:   report.Warnings,
+		Hotfix:     report.Hotfix,
+		Namespaces: report.Namespaces,
+		StartedAt:  report.StartedAt,
+		FinishedAt: report.FinishedAt,
+		Duration:   report.FinishedAt.Sub(report.StartedAt).Round(time.Second),
+		Tasks:      report.Tasks,
+	}
+	for _, svc := range report.Services {
+		summary.Services = append(summary.Services, notify.ServiceSummary{
+			Name:      svc.Name,
+			Tag:       svc.Tag,
+			Commit:    svc.Commit,
+			Pipelines: svc.Pipelines,
+		})
+	}
+	if release != nil {
+		format := notes.FormatText
+		if email := d.cfg.Notifications.Email; email != nil && email.ReleaseNotesFormat != "" {
+			format = email.ReleaseNotesFormat
+		}
+		text, err := renderReleaseNotes(d.cfg, release, format)
+		if err != nil {
+			logger.Warnf("Warning: failed to render the release notes: %v", err)
+		} else {
+			summary.ReleaseNotes = text
+			summary.ReleaseNotesFile = notes.FileName(report.Version, format)
+			summary.ReleaseNotesType = notes.ContentType(format)
+		}
+	}
+
+	for _, n := range d.notifiers {
+		if err := n.Finished(summary); err != nil {
+			logger.Warnf("Warning: %s notification failed: %v", n.Name(), strings.TrimSpace(err.Error()))
+			continue
+		}
+		logger.Infof("Sent %s notification", n.Name())
+	}
+}
@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"deploy/config"
+	"deploy/mail"
+)
+
+// defaultSubject and defaultBody are used when the configuration has no templates
+const (
+	defaultSubject = `[deploy] {{.Version}}: {{.Status}}`
+	defaultBody    = `Deployment {{.Version}} (tag {{.Tag}}) finished with status {{.Status}}.
+{{if .Error}}Error: {{.Error}}
+{{end}}{{range .Warnings}}WARNING: {{.}}
+{{end}}Namespaces: {{join .Namespaces ", "}}
+Started: {{.StartedAt.Format "2006-01-02 15:04:05"}}, duration: {{.Duration}}
+
+{{range .Services}}{{.Name}}{{if .Commit}} ({{short .Commit}}){{end}}
+{{range .Pipelines}}  {{.Namespace}}: {{.Status}}{{if .WebURL}} {{.WebURL}}{{end}}{{if .Error}} ({{.Error}}){{end}}
+{{else}}  no pipelines
+{{end}}{{end}}
+Tasks ({{len .Tasks}}): {{join .Tasks ", "}}
+`
+)
+
+// templateFuncs are available in the subject and body templates
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"short": func(commit string) string {
+		if len(commit) > 8 {
+			return commit[:8]
+		}
+		return commit
+	},
+}
+
+// Email sends the deployment summary by email with the release notes attached
+type Email struct {
+	server  mail.Server
+	from    string
+	to      []string
+	subject *template.Template
+	body    *template.Template
+}
+
+// NewEmail checks the email configuration and parses its templates
+func NewEmail(cfg *config.EmailNotification) (*Email, error) {
+	if cfg.Host == "" {
+		return nil, fmt.Errorf("email: smtp_host is not set")
+	}
+	if cfg.From == "" {
+		return nil, fmt.Errorf("email: from is not set")
+	}
+	if len(cfg.To) == 0 {
+		return nil, fmt.Errorf("email: to is not set")
+	}
+
+	server := mail.Server{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Security: cfg.Security,
+		Username: cfg.Username,
+	}
+	switch server.Security {
+	case "", mail.SecurityTLS, mail.SecurityStartTLS, mail.SecurityNone:
+	default:
+		return nil, fmt.Errorf("email: security must be tls, starttls or none, got %q", server.Security)
+	}
+	if server.Port == 0 {
+		server.Port = 587
+		if server.Security == "" || server.Security == mail.SecurityTLS {
+			server.Port = 465
+		}
+	}
+	if server.Username != "" {
+		passwordEnv := cfg.PasswordEnv
+		if passwordEnv == "" {
+			passwordEnv = "SMTP_PASSWORD"
+		}
+		server.Password = os.Getenv(passwordEnv)
+	}
+
+	subject, err := parseTemplate("subject", cfg.Subject, defaultSubject)
+	if err != nil {
+		return nil, err
+	}
+	body, err := parseTemplate("body", cfg.Body, defaultBody)
+	if err != nil {
+		return nil, err
+	}
+	return &Email{server: server, from: cfg.From, to: cfg.To, subject: subject, body: body}, nil
+}
+
+// parseTemplate parses the configured template, or the default one if it is empty
+func parseTemplate(name, text, def string) (*template.Template, error) {
+	if text == "" {
+		text = def
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("email: invalid %s template: %v", name, err)
+	}
+	return tmpl, nil
+}
+
+// Name identifies the notifier
+func (e *Email) Name() string {
+	return "email"
+}
+
+// PhaseStarted does nothing: only the final summary is emailed
+func (e *Email) PhaseStarted(event PhaseEvent) error {
+	return nil
+}
+
+// Finished sends the summary to the distribution list
+func (e *Email) Finished(summary *Summary) error {
+	var subject, body bytes.Buffer
+	if err := e.subject.Execute(&subject, summary); err != nil {
+		return fmt.Errorf("failed to render subject: %v", err)
+	}
+	if err := e.body.Execute(&body, summary); err != nil {
+		return fmt.Errorf("failed to render body: %v", err)
+	}
+
+	msg := mail.Message{
+		From:    e.from,
+		To:      e.to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    body.String(),
+	}
+	if summary.ReleaseNotes != "" {
+		msg.Attachments = append(msg.Attachments, mail.Attachment{
+			Name:        summary.ReleaseNotesFile,
+			ContentType: summary.ReleaseNotesType,
+			Data:        []byte(summary.ReleaseNotes),
+		})
+	}
+	return mail.Send(e.server, msg)
+}
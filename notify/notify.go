@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"time"
+
+	"deploy/gitlab"
+)
+
+// Summary is the outcome of a deployment, passed to notifiers and their templates
+type Summary struct {
+	Version          string
+	Tag              string
+	Status           string   // success, failed or interrupted
+	Error            string   // error that stopped a failed deployment
+	Warnings         []string // problems that did not stop the deployment, e.g. failed tests with run_tests: warn
+	Hotfix           bool
+	Namespaces       []string
+	StartedAt        time.Time
+	FinishedAt       time.Time
+	Duration         time.Duration
+	Services         []ServiceSummary
+	Tasks            []string
+	ReleaseNotes     string // rendered release notes, empty if they could not be collected
+	ReleaseNotesFile string // file name the release notes are attached as
+	ReleaseNotesType string // MIME type of the release notes
+}
+
+// ServiceSummary is the outcome of a single service
+type ServiceSummary struct {
+	Name      string
+	Tag       string // set if the release tag exists
+	Commit    string
+	Pipelines []gitlab.PipelineResult
+}
+
+// PhaseEvent describes a phase of the deployment that has started
+type PhaseEvent struct {
+	Version string
+	Number  int // phase number, 0 for fetch
+	Total   int // number of the last phase
+	Name    string
+	Title   string
+}
+
+// Notifier delivers deployment notifications
+type Notifier interface {
+	// Name identifies the notifier in warnings
+	Name() string
+	// PhaseStarted is called when a selected phase begins
+	PhaseStarted(event PhaseEvent) error
+	// Finished is called once when the deployment ends, whatever its status
+	Finished(summary *Summary) error
+}
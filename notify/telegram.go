@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"deploy/audit"
+	"deploy/config"
+)
+
+// telegramMessageLimit is the maximum length of a Telegram message
+const telegramMessageLimit = 4096
+
+// Telegram posts phase transitions and the final summary to a chat through a bot
+type Telegram struct {
+	apiURL string
+	token  string
+	chatID string
+	phases bool
+	client *http.Client
+}
+
+// NewTelegram checks the Telegram configuration
+func NewTelegram(cfg *config.TelegramNotification) (*Telegram, error) {
+	token := cfg.BotToken
+	if token == "" {
+		token = os.Getenv("TELEGRAM_BOT_TOKEN")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("telegram: bot_token is not set and TELEGRAM_BOT_TOKEN is empty")
+	}
+	if cfg.ChatID == "" {
+		return nil, fmt.Errorf("telegram: chat_id is not set")
+	}
+	apiURL := strings.TrimRight(cfg.APIURL, "/")
+	if apiURL == "" {
+		apiURL = "https://api.telegram.org"
+	}
+
+	// The token is part of every request URL
+	audit.AddSecret(token)
+	return &Telegram{
+		apiURL: apiURL,
+		token:  token,
+		chatID: cfg.ChatID,
+		phases: cfg.Phases == nil || *cfg.Phases,
+		client: &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+// Name identifies the notifier
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+// PhaseStarted posts the phase that has begun
+func (t *Telegram) PhaseStarted(event PhaseEvent) error {
+	if !t.phases {
+		return nil
+	}
+	return t.send(fmt.Sprintf("Deploy %s: phase %d/%d %s", event.Version, event.Number, event.Total, event.Title))
+}
+
+// Finished posts the final summary
+func (t *Telegram) Finished(summary *Summary) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Deploy %s: %s in %s\n", summary.Version, summary.Status, summary.Duration)
+	if summary.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\n", summary.Error)
+	}
+	for _, warning := range summary.Warnings {
+		fmt.Fprintf(&b, "WARNING: %s\n", warning)
+	}
+	fmt.Fprintf(&b, "Namespaces: %s\n", strings.Join(summary.Namespaces, ", "))
+	for _, svc := range summary.Services {
+		if len(svc.Pipelines) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s:", svc.Name)
+		for _, p := range svc.Pipelines {
+			fmt.Fprintf(&b, "\n  %s: %s", p.Namespace, p.Status)
+			if p.WebURL != "" {
+				fmt.Fprintf(&b, " %s", p.WebURL)
+			}
+		}
+	}
+	if len(summary.Tasks) > 0 {
+		fmt.Fprintf(&b, "\n\nTasks: %s", strings.Join(summary.Tasks, ", "))
+	}
+	return t.send(b.String())
+}
+
+// send posts a plain text message to the chat
+func (t *Telegram) send(text string) error {
+	if len(text) > telegramMessageLimit {
+		text = text[:telegramMessageLimit-3] + "..."
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"chat_id":                  t.chatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/bot%s/sendMessage", t.apiURL, t.token), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := audit.Do(t.client, req)
+	if err != nil {
+		// The error includes the URL and with it the token
+		return fmt.Errorf("request failed: %s", strings.ReplaceAll(err.Error(), t.token, "***"))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		var result struct {
+			Description string `json:"description"`
+		}
+		if json.Unmarshal(body, &result) == nil && result.Description != "" {
+			return fmt.Errorf("Telegram API returned %d: %s", resp.StatusCode, result.Description)
+		}
+		return fmt.Errorf("Telegram API returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package npm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"deploy/command"
+	"deploy/logger"
+	"deploy/plan"
+	"deploy/version"
+)
+
+// Package managers of a frontend service
+const (
+	ManagerNpm  = "npm"
+	ManagerYarn = "yarn"
+)
+
+// versionPaths are the keys of the package version in package.json and package-lock.json:
+// the top-level version and, from lockfile version 2, that of the root package
+var versionPaths = [][]string{
+	{"version"},
+	{"packages", "", "version"},
+}
+
+// jsonSpan is the position of a string value in a JSON document, quotes excluded
+type jsonSpan struct {
+	start, end int
+}
+
+// UpdateVersionFiles sets the version of package.json and package-lock.json in dir to
+// the new version. Only the values change, never the formatting.
+func UpdateVersionFiles(dir string, version version.Version) error {
+	for _, name := range []string{"package.json", "package-lock.json"} {
+		file := filepath.Join(dir, name)
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) && name != "package.json" {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		spans, err := versionSpans(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		if len(spans) == 0 {
+			if name == "package.json" {
+				return fmt.Errorf("%s has no version", file)
+			}
+			continue
+		}
+
+		content := string(data)
+		if plan.Enabled() {
+			for _, span := range spans {
+				line := strings.Count(content[:span.start], "\n") + 1
+				plan.Record("edit %s:%d: version %s -> %s", file, line, content[span.start:span.end], version)
+			}
+			continue
+		}
+
+		// Replace from the end so the earlier offsets stay valid
+		for i := len(spans) - 1; i >= 0; i-- {
+			content = content[:spans[i].start] + version.String() + content[spans[i].end:]
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to update %s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+// versionSpans returns the positions of the package version values of a package.json
+// or package-lock.json document, in document order
+func versionSpans(data []byte) ([]jsonSpan, error) {
+	// frame is an open object or array; key is the current key of an object
+	type frame struct {
+		object    bool
+		key       string
+		expectKey bool
+	}
+	var stack []*frame
+	var spans []jsonSpan
+
+	// matches reports whether the value of the current key is a package version
+	matches := func() bool {
+		for _, path := range versionPaths {
+			if len(path) != len(stack) {
+				continue
+			}
+			match := true
+			for i, f := range stack {
+				if !f.object || f.key != path[i] {
+					match = false
+					break
+				}
+			}
+			if match {
+				return true
+			}
+		}
+		return false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return spans, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if top != nil && top.object && top.expectKey {
+			if key, ok := tok.(string); ok {
+				top.key, top.expectKey = key, false
+				continue
+			}
+		}
+
+		switch tok {
+		case json.Delim('{'):
+			stack = append(stack, &frame{object: true, expectKey: true})
+			continue
+		case json.Delim('['):
+			stack = append(stack, &frame{})
+			continue
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				stack[len(stack)-1].expectKey = true
+			}
+			continue
+		}
+
+		// A scalar value: versions never contain quotes or escapes, so the
+		// opening quote is the last one before the closing quote
+		if value, ok := tok.(string); ok && matches() && value != "" {
+			end := int(dec.InputOffset()) - 1
+			start := bytes.LastIndexByte(data[:end], '"') + 1
+			spans = append(spans, jsonSpan{start: start, end: end})
+		}
+		if top != nil {
+			top.expectKey = true
+		}
+	}
+}
+
+// ProjectVersion returns the version of package.json in dir, without a prerelease
+// qualifier; ok is false if it has none
+func ProjectVersion(dir string) (v version.Version, ok bool, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return version.Version{}, false, err
+	}
+	var pkg struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return version.Version{}, false, err
+	}
+	v, ok = version.Find(pkg.Version)
+	return v, ok, nil
+}
+
+// BuildService installs the dependencies of a frontend service from its lockfile and
+// runs its build script: npm ci && npm run build, or yarn install --frozen-lockfile &&
+// yarn build. The output goes to log, or the root logger if it is nil.
+func BuildService(serviceDir, manager string, log *logger.Logger) error {
+	steps := [][]string{{"npm", "ci"}, {"npm", "run", "build"}}
+	if manager == ManagerYarn {
+		steps = [][]string{{"yarn", "install", "--frozen-lockfile"}, {"yarn", "build"}}
+	}
+
+	for _, step := range steps {
+		if plan.Enabled() {
+			plan.Record("%s (in %s)", strings.Join(step, " "), serviceDir)
+			continue
+		}
+
+		cmd := command.New(step[0], step[1:]...)
+		cmd.Dir = serviceDir
+
+		// Capture output and also print it in real-time
+		var stderr bytes.Buffer
+		out := log.Writer(logger.LevelInfo)
+		cmd.Stdout = out
+		cmd.Stderr = io.MultiWriter(&stderr, out)
+
+		err := cmd.Run()
+		out.Flush()
+
+		if err != nil {
+			logger.Errorf("\n\033[31mBuild failed!\033[0m")
+			if stderr.Len() > 0 {
+				logger.Infof("Error output:\n%s", stderr.String())
+			}
+			return fmt.Errorf("%s failed: %v", strings.Join(step, " "), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package npm
+
+import (
+	"fmt"
+	"strings"
+
+	"deploy/command"
+	"deploy/plan"
+)
+
+// GenerateSBOM writes the CycloneDX SBOM of the built service in serviceDir to file
+// with cyclonedx-npm, which reads the installed node_modules: that of yarn too
+func GenerateSBOM(serviceDir, file string) error {
+	args := []string{"--yes", "@cyclonedx/cyclonedx-npm", "--output-format", "JSON", "--output-file", file}
+	if plan.Enabled() {
+		plan.Record("npx %s (in %s)", strings.Join(args, " "), serviceDir)
+		return nil
+	}
+	cmd := command.New("npx", args...)
+	cmd.Dir = serviceDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cyclonedx-npm failed: %v\n%s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,1044 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"deploy/command"
+	"deploy/config"
+	"deploy/git"
+	"deploy/gitlab"
+	"deploy/logger"
+	"deploy/maven"
+	"deploy/notify"
+	"deploy/plan"
+	"deploy/state"
+	"deploy/tui"
+	"deploy/version"
+)
+
+// deployment holds everything the phases of a full deployment share
+type deployment struct {
+	cfg              *config.Config
+	services         []string
+	directory        string // services directory (-directory)
+	serviceDirs      map[string]string
+	repoDirs         map[string]string // git repository of every service, shared by the modules of a monorepo
+	lfsRepos         map[string]bool   // services whose repository stores files in Git LFS
+	backupDirs       map[string]string // repository refs are backed up in: the working copy, also with -isolated
+	backup           *refBackup        // refs deleted or force-pushed by this run
+	backupOnce       sync.Once
+	serviceHooks     map[string][]config.Hook
+	meshServices     map[string]bool
+	buildTools       map[string]string             // build tool of every service: maven, gradle, npm or yarn
+	mavenBuilds      map[string]maven.BuildOptions // Maven goals and arguments of every service
+	testModes        map[string]string             // test mode of every build: skip, run or warn
+	reuseUnchanged   bool                          // -reuse-unchanged: unchanged services are not rebuilt
+	mavenDeploy      *maven.DeployTarget           // -maven-deploy: repositories of mvn deploy after the build, nil if off
+	mavenOffline     bool                          // -maven-offline: the Maven cache is kept, nothing could be downloaded again
+	sbom             bool                          // -sbom: a CycloneDX SBOM is written for every build
+	reusedBuilds     map[string]string             // previous release tag of a service that was not rebuilt, guarded by failMu
+	allowSnapshots   bool                          // -allow-snapshots: SNAPSHOT versions left in the poms are only reported
+	snapshotRefs     map[string][]string           // SNAPSHOT versions a service was released with, guarded by failMu
+	testFailures     map[string]int                // failed tests of the builds with test mode warn, guarded by failMu
+	buildRetries     map[string]int                // times a failed build of a service is retried (build_retries)
+	buildRetryDelay  time.Duration                 // delay before the first retry, doubled for each next one
+	buildAttempts    map[string]int                // builds run for a service until one succeeded or none was left, guarded by failMu
+	dependsOn        map[string][]string           // services whose builds a service waits for (depends_on)
+	version          version.Version
+	baseBranch       string            // branch the release starts from: -base-branch, or the release branch for a hotfix
+	baseBranches     map[string]string // per-service base_branch overrides
+	hotfix           bool
+	tagName          string
+	serviceVersions  map[string]version.Version // services released with their own version_override
+	mavenCachePath   string
+	propertyPatterns []string // properties set to the release version: -pom-property-pattern and property_patterns
+	namespaces       []string
+	st               *state.State
+	assumeYes        bool           // answer yes to all confirmations (-yes)
+	onDirty          string         // dirty working copy policy (-on-dirty)
+	log              *logger.Logger // logger of the running phase
+	interrupts       *interrupts
+	concurrency      int        // services processed at once by phases 0-7 and 9 (-concurrency)
+	parallel         bool       // services are being processed in parallel: failures are reported after the step
+	promptMu         sync.Mutex // keeps interactive prompts of parallel services apart
+	autoApprove      bool       // skip the destructive actions confirmation (-auto-approve or -yes)
+	board            *tui.Board // progress board (-tui), nil for plain output
+	startedAt        time.Time
+	buildTimes       map[string]buildTime // start and end of the build of every service, guarded by failMu
+	stashes          map[string]string    // stash commit of the local changes of a service, guarded by failMu
+	notifiers        []notify.Notifier
+	selectedServices string                   // services chosen interactively, passed as -services on resume
+	phaseTimeouts    map[string]time.Duration // by phase name, from timeouts.phases and -phase-timeout
+	bumped           bool                     // the version was computed with -bump
+	fromTag          string                   // existing tag redeployed with -from-tag
+	keepGoing        bool                     // -keep-going: a failing service does not stop the others
+	phase            string                   // name of the running phase
+	failMu           sync.Mutex
+	failures         map[string]serviceFailure // services excluded by -keep-going
+	retry            bool                      // "deploy retry": a single service continues the state of a failed run
+}
+
+// Dirty working copy policies for -on-dirty
+const (
+	onDirtyAsk   = "ask"
+	onDirtyFail  = "fail"
+	onDirtyClean = "clean"
+	onDirtyStash = "stash"
+)
+
+// parseOnDirty validates the -on-dirty flag value
+func parseOnDirty(value string) (string, error) {
+	switch value {
+	case onDirtyAsk, onDirtyFail, onDirtyClean, onDirtyStash:
+		return value, nil
+	}
+	return "", fmt.Errorf("-on-dirty must be one of ask, fail, clean, stash, got %q", value)
+}
+
+// phase is a single named step of the full deployment
+type phase struct {
+	name  string // used by -from-phase/-to-phase/-skip-phase and in the state file
+	title string
+	run   func(d *deployment)
+}
+
+// phases lists the full deployment steps in execution order; phase numbers are indexes, from 0 for fetch
+var phases = []phase{
+	{"fetch", "Fetching branches and tags", (*deployment).fetch},
+	{"check-clean", "Checking git status", (*deployment).checkClean},
+	{"checkout", "Switching to base branch", (*deployment).checkout},
+	{"pull", "Pulling latest changes", (*deployment).pull},
+	{"update-poms", "Updating project versions", (*deployment).updatePoms},
+	{"create-branch", "Creating release branches", (*deployment).createBranches},
+	{"commit", "Committing changes", (*deployment).commit},
+	{"tag", "Creating tags", (*deployment).tag},
+	{"build", "Cleaning build caches and building services", (*deployment).build},
+	{"push", "Pushing changes and tags", (*deployment).push},
+	{"pipelines", "Creating GitLab pipelines", (*deployment).pipelines},
+	{"back-merge", "Merging release branches back", (*deployment).backMerge},
+	{"merge-request", "Opening merge requests of release branches", (*deployment).openMergeRequests},
+}
+
+// phaseNames returns the names of all phases, used in help and error messages
+func phaseNames() string {
+	names := make([]string, len(phases))
+	for i, p := range phases {
+		names[i] = fmt.Sprintf("%d=%s", i, p.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// parsePhase resolves a phase given by number or name to its number
+func parsePhase(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.Atoi(value); err == nil {
+		if n < 0 || n >= len(phases) {
+			return 0, fmt.Errorf("phase number must be between 0 and %d, got %d", len(phases)-1, n)
+		}
+		return n, nil
+	}
+	for i, p := range phases {
+		if p.name == value {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown phase %q (available: %s)", value, phaseNames())
+}
+
+// phaseNumber returns the number of a phase known by name
+func phaseNumber(name string) int {
+	n, err := parsePhase(name)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// phaseList is a repeatable flag collecting phase numbers or names
+type phaseList []string
+
+func (l *phaseList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *phaseList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// selectPhases returns the set of phase numbers to run for the given range and skip list
+func selectPhases(from, to string, skip []string) (map[int]bool, error) {
+	first, last := 0, len(phases)-1
+	var err error
+	if from != "" {
+		if first, err = parsePhase(from); err != nil {
+			return nil, fmt.Errorf("-from-phase: %v", err)
+		}
+	}
+	if to != "" {
+		if last, err = parsePhase(to); err != nil {
+			return nil, fmt.Errorf("-to-phase: %v", err)
+		}
+	}
+	if first > last {
+		return nil, fmt.Errorf("-from-phase %d is after -to-phase %d", first, last)
+	}
+
+	selected := make(map[int]bool)
+	for n := first; n <= last; n++ {
+		selected[n] = true
+	}
+	for _, value := range skip {
+		for _, item := range strings.Split(value, ",") {
+			n, err := parsePhase(item)
+			if err != nil {
+				return nil, fmt.Errorf("-skip-phase: %v", err)
+			}
+			delete(selected, n)
+		}
+	}
+	return selected, nil
+}
+
+// runPhases executes the selected phases in order
+func (d *deployment) runPhases(selected map[int]bool) {
+	confirmed := false
+	for number, p := range phases {
+		d.checkInterrupted()
+		if !selected[number] {
+			logger.Noticef("\nPhase %d: %s... skipped", number, p.title)
+			continue
+		}
+		d.log = logger.With("phase", p.name)
+		d.phase = p.name
+		if destructivePhases[p.name] && !confirmed {
+			d.confirmDestructive(selected)
+			confirmed = true
+		}
+		d.log.Noticef("\nPhase %d: %s...", number, p.title)
+		d.notifyPhase(number, p)
+		command.StartPhase(p.name, d.phaseTimeouts[p.name])
+		d.runHooks(p.name, config.HookBefore)
+		p.run(d)
+		if !d.keepGoing {
+			// Failures of services processed in parallel stop the deployment after the phase
+			d.exitOnFailures()
+		}
+		d.runHooks(p.name, config.HookAfter)
+		command.EndPhase()
+		if len(d.activeServices()) == 0 {
+			break
+		}
+	}
+	d.exitOnFailures()
+}
+
+// logFor returns the logger of the running phase with the service field set
+func (d *deployment) logFor(service string) *logger.Logger {
+	return d.log.With("service", service)
+}
+
+// baseBranchFor returns the branch the release of the service starts from.
+// A hotfix always starts from the release branch.
+func (d *deployment) baseBranchFor(service string) string {
+	if d.hotfix {
+		return d.version.ReleaseLine().ServiceBranch(service)
+	}
+	return d.baseBranches[service]
+}
+
+// versionFor returns the version the service is released with: its version_override,
+// or the version of the deployment
+func (d *deployment) versionFor(service string) version.Version {
+	if v, ok := d.serviceVersions[service]; ok {
+		return v
+	}
+	return d.version
+}
+
+// tagFor returns the tag the service is released and deployed with
+func (d *deployment) tagFor(service string) string {
+	if d.fromTag != "" {
+		return d.fromTag
+	}
+	return d.versionFor(service).ServiceTag(service)
+}
+
+// skipDone reports whether the phase was already completed for the service
+// in a resumed deployment, printing a note when it is skipped
+func (d *deployment) skipDone(phase, service string) bool {
+	d.checkInterrupted()
+	if !d.st.Done(phase, service) {
+		d.board.SetPhase(service, phase)
+		return false
+	}
+	d.logFor(service).Infof("  Skipping %s: %s already done", service, phase)
+	return true
+}
+
+// checkInterrupted stops the deployment if Ctrl+C was pressed. It is called
+// between steps, where the state file matches what has been done.
+func (d *deployment) checkInterrupted() {
+	if d.interrupts != nil && d.interrupts.requested() {
+		d.interrupts.exit()
+	}
+}
+
+// printSummary reports, after an interrupt, which phases were completed
+// for how many services and how to resume the deployment
+func (d *deployment) printSummary() {
+	d.stopBoard()
+	logger.Warnf("\n=== Deployment interrupted ===")
+	if plan.Enabled() {
+		logger.Noticef("Dry run: nothing was changed.")
+		return
+	}
+
+	logger.Noticef("Completed so far:")
+	completed := false
+	for i, p := range phases {
+		var done []string
+		for _, service := range d.services {
+			if d.st.Done(p.name, service) {
+				done = append(done, service)
+			}
+		}
+		switch {
+		case p.name == "pipelines" && d.st.DoneGlobal("pipelines"):
+			logger.Noticef("  Phase %d %s: done", i, p.name)
+		case p.name == "pipelines" && d.st.DoneGlobal("pipelines-started"):
+			logger.Noticef("  Phase %d %s: started, resume re-runs only failed/missing pipelines", i, p.name)
+		case len(done) == len(d.services):
+			logger.Noticef("  Phase %d %s: all %d services", i, p.name, len(done))
+		case len(done) > 0:
+			logger.Noticef("  Phase %d %s: %d/%d services (%s)", i, p.name, len(done), len(d.services), strings.Join(done, ", "))
+		default:
+			continue
+		}
+		completed = true
+	}
+	if !completed {
+		logger.Noticef("  nothing")
+	}
+
+	d.printStashes()
+	logger.Noticef("\nA step that was in progress for a service is repeated on resume.")
+	logger.Noticef("Resume with:\n  %s", d.resumeCommand())
+}
+
+// stashRecovery returns the command restoring the local changes of the service that
+// were stashed before the release
+func (d *deployment) stashRecovery(service string) string {
+	d.failMu.Lock()
+	defer d.failMu.Unlock()
+	return fmt.Sprintf("git -C %s stash apply %s", d.repoDirs[service], d.stashes[service])
+}
+
+// printStashes reminds of the local changes stashed by this run and how to restore them.
+// It must not wait for promptMu: it also runs on fatal errors and interrupts.
+func (d *deployment) printStashes() {
+	d.failMu.Lock()
+	stashed := len(d.stashes)
+	d.failMu.Unlock()
+	if stashed == 0 {
+		return
+	}
+	logger.Warnf("\nLocal changes were stashed before the release, restore them with:")
+	for _, service := range d.services {
+		d.failMu.Lock()
+		_, ok := d.stashes[service]
+		d.failMu.Unlock()
+		if ok {
+			logger.Warnf("  %s: %s", service, d.stashRecovery(service))
+		}
+	}
+}
+
+// printTestFailures repeats at the end of the run which services were released with
+// failed tests (run_tests: warn), so the warning is not lost in the build output
+func (d *deployment) printTestFailures() {
+	d.failMu.Lock()
+	defer d.failMu.Unlock()
+	if len(d.testFailures) == 0 {
+		return
+	}
+	logger.Warnf("\n%s", strings.Repeat("!", 60))
+	logger.Warnf("WARNING: services released with failed tests (run_tests: warn):")
+	for _, service := range d.services {
+		if n := d.testFailures[service]; n > 0 {
+			logger.Warnf("  %s: %d test(s) failed", service, n)
+		}
+	}
+	logger.Warnf("%s", strings.Repeat("!", 60))
+}
+
+// prompt asks the user a question on the terminal and returns the lowercased answer
+func (d *deployment) prompt(question string) string {
+	d.board.Suspend()
+	defer d.board.Resume()
+
+	fmt.Print(question)
+	reader := bufio.NewReader(os.Stdin)
+	response, _ := reader.ReadString('\n')
+	return strings.TrimSpace(strings.ToLower(response))
+}
+
+// startBoard shows the progress board instead of the scrolling log
+func (d *deployment) startBoard() {
+	d.board = tui.New(fmt.Sprintf("Deploying %s", d.version), d.services)
+	logger.SetHandler(func(level logger.Level, fields map[string]string, msg string) {
+		service := fields["service"]
+		if level == logger.LevelError {
+			d.board.Fail(service)
+		}
+		for _, line := range strings.Split(strings.Trim(msg, "\n"), "\n") {
+			d.board.Log(service, line)
+		}
+	})
+	if err := d.board.Start(); err != nil {
+		logger.SetHandler(nil)
+		d.board = nil
+		logger.Warnf("Warning: cannot start the progress board, using plain output: %v", err)
+		return
+	}
+	logger.OnFatal(d.stopBoard)
+}
+
+// stopBoard restores plain output, printing the final state of the board
+func (d *deployment) stopBoard() {
+	if d.board == nil {
+		return
+	}
+	d.board.Stop()
+	logger.SetHandler(nil)
+}
+
+// resumeCommand returns the command line of this run with -resume added,
+// and -services if the services were selected interactively
+func (d *deployment) resumeCommand() string {
+	args := []string{os.Args[0]}
+	resume := false
+	for i := 1; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		if arg == "-resume" || arg == "--resume" {
+			resume = true
+		}
+		// A resumed deployment continues the computed version instead of bumping again
+		if d.bumped && strings.HasPrefix(strings.TrimLeft(arg, "-"), "bump") {
+			if !strings.Contains(arg, "=") {
+				i++
+			}
+			continue
+		}
+		args = append(args, arg)
+	}
+	if d.bumped {
+		args = append(args, "-version", d.version.String())
+	}
+	if d.selectedServices != "" {
+		args = append(args, "-services", d.selectedServices)
+	}
+	if !resume {
+		args = append(args, "-resume")
+	}
+	return strings.Join(args, " ")
+}
+
+// markDone records that the phase has been completed for the service.
+// An empty service marks a phase step that is not tied to a single service.
+func (d *deployment) markDone(phase, service string) {
+	var err error
+	if service == "" {
+		err = d.st.MarkDoneGlobal(phase)
+	} else {
+		err = d.st.MarkDone(phase, service)
+		d.board.Done(service, phase)
+	}
+	if err != nil {
+		logger.Fatalf("Failed to save deployment state: %v", err)
+	}
+}
+
+// forEachService runs fn for every service, up to d.concurrency services at once,
+// and returns when all of them are done
+func (d *deployment) forEachService(fn func(service string)) {
+	d.forEachServiceOf(d.activeServices(), fn)
+}
+
+// forEachServiceOf runs fn like forEachService for the given services
+func (d *deployment) forEachServiceOf(services []string, fn func(service string)) {
+	if d.concurrency <= 1 {
+		for _, service := range services {
+			fn(service)
+		}
+		return
+	}
+
+	d.parallel = true
+	defer func() { d.parallel = false }()
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, d.concurrency)
+	for _, service := range services {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(service string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(service)
+		}(service)
+	}
+	wg.Wait()
+}
+
+// Phase 0: Fetch branches and tags of every repository, so that the checks of existing
+// release branches and tags and of the previous release see the state of origin.
+// It is not skipped on resume: origin may have changed since the failed run.
+func (d *deployment) fetch() {
+	d.forEachRepository("fetch", func(service string) {
+		d.logFor(service).Infof("  Fetching service: %s", service)
+		if err := git.FetchPrune(d.repoDirs[service]); err != nil {
+			d.failService(service, exitFailure, "Failed to fetch in %s: %v", service, err)
+			return
+		}
+		d.markDone("fetch", service)
+	})
+}
+
+// Phase 1: Check if all git working copies are clean
+func (d *deployment) checkClean() {
+	d.forEachRepository("check-clean", func(service string) {
+		if d.skipDone("check-clean", service) {
+			return
+		}
+		d.logFor(service).Infof("  Checking service: %s", service)
+		if err := git.CheckClean(d.repoDirs[service]); err != nil {
+			// Status output and the question must not interleave with other services
+			d.promptMu.Lock()
+			d.logFor(service).Warnf("\nWarning: Git working copy is not clean in %s", service)
+
+			// Show git status
+			if err := git.ShowStatus(d.repoDirs[service]); err != nil {
+				d.logFor(service).Fatalf("Failed to show git status in %s: %v", service, err)
+			}
+
+			d.handleDirty(service)
+			d.promptMu.Unlock()
+		}
+		d.markDone("check-clean", service)
+	})
+}
+
+// handleDirty resolves a dirty working copy according to the -on-dirty policy,
+// asking the user when the policy is "ask"
+func (d *deployment) handleDirty(service string) {
+	policy := d.onDirty
+	if policy == onDirtyAsk {
+		switch {
+		case plan.Enabled():
+			// In dry-run mode only report the cleanup
+			policy = onDirtyClean
+		case d.assumeYes:
+			// Never destroy local changes without an explicit policy in non-interactive mode
+			policy = onDirtyFail
+		default:
+			response := d.prompt(fmt.Sprintf("\nLocal changes in %s: [c]lean them (git reset --hard, they are lost), [s]tash them, or [n]o to cancel? ", service))
+			switch response {
+			case "c", "clean", "y", "yes":
+				policy = onDirtyClean
+			case "s", "stash":
+				policy = onDirtyStash
+			default:
+				d.log.Exitf(exitAborted, "Deployment cancelled by user")
+			}
+		}
+	}
+
+	switch policy {
+	case onDirtyFail:
+		d.logFor(service).Exitf(exitDirty, "Git working copy is not clean in %s (use -on-dirty=clean or -on-dirty=stash)", service)
+	case onDirtyStash:
+		d.logFor(service).Infof("  Stashing local changes for %s...", service)
+		message := fmt.Sprintf("deploy: local changes of %s before release %s (%s)", service, d.tagFor(service), time.Now().Format("2006-01-02 15:04"))
+		commit, err := git.Stash(d.repoDirs[service], message)
+		if err != nil {
+			d.logFor(service).Fatalf("Failed to stash local changes in %s: %v", service, err)
+		}
+		if commit != "" {
+			d.failMu.Lock()
+			d.stashes[service] = commit
+			d.failMu.Unlock()
+			d.logFor(service).Warnf("  Local changes of %s stashed, restore them with:\n    %s", service, d.stashRecovery(service))
+		}
+	default:
+		d.logFor(service).Infof("  Cleaning working directory for %s...", service)
+		if err := git.CleanWorkingDirectory(d.repoDirs[service]); err != nil {
+			d.logFor(service).Fatalf("Failed to clean working directory in %s: %v", service, err)
+		}
+	}
+}
+
+// Phase 2: Switch all to the base branch
+func (d *deployment) checkout() {
+	d.forEachRepository("checkout", func(service string) {
+		if d.skipDone("checkout", service) {
+			return
+		}
+		branch := d.baseBranchFor(service)
+		d.logFor(service).Infof("  Switching service: %s to %s", service, branch)
+		if d.hotfix {
+			// Release branches may use the old / separator
+			if err := git.Fetch(d.repoDirs[service]); err != nil {
+				d.failService(service, exitFailure, "Failed to fetch in %s: %v", service, err)
+				return
+			}
+			found, ok := git.FindBranch(d.repoDirs[service], branch)
+			if !ok && !plan.Enabled() {
+				d.failService(service, exitFailure, "Release branch %s does not exist in %s", branch, service)
+				return
+			}
+			if ok {
+				branch = found
+			}
+		}
+		if err := git.Checkout(d.repoDirs[service], branch); err != nil {
+			d.failService(service, exitFailure, "Failed to checkout %s branch in %s: %v", branch, service, err)
+			return
+		}
+		if d.lfsRepos[service] {
+			if err := git.LFSPull(d.repoDirs[service]); err != nil {
+				d.failService(service, exitFailure, "Failed to download LFS files in %s: %v", service, err)
+				return
+			}
+		}
+		d.markDone("checkout", service)
+	})
+}
+
+// Phase 3: Pull latest changes for all
+func (d *deployment) pull() {
+	d.forEachRepository("pull", func(service string) {
+		if d.skipDone("pull", service) {
+			return
+		}
+		d.logFor(service).Infof("  Pulling service: %s", service)
+		if err := git.Pull(d.repoDirs[service]); err != nil {
+			d.failService(service, exitFailure, "Failed to pull in %s: %v", service, err)
+			return
+		}
+		if d.lfsRepos[service] {
+			if err := git.LFSPull(d.repoDirs[service]); err != nil {
+				d.failService(service, exitFailure, "Failed to download LFS files in %s: %v", service, err)
+				return
+			}
+		}
+		d.markDone("pull", service)
+	})
+}
+
+// Phase 4: Update the versions in the pom.xml files, or the Gradle build files
+func (d *deployment) updatePoms() {
+	d.forEachService(func(service string) {
+		if d.skipDone("update-poms", service) {
+			return
+		}
+		d.logFor(service).Infof("  Updating service: %s", service)
+		if err := d.builderFor(service).updateVersion(d, service); err != nil {
+			d.failService(service, exitFailure, "Failed to update the version files in %s: %v", service, err)
+			return
+		}
+		if d.buildTools[service] == config.BuildMaven && !d.checkSnapshots(service) {
+			return
+		}
+		d.markDone("update-poms", service)
+	})
+}
+
+// checkSnapshots fails the service if its poms still reference -SNAPSHOT versions after
+// the update: a release built against snapshots is not reproducible. With
+// -allow-snapshots they are only reported. The files are not changed in a dry run, so
+// there is nothing to check.
+func (d *deployment) checkSnapshots(service string) bool {
+	if plan.Enabled() {
+		return true
+	}
+	refs, err := maven.SnapshotReferences(d.serviceDirs[service])
+	if err != nil {
+		d.failService(service, exitFailure, "Failed to check %s for SNAPSHOT versions: %v", service, err)
+		return false
+	}
+	if len(refs) == 0 {
+		return true
+	}
+	if !d.allowSnapshots {
+		d.failService(service, exitFailure, "%s still references SNAPSHOT versions:\n    %s\nRelease them first or pass -allow-snapshots",
+			service, strings.Join(refs, "\n    "))
+		return false
+	}
+
+	log := d.logFor(service)
+	log.Warnf("  Warning: %s references SNAPSHOT versions, released with -allow-snapshots:", service)
+	for _, ref := range refs {
+		log.Warnf("    %s", ref)
+	}
+	d.failMu.Lock()
+	d.snapshotRefs[service] = refs
+	d.failMu.Unlock()
+	return true
+}
+
+// Phase 5: Create release branches for all
+func (d *deployment) createBranches() {
+	if d.hotfix {
+		d.log.Infof("  Hotfix: committing directly on %s", d.baseBranch)
+		return
+	}
+
+	d.forEachRepository("create-branch", func(service string) {
+		if d.skipDone("create-branch", service) {
+			return
+		}
+		branchName := d.version.ServiceBranch(service)
+		d.logFor(service).Infof("  Creating branch for service: %s", service)
+
+		// Back up and delete the branch if it already exists (locally and remotely)
+		refs, err := git.BackupBranch(d.repoDirs[service], d.backupDirs[service], d.backup.ID, branchName)
+		if !d.backUpRefs(service, refs, err) {
+			return
+		}
+		if err := git.DeleteBranchIfExists(d.repoDirs[service], branchName); err != nil {
+			d.failService(service, exitFailure, "Failed to delete existing branch in %s: %v", service, err)
+			return
+		}
+
+		// Create new branch
+		if err := git.Checkout(d.repoDirs[service], "-b", branchName); err != nil {
+			d.failService(service, exitFailure, "Failed to create release branch in %s: %v", service, err)
+			return
+		}
+		d.markDone("create-branch", service)
+	})
+}
+
+// Phase 6: Show all diffs and commit changes for all
+func (d *deployment) commit() {
+	d.log.Infof("\nShowing all changes before commit:")
+	d.log.Infof("%s", strings.Repeat("=", 80))
+	primaries, _ := d.repositories()
+	for _, service := range primaries {
+		if d.st.Done("commit", service) {
+			continue
+		}
+		d.logFor(service).Infof("\n--- Changes in service: %s ---", service)
+		if err := git.ShowDiff(d.repoDirs[service]); err != nil {
+			// Don't fail if diff is empty, just continue
+			d.log.Infof("No changes to show")
+		}
+	}
+	d.log.Infof("%s", strings.Repeat("=", 80))
+
+	d.forEachRepository("commit", func(service string) {
+		if d.skipDone("commit", service) {
+			return
+		}
+		d.logFor(service).Infof("  Committing service: %s", service)
+		if err := git.AddAll(d.repoDirs[service]); err != nil {
+			d.failService(service, exitFailure, "Failed to add files in %s: %v", service, err)
+			return
+		}
+		message, err := d.commitMessage(service)
+		if err != nil {
+			d.failService(service, exitConfig, "Failed to build the commit message of %s: %v", service, err)
+			return
+		}
+		if err := git.Commit(d.repoDirs[service], message); err != nil {
+			d.failService(service, exitFailure, "Failed to commit in %s: %v", service, err)
+			return
+		}
+		d.markDone("commit", service)
+	})
+}
+
+// Phase 7: Create tags for all
+func (d *deployment) tag() {
+	d.forEachRepository("tag", func(service string) {
+		if d.skipDone("tag", service) {
+			return
+		}
+		d.logFor(service).Infof("  Creating tag for service: %s", service)
+
+		// Back up and delete the tag if it already exists (locally and remotely)
+		refs, err := git.BackupTag(d.repoDirs[service], d.backupDirs[service], d.backup.ID, d.tagFor(service))
+		if !d.backUpRefs(service, refs, err) {
+			return
+		}
+		if err := git.DeleteTagIfExists(d.repoDirs[service], d.tagFor(service)); err != nil {
+			d.failService(service, exitFailure, "Failed to delete existing tag in %s: %v", service, err)
+			return
+		}
+
+		// Create new tag
+		if d.cfg.UseAnnotatedTags() {
+			err = git.AnnotatedTag(d.repoDirs[service], d.tagFor(service), d.tagMessage(service))
+		} else {
+			err = git.Tag(d.repoDirs[service], d.tagFor(service))
+		}
+		if err != nil {
+			d.failService(service, exitFailure, "Failed to create tag in %s: %v", service, err)
+			return
+		}
+		d.markDone("tag", service)
+	})
+}
+
+// Phase 8: Clean the Maven and Gradle caches and build all services
+func (d *deployment) build() {
+	// Clean the cache of every build tool in use (only once: a resumed build must keep
+	// already installed artifacts). Maven keeps the step name of older state files.
+	cleaned := make(map[string]bool)
+	for _, service := range d.activeServices() {
+		b := d.builderFor(service)
+		step := "clean-cache"
+		if b.tool() != config.BuildMaven {
+			step += "-" + b.tool()
+		}
+		if cleaned[step] || d.st.DoneGlobal(step) || (b.tool() == config.BuildMaven && d.mavenOffline) {
+			continue
+		}
+		cleaned[step] = true
+		if err := b.cleanCache(d.mavenCachePath); err != nil {
+			d.log.Fatalf("Failed to clean %s cache: %v", b.tool(), err)
+		}
+		d.markDone(step, "")
+	}
+
+	// Build all services in order, or by their dependencies
+	d.forEachBuild(d.activeServices(), func(service string) {
+		if d.skipDone("build", service) {
+			return
+		}
+		if tag, ok := d.reusableBuild(service); ok {
+			d.logFor(service).Infof("\nNot rebuilding %s: no commits since %s, whose artifact is in the local Maven repository", service, tag)
+			d.failMu.Lock()
+			d.reusedBuilds[service] = tag
+			d.failMu.Unlock()
+			d.markDone("build", service)
+			return
+		}
+		log, logFile, closeLog := d.openBuildLog(service)
+		defer closeLog()
+		log.Infof("\nBuilding service: %s", service)
+		d.log.Infof("%s", strings.Repeat("-", 60))
+
+		started := time.Now()
+		failedTests, err := d.buildWithRetries(service, log)
+
+		d.failMu.Lock()
+		d.buildTimes[service] = buildTime{started: started, finished: time.Now()}
+		d.failMu.Unlock()
+		if err != nil {
+			if logFile != "" {
+				err = fmt.Errorf("%v (build log: %s)", err, logFile)
+			}
+			d.failService(service, exitBuild, "Build failed for service %s: %v", service, err)
+			return
+		}
+
+		if failedTests > 0 {
+			d.failMu.Lock()
+			d.testFailures[service] = failedTests
+			d.failMu.Unlock()
+			log.Warnf("  WARNING: %d test(s) of %s failed; the release goes on with run_tests: warn", failedTests, service)
+		}
+		if d.sbom {
+			log.Infof("  Generating the SBOM of %s...", service)
+			if err := d.writeSBOM(service, log); err != nil {
+				d.failService(service, exitBuild, "Failed to generate the SBOM of %s: %v", service, err)
+				return
+			}
+		}
+		log.Infof("%sService %s built successfully!%s", git.ColorGreen, service, git.ColorReset)
+		d.markDone("build", service)
+	})
+
+	if !d.hasFailures() {
+		d.log.Infof("\nAll services built successfully!")
+	}
+}
+
+// buildLogFile returns the file that keeps the build output of the service
+func (d *deployment) buildLogFile(service string) string {
+	return filepath.Join("logs", d.version.String(), service+"-build.log")
+}
+
+// buildWithRetries builds the service and retries a failed build up to build_retries
+// times, so that a Nexus timeout does not fail the whole release. The delay between
+// the attempts doubles; an interrupt stops the retries.
+func (d *deployment) buildWithRetries(service string, log *logger.Logger) (int, error) {
+	retries := d.buildRetries[service]
+	delay := d.buildRetryDelay
+	for attempt := 1; ; attempt++ {
+		failedTests, err := d.builderFor(service).build(d, service, log)
+		d.failMu.Lock()
+		d.buildAttempts[service] = attempt
+		d.failMu.Unlock()
+		if err == nil || attempt > retries || plan.Enabled() {
+			return failedTests, err
+		}
+
+		log.Warnf("  Build of %s failed (attempt %d of %d): %v", service, attempt, retries+1, err)
+		log.Warnf("  Retrying in %s...", delay)
+		select {
+		case <-time.After(delay):
+		case <-d.interrupts.stop:
+			return failedTests, err
+		}
+		delay *= 2
+	}
+}
+
+// sbomFile returns the file the SBOM of the service is written to with -sbom
+func (d *deployment) sbomFile(service string) string {
+	name := fmt.Sprintf("sbom-%s-%s.json", service, d.versionFor(service))
+	return filepath.Join("sbom", d.version.String(), name)
+}
+
+// writeSBOM writes the CycloneDX SBOM of the built service to its sbomFile
+func (d *deployment) writeSBOM(service string, log *logger.Logger) error {
+	file, err := filepath.Abs(d.sbomFile(service))
+	if err != nil {
+		return err
+	}
+	if !plan.Enabled() {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+	}
+	return d.builderFor(service).sbom(d, service, file, log)
+}
+
+// openBuildLog returns the logger of the build of the service, which also writes the
+// output to the build log file, the name of the file and the function closing it.
+// Without the file, in a dry run or if it cannot be created, it is the logger of the
+// service and the name is empty.
+func (d *deployment) openBuildLog(service string) (*logger.Logger, string, func()) {
+	log := d.logFor(service)
+	if plan.Enabled() {
+		return log, "", func() {}
+	}
+	name := d.buildLogFile(service)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		log.Warnf("Warning: could not create the build log of %s: %v", service, err)
+		return log, "", func() {}
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		log.Warnf("Warning: could not create the build log of %s: %v", service, err)
+		return log, "", func() {}
+	}
+	log.Infof("  Build log: %s", name)
+	return log.Tee(file), name, func() { file.Close() }
+}
+
+// Phase 9: Push changes and tags for all
+func (d *deployment) push() {
+	d.forEachRepository("push", func(service string) {
+		if d.skipDone("push", service) {
+			return
+		}
+		d.logFor(service).Infof("  Pushing service: %s", service)
+		branch, err := git.GetCurrentBranch(d.repoDirs[service])
+		if err != nil {
+			d.failService(service, exitPush, "Failed to push in %s: %v", service, err)
+			return
+		}
+		refs, err := git.BackupRemoteRefs(d.repoDirs[service], d.backupDirs[service], d.backup.ID, "refs/heads/"+branch, "refs/tags/"+d.tagFor(service))
+		if !d.backUpRefs(service, refs, err) {
+			return
+		}
+		if d.lfsRepos[service] {
+			if err := git.LFSPush(d.repoDirs[service]); err != nil {
+				d.failService(service, exitPush, "Failed to push LFS objects in %s: %v", service, err)
+				return
+			}
+		}
+		if err := git.PushWithTags(d.repoDirs[service]); err != nil {
+			d.failService(service, exitPush, "Failed to push in %s: %v", service, err)
+			return
+		}
+		d.markDone("push", service)
+	})
+}
+
+// Phase 10: Create GitLab pipelines
+func (d *deployment) pipelines() {
+	// A resumed run that already started pipelines re-runs only failed/missing ones
+	if d.st.DoneGlobal("pipelines") {
+		d.log.Infof("  Pipelines already completed, skipping")
+		return
+	}
+
+	// Services deployed by "deploy retry" are not deployed again
+	var pending []string
+	for _, service := range d.activeServices() {
+		if d.st.Done("pipelines", service) {
+			d.logFor(service).Infof("  Skipping %s: pipelines already done", service)
+			continue
+		}
+		pending = append(pending, service)
+	}
+	cfg := d.cfg
+	if len(pending) < len(d.services) && len(pending) > 0 {
+		filtered, err := d.cfg.FilterServices(pending)
+		if err != nil {
+			d.log.Fatalf("Failed to select services for pipelines: %v", err)
+		}
+		cfg = filtered
+	}
+
+	if len(pending) > 0 {
+		refs := releaseRefs(cfg, d.version, d.fromTag, d.serviceVersions)
+		d.board.SetPhaseAll("pipelines")
+		started := d.st.DoneGlobal("pipelines-started")
+		for _, service := range pending {
+			started = started || d.st.Done("pipelines-started", service)
+		}
+		if started {
+			if err := gitlab.ContinuePipelinesForRefs(cfg, refs, d.namespaces); err != nil {
+				d.checkInterrupted()
+				d.log.Exitf(exitPipeline, "Failed to continue GitLab pipelines: %v", err)
+			}
+		} else {
+			// A retry starts the pipelines of its service only
+			if d.retry {
+				for _, service := range pending {
+					d.markDone("pipelines-started", service)
+				}
+			} else {
+				d.markDone("pipelines-started", "")
+			}
+			if err := gitlab.CreatePipelinesForRefs(cfg, refs, d.namespaces); err != nil {
+				d.checkInterrupted()
+				d.log.Exitf(exitPipeline, "Failed to create GitLab pipelines: %v", err)
+			}
+		}
+		for _, service := range pending {
+			d.markDone("pipelines", service)
+		}
+	}
+	if d.cfg.GitlabRelease != nil {
+		d.createGitlabReleases()
+	}
+	// Services excluded by -keep-going still need their pipelines on resume
+	if !d.retry && !d.hasFailures() {
+		d.markDone("pipelines", "")
+	}
+	d.board.DoneAll("pipelines")
+}
@@ -0,0 +1,22 @@
+package plan
+
+import "deploy/logger"
+
+// enabled is set when the deployment runs in dry-run mode
+var enabled bool
+
+// Enable switches all packages into dry-run mode: operations that would modify
+// working copies, the Maven repository or GitLab are reported instead of executed
+func Enable() {
+	enabled = true
+}
+
+// Enabled reports whether dry-run mode is active
+func Enabled() bool {
+	return enabled
+}
+
+// Record prints an operation that would have been executed
+func Record(format string, args ...interface{}) {
+	logger.With("dry_run", "true").Infof("    \033[33m[dry-run]\033[0m "+format, args...)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"deploy/config"
+	"deploy/logger"
+	"deploy/maven"
+	"deploy/version"
+)
+
+// runPomDiff implements "deploy pom-diff": runs the pom.xml version rewrite of the
+// update-poms phase without writing anything and prints the changes as unified diffs
+func runPomDiff(args []string) {
+	fs := flag.NewFlagSet("pom-diff", flag.ExitOnError)
+	var logOpts logFlags
+	logOpts.register(fs)
+	var (
+		configFile         string
+		directory          string
+		versionStr         string
+		pomPropertyPattern string
+		servicesStr        string
+		envName            string
+		output             string
+	)
+	fs.StringVar(&configFile, "config", "", "Path to YAML configuration file (default: $DEPLOY_CONFIG or deploy.yaml)")
+	fs.StringVar(&configFile, "c", "", "Path to YAML configuration file (shorthand)")
+	fs.StringVar(&directory, "directory", "", "Base directory for services (required)")
+	fs.StringVar(&directory, "d", "", "Base directory for services (shorthand)")
+	fs.StringVar(&versionStr, "version", "", "Version the pom.xml files would be updated to (required)")
+	fs.StringVar(&versionStr, "v", "", "Version the pom.xml files would be updated to (shorthand)")
+	fs.StringVar(&pomPropertyPattern, "pom-property-pattern", "", "Patterns of the properties set to the release version, comma-separated (required unless property_patterns is set)")
+	fs.StringVar(&pomPropertyPattern, "p", "", "Patterns of the properties set to the release version (shorthand)")
+	fs.StringVar(&servicesStr, "services", "", "Only these services, comma-separated names or globs")
+	fs.StringVar(&envName, "env", "", "Environment profile from the config, e.g. staging or production")
+	fs.StringVar(&output, "output", "", "Write the diffs to this file instead of the standard output")
+	fs.StringVar(&output, "o", "", "Write the diffs to this file (shorthand)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s pom-diff [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Shows the pom.xml changes of the update-poms phase as unified diffs, without writing them.\n\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExample:\n")
+		fmt.Fprintf(os.Stderr, "  %s pom-diff -c deploy.yaml -d /path/to/services -v 123 -p ru.ps.services\n", os.Args[0])
+	}
+	fs.Parse(args)
+	logOpts.apply()
+
+	if directory == "" {
+		logger.Exitf(exitConfig, "Error: -directory parameter is required\n\nUse -h for help")
+	}
+	if versionStr == "" {
+		logger.Exitf(exitConfig, "Error: -version parameter is required\n\nUse -h for help")
+	}
+	ver, err := version.Parse(versionStr)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+
+	cfg, _ := loadConfig(configFile, directory, envName, servicesStr)
+	propertyPatterns := mergeLists(splitList(pomPropertyPattern), cfg.PropertyPatterns)
+	if len(propertyPatterns) == 0 {
+		logger.Exitf(exitConfig, "Error: -pom-property-pattern parameter or property_patterns in the config is required\n\nUse -h for help")
+	}
+	if maven.UsesVersionsPlugin() {
+		logger.Warnf("Warning: version_update is %s; the diff shows the changes of the xml strategy, Maven may format them differently", maven.UpdateVersionsPlugin)
+	}
+
+	var excludeArtifacts []maven.ArtifactExclusion
+	for _, excl := range cfg.SkipVersionUpdate {
+		excludeArtifacts = append(excludeArtifacts, maven.ArtifactExclusion{
+			GroupID:    excl.GroupID,
+			ArtifactID: excl.ArtifactID,
+		})
+	}
+
+	serviceVersions, err := resolveServiceVersions(cfg, ver)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+	maven.SetArtifactVersions(artifactVersions(cfg, ver, serviceVersions))
+
+	var diffs strings.Builder
+	files, lines := 0, 0
+	for _, wc := range workingCopies(cfg, directory) {
+		if wc.BuildTool() != config.BuildMaven {
+			logger.Infof("%s: built with %s, no pom.xml files to update", wc.Name, wc.BuildTool())
+			continue
+		}
+		svcVer := ver
+		if v, ok := serviceVersions[wc.Name]; ok {
+			svcVer = v
+		}
+		changes, err := maven.PreviewPomFiles(wc.Dir, svcVer, propertyPatterns, excludeArtifacts, cfg.SkipProperties)
+		if err != nil {
+			logger.Exitf(exitFailure, "Failed to read pom files of %s: %v", wc.Name, err)
+		}
+
+		changed := 0
+		for _, change := range changes {
+			name := change.File
+			if rel, err := filepath.Rel(directory, change.File); err == nil {
+				name = filepath.ToSlash(rel)
+			}
+			diffs.WriteString(change.Diff("a/"+name, "b/"+name))
+			changed += change.ChangedLines()
+		}
+		logger.Infof("  %-40s %d file(s), %d line(s)", wc.Name, len(changes), changed)
+		files += len(changes)
+		lines += changed
+	}
+
+	if output != "" {
+		if err := ioutil.WriteFile(output, []byte(diffs.String()), 0644); err != nil {
+			logger.Exitf(exitFailure, "Failed to write %s: %v", output, err)
+		}
+		logger.Infof("Diffs written to %s", output)
+	} else {
+		fmt.Print(diffs.String())
+	}
+	logger.Noticef("update-poms would change %d line(s) in %d file(s); nothing was written", lines, files)
+}
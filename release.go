@@ -0,0 +1,1043 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"deploy/command"
+	"deploy/config"
+	"deploy/git"
+	"deploy/gitlab"
+	"deploy/lock"
+	"deploy/logger"
+	"deploy/maven"
+	"deploy/plan"
+	"deploy/state"
+	"deploy/tui"
+	"deploy/version"
+)
+
+// runRelease implements "deploy release", the full deployment flow
+func runRelease(args []string) {
+	deploy("release", args)
+}
+
+// runRetry implements "deploy retry": re-runs the remaining phases of a single service
+// of a failed deployment and merges the outcome into the state and report of that run
+func runRetry(args []string) {
+	deploy("retry", args)
+}
+
+// deploy parses the flags of the release or retry command and runs the deployment
+func deploy(name string, args []string) {
+	retry := name == "retry"
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var logOpts logFlags
+	logOpts.register(fs)
+
+	// Parse command line arguments
+	var (
+		namespaceStr       string
+		directory          string
+		versionStr         string
+		mavenCachePath     string
+		pomPropertyPattern string
+		mavenProfiles      string
+		runTests           testModeFlag
+		reuseUnchanged     bool
+		allowSnapshots     bool
+		mavenDeploy        bool
+		mavenOffline       bool
+		sbom               bool
+		configFile         string
+		continueMode       bool
+		dryRun             bool
+		resume             bool
+		fromPhase          string
+		toPhase            string
+		skipPhases         phaseList
+		servicesStr        string
+		envName            string
+		assumeYes          bool
+		onDirtyStr         string
+		hotfix             bool
+		cancelPipelines    bool
+		concurrency        int
+		autoApprove        bool
+		useTUI             bool
+		baseBranchStr      string
+		retryService       string
+		bump               string
+		fromTag            string
+		keepGoing          bool
+		cloneMissing       bool
+		cloneProtocol      string
+		isolated           bool
+		scratchDir         string
+		phaseTimeouts      = make(timeoutList)
+		commandTimeouts    = make(timeoutList)
+	)
+
+	fs.StringVar(&namespaceStr, "namespace", "", "Helm namespace(s) for deployment, comma-separated (required unless -env defines them)")
+	fs.StringVar(&namespaceStr, "n", "", "Helm namespace(s) for deployment, comma-separated (shorthand)")
+	fs.BoolVar(&continueMode, "continue", false, "Continue deployment: skip build phases, re-run only failed/missing pipelines")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the execution plan without modifying working copies or calling GitLab")
+	fs.BoolVar(&resume, "resume", false, "Resume a failed deployment from its state file, skipping completed work")
+	fs.BoolVar(&hotfix, "hotfix", false, "Hotfix release: commit on the existing release branch with the next patch version")
+	fs.BoolVar(&cancelPipelines, "cancel-pipelines", false, "On Ctrl+C, or when a pipeline of a group fails, cancel the running pipelines of this run via the GitLab API")
+	fs.IntVar(&concurrency, "concurrency", 1, "Number of services processed at once in phases 0-7 and 9")
+	fs.BoolVar(&keepGoing, "keep-going", false, "Exclude a failing service from the remaining phases instead of stopping, and list the failures at the end")
+	fs.BoolVar(&cloneMissing, "clone", false, "Clone service repositories missing from -directory from GITLAB_URI and their gitlab_project without asking")
+	fs.StringVar(&cloneProtocol, "clone-protocol", git.CloneSSH, "Protocol of -clone: ssh, or https with GITLAB_TOKEN")
+	fs.BoolVar(&isolated, "isolated", false, "Release in temporary clones of the repositories instead of the working copies in -directory")
+	fs.StringVar(&scratchDir, "scratch-dir", "", "Directory of the -isolated clones (default: a directory of the release under the system temp directory)")
+	fs.BoolVar(&autoApprove, "auto-approve", false, "Delete existing release branches/tags and push without asking")
+	fs.BoolVar(&useTUI, "tui", false, "Show a progress table with one row per service instead of the scrolling log")
+	fs.BoolVar(&assumeYes, "yes", false, "Non-interactive mode: answer yes to all confirmations")
+	fs.StringVar(&onDirtyStr, "on-dirty", onDirtyAsk, "What to do with a dirty working copy: ask, fail, clean or stash")
+	fs.StringVar(&baseBranchStr, "base-branch", "master", "Branch releases start from (per-service base_branch in the config overrides it)")
+	fs.StringVar(&servicesStr, "services", "", "Deploy only these services, comma-separated names or globs")
+	fs.StringVar(&envName, "env", "", "Environment profile from the config, e.g. staging or production")
+	fs.StringVar(&fromPhase, "from-phase", "", "First phase to run, by number or name")
+	fs.StringVar(&toPhase, "to-phase", "", "Last phase to run, by number or name")
+	fs.Var(&skipPhases, "skip-phase", "Phase to skip, by number or name (repeatable)")
+	fs.Var(phaseTimeouts, "phase-timeout", "Time limit of a phase, e.g. build=30m (repeatable, overrides timeouts.phases)")
+	fs.Var(commandTimeouts, "command-timeout", "Time limit of a command, e.g. mvn=20m or \"git push=2m\" (repeatable, overrides timeouts.commands)")
+	fs.StringVar(&directory, "directory", "", "Base directory for services (required unless --continue)")
+	fs.StringVar(&directory, "d", "", "Base directory for services (shorthand)")
+	fs.StringVar(&versionStr, "version", "", "Version to deploy, e.g. 123 or 2.14.3 (required)")
+	fs.StringVar(&versionStr, "v", "", "Version number to deploy (shorthand)")
+	fs.StringVar(&versionStr, "set-version", "", "Version to deploy, instead of computing it with -bump")
+	fs.StringVar(&fromTag, "from-tag", "", "Redeploy an existing release tag: only create the pipelines of this tag, without git changes or build")
+	fs.StringVar(&bump, "bump", "", "Compute the version from the latest release tag: major, minor or patch")
+	fs.StringVar(&mavenCachePath, "maven-cache-path", "", "Path to Maven cache for cleanup (required unless --continue)")
+	fs.StringVar(&mavenCachePath, "m", "", "Path to Maven cache for cleanup (shorthand)")
+	fs.StringVar(&pomPropertyPattern, "pom-property-pattern", "", "Patterns of the properties set to the release version, comma-separated (required unless --continue or property_patterns is set)")
+	fs.StringVar(&pomPropertyPattern, "p", "", "Patterns of the properties set to the release version (shorthand)")
+	fs.StringVar(&mavenProfiles, "maven-profiles", "", "Maven profiles activated in every mvn call, comma-separated (e.g. prod,fast)")
+	fs.Var(&runTests, "run-tests", "Run the tests in the build; -run-tests=warn reports test failures without failing the build")
+	fs.BoolVar(&mavenDeploy, "maven-deploy", false, "Deploy the artifacts of the Maven services with mvn deploy after a successful build")
+	fs.BoolVar(&mavenOffline, "maven-offline", false, "Run Maven offline (-o), after checking that the dependencies of the Maven services are in the local repository")
+	fs.BoolVar(&sbom, "sbom", false, "Write a CycloneDX SBOM of every built service to sbom/<version>")
+	fs.BoolVar(&reuseUnchanged, "reuse-unchanged", false, "Do not rebuild Maven services without commits since their previous release whose artifact is in the local repository")
+	fs.BoolVar(&allowSnapshots, "allow-snapshots", false, "Release Maven services whose poms still reference SNAPSHOT versions after the update, with a warning")
+	fs.StringVar(&configFile, "config", "", "Path to YAML configuration file (default: $DEPLOY_CONFIG or deploy.yaml)")
+	fs.StringVar(&configFile, "c", "", "Path to YAML configuration file (shorthand)")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [release] [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nRequired options:\n")
+		fmt.Fprintf(os.Stderr, "  -directory, -d string\n")
+		fmt.Fprintf(os.Stderr, "        Base directory for services\n")
+		fmt.Fprintf(os.Stderr, "  -version, -v, -set-version string\n")
+		fmt.Fprintf(os.Stderr, "        Version to deploy: MAJOR[.MINOR[.PATCH]], e.g. 123 or 2.14.3\n")
+		fmt.Fprintf(os.Stderr, "  -bump string\n")
+		fmt.Fprintf(os.Stderr, "        Instead of -version: major, minor or patch; the next version after the latest\n")
+		fmt.Fprintf(os.Stderr, "        release tag of the services (or the root pom version if a service has no tags)\n")
+		fmt.Fprintf(os.Stderr, "  -maven-cache-path, -m string\n")
+		fmt.Fprintf(os.Stderr, "        Path to Maven cache for cleanup (e.g. ru/gov/pfr/ecp/apso/proezd)\n")
+		fmt.Fprintf(os.Stderr, "  -pom-property-pattern, -p string\n")
+		fmt.Fprintf(os.Stderr, "        Patterns of the POM properties set to the release version, comma-separated (e.g. proezd or\n")
+		fmt.Fprintf(os.Stderr, "        common.version,api.version); property_patterns of the config adds to them or replaces the flag\n")
+		fmt.Fprintf(os.Stderr, "  -namespace, -n string\n")
+		fmt.Fprintf(os.Stderr, "        Helm namespace(s) for deployment, comma-separated (e.g. test,prod);\n")
+		fmt.Fprintf(os.Stderr, "        may be omitted when the -env profile defines namespaces\n")
+		fmt.Fprintf(os.Stderr, "\nOptional:\n")
+		fmt.Fprintf(os.Stderr, "  -config, -c string\n")
+		fmt.Fprintf(os.Stderr, "        Path to YAML configuration file (e.g. deploy-proezd.yaml, deploy-skl.yaml)\n")
+		fmt.Fprintf(os.Stderr, "        Defaults to $DEPLOY_CONFIG, then deploy.yaml; relative paths are searched in\n")
+		fmt.Fprintf(os.Stderr, "        the current directory, -directory and $HOME/.config/deploy\n")
+		fmt.Fprintf(os.Stderr, "  -continue\n")
+		fmt.Fprintf(os.Stderr, "        Continue deployment: skip build phases, re-run only failed/missing pipelines\n")
+		fmt.Fprintf(os.Stderr, "  -resume\n")
+		fmt.Fprintf(os.Stderr, "        Resume a failed deployment from its state file, skipping completed work\n")
+		fmt.Fprintf(os.Stderr, "  -hotfix\n")
+		fmt.Fprintf(os.Stderr, "        Hotfix release of an existing release branch: -version 123 checks out release-123\n")
+		fmt.Fprintf(os.Stderr, "        and tags the next free patch version (e.g. 123.0.1); -version 123.0.2 sets it explicitly\n")
+		fmt.Fprintf(os.Stderr, "  -cancel-pipelines\n")
+		fmt.Fprintf(os.Stderr, "        On Ctrl+C also cancel the running pipelines of this run via the GitLab API; when a pipeline\n")
+		fmt.Fprintf(os.Stderr, "        of a group fails, cancel the pipelines of the group still running on that namespace\n")
+		fmt.Fprintf(os.Stderr, "  -concurrency int\n")
+		fmt.Fprintf(os.Stderr, "        Number of services processed at once in phases 0-7 and 9 (git, pom update, push)\n")
+		fmt.Fprintf(os.Stderr, "        and in the build phase if services declare depends_on, default 1\n")
+		fmt.Fprintf(os.Stderr, "  -auto-approve\n")
+		fmt.Fprintf(os.Stderr, "        Delete existing release branches/tags and push without asking (implied by -yes)\n")
+		fmt.Fprintf(os.Stderr, "  -tui\n")
+		fmt.Fprintf(os.Stderr, "        Show a progress table with one row per service and the log of the active service;\n")
+		fmt.Fprintf(os.Stderr, "        ignored when the output is not a terminal or with -log-format json\n")
+		fmt.Fprintf(os.Stderr, "  -yes\n")
+		fmt.Fprintf(os.Stderr, "        Non-interactive mode: answer yes to all confirmations (for CI)\n")
+		fmt.Fprintf(os.Stderr, "  -on-dirty string\n")
+		fmt.Fprintf(os.Stderr, "        What to do with a dirty working copy: ask, fail, clean or stash (default ask; with -yes ask means fail)\n")
+		fmt.Fprintf(os.Stderr, "  -env string\n")
+		fmt.Fprintf(os.Stderr, "        Environment profile from the config (namespaces, base branch, pipeline variables, services)\n")
+		fmt.Fprintf(os.Stderr, "  -base-branch string\n")
+		fmt.Fprintf(os.Stderr, "        Branch releases start from (default master); base_branch of a service in the config overrides it\n")
+		fmt.Fprintf(os.Stderr, "  -maven-profiles string\n")
+		fmt.Fprintf(os.Stderr, "        Maven profiles activated in every mvn call, comma-separated (e.g. prod,fast);\n")
+		fmt.Fprintf(os.Stderr, "        the profiles of a service in the config are added to them\n")
+		fmt.Fprintf(os.Stderr, "  -run-tests, -run-tests=warn\n")
+		fmt.Fprintf(os.Stderr, "        Run the tests in the Maven and Gradle builds instead of skipping them; with warn\n")
+		fmt.Fprintf(os.Stderr, "        test failures are reported in the final report without failing the build.\n")
+		fmt.Fprintf(os.Stderr, "        run_tests of a service in the config overrides it\n")
+		fmt.Fprintf(os.Stderr, "  -maven-deploy\n")
+		fmt.Fprintf(os.Stderr, "        Deploy the artifacts of the Maven services with mvn deploy after a successful build, to\n")
+		fmt.Fprintf(os.Stderr, "        maven_deploy of the config or the distributionManagement of the project\n")
+		fmt.Fprintf(os.Stderr, "  -maven-offline\n")
+		fmt.Fprintf(os.Stderr, "        Run every mvn call offline (-o), without the Maven cache cleanup. Before the release\n")
+		fmt.Fprintf(os.Stderr, "        starts, the dependencies of the Maven services are resolved from the local repository\n")
+		fmt.Fprintf(os.Stderr, "  -sbom\n")
+		fmt.Fprintf(os.Stderr, "        Write the CycloneDX SBOM of every built service to sbom/<version>/sbom-<service>-<version>.json\n")
+		fmt.Fprintf(os.Stderr, "  -reuse-unchanged\n")
+		fmt.Fprintf(os.Stderr, "        Skip the build of Maven services without commits since their previous release tag\n")
+		fmt.Fprintf(os.Stderr, "        whose artifact of that release is in the local Maven repository\n")
+		fmt.Fprintf(os.Stderr, "  -allow-snapshots\n")
+		fmt.Fprintf(os.Stderr, "        Only warn about SNAPSHOT versions left in the poms after the update instead of\n")
+		fmt.Fprintf(os.Stderr, "        failing the service\n")
+		fmt.Fprintf(os.Stderr, "  -services string\n")
+		fmt.Fprintf(os.Stderr, "        Deploy only these services, comma-separated names or globs (e.g. proezd-api,*-bo)\n")
+		fmt.Fprintf(os.Stderr, "  -from-phase string, -to-phase string\n")
+		fmt.Fprintf(os.Stderr, "        Run only phases in this range, by number or name\n")
+		fmt.Fprintf(os.Stderr, "  -skip-phase string\n")
+		fmt.Fprintf(os.Stderr, "        Skip a phase, by number or name (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "        Phases: %s\n", phaseNames())
+		fmt.Fprintf(os.Stderr, "  -clone\n")
+		fmt.Fprintf(os.Stderr, "        Clone service repositories missing from -directory from GITLAB_URI and their\n")
+		fmt.Fprintf(os.Stderr, "        gitlab_project (without it the deployment asks in a terminal)\n")
+		fmt.Fprintf(os.Stderr, "  -clone-protocol string\n")
+		fmt.Fprintf(os.Stderr, "        ssh (default, git@host:project.git) or https (authenticated with GITLAB_TOKEN)\n")
+		fmt.Fprintf(os.Stderr, "  -isolated\n")
+		fmt.Fprintf(os.Stderr, "        Release in temporary clones of the repositories, leaving the working copies in -directory\n")
+		fmt.Fprintf(os.Stderr, "        untouched; the clones are removed after a successful release and reused by -resume\n")
+		fmt.Fprintf(os.Stderr, "  -scratch-dir string\n")
+		fmt.Fprintf(os.Stderr, "        Directory of the -isolated clones (default $TMPDIR/deploy-isolated/<directory>-<version>)\n")
+		fmt.Fprintf(os.Stderr, "  -keep-going\n")
+		fmt.Fprintf(os.Stderr, "        A failing service is excluded from the remaining phases instead of stopping the others;\n")
+		fmt.Fprintf(os.Stderr, "        the failures are listed at the end\n")
+		fmt.Fprintf(os.Stderr, "  -from-tag string\n")
+		fmt.Fprintf(os.Stderr, "        Redeploy an existing tag: check it exists in every GitLab project and run only the pipelines\n")
+		fmt.Fprintf(os.Stderr, "  -phase-timeout name=duration, -command-timeout program=duration\n")
+		fmt.Fprintf(os.Stderr, "        Time limits of phases and commands, e.g. build=40m or mvn=20m (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  -dry-run\n")
+		fmt.Fprintf(os.Stderr, "        Print the execution plan without modifying working copies or calling GitLab\n")
+		fmt.Fprintf(os.Stderr, "\nExample:\n")
+		fmt.Fprintf(os.Stderr, "  %s -config deploy.yaml -directory /path/to/services -version 123 -maven-cache-path ru/gov/pfr/ecp/apso/proezd -pom-property-pattern proezd -namespace production\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -c deploy.yaml -v 123 -n test,prod --continue\n", os.Args[0])
+	}
+
+	if retry {
+		fs.StringVar(&retryService, "service", "", "Service to retry (required)")
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: %s retry -service NAME -version N [release options]\n\n", os.Args[0])
+			fmt.Fprintf(os.Stderr, "Re-runs the phases of one service that a failed deployment did not complete\n")
+			fmt.Fprintf(os.Stderr, "(e.g. build, push, pipelines) and merges the result into its state and report.\n\n")
+			fs.PrintDefaults()
+			fmt.Fprintf(os.Stderr, "\nExample:\n")
+			fmt.Fprintf(os.Stderr, "  %s retry -service proezd-api -c deploy.yaml -d /path/to/services -v 123 -m ru/gov/pfr/ecp/apso/proezd -p proezd -n test\n", os.Args[0])
+		}
+	}
+
+	fs.Parse(args)
+	logOpts.apply()
+
+	if retry {
+		if retryService == "" {
+			logger.Exitf(exitConfig, "Error: -service parameter is required\n\nUse -h for help")
+		}
+		if continueMode || hotfix {
+			logger.Exitf(exitConfig, "Error: -continue and -hotfix cannot be used with retry\n\nUse -h for help")
+		}
+		// The retried service continues the state of the original run
+		servicesStr = retryService
+		resume = true
+	}
+
+	// Validate required parameters
+	switch {
+	case fromTag != "" && (bump != "" || hotfix || continueMode):
+		logger.Exitf(exitConfig, "Error: -from-tag cannot be used with -bump, -hotfix or -continue\n\nUse -h for help")
+	case fromTag != "" && (fromPhase != "" || toPhase != "" || len(skipPhases) > 0):
+		logger.Exitf(exitConfig, "Error: -from-tag runs only the pipelines phase and cannot be used with -from-phase, -to-phase or -skip-phase\n\nUse -h for help")
+	case bump != "" && versionStr != "":
+		logger.Exitf(exitConfig, "Error: -bump cannot be used with -version/-set-version\n\nUse -h for help")
+	case bump != "" && (continueMode || resume || hotfix):
+		logger.Exitf(exitConfig, "Error: -bump computes a new version and cannot be used with -continue, -resume, -hotfix or retry; pass the version with -version\n\nUse -h for help")
+	case bump != "":
+		if _, err := (version.Version{}).Bump(bump); err != nil {
+			logger.Exitf(exitConfig, "Error: -bump: %v\n\nUse -h for help", err)
+		}
+	case fromTag != "" && versionStr == "":
+		// The version of the report and notifications is taken from the tag
+		v, ok := version.Find(fromTag)
+		if !ok {
+			logger.Exitf(exitConfig, "Error: no version in tag %q, pass it with -version\n\nUse -h for help", fromTag)
+		}
+		versionStr = v.String()
+	case versionStr == "":
+		logger.Exitf(exitConfig, "Error: -version parameter is required (or -bump)\n\nUse -h for help")
+	}
+	if fromTag != "" {
+		fromPhase, toPhase = "pipelines", "pipelines"
+	}
+
+	selected, err := selectPhases(fromPhase, toPhase, skipPhases)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: %v\n\nUse -h for help", err)
+	}
+
+	onDirty, err := parseOnDirty(onDirtyStr)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: %v\n\nUse -h for help", err)
+	}
+	if cloneProtocol != git.CloneSSH && cloneProtocol != git.CloneHTTPS {
+		logger.Exitf(exitConfig, "Error: -clone-protocol must be %s or %s, got %q\n\nUse -h for help", git.CloneSSH, git.CloneHTTPS, cloneProtocol)
+	}
+
+	if concurrency < 1 {
+		logger.Exitf(exitConfig, "Error: -concurrency must be at least 1\n\nUse -h for help")
+	}
+	if concurrency > 1 {
+		// Lines of services processed in parallel are told apart by a [service] prefix
+		logger.SetTextPrefix("service")
+	}
+
+	if !continueMode {
+		if directory == "" {
+			logger.Exitf(exitConfig, "Error: -directory parameter is required\n\nUse -h for help")
+		}
+		if mavenCachePath == "" && !mavenOffline && selected[phaseNumber("build")] {
+			logger.Exitf(exitConfig, "Error: -maven-cache-path parameter is required\n\nUse -h for help")
+		}
+	}
+
+	// Parse version; with -bump it is computed once the service directories are known
+	var ver version.Version
+	if bump == "" {
+		ver, err = version.Parse(versionStr)
+		if err != nil {
+			logger.Exitf(exitConfig, "Error: %v", err)
+		}
+	}
+
+	// Read configuration file, restricted to the selected services
+	cfg, configFile := loadConfig(configFile, directory, envName, servicesStr)
+	propertyPatterns := mergeLists(splitList(pomPropertyPattern), cfg.PropertyPatterns)
+	if !continueMode && len(propertyPatterns) == 0 && selected[phaseNumber("update-poms")] {
+		logger.Exitf(exitConfig, "Error: -pom-property-pattern parameter or property_patterns in the config is required\n\nUse -h for help")
+	}
+	if cfg.BackMerge == nil {
+		// The back-merge phase is optional and runs only when back_merge is configured
+		delete(selected, phaseNumber("back-merge"))
+	}
+	if cfg.MergeRequest == nil {
+		// The merge-request phase is optional and runs only when release_merge_request is configured
+		delete(selected, phaseNumber("merge-request"))
+	}
+	if retry && len(cfg.GetAllServices()) != 1 {
+		logger.Exitf(exitConfig, "Error: -service must name exactly one service, %q matches %d", retryService, len(cfg.GetAllServices()))
+	}
+	phaseLimits, commandLimits, problems := resolveTimeouts(cfg, phaseTimeouts, commandTimeouts)
+	if len(problems) > 0 {
+		logger.Exitf(exitConfig, "Error: invalid timeouts:\n  %s", strings.Join(problems, "\n  "))
+	}
+	command.SetTimeouts(commandLimits)
+	gitlab.SetCancelGroups(cancelPipelines)
+
+	// Without -services an interactive deployment lets the user deselect services
+	// that did not change; the choice is added to the resume command
+	var selectedServices string
+	if servicesStr == "" && !continueMode && !resume && !assumeYes && tui.IsTerminal(os.Stdin) && tui.IsTerminal(os.Stdout) {
+		cfg, selectedServices = selectServices(cfg)
+	}
+
+	// Namespaces come from -namespace, or from the selected environment
+	namespaces := resolveNamespaces(namespaceStr, cfg)
+	if len(namespaces) == 0 {
+		logger.Exitf(exitConfig, "Error: -namespace parameter is required (or an -env with namespaces)\n\nUse -h for help")
+	}
+
+	if dryRun {
+		plan.Enable()
+		logger.Infof("*** DRY RUN: no changes will be made ***")
+	}
+
+	if continueMode {
+		if hotfix && ver.Patch == 0 {
+			logger.Exitf(exitConfig, "Error: -continue with -hotfix requires the full hotfix version, e.g. -version 123.0.1")
+		}
+		restoreRefDate(directory, ver.String())
+		tagName := ver.Tag()
+		serviceVersions, err := resolveServiceVersions(cfg, ver)
+		if err != nil {
+			logger.Exitf(exitConfig, "Error: %v", err)
+		}
+
+		// Continue mode: skip build phases, re-run failed/missing pipelines
+		logger.Infof("=== Continue Deployment ===")
+		logger.Infof("Config File: %s", configFile)
+		logger.Infof("Version: %s", ver)
+		printServiceVersions(serviceVersions)
+		logger.Infof("Tag: %s", tagName)
+		logger.Infof("Namespaces: %s", strings.Join(namespaces, ", "))
+		logger.Infof("===========================\n")
+
+		logger.Infof("Checking pipeline statuses and re-running failed/missing pipelines...")
+
+		gitlab.SetTraceDir(filepath.Join("logs", ver.String()))
+		in := watchInterrupts(cancelPipelines, func() {
+			logger.Warnf("\n=== Deployment interrupted ===")
+			logger.Noticef("Run the same command again to re-run failed/missing pipelines:\n  %s", strings.Join(os.Args, " "))
+		})
+		if err := gitlab.ContinuePipelinesForRefs(cfg, releaseRefs(cfg, ver, "", serviceVersions), namespaces); err != nil {
+			if in.requested() {
+				in.exit()
+			}
+			logger.Exitf(exitPipeline, "Failed to continue deployment: %v", err)
+		}
+
+		logger.Noticef("\nContinue deployment completed successfully!")
+		return
+	}
+
+	// Full deployment mode
+	// Check if directory exists
+	if _, err := os.Stat(directory); os.IsNotExist(err) {
+		logger.Exitf(exitConfig, "Error: Directory does not exist: %s", directory)
+	}
+
+	if problems := checkHooks(cfg); len(problems) > 0 {
+		logger.Exitf(exitConfig, "Error: invalid hooks in %s:\n  %s", configFile, strings.Join(problems, "\n  "))
+	}
+	notifiers, err := newNotifiers(cfg)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: invalid notifications in %s: %v", configFile, err)
+	}
+
+	// Get all services with metadata
+	allServices := cfg.GetAllServices()
+
+	// Build service directories map
+	serviceDirs := make(map[string]string)
+	repoDirs := make(map[string]string)
+	serviceConfigs := make(map[string]gitlab.Service)
+	meshServices := make(map[string]bool)
+	buildTools := make(map[string]string)
+	mavenBuilds := make(map[string]maven.BuildOptions)
+	testModes := make(map[string]string)
+	buildRetries := make(map[string]int)
+	dependsOn := make(map[string][]string)
+	baseBranches := make(map[string]string)
+	serviceHooks := make(map[string][]config.Hook)
+
+	cloneOpts := cloneOptions{clone: cloneMissing, protocol: cloneProtocol, assumeYes: assumeYes}
+	for _, svcMeta := range allServices {
+		service := svcMeta.Service
+		serviceDir := filepath.Join(directory, service.Directory)
+
+		// A missing repository may be cloned from its GitLab project
+		ensureRepository(service, filepath.Join(directory, service.RepositoryDir()), cloneOpts)
+		if _, err := os.Stat(serviceDir); os.IsNotExist(err) && !plan.Enabled() {
+			logger.Exitf(exitConfig, "Service directory does not exist: %s", serviceDir)
+		}
+
+		serviceDirs[service.Name] = serviceDir
+		repoDirs[service.Name] = filepath.Join(directory, service.RepositoryDir())
+		meshServices[service.Name] = service.IsMesh
+		buildTools[service.Name] = service.BuildTool()
+		testModes[service.Name] = testMode(service, runTests.mode)
+		buildRetries[service.Name] = cfg.BuildRetriesFor(service)
+		dependsOn[service.Name] = service.DependsOn
+		// The JDK of a service must be installed before the release is tagged
+		javaHome := ""
+		if service.JDK != "" && service.BuildTool() == config.BuildMaven && (selected[phaseNumber("update-poms")] || selected[phaseNumber("build")]) {
+			javaHome, err = maven.FindJDK(service.JDK, cfg.JDKs)
+			if err != nil {
+				logger.Exitf(exitConfig, "Error: %s: %v", service.Name, err)
+			}
+		}
+		goals, args := cfg.MavenBuild(service)
+		mavenBuilds[service.Name] = maven.BuildOptions{
+			Invocation: maven.Invocation{
+				Profiles: mergeLists(splitList(mavenProfiles), service.Profiles),
+				Settings: cfg.MavenSettingsFor(service),
+				Command:  cfg.MavenCommandFor(service),
+				JavaHome: javaHome,
+				Offline:  mavenOffline,
+				Opts:     cfg.MavenOptsFor(service),
+			},
+			Goals:   goals,
+			Args:    args,
+			Modules: service.MavenModules,
+		}
+		baseBranches[service.Name] = service.BaseBranchOr(baseBranchStr)
+		serviceHooks[service.Name] = service.Hooks
+
+		// Convert to gitlab.Service
+		gitlabService := gitlab.Service{
+			Name:          service.Name,
+			Directory:     service.Directory,
+			GitlabProject: service.GitlabProject,
+			Group:         svcMeta.Group,
+			Sequential:    svcMeta.Sequential,
+		}
+		serviceConfigs[service.Name] = gitlabService
+	}
+
+	// Repositories with LFS files need git-lfs for checkout, pull and push
+	lfsRepos := make(map[string]bool)
+	var lfsServices []string
+	for _, svcMeta := range allServices {
+		if git.UsesLFS(repoDirs[svcMeta.Name]) {
+			lfsRepos[svcMeta.Name] = true
+			lfsServices = append(lfsServices, svcMeta.Name)
+		}
+	}
+	if len(lfsServices) > 0 {
+		if err := git.CheckLFS(); err != nil {
+			logger.Exitf(exitConfig, "Error: %s use Git LFS, but %v", strings.Join(lfsServices, ", "), err)
+		}
+	}
+
+	// A working copy cloned from another project would be tagged and pushed by mistake
+	checkRemotes(allServices, repoDirs)
+
+	// An offline build would fail on the first missing dependency, halfway through
+	if mavenOffline && (selected[phaseNumber("update-poms")] || selected[phaseNumber("build")]) {
+		checkOffline(allServices, serviceDirs, mavenBuilds)
+	}
+
+	// Extract service names for compatibility
+	services := make([]string, len(allServices))
+	for i, svcMeta := range allServices {
+		services[i] = svcMeta.Service.Name
+	}
+
+	if bump != "" {
+		current, err := latestReleaseVersion(services, serviceDirs, buildTools)
+		if err != nil {
+			logger.Exitf(exitConfig, "Failed to determine the current version for -bump: %v", err)
+		}
+		ver, _ = current.Bump(bump)
+		logger.Infof("Version %s: %s bump of %s", ver, bump, current)
+	}
+
+	// Hotfix mode: commit on the existing release branch with the next patch version
+	baseBranch := baseBranchStr
+	if hotfix {
+		if ver.Patch == 0 {
+			ver, err = nextHotfixVersion(ver, services, serviceDirs)
+			if err != nil {
+				logger.Fatalf("Failed to determine hotfix version: %v", err)
+			}
+		}
+		baseBranch = ver.ReleaseLine().Branch()
+	}
+	// Load or create the deployment state used for -resume. A redeployment with
+	// -from-tag keeps its own state next to that of the release.
+	stateKey := ver.String()
+	if fromTag != "" {
+		stateKey = ver.String() + "-redeploy"
+	}
+	if resume {
+		restoreRefDate(directory, stateKey)
+	}
+
+	tagName := ver.Tag()
+	if fromTag != "" {
+		logger.Infof("Checking that tag %s exists in every GitLab project...", fromTag)
+		missing, err := gitlab.ServicesWithoutTag(cfg, fromTag)
+		if err != nil {
+			logger.Fatalf("Failed to check tag %s: %v", fromTag, err)
+		}
+		if len(missing) > 0 {
+			logger.Exitf(exitConfig, "Error: tag %s does not exist in: %s", fromTag, strings.Join(missing, ", "))
+		}
+		tagName = fromTag
+	}
+
+	// Services with a version_override are released with their own version and tag;
+	// a redeployment with -from-tag uses the given tag for every service
+	var serviceVersions map[string]version.Version
+	if fromTag == "" {
+		serviceVersions, err = resolveServiceVersions(cfg, ver)
+		if err != nil {
+			logger.Exitf(exitConfig, "Error: %v", err)
+		}
+		maven.SetArtifactVersions(artifactVersions(cfg, ver, serviceVersions))
+	}
+
+	// Only one deployment at a time may work on the checkouts in directory.
+	// The lock is released on return, on panics in this goroutine, on fatal errors and
+	// on interrupts; a lock left by a crashed process is detected as stale.
+	var lk *lock.Lock
+	if !plan.Enabled() {
+		var stale *lock.Info
+		lk, stale, err = lock.Acquire(directory, ver.String())
+		if err != nil {
+			logger.Fatalf("Error: %v", err)
+		}
+		if stale != nil {
+			logger.Warnf("Warning: took over the stale lock of deployment %s (PID %d, started %s), the process is no longer running",
+				stale.Version, stale.PID, stale.StartedAt.Format("2006-01-02 15:04:05"))
+		}
+		defer lk.Release()
+		logger.OnFatal(func() { lk.Release() })
+	}
+
+	stateFile := state.FileName(directory, stateKey)
+	var st *state.State
+	switch {
+	case plan.Enabled():
+		st = state.New("", stateKey)
+	case resume:
+		st, err = state.Load(stateFile, stateKey)
+		if err != nil && retry {
+			logger.Exitf(exitConfig, "No deployment of %s to retry: %v", tagName, err)
+		}
+		if err != nil {
+			logger.Fatalf("Failed to load deployment state for -resume: %v", err)
+		}
+	default:
+		st = state.New(stateFile, stateKey)
+	}
+	if st.RefDate == "" {
+		st.RefDate = version.RefDate()
+	}
+	if st.AbortedAt != nil && !plan.Enabled() {
+		logger.Infof("Resuming deployment aborted at %s", st.AbortedAt.Format("2006-01-02 15:04:05"))
+		if err := st.ResumeAborted(); err != nil {
+			logger.Fatalf("Failed to save deployment state: %v", err)
+		}
+	}
+
+	// Refs are backed up in the working copies, which outlive -isolated clones
+	backupDirs := make(map[string]string, len(repoDirs))
+	for service, dir := range repoDirs {
+		backupDirs[service] = dir
+	}
+
+	// With -isolated the release works in clones of the repositories; the working copies
+	// in directory are only read
+	if isolated {
+		if scratchDir == "" {
+			scratchDir = defaultScratchDir(directory, ver)
+		}
+		if plan.Enabled() {
+			logger.Infof("Dry run: the repositories would be cloned into %s; the plan is made with the working copies in %s", scratchDir, directory)
+		} else {
+			logger.Infof("Isolated clones: %s", scratchDir)
+			isolate(scratchDir, directory, services, serviceDirs, repoDirs)
+			logger.OnFatal(func() {
+				logger.Noticef("The isolated clones are kept in %s for -resume", scratchDir)
+			})
+		}
+	}
+
+	// Pipeline IDs are saved as soon as they exist, so that deploy abort can cancel them
+	gitlab.OnPipelineCreated(func(p gitlab.CreatedPipeline) {
+		err := st.AddPipeline(state.Pipeline{Service: p.Service, Namespace: p.Namespace, Project: p.Project, ID: p.ID, WebURL: p.WebURL})
+		if err != nil {
+			logger.Warnf("Warning: failed to save pipeline %d of %s: %v", p.ID, p.Service, err)
+		}
+	})
+
+	// Print deployment configuration
+	logger.Infof("=== Deployment Configuration ===")
+	logger.Infof("Config File: %s", configFile)
+	logger.Infof("Directory: %s", directory)
+	logger.Infof("Version: %s", ver)
+	printServiceVersions(serviceVersions)
+	if hotfix {
+		logger.Infof("Hotfix of branch: %s", baseBranch)
+	} else if cfg.Env != nil && cfg.Env.BaseBranch != "" {
+		logger.Infof("Base Branch: %s (environment %s)", cfg.Env.BaseBranch, envName)
+	} else {
+		logger.Infof("Base Branch: %s", baseBranch)
+	}
+	if !hotfix {
+		def := baseBranch
+		if cfg.Env != nil && cfg.Env.BaseBranch != "" {
+			def = cfg.Env.BaseBranch
+		}
+		printBaseBranches(baseBranches, def)
+	}
+	if mavenOffline {
+		logger.Infof("Maven: offline, the Maven cache is not cleaned")
+	} else {
+		logger.Infof("Maven Cache Path: %s", mavenCachePath)
+	}
+	if mavenProfiles != "" {
+		logger.Infof("Maven Profiles: %s", strings.Join(splitList(mavenProfiles), ","))
+	}
+	if mavenDeploy {
+		target := "distributionManagement of the projects"
+		if url := cfg.MavenDeploy.ReleaseRepository.URL; url != "" {
+			target = url
+		}
+		logger.Infof("Maven Deploy: %s", target)
+	}
+	logger.Infof("POM Property Patterns: %s", strings.Join(propertyPatterns, ", "))
+	if envName != "" {
+		logger.Infof("Environment: %s", envName)
+	}
+	logger.Infof("Namespaces: %s", strings.Join(namespaces, ", "))
+	logger.Infof("Services: %d", len(services))
+	if concurrency > 1 {
+		logger.Infof("Concurrency: %d", concurrency)
+	}
+	if retry {
+		logger.Infof("Retrying service: %s", services[0])
+	}
+	if fromTag != "" {
+		logger.Infof("Redeploying tag: %s", fromTag)
+	}
+	if resume {
+		logger.Infof("Resuming from: %s", stateFile)
+	}
+	if fromPhase != "" || toPhase != "" || len(skipPhases) > 0 {
+		var numbers []string
+		for i := range phases {
+			if selected[i] {
+				numbers = append(numbers, strconv.Itoa(i))
+			}
+		}
+		logger.Infof("Phases: %s", strings.Join(numbers, ", "))
+	}
+	logger.Infof("================================")
+
+	d := &deployment{
+		cfg:              cfg,
+		services:         services,
+		directory:        directory,
+		serviceDirs:      serviceDirs,
+		repoDirs:         repoDirs,
+		lfsRepos:         lfsRepos,
+		backupDirs:       backupDirs,
+		backup:           newRefBackup(directory, ver.String()),
+		serviceHooks:     serviceHooks,
+		meshServices:     meshServices,
+		buildTools:       buildTools,
+		mavenBuilds:      mavenBuilds,
+		testModes:        testModes,
+		reuseUnchanged:   reuseUnchanged,
+		mavenDeploy:      deployTarget(cfg, mavenDeploy),
+		mavenOffline:     mavenOffline,
+		sbom:             sbom,
+		reusedBuilds:     make(map[string]string),
+		allowSnapshots:   allowSnapshots,
+		snapshotRefs:     make(map[string][]string),
+		testFailures:     make(map[string]int),
+		buildRetries:     buildRetries,
+		buildRetryDelay:  buildRetryDelay(cfg),
+		buildAttempts:    make(map[string]int),
+		dependsOn:        dependsOn,
+		version:          ver,
+		baseBranch:       baseBranch,
+		baseBranches:     baseBranches,
+		hotfix:           hotfix,
+		tagName:          tagName,
+		serviceVersions:  serviceVersions,
+		mavenCachePath:   mavenCachePath,
+		propertyPatterns: propertyPatterns,
+		namespaces:       namespaces,
+		st:               st,
+		assumeYes:        assumeYes,
+		onDirty:          onDirty,
+		concurrency:      concurrency,
+		autoApprove:      autoApprove || assumeYes,
+		startedAt:        time.Now(),
+		buildTimes:       make(map[string]buildTime),
+		stashes:          make(map[string]string),
+		notifiers:        notifiers,
+		phaseTimeouts:    phaseLimits,
+		bumped:           bump != "",
+		fromTag:          fromTag,
+		keepGoing:        keepGoing,
+		failures:         make(map[string]serviceFailure),
+		selectedServices: selectedServices,
+		retry:            retry,
+	}
+	// The traces of failed pipeline jobs are kept next to the build logs, under the
+	// version decided by -bump or -hotfix
+	gitlab.SetTraceDir(filepath.Join("logs", d.version.String()))
+	if useTUI && tui.IsTerminal(os.Stdout) && !logger.JSON() {
+		d.startBoard()
+	}
+	d.interrupts = watchInterrupts(cancelPipelines, func() {
+		d.printSummary()
+		if isolated && !plan.Enabled() {
+			logger.Noticef("The isolated clones are kept in %s for -resume", scratchDir)
+		}
+		d.finish("interrupted")
+		lk.Release()
+	})
+	// The report and release notes are collected after the phase timeout is lifted
+	logger.OnFatal(command.EndPhase)
+	logger.OnFatal(func() {
+		if !d.interrupts.requested() {
+			d.printStashes()
+			d.finish("failed")
+		}
+	})
+	d.runPhases(selected)
+	d.stopBoard()
+	d.printStashes()
+	d.printTestFailures()
+	d.printBuildTimes()
+	d.finish("success")
+	if isolated && !plan.Enabled() {
+		removeScratch(scratchDir)
+	}
+
+	if plan.Enabled() {
+		logger.Noticef("\nDry run completed, no changes were made.")
+		return
+	}
+	if retry {
+		logger.Noticef("\nRetry of %s completed successfully!", services[0])
+		return
+	}
+	logger.Noticef("\nDeployment script completed successfully!")
+}
+
+// buildRetryDelay returns the delay before the first retry of a failed build:
+// build_retry_delay, 30s by default. loadConfig has checked the value.
+func buildRetryDelay(cfg *config.Config) time.Duration {
+	if delay, _ := parseTimeout(cfg.BuildRetryDelay); delay > 0 {
+		return delay
+	}
+	return 30 * time.Second
+}
+
+// checkOffline exits if the dependencies of a Maven service are not all in the local
+// repository for -maven-offline
+func checkOffline(services []config.ServiceWithMeta, serviceDirs map[string]string, builds map[string]maven.BuildOptions) {
+	logger.Infof("Checking that the dependencies of the Maven services are in the local repository...")
+	var problems []string
+	for _, svc := range services {
+		if svc.BuildTool() != config.BuildMaven {
+			continue
+		}
+		if err := maven.CheckOffline(serviceDirs[svc.Name], builds[svc.Name].Invocation); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", svc.Name, err))
+		}
+	}
+	if len(problems) > 0 {
+		logger.Exitf(exitConfig, "Error: -maven-offline, but dependencies are missing from the local repository:\n  %s\nRun the build once online or mvn dependency:go-offline", strings.Join(problems, "\n  "))
+	}
+}
+
+// checkRemotes exits if the origin of a service repository is not its gitlab_project
+func checkRemotes(services []config.ServiceWithMeta, repoDirs map[string]string) {
+	var problems []string
+	checked := make(map[string]bool)
+	for _, svcMeta := range services {
+		project := svcMeta.Service.GitlabProject
+		repoDir := repoDirs[svcMeta.Name]
+		if project == "" || checked[repoDir] {
+			continue
+		}
+		checked[repoDir] = true
+		if _, err := os.Stat(repoDir); os.IsNotExist(err) && plan.Enabled() {
+			continue // cloned by the plan
+		}
+		remote, err := git.RemoteURL(repoDir)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", svcMeta.Name, err))
+		case !remoteMatchesProject(remote, project):
+			problems = append(problems, fmt.Sprintf("%s: origin %s is not the GitLab project %s", svcMeta.Name, remote, project))
+		}
+	}
+	if len(problems) > 0 {
+		logger.Exitf(exitConfig, "Error: working copies do not match deploy.yaml:\n  %s", strings.Join(problems, "\n  "))
+	}
+}
+
+// selectServices asks which of the configured services to deploy. It returns the
+// configuration restricted to them and their comma-separated names, or the
+// configuration unchanged and an empty string if all services were kept.
+func selectServices(cfg *config.Config) (*config.Config, string) {
+	all := cfg.GetAllServices()
+	choices := make([]tui.Choice, len(all))
+	for i, svc := range all {
+		choices[i] = tui.Choice{Name: svc.Name, Group: svc.Group}
+	}
+
+	names, err := tui.Checklist("Services to deploy:", "sequential", choices, os.Stdin, os.Stdout)
+	if err != nil {
+		logger.Exitf(exitAborted, "Service selection cancelled: %v", err)
+	}
+	if len(names) == len(all) {
+		return cfg, ""
+	}
+
+	filtered, err := cfg.FilterServices(names)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+	selected := strings.Join(names, ",")
+	logger.Infof("Selected services: %s", selected)
+	return filtered, selected
+}
+
+// restoreRefDate names refs with the date saved in the state of the deployment that is
+// resumed or continued, so that refs named with {{.Date}} on an earlier day are found
+func restoreRefDate(directory, stateKey string) {
+	stateFile := state.FileName(directory, stateKey)
+	if st, err := state.Load(stateFile, stateKey); err == nil && st.RefDate != "" {
+		version.SetRefDate(st.RefDate)
+		return
+	}
+	if version.RefsUseDate() {
+		logger.Warnf("Warning: %s has no date of the deployment, refs are named with today's date %s", stateFile, version.RefDate())
+	}
+}
+
+// latestReleaseVersion returns the highest release version of the services: the
+// highest version tag on origin, or the project version of a service without tags
+func latestReleaseVersion(services []string, serviceDirs, buildTools map[string]string) (version.Version, error) {
+	var latest version.Version
+	for _, service := range services {
+		tags, err := git.ListRemoteTags(serviceDirs[service], "*")
+		if err != nil {
+			return version.Version{}, fmt.Errorf("%s: %v", service, err)
+		}
+
+		var current version.Version
+		found := false
+		for _, tag := range tags {
+			v, ok := version.ParseTag(tag, service)
+			if !ok {
+				continue
+			}
+			if !found || current.Less(v) {
+				current, found = v, true
+			}
+		}
+		if !found {
+			v, ok, err := builders[buildTools[service]].projectVersion(serviceDirs[service])
+			if err != nil || !ok {
+				return version.Version{}, fmt.Errorf("%s: no release tags on origin and no project version in its %s build files", service, buildTools[service])
+			}
+			logger.Infof("  %s: no release tags, using project version %s", service, v)
+			current = v
+		}
+		if latest.Less(current) {
+			latest = current
+		}
+	}
+	return latest, nil
+}
+
+// resolveServiceVersions returns the versions of the services with a version_override,
+// expanded against the release version
+func resolveServiceVersions(cfg *config.Config, release version.Version) (map[string]version.Version, error) {
+	versions := make(map[string]version.Version)
+	for _, svc := range cfg.GetAllServices() {
+		if svc.VersionOverride == "" {
+			continue
+		}
+		v, err := version.Expand(svc.VersionOverride, release)
+		if err != nil {
+			return nil, fmt.Errorf("%s: version_override: %v", svc.Name, err)
+		}
+		versions[svc.Name] = v
+	}
+	return versions, nil
+}
+
+// artifactVersions returns the versions of the artifacts of the services, by the
+// groupId:artifactId of their artifacts key: the services depending on them get the
+// version they are released with
+func artifactVersions(cfg *config.Config, release version.Version, serviceVersions map[string]version.Version) map[string]string {
+	versions := make(map[string]string)
+	for _, svc := range cfg.GetAllServices() {
+		v := release
+		if own, ok := serviceVersions[svc.Name]; ok {
+			v = own
+		}
+		for _, artifact := range svc.Artifacts {
+			versions[artifact] = v.String()
+		}
+	}
+	return versions
+}
+
+// printServiceVersions lists the services released with their own version
+func printServiceVersions(versions map[string]version.Version) {
+	var names []string
+	for name := range versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		logger.Infof("Version of %s: %s (version_override)", name, versions[name])
+	}
+}
+
+// printBaseBranches lists the services released from their own base_branch
+func printBaseBranches(branches map[string]string, def string) {
+	var names []string
+	for name, branch := range branches {
+		if branch != def {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		logger.Infof("Base Branch of %s: %s (base_branch)", name, branches[name])
+	}
+}
+
+// releaseRefs returns the ref the pipelines of every service run on: the tag of the
+// release, or of the service's own version, or the tag redeployed with -from-tag
+func releaseRefs(cfg *config.Config, release version.Version, fromTag string, versions map[string]version.Version) map[string]string {
+	refs := make(map[string]string)
+	for _, svc := range cfg.GetAllServices() {
+		switch v, ok := versions[svc.Name]; {
+		case fromTag != "":
+			refs[svc.Name] = fromTag
+		case ok:
+			refs[svc.Name] = v.ServiceTag(svc.Name)
+		default:
+			refs[svc.Name] = release.ServiceTag(svc.Name)
+		}
+	}
+	return refs
+}
+
+// nextHotfixVersion finds the highest patch version of the release line tagged in any
+// service repository and returns the next one, so that all services share one hotfix tag
+func nextHotfixVersion(release version.Version, services []string, serviceDirs map[string]string) (version.Version, error) {
+	next := release.ReleaseLine().NextPatch()
+	pattern := fmt.Sprintf("%d.%d.*", release.Major, release.Minor)
+	if version.HasTagTemplate() {
+		// Named tags are filtered by their parsed version below
+		pattern = "*"
+	}
+
+	for _, service := range services {
+		tags, err := git.ListRemoteTags(serviceDirs[service], pattern)
+		if err != nil {
+			return version.Version{}, fmt.Errorf("%s: %v", service, err)
+		}
+		for _, tag := range tags {
+			v, ok := version.ParseTag(tag, service)
+			if !ok || v.ReleaseLine() != release.ReleaseLine() {
+				continue
+			}
+			if v.Patch >= next.Patch {
+				next = v.NextPatch()
+			}
+		}
+	}
+
+	return next, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"deploy/git"
+	"deploy/gitlab"
+	"deploy/logger"
+	"deploy/notes"
+	"deploy/plan"
+)
+
+// deployReport is the machine-readable outcome of a full deployment,
+// written to deploy-report-<version>.json at the end of the run
+type deployReport struct {
+	Version    string          `json:"version"`
+	Tag        string          `json:"tag"`
+	Branch     string          `json:"branch"`
+	Hotfix     bool            `json:"hotfix,omitempty"`
+	Namespaces []string        `json:"namespaces"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Status     string          `json:"status"`             // success, failed, interrupted or aborted
+	Error      string          `json:"error,omitempty"`    // error that stopped a failed deployment
+	Warnings   []string        `json:"warnings,omitempty"` // problems that did not stop it, like failed tests with run_tests: warn
+	Services   []serviceReport `json:"services"`
+	Tasks      []string        `json:"tasks"`             // release notes task IDs of all services
+	Retried    []string        `json:"retried,omitempty"` // services re-run with "deploy retry"
+	Builds     []buildTiming   `json:"builds,omitempty"`  // builds of the services, longest first
+}
+
+// serviceReport is the outcome of a single service
+type serviceReport struct {
+	Name            string                  `json:"name"`
+	Version         string                  `json:"version,omitempty"` // own version of the service (version_override)
+	Commit          string                  `json:"commit,omitempty"`  // full SHA the tag (or HEAD) points to
+	Tag             string                  `json:"tag,omitempty"`     // set if the release tag exists
+	CompletedPhases []string                `json:"completed_phases"`
+	BuildSeconds    float64                 `json:"build_seconds,omitempty"`
+	BuildStartedAt  *time.Time              `json:"build_started_at,omitempty"`
+	BuildFinishedAt *time.Time              `json:"build_finished_at,omitempty"`
+	TestFailures    int                     `json:"test_failures,omitempty"`  // failed tests of a build with run_tests: warn
+	BuildAttempts   int                     `json:"build_attempts,omitempty"` // builds run, more than 1 if a failed build was retried (build_retries)
+	BuildLog        string                  `json:"build_log,omitempty"`      // file with the build output
+	SBOM            string                  `json:"sbom,omitempty"`           // CycloneDX SBOM of the build (-sbom)
+	ReusedBuild     string                  `json:"reused_build,omitempty"`   // previous release tag whose artifact was kept (-reuse-unchanged)
+	Snapshots       []string                `json:"snapshots,omitempty"`      // SNAPSHOT versions left in the poms (-allow-snapshots)
+	Pipelines       []gitlab.PipelineResult `json:"pipelines,omitempty"`
+	Tasks           []string                `json:"tasks"`
+	Authors         []string                `json:"authors,omitempty"`        // authors of the released commits
+	MergeRequests   []string                `json:"merge_requests,omitempty"` // links to the merge requests the commits came from
+	Error           string                  `json:"error,omitempty"`          // failure that excluded the service (-keep-going)
+}
+
+// reportFileName returns the name of the report file for the deployed version;
+// a redeployment with -from-tag does not overwrite the report of the release
+func (d *deployment) reportFileName() string {
+	if d.fromTag != "" {
+		return fmt.Sprintf("deploy-report-%s-redeploy.json", d.version)
+	}
+	return fmt.Sprintf("deploy-report-%s.json", d.version)
+}
+
+// finish writes the deployment report and sends notifications with the given status.
+// Nothing is reported in dry-run mode.
+func (d *deployment) finish(status string) {
+	if plan.Enabled() {
+		return
+	}
+	// Pipelines canceled by deploy abort fail the run, which is reported as aborted
+	if status != "success" && d.st.Aborted() {
+		status = "aborted"
+	}
+	report, release := d.buildReport(status)
+	if d.retry {
+		report = d.mergeReport(report)
+	}
+	d.writeReport(report)
+	d.notifyFinished(report, release)
+}
+
+// buildReport collects the outcome of every service. The release notes are nil if
+// they could not be collected; errors are only reported: the report must not hide
+// the result of the deployment.
+func (d *deployment) buildReport(status string) (*deployReport, *notes.Release) {
+	report := &deployReport{
+		Version:    d.version.String(),
+		Tag:        d.tagName,
+		Branch:     d.version.Branch(),
+		Hotfix:     d.hotfix,
+		Namespaces: d.namespaces,
+		StartedAt:  d.startedAt,
+		FinishedAt: time.Now(),
+		Status:     status,
+		Tasks:      []string{},
+	}
+	if status == "failed" {
+		report.Error = logger.FatalMessage()
+	}
+	if d.hotfix {
+		report.Branch = d.baseBranch
+	}
+
+	pipelines := make(map[string][]gitlab.PipelineResult)
+	for _, p := range gitlab.PipelineResults() {
+		pipelines[p.Service] = append(pipelines[p.Service], p)
+	}
+
+	var services []notes.Service
+	for _, service := range d.services {
+		svc := notes.Service{Name: service, Dir: d.serviceDirs[service], Project: d.gitlabProject(service), Branch: d.baseBranchFor(service)}
+		if v, ok := d.serviceVersions[service]; ok {
+			svc.Version = &v
+		}
+		services = append(services, svc)
+	}
+	tasks := make(map[string][]string)
+	changes := make(map[string]notes.ServiceNotes)
+	release, err := notes.Collect(services, d.version, "")
+	if err != nil {
+		logger.Warnf("Warning: failed to collect release notes tasks for the report: %v", err)
+		release = nil
+	} else {
+		readIssues(d.cfg, release)
+		report.Tasks = append(report.Tasks, release.Tasks...)
+		for _, svc := range release.Services {
+			tasks[svc.Name] = svc.Tasks
+			changes[svc.Name] = svc
+		}
+	}
+
+	for _, service := range d.services {
+		dir := d.serviceDirs[service]
+		svc := serviceReport{
+			Name:            service,
+			CompletedPhases: []string{},
+			Pipelines:       pipelines[service],
+			Tasks:           append([]string{}, tasks[service]...),
+			Authors:         changes[service].Authors,
+		}
+		for _, iid := range changes[service].MergeRequests {
+			svc.MergeRequests = append(svc.MergeRequests, gitlab.MergeRequestURL(d.gitlabProject(service), iid))
+		}
+		if v, ok := d.serviceVersions[service]; ok {
+			svc.Version = v.String()
+		}
+		if f, ok := d.failure(service); ok {
+			svc.Error = fmt.Sprintf("%s: %s", f.phase, f.err)
+		}
+
+		ref := "HEAD"
+		if tag := d.tagFor(service); git.RefExists(dir, tag) {
+			svc.Tag = tag
+			ref = tag
+		}
+		if commit, err := git.ResolveCommit(dir, ref); err == nil {
+			svc.Commit = commit
+		}
+
+		for _, p := range phases {
+			if d.st.Done(p.name, service) {
+				svc.CompletedPhases = append(svc.CompletedPhases, p.name)
+			}
+		}
+		d.failMu.Lock()
+		if t, ok := d.buildTimes[service]; ok {
+			svc.BuildSeconds = t.finished.Sub(t.started).Round(time.Millisecond).Seconds()
+			svc.BuildStartedAt, svc.BuildFinishedAt = &t.started, &t.finished
+		}
+		svc.TestFailures = d.testFailures[service]
+		svc.BuildAttempts = d.buildAttempts[service]
+		svc.ReusedBuild = d.reusedBuilds[service]
+		svc.Snapshots = d.snapshotRefs[service]
+		d.failMu.Unlock()
+		// A resumed deployment reports the logs of the builds of the failed run too
+		if _, err := os.Stat(d.buildLogFile(service)); err == nil {
+			svc.BuildLog = d.buildLogFile(service)
+		}
+		if _, err := os.Stat(d.sbomFile(service)); err == nil {
+			svc.SBOM = d.sbomFile(service)
+		}
+
+		report.Services = append(report.Services, svc)
+	}
+	report.Warnings = reportWarnings(report.Services)
+	report.Builds = buildTimings(report.Services)
+	return report, release
+}
+
+// reportWarnings returns a warning for every service whose build had failed tests or
+// only succeeded when retried, or that was released with SNAPSHOT versions
+func reportWarnings(services []serviceReport) []string {
+	var warnings []string
+	for _, svc := range services {
+		if svc.TestFailures > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s: %d test(s) failed, released with run_tests: warn", svc.Name, svc.TestFailures))
+		}
+		if svc.BuildAttempts > 1 && svc.Error == "" {
+			warnings = append(warnings, fmt.Sprintf("%s: built on attempt %d (build_retries)", svc.Name, svc.BuildAttempts))
+		}
+		if len(svc.Snapshots) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s: %d SNAPSHOT version(s) left in the poms, released with -allow-snapshots", svc.Name, len(svc.Snapshots)))
+		}
+	}
+	return warnings
+}
+
+// mergeReport puts the outcome of the retried service into the report of the original
+// run. The deployment succeeds once no other service is behind the retried one.
+func (d *deployment) mergeReport(retried *deployReport) *deployReport {
+	data, err := ioutil.ReadFile(d.reportFileName())
+	if err != nil {
+		logger.Warnf("Warning: no report of the original run to merge into: %v", err)
+		return retried
+	}
+	var merged deployReport
+	if err := json.Unmarshal(data, &merged); err != nil {
+		logger.Warnf("Warning: failed to parse the report of the original run: %v", err)
+		return retried
+	}
+
+	svc := retried.Services[0]
+	replaced := false
+	for i := range merged.Services {
+		if merged.Services[i].Name == svc.Name {
+			merged.Services[i] = svc
+			replaced = true
+		}
+	}
+	if !replaced {
+		merged.Services = append(merged.Services, svc)
+	}
+	merged.Retried = append(merged.Retried, svc.Name)
+	merged.Warnings = reportWarnings(merged.Services)
+	merged.Builds = buildTimings(merged.Services)
+	merged.FinishedAt = retried.FinishedAt
+
+	tasks := make(map[string]bool)
+	for _, task := range append(merged.Tasks, retried.Tasks...) {
+		tasks[task] = true
+	}
+	merged.Tasks = []string{}
+	for task := range tasks {
+		merged.Tasks = append(merged.Tasks, task)
+	}
+	sort.Strings(merged.Tasks)
+
+	merged.Status = retried.Status
+	merged.Error = retried.Error
+	if retried.Status == "success" {
+		var behind []string
+		for _, other := range merged.Services {
+			if len(other.CompletedPhases) < len(svc.CompletedPhases) {
+				behind = append(behind, other.Name)
+			}
+		}
+		if len(behind) > 0 {
+			merged.Status = "failed"
+			merged.Error = fmt.Sprintf("services not completed: %s", strings.Join(behind, ", "))
+		}
+	}
+	return &merged
+}
+
+// writeReport writes the report to deploy-report-<version>.json
+func (d *deployment) writeReport(report *deployReport) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		logger.Warnf("Warning: failed to encode deployment report: %v", err)
+		return
+	}
+	filename := d.reportFileName()
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		logger.Warnf("Warning: failed to write deployment report: %v", err)
+		return
+	}
+	logger.Noticef("Deployment report written to %s", filename)
+}
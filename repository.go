@@ -0,0 +1,52 @@
+package main
+
+// repositories groups the active services by git repository, in deployment order.
+// The first service of every repository runs the git operations of the repository;
+// the others are modules of the same repository that follow its result.
+func (d *deployment) repositories() (primaries []string, modules map[string][]string) {
+	modules = make(map[string][]string)
+	primaryOf := make(map[string]string) // repository directory -> first service
+	for _, service := range d.activeServices() {
+		dir := d.repoDirs[service]
+		primary, ok := primaryOf[dir]
+		if !ok {
+			primaryOf[dir] = service
+			primaries = append(primaries, service)
+			continue
+		}
+		modules[primary] = append(modules[primary], service)
+	}
+	return primaries, modules
+}
+
+// forEachRepository runs fn like forEachService, but once per git repository, for its
+// first service. The other services of a shared repository complete the phase with it,
+// or fail with it in a -keep-going deployment.
+func (d *deployment) forEachRepository(phase string, fn func(service string)) {
+	primaries, modules := d.repositories()
+	d.forEachServiceOf(primaries, fn)
+	d.followRepository(phase, primaries, modules)
+}
+
+// followRepository applies the outcome of the phase for the first service of every
+// shared repository to the other services of the repository. A failed first service
+// was recorded rather than stopping the deployment (-keep-going or a parallel step),
+// so the failures of its modules are recorded too and reported together after the phase.
+func (d *deployment) followRepository(phase string, primaries []string, modules map[string][]string) {
+	parallel := d.parallel
+	d.parallel = true
+	defer func() { d.parallel = parallel }()
+
+	for _, primary := range primaries {
+		for _, service := range modules[primary] {
+			if f, failed := d.failure(primary); failed {
+				d.failService(service, f.code, "%s: the repository shared with %s failed: %s", service, primary, f.err)
+				continue
+			}
+			if d.st.Done(phase, primary) && !d.st.Done(phase, service) {
+				d.logFor(service).Infof("  %s: %s done with %s (shared repository)", service, phase, primary)
+				d.markDone(phase, service)
+			}
+		}
+	}
+}
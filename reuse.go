@@ -0,0 +1,74 @@
+package main
+
+import (
+	"deploy/config"
+	"deploy/git"
+	"deploy/maven"
+	"deploy/version"
+)
+
+// reusableBuild returns the previous release tag of the service if -reuse-unchanged
+// may skip its build: it has no commits since that release, the artifact of the
+// release is in the local Maven repository and no service of this run depends on it,
+// as they need the artifact at the new version. Merge commits do not count: the
+// back-merge of the previous release brings nothing new.
+func (d *deployment) reusableBuild(service string) (string, bool) {
+	if !d.reuseUnchanged || d.buildTools[service] != config.BuildMaven {
+		return "", false
+	}
+	log := d.logFor(service)
+	for _, other := range d.services {
+		for _, dep := range d.dependsOn[other] {
+			if dep == service {
+				log.Infof("  %s depends on %s, rebuilding it", other, service)
+				return "", false
+			}
+		}
+	}
+
+	dir := d.repoDirs[service]
+	tag, found, err := git.GetPreviousReleaseTag(dir, service, d.versionFor(service))
+	if err != nil {
+		log.Warnf("  Warning: %v, rebuilding %s", err, service)
+		return "", false
+	}
+	if !found {
+		return "", false
+	}
+	previous, ok := version.ParseTag(tag, service)
+	if !ok {
+		return "", false
+	}
+
+	base := d.baseBranchFor(service)
+	if err := git.EnsureHistory(dir, tag, base); err != nil {
+		log.Warnf("  Warning: %v, rebuilding %s", err, service)
+		return "", false
+	}
+	commits, err := git.GetCommitsBetween(dir, tag, base)
+	if err != nil {
+		log.Warnf("  Warning: %v, rebuilding %s", err, service)
+		return "", false
+	}
+	changes := 0
+	for _, commit := range commits {
+		if !commit.Merge {
+			changes++
+		}
+	}
+	if changes > 0 {
+		log.Infof("  %d commit(s) since %s, rebuilding %s", changes, tag, service)
+		return "", false
+	}
+
+	path, ok, err := maven.InstalledArtifact(d.serviceDirs[service], previous)
+	if err != nil {
+		log.Warnf("  Warning: %v, rebuilding %s", err, service)
+		return "", false
+	}
+	if !ok {
+		log.Infof("  No commits since %s, but %s is not installed, rebuilding %s", tag, path, service)
+		return "", false
+	}
+	return tag, true
+}
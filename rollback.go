@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"deploy/gitlab"
+	"deploy/logger"
+	"deploy/plan"
+	"deploy/version"
+)
+
+// rollbackRecord describes a rollback run, saved so that it is known afterwards what was redeployed
+type rollbackRecord struct {
+	Version    string            `json:"version"`
+	Namespaces []string          `json:"namespaces"`
+	Tags       map[string]string `json:"tags"` // service -> tag that was redeployed
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// runRollback implements "deploy rollback": for every service it finds the release tag
+// preceding -version and re-runs the GitLab pipelines on it. No git changes are made.
+func runRollback(args []string) {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	var logOpts logFlags
+	logOpts.register(fs)
+	var (
+		configFile   string
+		versionStr   string
+		namespaceStr string
+		servicesStr  string
+		envName      string
+		dryRun       bool
+	)
+	fs.StringVar(&configFile, "config", "", "Path to YAML configuration file (default: $DEPLOY_CONFIG or deploy.yaml)")
+	fs.StringVar(&configFile, "c", "", "Path to YAML configuration file (shorthand)")
+	fs.StringVar(&versionStr, "version", "", "Version being rolled back (required)")
+	fs.StringVar(&versionStr, "v", "", "Version being rolled back (shorthand)")
+	fs.StringVar(&namespaceStr, "namespace", "", "Helm namespace(s), comma-separated (required unless -env defines them)")
+	fs.StringVar(&namespaceStr, "n", "", "Helm namespace(s), comma-separated (shorthand)")
+	fs.StringVar(&servicesStr, "services", "", "Roll back only these services, comma-separated names or globs")
+	fs.StringVar(&envName, "env", "", "Environment profile from the config, e.g. staging or production")
+	fs.BoolVar(&dryRun, "dry-run", false, "Print the tags and pipelines without triggering them")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s rollback [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Redeploys, for every service, the release tag preceding -version.\n\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExample:\n")
+		fmt.Fprintf(os.Stderr, "  %s rollback -c deploy.yaml -v 123 -n test,prod\n", os.Args[0])
+	}
+	fs.Parse(args)
+	logOpts.apply()
+
+	if versionStr == "" {
+		logger.Exitf(exitConfig, "Error: -version parameter is required\n\nUse -h for help")
+	}
+	ver, err := version.Parse(versionStr)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+
+	cfg, configFile := loadConfig(configFile, "", envName, servicesStr)
+	namespaces := resolveNamespaces(namespaceStr, cfg)
+	if len(namespaces) == 0 {
+		logger.Exitf(exitConfig, "Error: -namespace parameter is required (or an -env with namespaces)\n\nUse -h for help")
+	}
+
+	if dryRun {
+		plan.Enable()
+		logger.Infof("*** DRY RUN: no pipelines will be triggered ***")
+	}
+
+	logger.Infof("=== Rollback ===")
+	logger.Infof("Config File: %s", configFile)
+	logger.Infof("Rolling back version: %s", ver)
+	logger.Infof("Namespaces: %s", strings.Join(namespaces, ", "))
+	logger.Infof("================\n")
+
+	logger.Infof("Resolving previous release tags...")
+	serviceVersions, err := resolveServiceVersions(cfg, ver)
+	if err != nil {
+		logger.Exitf(exitConfig, "Error: %v", err)
+	}
+	tags, err := gitlab.FindPreviousTags(cfg, ver, serviceVersions)
+	if err != nil {
+		logger.Fatalf("Failed to resolve previous tags: %v", err)
+	}
+
+	var names []string
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		logger.Infof("  %s -> %s", name, tags[name])
+	}
+
+	record := rollbackRecord{
+		Version:    ver.String(),
+		Namespaces: namespaces,
+		Tags:       tags,
+		StartedAt:  time.Now(),
+	}
+
+	gitlab.SetTraceDir(filepath.Join("logs", ver.String()))
+	logger.Infof("\nCreating GitLab pipelines on previous tags...")
+	err = gitlab.CreatePipelinesForRefs(cfg, tags, namespaces)
+
+	if plan.Enabled() {
+		logger.Infof("\nDry run completed, no pipelines were triggered.")
+		return
+	}
+
+	record.FinishedAt = time.Now()
+	record.Status = "success"
+	if err != nil {
+		record.Status = "failed"
+		record.Error = err.Error()
+	}
+	recordFile := fmt.Sprintf("deploy-rollback-%s-%s.json", ver, record.StartedAt.Format("20060102-150405"))
+	if data, merr := json.MarshalIndent(record, "", "  "); merr == nil {
+		if werr := ioutil.WriteFile(recordFile, data, 0644); werr != nil {
+			logger.Warnf("Warning: failed to write rollback record: %v", werr)
+		} else {
+			logger.Infof("\nRollback record written to %s", recordFile)
+		}
+	}
+
+	if err != nil {
+		logger.Exitf(exitPipeline, "Rollback failed: %v", err)
+	}
+	logger.Noticef("\nRollback completed successfully!")
+}
@@ -0,0 +1,204 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State records which phases have been completed for which services,
+// so that a failed deployment can be resumed from where it stopped
+type State struct {
+	Version   string                     `json:"version"`
+	UpdatedAt time.Time                  `json:"updated_at"`
+	Completed map[string]map[string]bool `json:"completed"`            // phase -> service -> done
+	Pipelines []Pipeline                 `json:"pipelines,omitempty"`  // created by the deployment, for deploy abort
+	AbortedAt *time.Time                 `json:"aborted_at,omitempty"` // set by deploy abort
+	RefDate   string                     `json:"ref_date,omitempty"`   // date refs are named with, for {{.Date}}
+
+	path    string
+	owned   bool      // the saved file is this state's, not one of an earlier run
+	written time.Time // UpdatedAt of the last save or load
+	mu      sync.Mutex
+}
+
+// Pipeline is a GitLab pipeline created by the deployment
+type Pipeline struct {
+	Service   string `json:"service"`
+	Namespace string `json:"namespace"`
+	Project   string `json:"project"`
+	ID        int    `json:"id"`
+	WebURL    string `json:"web_url,omitempty"`
+}
+
+// global is the key used for phase steps that are not tied to a single service
+const global = "*"
+
+// FileName returns the state file path for a version inside the given directory
+func FileName(dir string, version string) string {
+	return filepath.Join(dir, fmt.Sprintf(".deploy-state-%s.json", version))
+}
+
+// New creates an empty state that will be saved to path.
+// An empty path keeps the state in memory only (used in dry-run mode).
+func New(path string, version string) *State {
+	return &State{
+		Version:   version,
+		Completed: make(map[string]map[string]bool),
+		path:      path,
+	}
+}
+
+// Load reads a previously saved state file
+func Load(path string, version string) (*State, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	st := New(path, version)
+	st.owned = true
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %v", path, err)
+	}
+	st.written = st.UpdatedAt
+	if st.Version != version {
+		return nil, fmt.Errorf("state file %s belongs to version %s, not %s", path, st.Version, version)
+	}
+	if st.Completed == nil {
+		st.Completed = make(map[string]map[string]bool)
+	}
+	return st, nil
+}
+
+// Done reports whether the phase has been completed for the service
+func (s *State) Done(phase, service string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Completed[phase][service]
+}
+
+// DoneGlobal reports whether a phase step not tied to a service has been completed
+func (s *State) DoneGlobal(phase string) bool {
+	return s.Done(phase, global)
+}
+
+// Started reports whether the phase has been completed for at least one service
+func (s *State) Started(phase string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.Completed[phase]) > 0
+}
+
+// MarkDone records that the phase has been completed for the service and saves the state
+func (s *State) MarkDone(phase, service string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Completed[phase] == nil {
+		s.Completed[phase] = make(map[string]bool)
+	}
+	s.Completed[phase][service] = true
+	return s.save()
+}
+
+// MarkDoneGlobal records that a phase step not tied to a service has been completed
+func (s *State) MarkDoneGlobal(phase string) error {
+	return s.MarkDone(phase, global)
+}
+
+// AddPipeline records a pipeline created by the deployment and saves the state
+func (s *State) AddPipeline(p Pipeline) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Pipelines = append(s.Pipelines, p)
+	return s.save()
+}
+
+// CreatedPipelines returns the pipelines recorded with AddPipeline
+func (s *State) CreatedPipelines() []Pipeline {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Pipeline(nil), s.Pipelines...)
+}
+
+// MarkAborted records that the deployment was aborted and saves the state
+func (s *State) MarkAborted() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.AbortedAt = &now
+	return s.save()
+}
+
+// ResumeAborted clears the abort of a deployment that is resumed and saves the state
+func (s *State) ResumeAborted() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.AbortedAt = nil
+	return s.save()
+}
+
+// Aborted reports whether the deployment was aborted. The saved file is read again,
+// since deploy abort runs in another process than the deployment it aborts.
+func (s *State) Aborted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.AbortedAt == nil {
+		s.AbortedAt = s.abortedSince()
+	}
+	return s.AbortedAt != nil
+}
+
+// abortedSince returns the abort time in the saved file if deploy abort has written
+// it after this state was last saved or loaded. Caller must hold s.mu.
+func (s *State) abortedSince() *time.Time {
+	if s.path == "" || !s.owned {
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil
+	}
+	var saved State
+	if json.Unmarshal(data, &saved) != nil || saved.AbortedAt == nil || !saved.AbortedAt.After(s.written) {
+		return nil
+	}
+	return saved.AbortedAt
+}
+
+// save writes the state file atomically. Caller must hold s.mu.
+func (s *State) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	if s.AbortedAt == nil {
+		// Keep an abort recorded by deploy abort while this deployment was running
+		s.AbortedAt = s.abortedSince()
+	}
+	s.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.owned = true
+	s.written = s.UpdatedAt
+	return nil
+}
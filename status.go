@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"deploy/git"
+	"deploy/gitlab"
+	"deploy/logger"
+	"deploy/version"
+)
+
+// runStatus implements "deploy status": a read-only overview of every service working copy,
+// its release branch and tag, and the last pipeline of its GitLab project
+func runStatus(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	var logOpts logFlags
+	logOpts.register(fs)
+	var (
+		configFile  string
+		directory   string
+		servicesStr string
+		envName     string
+		versionStr  string
+		noGitlab    bool
+	)
+	fs.StringVar(&configFile, "config", "", "Path to YAML configuration file (default: $DEPLOY_CONFIG or deploy.yaml)")
+	fs.StringVar(&configFile, "c", "", "Path to YAML configuration file (shorthand)")
+	fs.StringVar(&directory, "directory", "", "Base directory for services (required)")
+	fs.StringVar(&directory, "d", "", "Base directory for services (shorthand)")
+	fs.StringVar(&servicesStr, "services", "", "Only these services, comma-separated names or globs")
+	fs.StringVar(&envName, "env", "", "Environment profile from the config, e.g. staging or production")
+	fs.StringVar(&versionStr, "version", "", "Also show whether the release branch and tag of this version exist locally and on origin")
+	fs.StringVar(&versionStr, "v", "", "Release version to look up (shorthand)")
+	fs.BoolVar(&noGitlab, "no-gitlab", false, "Do not query GitLab for the last pipeline of each project")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s status [options]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	logOpts.apply()
+
+	if directory == "" {
+		logger.Exitf(exitConfig, "Error: -directory parameter is required\n\nUse -h for help")
+	}
+
+	var ver *version.Version
+	if versionStr != "" {
+		v, err := version.Parse(versionStr)
+		if err != nil {
+			logger.Exitf(exitConfig, "Error: -version: %v", err)
+		}
+		ver = &v
+	}
+
+	cfg, _ := loadConfig(configFile, directory, envName, servicesStr)
+
+	var (
+		pipelines      map[string]gitlab.PipelineResponse
+		pipelineErrors map[string]error
+	)
+	if !noGitlab {
+		var err error
+		pipelines, pipelineErrors, err = gitlab.LatestPipelines(cfg)
+		if err != nil {
+			logger.Warnf("Warning: pipelines are not shown: %v (use -no-gitlab to skip GitLab)", err)
+			noGitlab = true
+		}
+	}
+
+	header := []string{"SERVICE", "BRANCH", "HEAD", "WORKING COPY"}
+	if ver != nil {
+		header = append(header, "BRANCH "+ver.Branch(), "TAG "+ver.Tag())
+	}
+	if !noGitlab {
+		header = append(header, "LAST PIPELINE")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	var problems []string
+	for _, wc := range workingCopies(cfg, directory) {
+		row := []string{wc.Name}
+		if _, err := os.Stat(wc.Dir); err != nil {
+			row = append(row, "-", "-", fmt.Sprintf("missing (%s)", wc.Dir))
+			if ver != nil {
+				row = append(row, "-", "-")
+			}
+		} else {
+			branch, err := git.GetCurrentBranch(wc.Dir)
+			if err != nil {
+				branch = "?"
+			}
+			head, err := git.GetHeadCommit(wc.Dir)
+			if err != nil {
+				head = "?"
+			}
+			clean := "clean"
+			if err := git.CheckClean(wc.Dir); err != nil {
+				clean = "dirty"
+			}
+			row = append(row, branch, head, clean)
+
+			if ver != nil {
+				local, remote, err := git.ExistingBranches(wc.Dir, ver.ServiceBranch(wc.Name))
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("%s: %v", wc.Name, err))
+				}
+				row = append(row, refPlaces(local, remote, err))
+				local, remote, err = git.ExistingTags(wc.Dir, ver.ServiceTag(wc.Name))
+				if err != nil {
+					problems = append(problems, fmt.Sprintf("%s: %v", wc.Name, err))
+				}
+				row = append(row, refPlaces(local, remote, err))
+			}
+		}
+
+		if !noGitlab {
+			switch pipeline, ok := pipelines[wc.Name]; {
+			case pipelineErrors[wc.Name] != nil:
+				row = append(row, "?")
+				problems = append(problems, fmt.Sprintf("%s: failed to get pipelines of %s: %v", wc.Name, wc.GitlabProject, pipelineErrors[wc.Name]))
+			case ok:
+				row = append(row, fmt.Sprintf("#%d %s (%s)", pipeline.ID, pipeline.Status, pipeline.Ref))
+			default:
+				row = append(row, "none")
+			}
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+
+	sort.Strings(problems)
+	for _, problem := range problems {
+		logger.Warnf("Warning: %s", problem)
+	}
+}
+
+// refPlaces describes where a branch or tag exists under which separator form,
+// e.g. "local release-123, origin release/123", or "-" if nowhere
+func refPlaces(local, remote []string, remoteErr error) string {
+	var places []string
+	for _, name := range local {
+		places = append(places, "local "+name)
+	}
+	for _, name := range remote {
+		places = append(places, "origin "+name)
+	}
+	if remoteErr != nil {
+		places = append(places, "origin ?")
+	}
+	if len(places) == 0 {
+		return "-"
+	}
+	return strings.Join(places, ", ")
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strings"
+	"time"
+
+	"deploy/notes"
+)
+
+// tagMessage returns the message of the annotated release tag of the service: the
+// version, who deployed it and when, and the tasks released since its previous release
+func (d *deployment) tagMessage(service string) string {
+	ver := d.versionFor(service)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Release %s\n\n", ver)
+	fmt.Fprintf(&b, "Version: %s\n", ver)
+	fmt.Fprintf(&b, "Deployed by: %s\n", deployingUser())
+	fmt.Fprintf(&b, "Date: %s\n", time.Now().Format("2006-01-02 15:04:05"))
+
+	tasks := d.releasedTasks(service)
+	if len(tasks) == 0 {
+		b.WriteString("Tasks: none\n")
+		return b.String()
+	}
+	b.WriteString("Tasks:\n")
+	for _, task := range tasks {
+		fmt.Fprintf(&b, "  %s\n", task)
+	}
+	return b.String()
+}
+
+// releasedTasks returns the task IDs of the commits since the previous release of the
+// service. The release is not tagged yet, so they are collected up to HEAD of the release
+// branch; a failure is only reported, the tasks are informational.
+func (d *deployment) releasedTasks(service string) []string {
+	ver := d.versionFor(service)
+	svc := notes.Service{Name: service, Dir: d.repoDirs[service], Version: &ver}
+	release, err := notes.Collect([]notes.Service{svc}, d.version, "")
+	if err != nil {
+		d.logFor(service).Warnf("  Warning: failed to collect the released tasks of %s: %v", service, err)
+		return nil
+	}
+	return release.Services[0].Tasks
+}
+
+// deployingUser returns the name of the user running the deployment
+func deployingUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"deploy/config"
+)
+
+// timeoutList collects repeatable key=duration flags such as -phase-timeout build=30m
+type timeoutList map[string]string
+
+func (l timeoutList) String() string {
+	var items []string
+	for key, value := range l {
+		items = append(items, key+"="+value)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+func (l timeoutList) Set(value string) error {
+	key, limit, ok := strings.Cut(value, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return fmt.Errorf("expected name=duration, got %q", value)
+	}
+	l[key] = strings.TrimSpace(limit)
+	return nil
+}
+
+// parseTimeout parses a timeout value; an empty value means no limit
+func parseTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if limit < 0 {
+		return 0, fmt.Errorf("must not be negative")
+	}
+	return limit, nil
+}
+
+// resolveTimeouts merges the timeouts of the configuration with the -phase-timeout and
+// -command-timeout flags, the flags taking precedence. Phases may be given by number
+// or name and are returned by name.
+func resolveTimeouts(cfg *config.Config, phaseFlags, commandFlags timeoutList) (phaseLimits, commandLimits map[string]time.Duration, problems []string) {
+	phaseLimits = make(map[string]time.Duration)
+	commandLimits = make(map[string]time.Duration)
+
+	addPhases := func(source string, list map[string]string) {
+		for key, value := range list {
+			n, err := parsePhase(key)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", source, err))
+				continue
+			}
+			limit, err := parseTimeout(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: phase %s: %v", source, key, err))
+				continue
+			}
+			phaseLimits[phases[n].name] = limit
+		}
+	}
+	addCommands := func(source string, list map[string]string) {
+		for key, value := range list {
+			limit, err := parseTimeout(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: command %s: %v", source, key, err))
+				continue
+			}
+			commandLimits[key] = limit
+		}
+	}
+
+	addPhases("timeouts.phases", cfg.Timeouts.Phases)
+	addCommands("timeouts.commands", cfg.Timeouts.Commands)
+	addPhases("-phase-timeout", phaseFlags)
+	addCommands("-command-timeout", commandFlags)
+	sort.Strings(problems)
+	return phaseLimits, commandLimits, problems
+}
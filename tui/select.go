@@ -0,0 +1,159 @@
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Choice is an entry of a checklist
+type Choice struct {
+	Name  string
+	Group string // entries are listed under their group; empty for ungrouped ones
+}
+
+// Checklist lists the choices, all of them selected, and lets the user toggle entries
+// by number, range ("3-5"), group or name, or select "all" or "none". An empty line
+// confirms the selection. The selected names are returned in the order of choices.
+func Checklist(title string, ungrouped string, choices []Choice, in io.Reader, out io.Writer) ([]string, error) {
+	order := displayOrder(choices)
+	selected := make([]bool, len(order))
+	for i := range selected {
+		selected[i] = true
+	}
+
+	reader := bufio.NewReader(in)
+	for {
+		printChecklist(out, title, ungrouped, order, selected)
+		fmt.Fprintf(out, "Toggle by number, range, group or name (e.g. \"2 4-6 backend\"), \"all\" or \"none\"; Enter to continue: ")
+		line, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			picked := make(map[Choice]bool)
+			for i, c := range order {
+				picked[c] = selected[i]
+			}
+			var names []string
+			for _, c := range choices {
+				if picked[c] {
+					names = append(names, c.Name)
+				}
+			}
+			if len(names) > 0 {
+				return names, nil
+			}
+			fmt.Fprintf(out, "Select at least one entry.\n")
+			continue
+		}
+
+		for _, token := range strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == ',' }) {
+			if err := toggle(token, order, selected); err != nil {
+				fmt.Fprintf(out, "%v\n", err)
+			}
+		}
+	}
+}
+
+// displayOrder returns the choices grouped: ungrouped ones first, then groups by name
+func displayOrder(choices []Choice) []Choice {
+	order := make([]Choice, len(choices))
+	copy(order, choices)
+	sort.SliceStable(order, func(i, j int) bool {
+		if (order[i].Group == "") != (order[j].Group == "") {
+			return order[i].Group == ""
+		}
+		return order[i].Group < order[j].Group
+	})
+	return order
+}
+
+// printChecklist prints the entries under their group headers
+func printChecklist(out io.Writer, title, ungrouped string, order []Choice, selected []bool) {
+	fmt.Fprintf(out, "\n%s\n", title)
+	group := "\x00"
+	for i, c := range order {
+		if c.Group != group {
+			group = c.Group
+			if group == "" {
+				fmt.Fprintf(out, "  %s\n", ungrouped)
+			} else {
+				fmt.Fprintf(out, "  %s\n", group)
+			}
+		}
+		mark := " "
+		if selected[i] {
+			mark = "x"
+		}
+		fmt.Fprintf(out, "    [%s] %2d %s\n", mark, i+1, c.Name)
+	}
+}
+
+// toggle applies a single token of the user's input to the selection
+func toggle(token string, order []Choice, selected []bool) error {
+	switch token {
+	case "all", "none":
+		for i := range selected {
+			selected[i] = token == "all"
+		}
+		return nil
+	}
+
+	if from, to, ok := parseRange(token); ok {
+		if from < 1 || to > len(order) || from > to {
+			return fmt.Errorf("no entries %s (1-%d)", token, len(order))
+		}
+		for i := from - 1; i < to; i++ {
+			selected[i] = !selected[i]
+		}
+		return nil
+	}
+
+	// A group toggles all its entries together: selected if any of them was not
+	var members []int
+	for i, c := range order {
+		if c.Group == token {
+			members = append(members, i)
+		}
+	}
+	if len(members) > 0 {
+		all := true
+		for _, i := range members {
+			all = all && selected[i]
+		}
+		for _, i := range members {
+			selected[i] = !all
+		}
+		return nil
+	}
+
+	for i, c := range order {
+		if c.Name == token {
+			selected[i] = !selected[i]
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown entry %q", token)
+}
+
+// parseRange parses "N" or "N-M"
+func parseRange(token string) (from, to int, ok bool) {
+	parts := strings.SplitN(token, "-", 2)
+	from, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	to = from
+	if len(parts) == 2 {
+		if to, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, false
+		}
+	}
+	return from, to, true
+}
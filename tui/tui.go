@@ -0,0 +1,372 @@
+package tui
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Row statuses
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// ANSI sequences used for drawing
+const (
+	altScreenOn  = "\033[?1049h"
+	altScreenOff = "\033[?1049l"
+	cursorHide   = "\033[?25l"
+	cursorShow   = "\033[?25h"
+	clearScreen  = "\033[H\033[2J"
+	colorReset   = "\033[0m"
+	colorRed     = "\033[31m"
+	colorGreen   = "\033[32m"
+	colorYellow  = "\033[33m"
+	colorCyan    = "\033[36m"
+)
+
+// maxLines is the number of log lines kept per service
+const maxLines = 200
+
+// row is the progress of a single service
+type row struct {
+	service string
+	phase   string
+	status  string
+	started time.Time
+	elapsed time.Duration // duration of the last finished step
+	lines   []string
+}
+
+// Board is a full-screen progress table with one row per service and a log pane
+// showing the output of the service that printed last (or the general log). While it runs, the process
+// standard output and error are captured, so output of git and Maven ends up in
+// the log pane instead of breaking the screen.
+type Board struct {
+	title   string
+	started time.Time
+
+	mu        sync.Mutex
+	rows      []*row
+	index     map[string]*row
+	active    string   // service whose log is shown, "" for the general log
+	status    string   // last message not tied to a service
+	general   []string // log of messages not tied to a service
+	running   bool
+	suspended bool
+
+	term           *os.File // the real terminal
+	stdout, stderr *os.File // original os.Stdout and os.Stderr
+	pipeR, pipeW   *os.File
+	stop           chan struct{}
+	done           sync.WaitGroup
+}
+
+// IsTerminal reports whether f is an interactive terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// New creates a board for the services
+func New(title string, services []string) *Board {
+	b := &Board{
+		title: title,
+		index: make(map[string]*row),
+	}
+	for _, service := range services {
+		r := &row{service: service, phase: "-", status: StatusPending}
+		b.rows = append(b.rows, r)
+		b.index[service] = r
+	}
+	return b
+}
+
+// Start switches the terminal to the board and captures the standard output
+func (b *Board) Start() error {
+	if b == nil {
+		return nil
+	}
+	pipeR, pipeW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.started = time.Now()
+	b.term = os.Stdout
+	b.stdout, b.stderr = os.Stdout, os.Stderr
+	b.pipeR, b.pipeW = pipeR, pipeW
+	b.stop = make(chan struct{})
+	b.running = true
+	os.Stdout, os.Stderr = pipeW, pipeW
+	fmt.Fprint(b.term, altScreenOn+cursorHide)
+	b.mu.Unlock()
+
+	b.done.Add(2)
+	go b.readOutput()
+	go b.redrawLoop()
+	return nil
+}
+
+// Stop restores the terminal and prints the final table with the last lines
+// of the active service. It is safe to call more than once.
+func (b *Board) Stop() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if !b.running {
+		b.mu.Unlock()
+		return
+	}
+	b.running = false
+	close(b.stop)
+	os.Stdout, os.Stderr = b.stdout, b.stderr
+	b.pipeW.Close()
+	b.mu.Unlock()
+
+	b.done.Wait()
+	b.pipeR.Close()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.suspended {
+		fmt.Fprint(b.term, altScreenOff+cursorShow)
+	}
+	fmt.Fprint(b.term, b.render(20))
+}
+
+// Suspend gives the terminal back, e.g. for an interactive question
+func (b *Board) Suspend() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.running || b.suspended {
+		return
+	}
+	b.suspended = true
+	os.Stdout, os.Stderr = b.stdout, b.stderr
+	fmt.Fprint(b.term, altScreenOff+cursorShow)
+	fmt.Fprint(b.term, b.render(20))
+}
+
+// Resume takes the terminal over again after Suspend
+func (b *Board) Resume() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.running || !b.suspended {
+		return
+	}
+	b.suspended = false
+	os.Stdout, os.Stderr = b.pipeW, b.pipeW
+	fmt.Fprint(b.term, altScreenOn+cursorHide)
+}
+
+// SetPhase marks the service as running the phase
+func (b *Board) SetPhase(service, phase string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r := b.index[service]; r != nil && (r.phase != phase || r.status != StatusRunning) {
+		r.phase = phase
+		r.status = StatusRunning
+		r.started = time.Now()
+	}
+}
+
+// SetPhaseAll marks all services as running the phase
+func (b *Board) SetPhaseAll(phase string) {
+	if b == nil {
+		return
+	}
+	for _, r := range b.rows {
+		b.SetPhase(r.service, phase)
+	}
+}
+
+// Done marks the phase as finished for the service
+func (b *Board) Done(service, phase string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r := b.index[service]; r != nil {
+		if r.status == StatusRunning {
+			r.elapsed = time.Since(r.started)
+		}
+		r.phase = phase
+		r.status = StatusDone
+	}
+}
+
+// DoneAll marks the phase as finished for all services
+func (b *Board) DoneAll(phase string) {
+	if b == nil {
+		return
+	}
+	for _, r := range b.rows {
+		b.Done(r.service, phase)
+	}
+}
+
+// Fail marks the service as failed
+func (b *Board) Fail(service string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r := b.index[service]; r != nil {
+		if r.status == StatusRunning {
+			r.elapsed = time.Since(r.started)
+		}
+		r.status = StatusFailed
+	}
+}
+
+// Log adds a line to the log of the service, which becomes the active one.
+// Lines without a known service go to the general log and the status line.
+func (b *Board) Log(service, line string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addLine(service, line)
+}
+
+// addLine appends a line to the service log. Caller must hold b.mu.
+func (b *Board) addLine(service, line string) {
+	r := b.index[service]
+	if r == nil {
+		if strings.TrimSpace(line) != "" {
+			b.status = strings.TrimSpace(line)
+		}
+		b.general = appendLine(b.general, line)
+		b.active = ""
+		return
+	}
+	r.lines = appendLine(r.lines, line)
+	b.active = service
+}
+
+// appendLine adds a line to a log, keeping at most maxLines lines
+func appendLine(lines []string, line string) []string {
+	lines = append(lines, line)
+	if len(lines) > maxLines {
+		lines = lines[len(lines)-maxLines:]
+	}
+	return lines
+}
+
+// readOutput moves the captured standard output to the log of the active service
+func (b *Board) readOutput() {
+	defer b.done.Done()
+	scanner := bufio.NewScanner(b.pipeR)
+	for scanner.Scan() {
+		b.mu.Lock()
+		b.addLine(b.active, scanner.Text())
+		b.mu.Unlock()
+	}
+}
+
+// redrawLoop redraws the board a few times per second
+func (b *Board) redrawLoop() {
+	defer b.done.Done()
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			if !b.suspended {
+				fmt.Fprint(b.term, clearScreen+b.render(b.paneHeight()))
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// paneHeight returns how many log lines fit under the table, using $LINES if set
+func (b *Board) paneHeight() int {
+	height := 40
+	if lines, err := strconv.Atoi(os.Getenv("LINES")); err == nil && lines > 0 {
+		height = lines
+	}
+	pane := height - len(b.rows) - 6
+	if pane < 5 {
+		pane = 5
+	}
+	return pane
+}
+
+// render draws the table and the last paneLines lines of the active log.
+// Caller must hold b.mu.
+func (b *Board) render(paneLines int) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s%s%s  %s\n", colorCyan, b.title, colorReset, time.Since(b.started).Round(time.Second))
+	fmt.Fprintf(&buf, "%s\n\n", b.status)
+
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tPHASE\tSTATUS\tTIME")
+	for _, r := range b.rows {
+		elapsed := r.elapsed
+		if r.status == StatusRunning {
+			elapsed = time.Since(r.started)
+		}
+		duration := "-"
+		if r.status != StatusPending {
+			duration = elapsed.Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.service, r.phase, colorStatus(r.status), duration)
+	}
+	w.Flush()
+
+	name, lines := "log", b.general
+	if r := b.index[b.active]; r != nil {
+		name, lines = r.service, r.lines
+	}
+	fmt.Fprintf(&buf, "\n--- %s ---\n", name)
+	if len(lines) > paneLines {
+		lines = lines[len(lines)-paneLines:]
+	}
+	for _, line := range lines {
+		fmt.Fprintln(&buf, line)
+	}
+	return buf.String()
+}
+
+// colorStatus returns the status colored for the terminal. Every status gets
+// an escape sequence of the same length so that tabwriter keeps the columns aligned.
+func colorStatus(status string) string {
+	color := colorReset
+	switch status {
+	case StatusRunning:
+		color = colorYellow
+	case StatusDone:
+		color = colorGreen
+	case StatusFailed:
+		color = colorRed
+	}
+	return color + status + colorReset
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"deploy/config"
+	"deploy/git"
+	"deploy/gitlab"
+	"deploy/gradle"
+	"deploy/logger"
+	"deploy/maven"
+	"deploy/version"
+)
+
+// runValidate implements "deploy validate": checks the configuration, the service
+// working copies, the installed tools and GitLab access up front and reports all
+// problems at once
+func runValidate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	var logOpts logFlags
+	logOpts.register(fs)
+	var (
+		configFile  string
+		directory   string
+		servicesStr string
+		envName     string
+		noGitlab    bool
+	)
+	fs.StringVar(&configFile, "config", "", "Path to YAML configuration file (default: $DEPLOY_CONFIG or deploy.yaml)")
+	fs.StringVar(&configFile, "c", "", "Path to YAML configuration file (shorthand)")
+	fs.StringVar(&directory, "directory", "", "Base directory for services (required)")
+	fs.StringVar(&directory, "d", "", "Base directory for services (shorthand)")
+	fs.StringVar(&servicesStr, "services", "", "Only these services, comma-separated names or globs")
+	fs.StringVar(&envName, "env", "", "Environment profile from the config, e.g. staging or production")
+	fs.BoolVar(&noGitlab, "no-gitlab", false, "Do not check that GITLAB_TOKEN can read the GitLab projects")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s validate [options]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	logOpts.apply()
+
+	if directory == "" {
+		logger.Exitf(exitConfig, "Error: -directory parameter is required\n\nUse -h for help")
+	}
+
+	cfg, configFile := loadConfig(configFile, directory, envName, servicesStr)
+	logger.Infof("Validating %s...", configFile)
+
+	var problems []string
+	if err := config.CheckSchema(configFile); err != nil {
+		problems = append(problems, schemaProblems(configFile, err)...)
+	}
+	problems = append(problems, checkTools(cfg.Requirements)...)
+
+	seen := make(map[string]bool)
+	var lfsServices []string
+	for _, wc := range workingCopies(cfg, directory) {
+		if wc.Name == "" {
+			problems = append(problems, fmt.Sprintf("service in directory %q has no name", wc.Directory))
+			continue
+		}
+		if seen[wc.Name] {
+			problems = append(problems, fmt.Sprintf("%s: service is defined more than once", wc.Name))
+		}
+		seen[wc.Name] = true
+
+		if wc.Directory == "" {
+			problems = append(problems, fmt.Sprintf("%s: directory is not set", wc.Name))
+		}
+		if wc.GitlabProject == "" {
+			problems = append(problems, fmt.Sprintf("%s: gitlab_project is not set", wc.Name))
+		}
+		if wc.VersionOverride != "" {
+			if _, err := version.Expand(wc.VersionOverride, version.Version{}); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: version_override: %v", wc.Name, err))
+			}
+		}
+		if _, err := os.Stat(wc.Dir); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: directory %s does not exist", wc.Name, wc.Dir))
+			continue
+		}
+		repoDir := filepath.Join(directory, wc.RepositoryDir())
+		if _, err := os.Stat(filepath.Join(repoDir, ".git")); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s is not a git repository", wc.Name, repoDir))
+		} else if wc.GitlabProject != "" {
+			remote, err := git.RemoteURL(repoDir)
+			switch {
+			case err != nil:
+				problems = append(problems, fmt.Sprintf("%s: %v", wc.Name, err))
+			case !remoteMatchesProject(remote, wc.GitlabProject):
+				problems = append(problems, fmt.Sprintf("%s: origin %s is not the GitLab project %s", wc.Name, remote, wc.GitlabProject))
+			}
+		}
+		if git.UsesLFS(repoDir) {
+			lfsServices = append(lfsServices, wc.Name)
+		}
+		switch wc.BuildTool() {
+		case config.BuildGradle:
+			if !gradle.IsProject(wc.Dir) {
+				problems = append(problems, fmt.Sprintf("%s: build.gradle not found in %s", wc.Name, wc.Dir))
+			}
+		case config.BuildNpm, config.BuildYarn:
+			if _, err := os.Stat(filepath.Join(wc.Dir, "package.json")); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: package.json not found in %s", wc.Name, wc.Dir))
+			}
+		default:
+			if _, err := os.Stat(filepath.Join(wc.Dir, "pom.xml")); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: pom.xml not found in %s", wc.Name, wc.Dir))
+			}
+			for _, module := range wc.MavenModules {
+				// [groupId]:artifactId selectors are resolved by Maven
+				if strings.Contains(module, ":") {
+					continue
+				}
+				if _, err := os.Stat(filepath.Join(wc.Dir, module, "pom.xml")); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: maven_modules: %s/pom.xml not found in %s", wc.Name, module, wc.Dir))
+				}
+			}
+			if wc.JDK != "" {
+				if _, err := maven.FindJDK(wc.JDK, cfg.JDKs); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: %v", wc.Name, err))
+				}
+			}
+			if cfg.MavenCommandFor(wc.Service) == maven.CommandWrapper && maven.Wrapper(wc.Dir) == "" {
+				problems = append(problems, fmt.Sprintf("%s: maven_command is %s, but no Maven wrapper found in %s", wc.Name, maven.CommandWrapper, wc.Dir))
+			}
+			if settings := cfg.MavenSettingsFor(wc.Service); settings != "" {
+				if _, err := os.Stat(settings); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: maven_settings %s not found", wc.Name, settings))
+				}
+			}
+		}
+	}
+	if len(seen) == 0 {
+		problems = append(problems, "no services configured")
+	}
+	if len(lfsServices) > 0 {
+		if err := git.CheckLFS(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s use Git LFS, but %v", strings.Join(lfsServices, ", "), err))
+		}
+	}
+	problems = append(problems, checkSharedRepositories(cfg, directory)...)
+	problems = append(problems, checkHooks(cfg)...)
+	_, _, timeoutProblems := resolveTimeouts(cfg, nil, nil)
+	problems = append(problems, timeoutProblems...)
+	if _, err := newNotifiers(cfg); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	// Every environment profile must resolve against the configured services.
+	// Skipped when -env or -services has already narrowed the service list.
+	if envName == "" && servicesStr == "" {
+		var envNames []string
+		for name := range cfg.Environments {
+			envNames = append(envNames, name)
+		}
+		sort.Strings(envNames)
+		for _, name := range envNames {
+			if _, err := cfg.ApplyEnvironment(name); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+
+	gitlabConfigured := true
+	for _, env := range []string{"GITLAB_TOKEN", "GITLAB_URI"} {
+		if os.Getenv(env) == "" {
+			problems = append(problems, fmt.Sprintf("%s environment variable is not set", env))
+			gitlabConfigured = false
+		}
+	}
+	if gitlabConfigured && !noGitlab {
+		logger.Infof("Checking access to %d GitLab project(s)...", len(seen))
+		errs, err := gitlab.CheckProjectAccess(cfg)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		var names []string
+		for name := range errs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			problems = append(problems, fmt.Sprintf("%s: GITLAB_TOKEN cannot read the GitLab project: %v", name, errs[name]))
+		}
+	}
+
+	if len(problems) > 0 {
+		logger.Errorf("\n\033[31m=== %d problem(s) found ===\033[0m", len(problems))
+		for _, p := range problems {
+			logger.Errorf("  \033[31m✗ %s\033[0m", p)
+		}
+		os.Exit(exitConfig)
+	}
+
+	logger.Noticef("\033[32m✓ Configuration is valid: %d service(s)\033[0m", len(seen))
+}
+
+// checkSharedRepositories checks that the modules of a shared repository lie inside it
+// and agree on everything the git phases do once per repository: the base branch,
+// the version of the tag and the GitLab project
+func checkSharedRepositories(cfg *config.Config, directory string) []string {
+	var problems []string
+	first := make(map[string]config.Service) // repository -> first service in it
+	for _, wc := range workingCopies(cfg, directory) {
+		repo := path.Clean(wc.RepositoryDir())
+		if wc.Repository != "" {
+			module := path.Clean(wc.Directory)
+			if module != repo && !strings.HasPrefix(module, repo+"/") {
+				problems = append(problems, fmt.Sprintf("%s: directory %s is not inside repository %s", wc.Name, wc.Directory, wc.Repository))
+			}
+		}
+		other, ok := first[repo]
+		if !ok {
+			first[repo] = wc.Service
+			continue
+		}
+		for _, field := range []struct{ name, value, other string }{
+			{"base_branch", wc.BaseBranch, other.BaseBranch},
+			{"version_override", wc.VersionOverride, other.VersionOverride},
+			{"gitlab_project", wc.GitlabProject, other.GitlabProject},
+		} {
+			if field.value != field.other {
+				problems = append(problems, fmt.Sprintf("%s: %s differs from %s, which shares repository %s", wc.Name, field.name, other.Name, repo))
+			}
+		}
+	}
+	return problems
+}
+
+// checkServiceTags rejects a tag_template with {{.Service}} when services share a
+// repository: it is tagged once, with the name of its first service, so the pipelines
+// of the other services would run on tags that do not exist
+func checkServiceTags(cfg *config.Config) []string {
+	if !version.TagsUseService() {
+		return nil
+	}
+	var problems []string
+	first := make(map[string]string) // repository -> first service in it
+	for _, svc := range cfg.GetAllServices() {
+		repo := path.Clean(svc.RepositoryDir())
+		other, ok := first[repo]
+		if !ok {
+			first[repo] = svc.Name
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("%s: tag_template uses {{.Service}}, but %s shares repository %s, which is tagged once", svc.Name, other, repo))
+	}
+	return problems
+}
+
+// schemaProblems splits the error of a strict configuration read into one problem per line
+func schemaProblems(configFile string, err error) []string {
+	var problems []string
+	for _, line := range strings.Split(err.Error(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line == "yaml: unmarshal errors:" {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("%s: %s", configFile, line))
+	}
+	return problems
+}
+
+// remoteMatchesProject reports whether a remote URL (https or ssh) points to the
+// GitLab project path, e.g. git@gitlab:team/api.git to team/api. GitLab paths are
+// case-insensitive, and an instance under a relative URL root prefixes them.
+func remoteMatchesProject(remote, project string) bool {
+	path := strings.ToLower(projectFromRemote(remote))
+	project = strings.ToLower(strings.Trim(project, "/"))
+	return path != "" && (path == project || strings.HasSuffix(path, "/"+project))
+}
+
+// checkTools checks that git, Maven and Java are installed in at least the versions
+// required by the configuration
+func checkTools(req config.Requirements) []string {
+	tools := []struct {
+		name     string
+		required string
+		detect   func() (version.Version, error)
+	}{
+		{"git", req.Git, git.Version},
+		{"mvn", req.Maven, maven.Version},
+		{"java", req.Java, maven.JavaVersion},
+	}
+
+	var problems []string
+	for _, tool := range tools {
+		installed, err := tool.detect()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: not usable: %v", tool.name, err))
+			continue
+		}
+		if tool.required == "" {
+			logger.Infof("  %s %s", tool.name, installed)
+			continue
+		}
+		required, err := version.Parse(tool.required)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("requirements: %s: %v", tool.name, err))
+			continue
+		}
+		if installed.Less(required) {
+			problems = append(problems, fmt.Sprintf("%s %s is installed, %s or later is required", tool.name, installed, tool.required))
+			continue
+		}
+		logger.Infof("  %s %s (required %s)", tool.name, installed, tool.required)
+	}
+	return problems
+}
@@ -0,0 +1,308 @@
+package version
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// RefData is the data the tag_template and branch_template are executed with
+type RefData struct {
+	Version string // full version, e.g. 123.0.0
+	Short   string // version without trailing zero components, e.g. 123
+	Major   string
+	Minor   string
+	Patch   string
+	Service string // name of the service, empty where the ref is not tied to one
+	Date    string // date of the deployment, e.g. 2024-05-20
+}
+
+// refDate is the date refs are named with: the day the deployment started, see SetRefDate
+var refDate = time.Now().Format("2006-01-02")
+
+// RefDate returns the date refs are named with, e.g. 2024-05-20
+func RefDate() string {
+	return refDate
+}
+
+// SetRefDate sets the date refs are named with, e.g. the day a resumed deployment
+// started, so that refs named with {{.Date}} on an earlier day are found again
+func SetRefDate(date string) {
+	refDate = date
+}
+
+// RefsUseDate reports whether the tag_template or the branch_template names refs with
+// {{.Date}}
+func RefsUseDate() bool {
+	return (tagTemplate != nil && tagTemplate.usesDate()) || (branchTemplate != nil && branchTemplate.usesDate())
+}
+
+// tagTemplate names the release tags, nil for the plain version
+var tagTemplate *refTemplate
+
+// branchTemplate names the release branches, nil for release-<short version>
+var branchTemplate *refTemplate
+
+// SetTagTemplate sets the Go template release tags are named with, e.g.
+// "{{.Service}}/v{{.Version}}". An empty text restores the plain version.
+func SetTagTemplate(text string) error {
+	if text == "" {
+		tagTemplate = nil
+		return nil
+	}
+	t, err := parseRefTemplate("tag_template", text)
+	if err != nil {
+		return err
+	}
+	tagTemplate = t
+	return nil
+}
+
+// HasTagTemplate reports whether release tags are named with a tag_template
+func HasTagTemplate() bool {
+	return tagTemplate != nil
+}
+
+// SetBranchTemplate sets the Go template release branches are named with, e.g.
+// "rel/{{.Short}}". An empty text restores release-<short version>.
+func SetBranchTemplate(text string) error {
+	if text == "" {
+		branchTemplate = nil
+		return nil
+	}
+	t, err := parseRefTemplate("branch_template", text)
+	if err != nil {
+		return err
+	}
+	branchTemplate = t
+	return nil
+}
+
+// HasBranchTemplate reports whether release branches are named with a branch_template
+func HasBranchTemplate() bool {
+	return branchTemplate != nil
+}
+
+// ServiceBranch returns the release branch name of the service
+func (v Version) ServiceBranch(service string) string {
+	if branchTemplate == nil {
+		return "release-" + v.Short()
+	}
+	return branchTemplate.name(v, service)
+}
+
+// ServiceTag returns the git tag name of the release of the service
+func (v Version) ServiceTag(service string) string {
+	if tagTemplate == nil {
+		return v.String()
+	}
+	return tagTemplate.name(v, service)
+}
+
+// ParseTag returns the version of a release tag of the service, and false if name is
+// not a release tag
+func ParseTag(name, service string) (Version, bool) {
+	if tagTemplate == nil {
+		v, err := Parse(name)
+		return v, err == nil
+	}
+	return tagTemplate.parse(name, service)
+}
+
+// refTemplate is a parsed tag_template or branch_template
+type refTemplate struct {
+	text string
+	tmpl *template.Template
+}
+
+// Placeholders the template is executed with to find where the version goes in a name
+const (
+	slotVersion = "\x00version\x00"
+	slotShort   = "\x00short\x00"
+	slotMajor   = "\x00major\x00"
+	slotMinor   = "\x00minor\x00"
+	slotPatch   = "\x00patch\x00"
+	slotDate    = "\x00date\x00"
+)
+
+// slotPatterns are the regular expressions matching the placeholders in a ref name
+var slotPatterns = map[string]string{
+	slotVersion: `(\d+\.\d+\.\d+)`,
+	slotShort:   `(\d+(?:\.\d+){0,2})`,
+	slotMajor:   `(\d+)`,
+	slotMinor:   `(\d+)`,
+	slotPatch:   `(\d+)`,
+	slotDate:    `(\d{4}-\d{2}-\d{2})`,
+}
+
+var slotPattern = regexp.MustCompile("\x00[a-z]+\x00")
+
+func parseRefTemplate(name, text string) (*refTemplate, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s %q: %v", name, text, err)
+	}
+	t := &refTemplate{text: text, tmpl: tmpl}
+
+	slots, err := t.execute(RefData{
+		Version: slotVersion, Short: slotShort,
+		Major: slotMajor, Minor: slotMinor, Patch: slotPatch,
+		Service: "service", Date: slotDate,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s %q: %v", name, text, err)
+	}
+	if !strings.Contains(slots, slotVersion) && !strings.Contains(slots, slotShort) && !strings.Contains(slots, slotMajor) {
+		return nil, fmt.Errorf("%s %q does not contain the version ({{.Version}}, {{.Short}} or {{.Major}})", name, text)
+	}
+	// Refs not tied to a service, e.g. the branch of the status header, have no name
+	if err := t.check(""); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// sampleVersions are versions of different lengths the templates are checked with,
+// since a template such as {{slice .Version 0 7}} fails on short ones
+var sampleVersions = []Version{{Major: 1}, {Major: 1, Minor: 2, Patch: 3}, {Major: 123, Minor: 45, Patch: 6}}
+
+// check executes the template with the sample versions of the service
+func (t *refTemplate) check(service string) error {
+	for _, v := range sampleVersions {
+		if _, err := t.execute(v.RefData(service)); err != nil {
+			return fmt.Errorf("%s %q fails for version %s of service %q: %v", t.tmpl.Name(), t.text, v, service, err)
+		}
+	}
+	return nil
+}
+
+// CheckRefNames checks that the tag_template and the branch_template name the refs of
+// every service, so that a template failing on a short name stops the deployment
+// before it starts rather than in the middle
+func CheckRefNames(services []string) error {
+	for _, t := range []*refTemplate{tagTemplate, branchTemplate} {
+		if t == nil {
+			continue
+		}
+		for _, service := range services {
+			if err := t.check(service); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *refTemplate) execute(data RefData) (string, error) {
+	var b bytes.Buffer
+	if err := t.tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// RefData returns the template data of the version of the service
+func (v Version) RefData(service string) RefData {
+	return RefData{
+		Version: v.String(),
+		Short:   v.Short(),
+		Major:   strconv.Itoa(v.Major),
+		Minor:   strconv.Itoa(v.Minor),
+		Patch:   strconv.Itoa(v.Patch),
+		Service: service,
+		Date:    refDate,
+	}
+}
+
+// TagsUseService reports whether the tag_template names tags with {{.Service}}
+func TagsUseService() bool {
+	if tagTemplate == nil {
+		return false
+	}
+	v := Version{Major: 1, Minor: 2, Patch: 3}
+	first, err := tagTemplate.execute(v.RefData("first"))
+	second, err2 := tagTemplate.execute(v.RefData("second"))
+	return err == nil && err2 == nil && first != second
+}
+
+// usesDate reports whether the names depend on the date
+func (t *refTemplate) usesDate() bool {
+	v := Version{Major: 1, Minor: 2, Patch: 3}
+	data := v.RefData("service")
+	data.Date = "2000-01-01"
+	first, err := t.execute(data)
+	data.Date = "2000-01-02"
+	second, err2 := t.execute(data)
+	return err == nil && err2 == nil && first != second
+}
+
+// name returns the ref name of the version of the service
+func (t *refTemplate) name(v Version, service string) string {
+	name, err := t.execute(v.RefData(service))
+	if err != nil {
+		// The template was checked with names and versions of every length, see
+		// parseRefTemplate and CheckRefNames
+		panic(fmt.Sprintf("%s %q: %v", t.tmpl.Name(), t.text, err))
+	}
+	return name
+}
+
+// parse returns the version a ref name of the service was built from
+func (t *refTemplate) parse(name, service string) (Version, bool) {
+	slots, err := t.execute(RefData{
+		Version: slotVersion, Short: slotShort,
+		Major: slotMajor, Minor: slotMinor, Patch: slotPatch,
+		Service: service, Date: slotDate,
+	})
+	if err != nil {
+		return Version{}, false
+	}
+
+	// Every placeholder becomes a group of the expression; kinds keeps their order
+	var kinds []string
+	var expr strings.Builder
+	expr.WriteString("^")
+	last := 0
+	for _, loc := range slotPattern.FindAllStringIndex(slots, -1) {
+		expr.WriteString(regexp.QuoteMeta(slots[last:loc[0]]))
+		slot := slots[loc[0]:loc[1]]
+		expr.WriteString(slotPatterns[slot])
+		kinds = append(kinds, slot)
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(slots[last:]))
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return Version{}, false
+	}
+	match := re.FindStringSubmatch(name)
+	if match == nil {
+		return Version{}, false
+	}
+
+	var v Version
+	for i, kind := range kinds {
+		value := match[i+1]
+		switch kind {
+		case slotVersion, slotShort:
+			parsed, err := Parse(value)
+			if err != nil {
+				return Version{}, false
+			}
+			v = parsed
+		case slotMajor:
+			v.Major, _ = strconv.Atoi(value)
+		case slotMinor:
+			v.Minor, _ = strconv.Atoi(value)
+		case slotPatch:
+			v.Patch, _ = strconv.Atoi(value)
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,40 @@
+package version
+
+import "testing"
+
+func TestSetTagTemplateRejectsFailingTemplates(t *testing.T) {
+	t.Cleanup(func() { SetTagTemplate("") })
+
+	for _, text := range []string{
+		"v{{slice .Version 0 7}}",
+		"{{slice .Service 0 3}}/v{{.Version}}",
+		"{{index .Short 2}}-{{.Version}}",
+	} {
+		if err := SetTagTemplate(text); err == nil {
+			t.Errorf("SetTagTemplate(%q) succeeded, want an error", text)
+		}
+	}
+	if err := SetTagTemplate("{{.Service}}/v{{.Version}}"); err != nil {
+		t.Errorf("SetTagTemplate = %v", err)
+	}
+}
+
+func TestCheckRefNames(t *testing.T) {
+	t.Cleanup(func() { SetBranchTemplate("") })
+
+	// Refs not tied to a service are named with the whole template
+	if err := SetBranchTemplate("rel/{{if .Service}}{{slice .Service 0 4}}-{{end}}{{.Short}}"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckRefNames([]string{"billing", "auth"}); err != nil {
+		t.Errorf("CheckRefNames = %v", err)
+	}
+	if err := CheckRefNames([]string{"billing", "ui"}); err == nil {
+		t.Error("CheckRefNames succeeded for service ui, want an error")
+	}
+
+	v := Version{Major: 2, Minor: 14}
+	if name := v.ServiceBranch("billing"); name != "rel/bill-2.14" {
+		t.Errorf("ServiceBranch = %q, want rel/bill-2.14", name)
+	}
+}
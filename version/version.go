@@ -0,0 +1,141 @@
+package version
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version is a release version in MAJOR.MINOR.PATCH form
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// Parse parses a version given as "123", "2.14" or "2.14.3".
+// Missing components default to zero.
+func Parse(s string) (Version, error) {
+	s = strings.TrimSpace(s)
+	parts := strings.Split(s, ".")
+	if s == "" || len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid version %q: expected MAJOR[.MINOR[.PATCH]]", s)
+	}
+
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid version %q: component %q is not a non-negative integer", s, part)
+		}
+		numbers[i] = n
+	}
+
+	return Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, nil
+}
+
+// numberPattern matches a version number in the output of a tool
+var numberPattern = regexp.MustCompile(`\d+(\.\d+){0,2}`)
+
+// Find returns the first version number in text, such as the output of "git --version"
+// or "java -version"; components after the third are ignored
+func Find(text string) (Version, bool) {
+	match := numberPattern.FindString(text)
+	if match == "" {
+		return Version{}, false
+	}
+	v, err := Parse(match)
+	return v, err == nil
+}
+
+// String returns the full version, e.g. "2.14.3" or "123.0.0"
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Short returns the version without trailing zero components, e.g. "123" for 123.0.0
+// and "2.14" for 2.14.0
+func (v Version) Short() string {
+	switch {
+	case v.Patch != 0:
+		return v.String()
+	case v.Minor != 0:
+		return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+	default:
+		return strconv.Itoa(v.Major)
+	}
+}
+
+// Tag returns the git tag name of the release, see ServiceTag
+func (v Version) Tag() string {
+	return v.ServiceTag("")
+}
+
+// Branch returns the release branch name, e.g. "release-123", see ServiceBranch
+func (v Version) Branch() string {
+	return v.ServiceBranch("")
+}
+
+// CommitMessage returns the message of the version bump commit
+func (v Version) CommitMessage() string {
+	return "Update version to " + v.String()
+}
+
+// Expand returns the version described by a template: a fixed version such as "7.4.0",
+// or one built from the components of release, e.g. "7.{minor}.{patch}"
+func Expand(template string, release Version) (Version, error) {
+	expanded := strings.NewReplacer(
+		"{major}", strconv.Itoa(release.Major),
+		"{minor}", strconv.Itoa(release.Minor),
+		"{patch}", strconv.Itoa(release.Patch),
+	).Replace(template)
+	v, err := Parse(expanded)
+	if err != nil {
+		return Version{}, fmt.Errorf("template %q: %v", template, err)
+	}
+	return v, nil
+}
+
+// NextPatch returns the version with the patch component incremented
+func (v Version) NextPatch() Version {
+	return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+}
+
+// Parts of a version that Bump increments
+const (
+	BumpMajor = "major"
+	BumpMinor = "minor"
+	BumpPatch = "patch"
+)
+
+// Bump returns the next version after v: major 123 -> 124, minor 2.14.3 -> 2.15,
+// patch 2.14.3 -> 2.14.4
+func (v Version) Bump(part string) (Version, error) {
+	switch part {
+	case BumpMajor:
+		return Version{Major: v.Major + 1}, nil
+	case BumpMinor:
+		return Version{Major: v.Major, Minor: v.Minor + 1}, nil
+	case BumpPatch:
+		return v.NextPatch(), nil
+	}
+	return Version{}, fmt.Errorf("unknown version part %q: expected %s, %s or %s", part, BumpMajor, BumpMinor, BumpPatch)
+}
+
+// ReleaseLine returns the version with the patch component dropped: the release
+// whose branch hotfixes for v are committed to
+func (v Version) ReleaseLine() Version {
+	return Version{Major: v.Major, Minor: v.Minor}
+}
+
+// Less reports whether v is lower than other
+func (v Version) Less(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+	return v.Patch < other.Patch
+}